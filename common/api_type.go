@@ -71,6 +71,10 @@ func ChannelType2APIType(channelType int) (int, bool) {
 		apiType = constant.APITypeSubmodel
 	case constant.ChannelTypeMiniMax:
 		apiType = constant.APITypeMiniMax
+	case constant.ChannelTypeStability:
+		apiType = constant.APITypeStability
+	case constant.ChannelTypeMock:
+		apiType = constant.APITypeMock
 	}
 	if apiType == -1 {
 		return constant.APITypeOpenAI, false
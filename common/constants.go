@@ -69,6 +69,9 @@ var EmailLoginAuthServerList = []string{
 }
 
 var DebugEnabled bool
+
+// LogFormat 日志输出格式：text（默认，便于阅读）或 json（结构化，便于日志系统解析）
+var LogFormat = "text"
 var MemoryCacheEnabled bool
 
 var LogConsumeEnabled = true
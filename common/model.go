@@ -16,6 +16,8 @@ var (
 		"prefix:imagen-",
 		"flux-",
 		"flux.1-",
+		"prefix:stable-diffusion-",
+		"prefix:mock-image",
 	}
 )
 
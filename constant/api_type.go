@@ -34,5 +34,7 @@ const (
 	APITypeMoonshot
 	APITypeSubmodel
 	APITypeMiniMax
+	APITypeStability
+	APITypeMock
 	APITypeDummy // this one is only for count, do not add any channel after this
 )
@@ -53,6 +53,8 @@ const (
 	ChannelTypeSubmodel       = 53
 	ChannelTypeDoubaoVideo    = 54
 	ChannelTypeSora           = 55
+	ChannelTypeStability      = 56
+	ChannelTypeMock           = 57
 	ChannelTypeDummy          // this one is only for count, do not add any channel after this
 
 )
@@ -114,6 +116,8 @@ var ChannelBaseURLs = []string{
 	"https://llm.submodel.ai",                   //53
 	"https://ark.cn-beijing.volces.com",         //54
 	"https://api.openai.com",                    //55
+	"https://api.stability.ai",                  //56
+	"",                                          //57
 }
 
 var ChannelTypeNames = map[int]string{
@@ -169,6 +173,8 @@ var ChannelTypeNames = map[int]string{
 	ChannelTypeSubmodel:       "Submodel",
 	ChannelTypeDoubaoVideo:    "DoubaoVideo",
 	ChannelTypeSora:           "Sora",
+	ChannelTypeStability:      "Stability",
+	ChannelTypeMock:           "Mock",
 }
 
 func GetChannelTypeName(channelType int) string {
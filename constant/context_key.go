@@ -17,6 +17,7 @@ const (
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenSetting           ContextKey = "token_setting"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
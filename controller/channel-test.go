@@ -38,6 +38,7 @@ type testResult struct {
 	context     *gin.Context
 	localErr    error
 	newAPIError *types.NewAPIError
+	imageProbe  *imageProbeInfo // 图片渠道测试时的测试方式与熔断状态
 }
 
 func testChannel(channel *model.Channel, testModel string, endpointType string) testResult {
@@ -57,6 +58,10 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 			localErr: fmt.Errorf("%s channel test is not supported", channelTypeName),
 		}
 	}
+	// 纯图片渠道只能按图片接口测试
+	if (channel.Type == constant.ChannelTypeStability || channel.Type == constant.ChannelTypeMock) && endpointType == "" {
+		endpointType = string(constant.EndpointTypeImageGeneration)
+	}
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 
@@ -93,9 +98,10 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 			requestPath = "/v1/embeddings" // 修改请求路径
 		}
 
-		// VolcEngine 图像生成模型
-		if channel.Type == constant.ChannelTypeVolcEngine && strings.Contains(testModel, "seedream") {
+		// VolcEngine 图像生成模型与其他图片模型按图片接口测试
+		if (channel.Type == constant.ChannelTypeVolcEngine && strings.Contains(testModel, "seedream")) || common.IsImageGenerationModel(testModel) {
 			requestPath = "/v1/images/generations"
+			endpointType = string(constant.EndpointTypeImageGeneration)
 		}
 	}
 
@@ -213,6 +219,10 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 
+	if info.RelayMode == relayconstant.RelayModeImagesGenerations {
+		return testImageChannel(c, channel, info, adaptor, request)
+	}
+
 	//// 创建一个用于日志的 info 副本，移除 ApiKey
 	//logInfo := info
 	//logInfo.ApiKey = ""
@@ -504,11 +514,11 @@ func TestChannel(c *gin.Context) {
 	tik := time.Now()
 	result := testChannel(channel, testModel, endpointType)
 	if result.localErr != nil {
-		c.JSON(http.StatusOK, gin.H{
+		c.JSON(http.StatusOK, withImageProbe(gin.H{
 			"success": false,
 			"message": result.localErr.Error(),
 			"time":    0.0,
-		})
+		}, result))
 		return
 	}
 	tok := time.Now()
@@ -516,18 +526,18 @@ func TestChannel(c *gin.Context) {
 	go channel.UpdateResponseTime(milliseconds)
 	consumedTime := float64(milliseconds) / 1000.0
 	if result.newAPIError != nil {
-		c.JSON(http.StatusOK, gin.H{
+		c.JSON(http.StatusOK, withImageProbe(gin.H{
 			"success": false,
 			"message": result.newAPIError.Error(),
 			"time":    consumedTime,
-		})
+		}, result))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, withImageProbe(gin.H{
 		"success": true,
 		"message": "",
 		"time":    consumedTime,
-	})
+	}, result))
 }
 
 var testAllChannelsLock sync.Mutex
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageBatchItemResult 批量生成中单个条目的结果，成功时 Response 为单图生成接口的原始响应
+type imageBatchItemResult struct {
+	Index      int             `json:"index"`
+	StatusCode int             `json:"status_code"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      any             `json:"error,omitempty"`
+}
+
+func imageBatchError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+// RelayImageBatch 批量图片生成：请求体顶层字段作为所有条目的默认参数，items 中每一项覆盖默认参数。
+// 每个条目都作为一次独立的 /v1/images/generations 请求经过完整的鉴权、选路、计费流程，
+// 单个条目失败只影响该条目的结果
+func RelayImageBatch(engine *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request map[string]json.RawMessage
+		if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+			imageBatchError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		var items []map[string]json.RawMessage
+		if err := common.Unmarshal(request["items"], &items); err != nil || len(items) == 0 {
+			imageBatchError(c, http.StatusBadRequest, "items must be a non-empty array")
+			return
+		}
+		settings := model_setting.GetImageSettings()
+		if settings.BatchMaxItems > 0 && len(items) > settings.BatchMaxItems {
+			imageBatchError(c, http.StatusBadRequest, fmt.Sprintf("too many items, at most %d items per batch", settings.BatchMaxItems))
+			return
+		}
+		delete(request, "items")
+
+		concurrency := settings.BatchMaxConcurrency
+		if concurrency <= 0 {
+			concurrency = 1
+		}
+		results := make([]imageBatchItemResult, len(items))
+		semaphore := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i, item := range items {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(index int, item map[string]json.RawMessage) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				results[index] = relayImageBatchItem(c, engine, index, request, item)
+			}(i, item)
+		}
+		wg.Wait()
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   results,
+		})
+	}
+}
+
+// relayImageBatchItem 合并默认参数后，以内部请求的方式调用单图生成接口
+func relayImageBatchItem(c *gin.Context, engine *gin.Engine, index int, defaults map[string]json.RawMessage, item map[string]json.RawMessage) imageBatchItemResult {
+	result := imageBatchItemResult{Index: index}
+	merged := make(map[string]json.RawMessage, len(defaults)+len(item))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range item {
+		merged[k] = v
+	}
+	body, err := common.Marshal(merged)
+	if err != nil {
+		result.StatusCode = http.StatusBadRequest
+		result.Error = gin.H{"message": err.Error(), "type": "invalid_request_error"}
+		return result
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, "/v1/images/generations", bytes.NewReader(body))
+	if err != nil {
+		result.StatusCode = http.StatusInternalServerError
+		result.Error = gin.H{"message": err.Error(), "type": "new_api_error"}
+		return result
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("Content-Length")
+	req.Header.Del("Content-Encoding")
+	// 条目结果需要以 JSON 嵌入批量响应，不使用 multipart 或压缩
+	req.Header.Del("Accept")
+	req.Header.Del("Accept-Encoding")
+	// 批量条目共享请求头，各条目内容不同，不参与幂等去重
+	req.Header.Del(imageIdempotencyKeyHeader)
+	req.RemoteAddr = c.Request.RemoteAddr
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	result.StatusCode = recorder.Code
+	responseBody := recorder.Body.Bytes()
+	if recorder.Code == http.StatusOK && json.Valid(responseBody) {
+		result.Response = responseBody
+		return result
+	}
+	var relayError struct {
+		Error any `json:"error"`
+	}
+	if err := common.Unmarshal(responseBody, &relayError); err == nil && relayError.Error != nil {
+		result.Error = relayError.Error
+	} else {
+		result.Error = gin.H{"message": string(responseBody), "type": "upstream_error"}
+	}
+	return result
+}
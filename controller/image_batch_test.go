@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newImageBatchTestEngine 注册批量接口与模拟的单图生成接口：
+// 提示词为 "rejected" 时返回 OpenAI 格式的错误，为 "crash" 时返回非 JSON 错误，其余返回合并后的参数
+func newImageBatchTestEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/images/generations", func(c *gin.Context) {
+		var request map[string]any
+		if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		switch request["prompt"] {
+		case "rejected":
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "prompt rejected", "type": "invalid_request_error"}})
+		case "crash":
+			c.String(http.StatusBadGateway, "bad gateway")
+		default:
+			c.JSON(http.StatusOK, gin.H{
+				"data":          []gin.H{{"url": "https://cdn.example.com/" + request["prompt"].(string) + ".png"}},
+				"size":          request["size"],
+				"authorization": c.GetHeader("Authorization"),
+			})
+		}
+	})
+	engine.POST("/v1/images/batch", RelayImageBatch(engine))
+	return engine
+}
+
+func serveImageBatch(t *testing.T, body string) (int, map[string]any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/images/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-test")
+	newImageBatchTestEngine().ServeHTTP(recorder, req)
+	var response map[string]any
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode batch response %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, response
+}
+
+func TestImageBatchWithMixedResults(t *testing.T) {
+	code, response := serveImageBatch(t, `{"model":"dall-e-3","size":"1024x1024","items":[
+		{"prompt":"cat"},
+		{"prompt":"rejected"},
+		{"prompt":"dog","size":"512x512"},
+		{"prompt":"crash"}
+	]}`)
+	// 部分条目失败不影响整个批量请求
+	if code != http.StatusOK {
+		t.Fatalf("batch status = %d, want 200: %v", code, response)
+	}
+	items, _ := response["data"].([]any)
+	if len(items) != 4 {
+		t.Fatalf("batch returned %d results, want 4: %v", len(items), response)
+	}
+	results := make([]map[string]any, len(items))
+	for i, item := range items {
+		results[i] = item.(map[string]any)
+		if results[i]["index"] != float64(i) {
+			t.Fatalf("result %d has index %v", i, results[i]["index"])
+		}
+	}
+
+	// 成功条目返回单图接口的响应，条目参数覆盖默认参数，请求头沿用批量请求
+	for i, want := range map[int]string{0: "1024x1024", 2: "512x512"} {
+		inner, _ := results[i]["response"].(map[string]any)
+		if results[i]["status_code"] != float64(http.StatusOK) || inner == nil || results[i]["error"] != nil {
+			t.Fatalf("item %d did not succeed: %v", i, results[i])
+		}
+		if inner["size"] != want || inner["authorization"] != "Bearer sk-test" {
+			t.Fatalf("item %d relayed with size %v, authorization %v", i, inner["size"], inner["authorization"])
+		}
+	}
+
+	// 失败条目返回各自的错误
+	rejected, _ := results[1]["error"].(map[string]any)
+	if results[1]["status_code"] != float64(http.StatusBadRequest) || rejected["message"] != "prompt rejected" || results[1]["response"] != nil {
+		t.Fatalf("rejected item: %v", results[1])
+	}
+	crashed, _ := results[3]["error"].(map[string]any)
+	if results[3]["status_code"] != float64(http.StatusBadGateway) || crashed["message"] != "bad gateway" || crashed["type"] != "upstream_error" {
+		t.Fatalf("crashed item: %v", results[3])
+	}
+}
+
+func TestImageBatchRejectsInvalidItems(t *testing.T) {
+	maxItems := model_setting.GetImageSettings().BatchMaxItems
+	model_setting.GetImageSettings().BatchMaxItems = 2
+	t.Cleanup(func() { model_setting.GetImageSettings().BatchMaxItems = maxItems })
+
+	for _, body := range []string{
+		`{"model":"dall-e-3","items":[]}`,
+		`{"model":"dall-e-3","prompt":"cat"}`,
+		`{"model":"dall-e-3","items":[{"prompt":"a"},{"prompt":"b"},{"prompt":"c"}]}`,
+	} {
+		if code, response := serveImageBatch(t, body); code != http.StatusBadRequest || response["error"] == nil {
+			t.Fatalf("%s: status %d, response %v, want 400", body, code, response)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CancelImageGeneration 取消当前令牌用户进行中的图片生成请求，id 为请求响应头 X-Oneapi-Request-Id 的值。
+// 取消后预扣费用随请求失败一并退还
+func CancelImageGeneration(c *gin.Context) {
+	err := service.CancelImageGeneration(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, service.ErrImageGenerationCompleted) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"error": gin.H{
+				"message": err.Error(),
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":        c.Param("id"),
+		"cancelled": true,
+	})
+}
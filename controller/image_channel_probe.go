@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 图片渠道测试的方式
+const (
+	imageProbeMethodValidation = "validation" // 适配器提供的校验接口，不产生费用
+	imageProbeMethodGeneration = "generation" // 最小尺寸、单张的生成请求
+)
+
+// imageProbeInfo 图片渠道测试的附加信息，随测试结果返回给管理界面
+type imageProbeInfo struct {
+	Method       string `json:"method,omitempty"`
+	CircuitState string `json:"circuit_state"`
+}
+
+// testImageChannel 图片渠道的健康检查：熔断中的渠道不发出请求；适配器实现了 ImageChannelProber 时使用校验接口，
+// 否则发送最小尺寸的单张生成请求。测试结果计入熔断器，不记录消费日志
+func testImageChannel(c *gin.Context, ch *model.Channel, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request dto.Request) testResult {
+	probe := &imageProbeInfo{}
+	result := testResult{context: c, imageProbe: probe}
+	defer func() {
+		probe.CircuitState = getImageCircuitState(ch.Id)
+	}()
+	if !service.AllowImageChannel(ch.Id) {
+		err := fmt.Errorf("image channel #%d circuit breaker is open, test skipped", ch.Id)
+		result.localErr = err
+		result.newAPIError = types.NewErrorWithStatusCode(err, types.ErrorCodeImageCircuitOpen, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+		return result
+	}
+	adaptor.Init(info)
+
+	var newAPIError *types.NewAPIError
+	handled := false
+	if prober, ok := adaptor.(channel.ImageChannelProber); ok {
+		handled, newAPIError = prober.ProbeImageChannel(c, info)
+	}
+	if handled {
+		probe.Method = imageProbeMethodValidation
+	} else {
+		probe.Method = imageProbeMethodGeneration
+		newAPIError = doImageGenerationProbe(c, info, adaptor, request)
+	}
+	if newAPIError != nil {
+		// 只设置 newAPIError，测试结果仍返回耗时
+		service.RecordImageChannelResult(ch.Id, false)
+		result.newAPIError = newAPIError
+		return result
+	}
+	service.RecordImageChannelResult(ch.Id, true)
+	common.SysLog(fmt.Sprintf("testing image channel #%d with model %s passed (%s)", ch.Id, info.UpstreamModelName, probe.Method))
+	return result
+}
+
+// doImageGenerationProbe 以最便宜的参数发送一次生成请求：n=1、模型允许的最小尺寸
+func doImageGenerationProbe(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request dto.Request) *types.NewAPIError {
+	imageRequest, ok := request.(*dto.ImageRequest)
+	if !ok {
+		return types.NewError(errors.New("invalid image request type"), types.ErrorCodeConvertRequestFailed)
+	}
+	probeRequest := *imageRequest
+	probeRequest.N = 1
+	if size := smallestImageSize(info.OriginModelName); size != "" {
+		probeRequest.Size = size
+	}
+	convertedRequest, err := adaptor.ConvertImageRequest(c, info, probeRequest)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	if batch, ok := convertedRequest.(channel.ImageRequestBatch); ok && len(batch) > 0 {
+		convertedRequest = batch[0]
+	}
+	var requestBody io.Reader
+	switch body := convertedRequest.(type) {
+	case io.Reader:
+		requestBody = body
+	default:
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeJsonMarshalFailed)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	httpResp, _ := resp.(*http.Response)
+	if httpResp != nil && httpResp.StatusCode != http.StatusOK {
+		return service.RelayErrorHandler(c.Request.Context(), httpResp, true)
+	}
+	if _, newAPIError := adaptor.DoResponse(c, httpResp, info); newAPIError != nil {
+		return newAPIError
+	}
+	return nil
+}
+
+// smallestImageSize 返回模型允许的尺寸中面积最小的一个，未配置时返回空字符串
+func smallestImageSize(modelName string) string {
+	sizes, configured := model_setting.GetImageSettings().GetModelAllowedSizes(modelName)
+	if !configured {
+		return ""
+	}
+	smallest, smallestArea := "", 0
+	for _, size := range sizes {
+		w, h, ok := strings.Cut(size, "x")
+		if !ok {
+			continue
+		}
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if errW != nil || errH != nil {
+			continue
+		}
+		if area := width * height; smallest == "" || area < smallestArea {
+			smallest, smallestArea = size, area
+		}
+	}
+	return smallest
+}
+
+func getImageCircuitState(channelId int) string {
+	for _, state := range service.GetImageCircuitBreakerStates() {
+		if state.ChannelId == channelId {
+			return state.State
+		}
+	}
+	return service.ImageCircuitClosed
+}
+
+// withImageProbe 图片渠道的测试结果附带测试方式与熔断状态
+func withImageProbe(response gin.H, result testResult) gin.H {
+	if result.imageProbe != nil {
+		response["image_probe"] = result.imageProbe
+	}
+	return response
+}
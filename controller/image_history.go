@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetImageHistory 分页列出当前令牌用户的图片生成历史，参数 p 与 page_size，按时间倒序
+func GetImageHistory(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	histories, total, err := model.GetUserImageHistory(c.GetInt("id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		imageBatchError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":    "list",
+		"data":      histories,
+		"total":     total,
+		"page":      pageInfo.GetPage(),
+		"page_size": pageInfo.GetPageSize(),
+	})
+}
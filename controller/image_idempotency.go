@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageIdempotencyKeyHeader      = "Idempotency-Key"
+	imageIdempotencyReplayedHeader = "Idempotent-Replayed"
+	imageIdempotencyKeyMaxLength   = 255
+)
+
+// imageIdempotencyWriter 在写回客户端的同时记录响应，超过保存上限后停止记录
+type imageIdempotencyWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	maxBytes int
+	overflow bool
+}
+
+func (w *imageIdempotencyWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *imageIdempotencyWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *imageIdempotencyWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.maxBytes > 0 && w.body.Len()+len(data) > w.maxBytes {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+// withImageIdempotency 客户端携带 Idempotency-Key 时，相同令牌、接口与键的请求在 IdempotencyTTLSeconds 内只执行一次：
+// 已成功的请求直接重放保存的响应，不再生成和计费；相同键的请求正在执行时等待其完成。
+// 与结果缓存不同，去重完全由客户端的键决定，相同键对应不同请求体时返回 422
+func withImageIdempotency(c *gin.Context, next func()) {
+	settings := model_setting.GetImageSettings()
+	idempotencyKey := strings.TrimSpace(c.GetHeader(imageIdempotencyKeyHeader))
+	if !settings.IdempotencyEnabled || idempotencyKey == "" {
+		next()
+		return
+	}
+	if len(idempotencyKey) > imageIdempotencyKeyMaxLength {
+		imageBatchError(c, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", imageIdempotencyKeyMaxLength))
+		return
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		imageBatchError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	// 键按令牌隔离，避免不同租户使用相同的键时互相命中
+	key := fmt.Sprintf("%d:%s:%s", c.GetInt("token_id"), c.Request.URL.Path, idempotencyKey)
+	fingerprint := sha256.Sum256(body)
+	response, lease, err := service.AcquireImageIdempotency(c.Request.Context(), key, hex.EncodeToString(fingerprint[:]))
+	if err != nil {
+		if errors.Is(err, service.ErrImageIdempotencyKeyMismatch) {
+			imageBatchError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		imageBatchError(c, http.StatusRequestTimeout, "wait for request with the same Idempotency-Key failed: "+err.Error())
+		return
+	}
+	if response != nil {
+		logger.LogInfo(c, "image request replayed by Idempotency-Key")
+		for k, v := range response.Header {
+			c.Writer.Header()[k] = v
+		}
+		c.Writer.Header().Set(imageIdempotencyReplayedHeader, "true")
+		c.Data(response.StatusCode, response.Header.Get("Content-Type"), response.Body)
+		return
+	}
+
+	writer := &imageIdempotencyWriter{ResponseWriter: c.Writer, maxBytes: settings.IdempotencyMaxBodyBytes}
+	c.Writer = writer
+	completed := false
+	defer func() {
+		// 处理过程中 panic 时释放幂等键，等待者重新执行
+		if !completed {
+			lease.Complete(nil, 0)
+		}
+	}()
+	next()
+	c.Writer = writer.ResponseWriter
+
+	status := writer.Status()
+	if status != http.StatusOK && status != http.StatusAccepted {
+		lease.Complete(nil, 0)
+		completed = true
+		return
+	}
+	if writer.overflow {
+		logger.LogWarn(c, fmt.Sprintf("image response exceeds %d bytes, not saved for Idempotency-Key", settings.IdempotencyMaxBodyBytes))
+		lease.Complete(nil, 0)
+		completed = true
+		return
+	}
+	header := make(http.Header)
+	for _, name := range []string{"Content-Type", "Content-Encoding"} {
+		if value := writer.Header().Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	lease.Complete(&service.ImageIdempotentResponse{
+		StatusCode: status,
+		Header:     header,
+		Body:       writer.body.Bytes(),
+	}, time.Duration(settings.IdempotencyTTLSeconds)*time.Second)
+	completed = true
+}
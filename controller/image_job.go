@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageJobResponse 异步任务的状态，任务成功时 result 为同步接口的原始响应，失败时 error 为同步接口返回的错误
+type imageJobResponse struct {
+	Id          string          `json:"id"`
+	Object      string          `json:"object"`
+	Status      string          `json:"status"`
+	CreatedAt   int64           `json:"created_at"`
+	CompletedAt int64           `json:"completed_at,omitempty"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Billing     imageJobBilling `json:"billing"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       any             `json:"error,omitempty"`
+}
+
+// imageJobBilling 任务费用：完成前只有按计费档位折算的预估值，完成后返回实际扣费，失败的任务实际扣费为 0
+type imageJobBilling struct {
+	EstimatedQuota int      `json:"estimated_quota"`
+	EstimatedCost  float64  `json:"estimated_cost"` // 美元
+	FinalQuota     *int     `json:"final_quota,omitempty"`
+	FinalCost      *float64 `json:"final_cost,omitempty"`
+}
+
+func newImageJobBilling(snapshot *service.ImageJobSnapshot) imageJobBilling {
+	billing := imageJobBilling{
+		EstimatedQuota: snapshot.EstimatedQuota,
+		EstimatedCost:  float64(snapshot.EstimatedQuota) / common.QuotaPerUnit,
+	}
+	if snapshot.FinalQuota != nil {
+		finalCost := float64(*snapshot.FinalQuota) / common.QuotaPerUnit
+		billing.FinalQuota = snapshot.FinalQuota
+		billing.FinalCost = &finalCost
+	}
+	return billing
+}
+
+// wantsAsyncImageJob 客户端通过 Prefer: respond-async 请求异步执行
+func wantsAsyncImageJob(c *gin.Context) bool {
+	if !model_setting.GetImageSettings().AsyncJobEnabled {
+		return false
+	}
+	for _, preference := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+			return true
+		}
+	}
+	return false
+}
+
+// RelayImage 图片生成与编辑接口，请求异步执行时提交后台任务，否则按同步接口处理
+func RelayImage(engine *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		withImageIdempotency(c, func() {
+			if wantsAsyncImageJob(c) {
+				submitImageJob(c, engine)
+				return
+			}
+			Relay(c, types.RelayFormatOpenAIImage)
+		})
+	}
+}
+
+// submitImageJob 以内部请求的方式在后台调用同步接口，鉴权、选路、预扣费都与同步请求一致。
+// 请求通过校验并完成预扣费后返回 202 与 job_id，在此之前失败时直接返回同步接口的错误，不创建任务
+func submitImageJob(c *gin.Context, engine *gin.Engine) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		imageBatchError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	job, err := service.NewImageJob(c.GetInt("id"))
+	if err != nil {
+		imageBatchError(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	// 后台任务不随提交请求结束而取消，最长执行时间由 AsyncJobTimeoutSeconds 控制
+	timeout := time.Duration(model_setting.GetImageSettings().AsyncJobTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(service.WithImageJob(context.Background(), job), timeout)
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, c.Request.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		service.RemoveImageJob(job.Id)
+		imageBatchError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Del("Prefer")
+	// 幂等键已由提交请求处理，后台请求不再参与去重
+	req.Header.Del(imageIdempotencyKeyHeader)
+	req.Header.Del("Content-Length")
+	req.Header.Del("Content-Encoding")
+	// 任务结果以 JSON 嵌入查询响应，不使用 multipart、原始图片或压缩
+	req.Header.Del("Accept")
+	req.Header.Del("Accept-Encoding")
+	req.RemoteAddr = c.Request.RemoteAddr
+
+	go func() {
+		defer cancel()
+		defer func() {
+			if r := recover(); r != nil {
+				logger.LogError(ctx, fmt.Sprintf("image job %s panicked: %v", job.Id, r))
+				job.Complete(http.StatusInternalServerError, "application/json", []byte(`{"error":{"message":"image job panicked","type":"new_api_error"}}`))
+			}
+		}()
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		job.Complete(recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes())
+	}()
+
+	select {
+	case <-job.Accepted():
+		c.JSON(http.StatusAccepted, newImageJobResponse(job.Id, job.UserId))
+	case <-job.Done():
+		// 未进入后台执行（参数错误、额度不足或仅预估费用等），原样返回同步接口的响应
+		snapshot, err := service.GetImageJob(job.Id, job.UserId)
+		service.RemoveImageJob(job.Id)
+		if err != nil {
+			imageBatchError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(snapshot.StatusCode, snapshot.ContentType, snapshot.Body)
+	}
+}
+
+// GetImageJob 查询当前令牌用户的异步图片任务，完成后的结果保留 AsyncJobResultTTLSeconds 秒
+func GetImageJob(c *gin.Context) {
+	snapshot, err := service.GetImageJob(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrImageJobNotFound) {
+			status = http.StatusNotFound
+		}
+		imageBatchError(c, status, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, buildImageJobResponse(snapshot))
+}
+
+// newImageJobResponse 刚被接受的任务状态
+func newImageJobResponse(id string, userId int) imageJobResponse {
+	snapshot, err := service.GetImageJob(id, userId)
+	if err != nil {
+		return imageJobResponse{Id: id, Object: "image.job", Status: service.ImageJobStatusQueued, CreatedAt: time.Now().Unix()}
+	}
+	return buildImageJobResponse(snapshot)
+}
+
+func buildImageJobResponse(snapshot *service.ImageJobSnapshot) imageJobResponse {
+	response := imageJobResponse{
+		Id:        snapshot.Id,
+		Object:    "image.job",
+		Status:    snapshot.Status,
+		CreatedAt: snapshot.CreatedAt.Unix(),
+		Billing:   newImageJobBilling(snapshot),
+	}
+	if snapshot.CompletedAt.IsZero() {
+		return response
+	}
+	response.CompletedAt = snapshot.CompletedAt.Unix()
+	response.StatusCode = snapshot.StatusCode
+	if snapshot.StatusCode == http.StatusOK && json.Valid(snapshot.Body) {
+		response.Result = snapshot.Body
+		return response
+	}
+	var relayError struct {
+		Error any `json:"error"`
+	}
+	if err := common.Unmarshal(snapshot.Body, &relayError); err == nil && relayError.Error != nil {
+		response.Error = relayError.Error
+	} else {
+		response.Error = gin.H{"message": string(snapshot.Body), "type": "upstream_error"}
+	}
+	return response
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func pollImageJob(t *testing.T, job *service.ImageJob) map[string]any {
+	t.Helper()
+	response := serveTokenRequest(t, GetImageJob, http.MethodGet, "/v1/images/jobs/"+job.Id, "", job.UserId,
+		gin.Params{{Key: "id", Value: job.Id}})
+	billing, ok := response["billing"].(map[string]any)
+	if !ok {
+		t.Fatalf("polling response has no billing: %v", response)
+	}
+	return billing
+}
+
+func TestImageJobPollingReturnsEstimatedThenFinalCost(t *testing.T) {
+	job, err := service.NewImageJob(7)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	t.Cleanup(func() { service.RemoveImageJob(job.Id) })
+	estimate := int(0.04 * common.QuotaPerUnit)
+	job.SetEstimatedQuota(estimate)
+	job.Accept()
+
+	billing := pollImageJob(t, job)
+	if billing["estimated_quota"] != float64(estimate) || billing["estimated_cost"] != 0.04 {
+		t.Fatalf("unexpected estimate before completion: %v", billing)
+	}
+	if _, ok := billing["final_quota"]; ok {
+		t.Fatalf("final cost reported before completion: %v", billing)
+	}
+
+	// 实际只返回一张图片，按实际张数结算
+	final := estimate / 2
+	job.SetFinalQuota(final)
+	job.Complete(http.StatusOK, "application/json", []byte(`{"data":[{"url":"https://cdn.example.com/a.png"}]}`))
+
+	billing = pollImageJob(t, job)
+	if billing["estimated_quota"] != float64(estimate) {
+		t.Fatalf("estimate changed after completion: %v", billing)
+	}
+	if billing["final_quota"] != float64(final) || billing["final_cost"] != 0.02 {
+		t.Fatalf("unexpected final cost: %v", billing)
+	}
+}
+
+func TestFailedImageJobReportsZeroFinalCost(t *testing.T) {
+	job, err := service.NewImageJob(7)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	t.Cleanup(func() { service.RemoveImageJob(job.Id) })
+	job.SetEstimatedQuota(1000)
+	job.Accept()
+	job.Complete(http.StatusBadGateway, "application/json", []byte(`{"error":{"message":"upstream failed"}}`))
+
+	billing := pollImageJob(t, job)
+	if billing["final_quota"] != float64(0) || billing["final_cost"] != float64(0) {
+		t.Fatalf("failed job without settlement should cost nothing: %v", billing)
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageEditApiTypes 适配器实现了 /v1/images/edits 的接口类型
+var imageEditApiTypes = []int{
+	constant.APITypeOpenAI,
+	constant.APITypeAli,
+	constant.APITypeBaiduV2,
+	constant.APITypeVolcEngine,
+	constant.APITypeXai,
+	constant.APITypeStability,
+}
+
+type imageModelPricing struct {
+	ModelPrice     *float64           `json:"model_price,omitempty"`      // 按次计费的单张价格（美元），按 token 计费时省略
+	SizePriceTiers map[string]float64 `json:"size_price_tiers,omitempty"` // 尺寸/品质价格档位倍率
+}
+
+// imageModelCapability 图片模型的能力说明，尺寸、张数与价格档位取自请求校验与计费使用的同一份配置
+type imageModelCapability struct {
+	Id                 string            `json:"id"`
+	Object             string            `json:"object"`
+	SupportsGeneration bool              `json:"supports_generation"`
+	SupportsEdits      bool              `json:"supports_edits"`
+	SupportsVariations bool              `json:"supports_variations"`
+	Sizes              []string          `json:"sizes,omitempty"` // 未限制尺寸时省略
+	Qualities          []string          `json:"qualities,omitempty"`
+	MaxN               int               `json:"max_n,omitempty"` // 0 表示不限制
+	Pricing            imageModelPricing `json:"pricing"`
+}
+
+// ListImageModels 列出当前令牌可用的图片模型及其能力，按分组内已启用的渠道汇总，并按令牌的模型限制过滤
+func ListImageModels(c *gin.Context) {
+	groups, err := getTokenModelGroups(c)
+	if err != nil {
+		imageBatchError(c, http.StatusInternalServerError, "get user group failed")
+		return
+	}
+	abilities, err := model.GetAllEnableAbilityWithChannels()
+	if err != nil {
+		imageBatchError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var tokenModelLimit map[string]bool
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		tokenModelLimit = map[string]bool{}
+		if s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit); ok {
+			tokenModelLimit = s.(map[string]bool)
+		}
+	}
+
+	supportsEdits := make(map[string]bool)
+	for _, ability := range abilities {
+		if !slices.Contains(groups, ability.Group) {
+			continue
+		}
+		if tokenModelLimit != nil && !tokenModelLimit[ability.Model] {
+			continue
+		}
+		if !common.IsImageGenerationModel(ability.Model) && !slices.Contains(model.GetModelSupportEndpointTypes(ability.Model), constant.EndpointTypeImageGeneration) {
+			continue
+		}
+		apiType, _ := common.ChannelType2APIType(ability.ChannelType)
+		supportsEdits[ability.Model] = supportsEdits[ability.Model] || slices.Contains(imageEditApiTypes, apiType)
+	}
+
+	settings := model_setting.GetImageSettings()
+	items := make([]imageModelCapability, 0, len(supportsEdits))
+	for modelName, edits := range supportsEdits {
+		item := imageModelCapability{
+			Id:                 modelName,
+			Object:             "image_model",
+			SupportsGeneration: true,
+			SupportsEdits:      edits,
+			MaxN:               settings.GetModelMaxN(modelName),
+		}
+		if sizes, configured := settings.GetModelAllowedSizes(modelName); configured {
+			item.Sizes = sizes
+		}
+		if price, ok := ratio_setting.GetModelPrice(modelName, false); ok {
+			item.Pricing.ModelPrice = &price
+		}
+		if tiers := settings.SizePriceTiers[modelName]; len(tiers) > 0 {
+			item.Pricing.SizePriceTiers = tiers
+			item.Qualities = imageTierQualities(tiers)
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Id < items[j].Id })
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   items,
+	})
+}
+
+// getTokenModelGroups 返回令牌可使用的分组，auto 分组展开为用户的自动分组列表
+func getTokenModelGroups(c *gin.Context) ([]string, error) {
+	userGroup, err := model.GetUserGroup(c.GetInt("id"), false)
+	if err != nil {
+		return nil, err
+	}
+	group := userGroup
+	if tokenGroup := common.GetContextKeyString(c, constant.ContextKeyTokenGroup); tokenGroup != "" {
+		group = tokenGroup
+	}
+	if group == "auto" {
+		return service.GetUserAutoGroup(userGroup), nil
+	}
+	return []string{group}, nil
+}
+
+// imageTierQualities 从 "尺寸:品质" 形式的价格档位中提取品质取值
+func imageTierQualities(tiers map[string]float64) []string {
+	var qualities []string
+	for key := range tiers {
+		if _, quality, ok := strings.Cut(key, ":"); ok && quality != "" && !slices.Contains(qualities, quality) {
+			qualities = append(qualities, quality)
+		}
+	}
+	sort.Strings(qualities)
+	return qualities
+}
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetImageQueueStatus 返回当前节点全局图片并发的排队请求数与按最近请求耗时估算的排队时间，
+// 未配置 global_max_concurrent_images 时不排队，estimated_wait_ms 为 0
+func GetImageQueueStatus(c *gin.Context) {
+	stats := service.GetGlobalImageLimiterStats()
+	c.JSON(http.StatusOK, gin.H{
+		"object":              "image.queue",
+		"queue_enabled":       stats.MaxConcurrent > 0,
+		"queue_depth":         stats.Queued,
+		"in_flight":           stats.InFlight,
+		"max_concurrent":      stats.MaxConcurrent,
+		"average_duration_ms": stats.AverageDurationMs,
+		"estimated_wait_ms":   stats.EstimatedWaitMs,
+	})
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetImageModelStats 返回滚动窗口内各图片模型的生成成功与失败次数，与耗时统计相互独立；
+// result_cache 为结果缓存自进程启动以来的命中与未命中次数，circuit_breakers 为有失败记录的渠道熔断状态，
+// global_limiter 为全局图片并发的进行中与排队请求数
+func GetImageModelStats(c *gin.Context) {
+	settings := model_setting.GetImageSettings()
+	common.ApiSuccess(c, gin.H{
+		"enabled":          settings.ModelStatsEnabled,
+		"window_seconds":   settings.ModelStatsWindowSeconds,
+		"items":            service.GetImageModelStats(),
+		"result_cache":     service.GetImageResultCacheStats(),
+		"circuit_breakers": service.GetImageCircuitBreakerStates(),
+		"global_limiter":   service.GetGlobalImageLimiterStats(),
+	})
+}
+
+// GetImageMetrics 以 Prometheus 文本格式返回图片请求各阶段耗时直方图（按模型与渠道区分）、渠道熔断状态与全局并发
+func GetImageMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	service.WriteImagePhaseMetrics(c.Writer)
+	service.WriteImageCircuitBreakerMetrics(c.Writer)
+	service.WriteGlobalImageLimiterMetrics(c.Writer)
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetStoredImage 提供临时存储图片的访问，id 本身不可猜测，因此不需要鉴权
+func GetStoredImage(c *gin.Context) {
+	stored, err := service.GetImageStorage().Load(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "image not found or expired",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	maxAge := int(time.Until(stored.ExpiresAt).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	c.Header("ETag", stored.ETag)
+	if etagMatches(c.GetHeader("If-None-Match"), stored.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	contentType := stored.ContentType
+	if contentType == "" || contentType == service.ImageContentTypeUnknown {
+		// 早期写入的对象可能缺少正确的类型，按文件头重新识别
+		contentType = service.ImageContentType(c, stored.Data)
+	}
+	c.Data(http.StatusOK, contentType, stored.Data)
+}
+
+// etagMatches 按 If-None-Match 的弱比较规则判断客户端缓存是否仍然有效
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveStoredImage(id string, ifNoneMatch string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/v1/images/files/:id", GetStoredImage)
+	req := httptest.NewRequest(http.MethodGet, "/v1/images/files/"+id, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestStoredImageETag(t *testing.T) {
+	data := []byte("stored image etag test")
+	sum := sha256.Sum256(data)
+	want := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	settings := model_setting.GetImageSettings()
+	previous := settings.StorageDedupEnabled
+	t.Cleanup(func() { settings.StorageDedupEnabled = previous })
+	// 去重与非去重存储的 ETag 都是内容哈希
+	for _, dedup := range []bool{false, true} {
+		settings.StorageDedupEnabled = dedup
+		id, err := service.GetImageStorage().Save(data, "image/png", time.Minute, nil)
+		if err != nil {
+			t.Fatalf("dedup %v: save image: %v", dedup, err)
+		}
+		t.Cleanup(func() { _ = service.GetImageStorage().Delete(id) })
+
+		w := serveStoredImage(id, "")
+		if w.Code != http.StatusOK || w.Header().Get("ETag") != want || w.Body.String() != string(data) {
+			t.Fatalf("dedup %v: status %d, ETag %q, want 200 with %s", dedup, w.Code, w.Header().Get("ETag"), want)
+		}
+
+		// 命中任一候选（含弱校验与 *）时返回 304 且不带内容
+		for _, ifNoneMatch := range []string{want, `"other", ` + want, "W/" + want, "*"} {
+			w = serveStoredImage(id, ifNoneMatch)
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != want {
+				t.Fatalf("dedup %v: If-None-Match %s returned %d with %d bytes", dedup, ifNoneMatch, w.Code, w.Body.Len())
+			}
+		}
+		w = serveStoredImage(id, `"other"`)
+		if w.Code != http.StatusOK || w.Body.String() != string(data) {
+			t.Fatalf("dedup %v: stale If-None-Match returned %d", dedup, w.Code)
+		}
+	}
+}
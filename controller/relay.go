@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -20,6 +21,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -68,8 +70,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	originalModel := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
 
 	var (
-		newAPIError *types.NewAPIError
-		ws          *websocket.Conn
+		newAPIError    *types.NewAPIError
+		ws             *websocket.Conn
+		attempts       []relayAttempt
+		exposeAttempts bool
 	)
 
 	if relayFormat == types.RelayFormatOpenAIRealtime {
@@ -95,9 +99,18 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 					"error": newAPIError.ToClaudeError(),
 				})
 			default:
-				c.JSON(newAPIError.StatusCode, gin.H{
+				response := gin.H{
 					"error": newAPIError.ToOpenAIError(),
-				})
+				}
+				if exposeAttempts && len(attempts) > 0 {
+					response["attempts"] = summarizeRelayAttempts(attempts)
+				}
+				// 图片请求已经以 SSE 形式发送了保活注释或部分事件，错误作为最后一个事件返回
+				if c.GetBool(relay.ImageKeepAliveStartedKey) || (isImageRelayPath(c) && c.Writer.Written()) {
+					_ = helper.ObjectData(c, response)
+					return
+				}
+				c.JSON(newAPIError.StatusCode, response)
 			}
 		}
 	}()
@@ -114,6 +127,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		return
 	}
 
+	isImageRelay := relayInfo.RelayMode == relayconstant.RelayModeImagesGenerations || relayInfo.RelayMode == relayconstant.RelayModeImagesEdits
+	exposeAttempts = isImageRelay && relayInfo.TokenSetting.ImageAttemptHistory
+
 	meta := request.GetTokenCountMeta()
 
 	if setting.ShouldCheckPromptSensitive() {
@@ -143,6 +159,8 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	if priceData.FreeModel {
 		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
+	} else if isImageRelay && relay.IsImageEstimateRequest(c) {
+		logger.LogInfo(c, "仅预估图片费用，跳过预扣费")
 	} else {
 		newAPIError = service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo)
 		if newAPIError != nil {
@@ -161,11 +179,15 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		channel, err := getChannel(c, group, originalModel, i)
 		if err != nil {
 			logger.LogError(c, err.Error())
-			newAPIError = err
+			// 图片请求重试时没有其他可用渠道，返回上一次的上游错误
+			if !isImageRelay || newAPIError == nil {
+				newAPIError = err
+			}
 			break
 		}
 
 		addUsedChannel(c, channel.Id)
+		attemptStart := time.Now()
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
@@ -181,14 +203,34 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 
 		if newAPIError == nil {
+			service.BindImageAffinity(c, channel.Id)
 			return
 		}
+		service.ReleaseImageAffinity(c, channel.Id)
+		if isImageRelay {
+			attempts = append(attempts, relayAttempt{
+				ChannelId:  channel.Id,
+				Model:      relayInfo.UpstreamModelName,
+				StatusCode: newAPIError.StatusCode,
+				ErrorCode:  string(newAPIError.GetErrorCode()),
+				Error:      newAPIError.Error(),
+				DurationMs: time.Since(attemptStart).Milliseconds(),
+			})
+		}
 
 		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
 			break
 		}
+		// 渠道熔断时没有请求上游，直接切换渠道
+		if newAPIError.GetErrorCode() != types.ErrorCodeImageCircuitOpen && !waitImageRetryBackoff(c, i+1) {
+			break
+		}
+		if isImageRelay {
+			// 图片请求重试时切换到其他渠道
+			service.ExcludeChannel(c, channel.Id)
+		}
 	}
 
 	useChannel := c.GetStringSlice("use_channel")
@@ -196,6 +238,50 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
 		logger.LogInfo(c, retryLogStr)
 	}
+	if newAPIError != nil && len(attempts) > 1 {
+		for i, attempt := range attempts {
+			logger.LogWarn(c, fmt.Sprintf("image attempt %d/%d failed: channel #%d, model %s, status %d, code %s, %dms: %s",
+				i+1, len(attempts), attempt.ChannelId, attempt.Model, attempt.StatusCode, attempt.ErrorCode, attempt.DurationMs, attempt.Error))
+		}
+	}
+}
+
+// relayAttempt 一次失败的渠道尝试，完整信息只写入日志
+type relayAttempt struct {
+	ChannelId  int
+	Model      string
+	StatusCode int
+	ErrorCode  string
+	Error      string
+	DurationMs int64
+}
+
+// relayAttemptSummary 图片请求最终失败时按令牌配置（由管理员开启）返回给客户端的尝试记录，
+// 不包含渠道 ID、上游模型与上游原始错误，错误信息只保留状态码对应的通用描述
+type relayAttemptSummary struct {
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	ErrorCode  string `json:"error_code"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+func summarizeRelayAttempts(attempts []relayAttempt) []relayAttemptSummary {
+	summaries := make([]relayAttemptSummary, 0, len(attempts))
+	for i, attempt := range attempts {
+		message := http.StatusText(attempt.StatusCode)
+		if message == "" {
+			message = "request failed"
+		}
+		summaries = append(summaries, relayAttemptSummary{
+			Attempt:    i + 1,
+			StatusCode: attempt.StatusCode,
+			ErrorCode:  attempt.ErrorCode,
+			Error:      message,
+			DurationMs: attempt.DurationMs,
+		})
+	}
+	return summaries
 }
 
 var upgrader = websocket.Upgrader{
@@ -205,6 +291,35 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+func isImageRelayPath(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/v1/images/")
+}
+
+// waitImageRetryBackoff 图片请求重试前按失败渠道的退避配置（未配置时使用全局设置）等待，上游 429 返回的 retry-after 更长时以其为准，客户端已断开时放弃重试
+func waitImageRetryBackoff(c *gin.Context, attempt int) bool {
+	if !isImageRelayPath(c) {
+		return true
+	}
+	backoff := getDefaultImageRetryBackoff()
+	if channelSetting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting); ok && channelSetting.ImageRetryBackoff != nil {
+		backoff = channelSetting.ImageRetryBackoff
+	}
+	retryAfter, _ := c.Get(relay.ImageRetryAfterKey)
+	minDelay, _ := retryAfter.(time.Duration)
+	return service.WaitImageRetryDelay(c.Request.Context(), backoff, attempt, minDelay)
+}
+
+// getDefaultImageRetryBackoff 渠道未单独配置退避时使用全局图片设置
+func getDefaultImageRetryBackoff() *dto.ImageRetryBackoffSetting {
+	settings := model_setting.GetImageSettings()
+	return &dto.ImageRetryBackoffSetting{
+		BaseDelayMs: settings.RetryBaseDelayMs,
+		MaxDelayMs:  settings.RetryMaxDelayMs,
+		Multiplier:  settings.RetryMultiplier,
+		Jitter:      settings.RetryJitter,
+	}
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -225,7 +340,14 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	var channel *model.Channel
+	var selectGroup string
+	var err error
+	if isImageRelayPath(c) {
+		channel, selectGroup, err = service.CacheGetRandomSatisfiedImageChannel(c, group, originalModel)
+	} else {
+		channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	}
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
@@ -262,9 +384,9 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 		return true
 	}
 	if openaiErr.StatusCode/100 == 5 {
-		// 超时不重试
+		// 超时不重试，图片请求的 504 通常是上游网关的临时故障，换渠道重试（本地超时带有 skip retry，不会走到这里）
 		if openaiErr.StatusCode == 504 || openaiErr.StatusCode == 524 {
-			return false
+			return openaiErr.StatusCode == 504 && isImageRelayPath(c)
 		}
 		return true
 	}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSummarizeRelayAttemptsHidesInternalDetails(t *testing.T) {
+	attempts := []relayAttempt{
+		{ChannelId: 12, Model: "internal-upstream-model", StatusCode: http.StatusBadGateway, ErrorCode: "bad_response_status_code",
+			Error: "upstream https://secret.example.com/v1 said: account acct_123 suspended", DurationMs: 800},
+		{ChannelId: 34, Model: "internal-upstream-model", StatusCode: http.StatusTooManyRequests, ErrorCode: "rate_limit_exceeded",
+			Error: "channel #34 rate limited", DurationMs: 20},
+	}
+
+	summaries := summarizeRelayAttempts(attempts)
+	if len(summaries) != 2 {
+		t.Fatalf("got %d summaries, want 2", len(summaries))
+	}
+	if summaries[0].Attempt != 1 || summaries[1].Attempt != 2 {
+		t.Fatalf("attempts are not numbered in order: %+v", summaries)
+	}
+	if summaries[0].Error != http.StatusText(http.StatusBadGateway) || summaries[1].ErrorCode != "rate_limit_exceeded" {
+		t.Fatalf("unexpected summaries: %+v", summaries)
+	}
+
+	data, err := common.Marshal(summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"channel", "internal-upstream-model", "secret.example.com", "acct_123", "#34"} {
+		if strings.Contains(string(data), leaked) {
+			t.Fatalf("client attempt history leaks %q: %s", leaked, data)
+		}
+	}
+}
+
+func TestShouldRetryImageGatewayTimeouts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newContext := func(path string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, path, nil)
+		return c
+	}
+	upstream := types.NewErrorWithStatusCode(errors.New("upstream gateway timeout"), types.ErrorCodeBadResponseStatusCode, http.StatusGatewayTimeout)
+	local := types.NewErrorWithStatusCode(errors.New("image request timed out"), types.ErrorCodeImageRequestTimeout, http.StatusGatewayTimeout, types.ErrOptionWithSkipRetry())
+
+	if !shouldRetry(newContext("/v1/images/generations"), upstream, 2) {
+		t.Fatal("upstream 504 on an image request should be retried on another channel")
+	}
+	if shouldRetry(newContext("/v1/images/generations"), local, 2) {
+		t.Fatal("local image timeout must not be retried")
+	}
+	if shouldRetry(newContext("/v1/chat/completions"), upstream, 2) {
+		t.Fatal("504 on non-image requests is not retried")
+	}
+	if shouldRetry(newContext("/v1/images/generations"), upstream, 0) {
+		t.Fatal("no retries left")
+	}
+}
+
+func TestWaitImageRetryBackoffUsesChannelJitter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.RetryBaseDelayMs, settings.RetryMaxDelayMs, settings.RetryJitter = 5000, 5000, dto.ImageRetryJitterNone
+	t.Cleanup(func() { *settings = previous })
+	newContext := func(path string, backoff *dto.ImageRetryBackoffSetting) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, path, nil)
+		common.SetContextKey(c, constant.ContextKeyChannelSetting, dto.ChannelSettings{ImageRetryBackoff: backoff})
+		return c
+	}
+	measure := func(c *gin.Context, attempt int) (time.Duration, bool) {
+		start := time.Now()
+		ok := waitImageRetryBackoff(c, attempt)
+		return time.Since(start), ok
+	}
+
+	// 渠道配置的 equal 抖动优先于全局设置：第 2 次重试退避 80ms，等待时间落在 [40ms, 80ms]
+	channelBackoff := &dto.ImageRetryBackoffSetting{BaseDelayMs: 40, MaxDelayMs: 1000, Multiplier: 2, Jitter: dto.ImageRetryJitterEqual}
+	for i := 0; i < 3; i++ {
+		elapsed, ok := measure(newContext("/v1/images/generations", channelBackoff), 2)
+		if !ok || elapsed < 40*time.Millisecond || elapsed > 500*time.Millisecond {
+			t.Fatalf("channel backoff waited %v (ok %v), want within [40ms, 80ms]", elapsed, ok)
+		}
+	}
+
+	// 上游 retry-after 更长时以其为准
+	c := newContext("/v1/images/generations", channelBackoff)
+	c.Set(relay.ImageRetryAfterKey, 150*time.Millisecond)
+	if elapsed, ok := measure(c, 1); !ok || elapsed < 150*time.Millisecond {
+		t.Fatalf("retry-after wait %v (ok %v), want at least 150ms", elapsed, ok)
+	}
+
+	// 非图片请求不等待；客户端断开时放弃重试
+	if elapsed, ok := measure(newContext("/v1/chat/completions", channelBackoff), 2); !ok || elapsed > 20*time.Millisecond {
+		t.Fatalf("non-image request waited %v (ok %v)", elapsed, ok)
+	}
+	c = newContext("/v1/images/generations", nil)
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	cancel()
+	c.Request = c.Request.WithContext(ctx)
+	if elapsed, ok := measure(c, 1); ok || elapsed > time.Second {
+		t.Fatalf("cancelled request waited %v (ok %v), want immediate false", elapsed, ok)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
 
 	"github.com/gin-gonic/gin"
@@ -262,6 +263,44 @@ func UpdateToken(c *gin.Context) {
 	return
 }
 
+// UpdateTokenSetting 管理员修改令牌设置。设置中包含输出格式、频率、尺寸等限制与权限，
+// 用户的令牌接口不能写入，否则用户可以自行取消限制
+func UpdateTokenSetting(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	setting := dto.TokenSetting{}
+	if err := common.DecodeJson(c.Request.Body, &setting); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if setting.ImagesPerMinute < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "images_per_minute 不能为负数",
+		})
+		return
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	data, err := common.Marshal(setting)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token.Setting = string(data)
+	if err = token.UpdateSetting(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    setting,
+	})
+}
+
 type TokenBatch struct {
 	Ids []int `json:"ids"`
 }
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTokenTestDB 使用内存 SQLite 替换全局数据库，测试结束后还原
+func setupTokenTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err = db.AutoMigrate(&model.Token{}); err != nil {
+		t.Fatalf("migrate tokens: %v", err)
+	}
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = previous })
+}
+
+func serveTokenRequest(t *testing.T, handler gin.HandlerFunc, method, target string, body string, userId int, params gin.Params) map[string]any {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	c.Set("id", userId)
+	handler(c)
+	var response map[string]any
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response %q: %v", recorder.Body.String(), err)
+	}
+	return response
+}
+
+func createTestToken(t *testing.T, userId int, setting string) *model.Token {
+	t.Helper()
+	token := &model.Token{
+		UserId:         userId,
+		Name:           "limited",
+		Key:            "test-key-" + strconv.Itoa(userId),
+		Status:         common.TokenStatusEnabled,
+		ExpiredTime:    -1,
+		UnlimitedQuota: true,
+		Setting:        setting,
+	}
+	if err := token.Insert(); err != nil {
+		t.Fatalf("insert token: %v", err)
+	}
+	return token
+}
+
+func TestAddTokenIgnoresSetting(t *testing.T) {
+	setupTokenTestDB(t)
+	response := serveTokenRequest(t, AddToken, http.MethodPost, "/api/token/",
+		`{"name":"mine","expired_time":-1,"unlimited_quota":true,"setting":"{\"image_raw_output_allowed\":true,\"images_per_minute\":1000}"}`, 7, nil)
+	if response["success"] != true {
+		t.Fatalf("add token failed: %v", response)
+	}
+	var token model.Token
+	if err := model.DB.First(&token, "user_id = ?", 7).Error; err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	if token.Setting != "" {
+		t.Fatalf("user-created token kept setting %q", token.Setting)
+	}
+}
+
+func TestUpdateTokenKeepsAdminSetting(t *testing.T) {
+	setupTokenTestDB(t)
+	const adminSetting = `{"image_allowed_output_formats":["jpeg"],"image_output_format_policy":"reject","images_per_minute":5}`
+	token := createTestToken(t, 7, adminSetting)
+
+	body := `{"id":` + strconv.Itoa(token.Id) + `,"name":"renamed","expired_time":-1,"unlimited_quota":true,"setting":""}`
+	response := serveTokenRequest(t, UpdateToken, http.MethodPut, "/api/token/", body, 7, nil)
+	if response["success"] != true {
+		t.Fatalf("update token failed: %v", response)
+	}
+	updated, err := model.GetTokenById(token.Id)
+	if err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	if updated.Name != "renamed" {
+		t.Fatalf("name = %q, want renamed", updated.Name)
+	}
+	if updated.Setting != adminSetting {
+		t.Fatalf("user update changed setting to %q", updated.Setting)
+	}
+	setting := updated.GetSetting()
+	if setting.ImagesPerMinute != 5 || setting.ImageOutputFormatPolicy != "reject" {
+		t.Fatalf("unexpected setting after user update: %+v", setting)
+	}
+}
+
+func TestUpdateTokenSettingWritesSetting(t *testing.T) {
+	setupTokenTestDB(t)
+	token := createTestToken(t, 7, "")
+
+	params := gin.Params{{Key: "id", Value: strconv.Itoa(token.Id)}}
+	response := serveTokenRequest(t, UpdateTokenSetting, http.MethodPut, "/api/token/"+strconv.Itoa(token.Id)+"/setting",
+		`{"images_per_minute":3,"image_attempt_history":true}`, 1, params)
+	if response["success"] != true {
+		t.Fatalf("update token setting failed: %v", response)
+	}
+	updated, err := model.GetTokenById(token.Id)
+	if err != nil {
+		t.Fatalf("load token: %v", err)
+	}
+	setting := updated.GetSetting()
+	if setting.ImagesPerMinute != 3 || !setting.ImageAttemptHistory {
+		t.Fatalf("unexpected setting: %+v", setting)
+	}
+
+	response = serveTokenRequest(t, UpdateTokenSetting, http.MethodPut, "/api/token/"+strconv.Itoa(token.Id)+"/setting",
+		`{"images_per_minute":-1}`, 1, params)
+	if response["success"] != false {
+		t.Fatalf("negative images_per_minute was accepted: %v", response)
+	}
+}
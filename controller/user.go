@@ -1109,6 +1109,7 @@ type UpdateUserSettingRequest struct {
 	GotifyPriority             int     `json:"gotify_priority,omitempty"`
 	AcceptUnsetModelRatioModel bool    `json:"accept_unset_model_ratio_model"`
 	RecordIpLog                bool    `json:"record_ip_log"`
+	PreferredImageChannelId    int     `json:"preferred_image_channel_id,omitempty"`
 }
 
 func UpdateUserSetting(c *gin.Context) {
@@ -1246,6 +1247,11 @@ func UpdateUserSetting(c *gin.Context) {
 		RecordIpLog:           req.RecordIpLog,
 	}
 
+	// 图片偏好渠道为软偏好，无效渠道在选择时会被忽略
+	if req.PreferredImageChannelId > 0 {
+		settings.PreferredImageChannelId = req.PreferredImageChannelId
+	}
+
 	// 如果是webhook类型,添加webhook相关设置
 	if req.QuotaWarningType == dto.NotifyTypeWebhook {
 		settings.WebhookUrl = req.WebhookUrl
@@ -0,0 +1,131 @@
+package dto
+
+// ImagePromptClassifierSetting 图片提示词分类过滤配置
+// 通过 OpenAI 兼容的 chat/completions 接口对提示词进行分类，不在允许列表中的分类将被拒绝
+type ImagePromptClassifierSetting struct {
+	Enabled           bool     `json:"enabled"`
+	BaseUrl           string   `json:"base_url"` // 分类接口完整地址，例如 https://api.openai.com/v1/chat/completions
+	ApiKey            string   `json:"api_key,omitempty"`
+	Model             string   `json:"model"`
+	Categories        []string `json:"categories,omitempty"` // 候选分类，留空时使用允许分类 + other
+	AllowedCategories []string `json:"allowed_categories"`
+	TimeoutSeconds    int      `json:"timeout_seconds,omitempty"` // 分类超时时间，默认 5 秒
+	FailOpen          bool     `json:"fail_open,omitempty"`       // 分类失败时是否放行，默认拒绝
+}
+
+// 图片审核命中后的处理方式
+const (
+	ImageModerationPolicyFail = "fail" // 任意一张命中即整体失败并退款
+	ImageModerationPolicyDrop = "drop" // 只移除命中的图片，按实际返回张数计费；全部命中时整体失败
+)
+
+// ImageModerationSetting 生成结果审核配置，返回给客户端之前把每张图片（base64 或 url）提交到审核接口。
+// 审核接口接收 {"model","type","image"}，type 为 b64_json 或 url，返回 {"flagged": bool, "categories": [...]}
+type ImageModerationSetting struct {
+	Enabled        bool   `json:"enabled"`
+	Endpoint       string `json:"endpoint"`
+	ApiKey         string `json:"api_key,omitempty"`
+	Policy         string `json:"policy,omitempty"`          // fail、drop，默认 fail
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // 整个审核过程的超时时间，默认 10 秒
+	FailOpen       bool   `json:"fail_open,omitempty"`       // 审核接口失败或超时时是否放行，默认拒绝
+}
+
+// ImageSlowRefundSetting 图片生成耗时超过阈值时按比例退还费用
+type ImageSlowRefundSetting struct {
+	ThresholdSeconds int     `json:"threshold_seconds"`
+	RefundPercent    float64 `json:"refund_percent"` // 退还比例，0-100
+}
+
+// ImageProfileSetting 命名的图片生成档位，客户端通过 profile 字段选择，由网关展开为具体参数
+type ImageProfileSetting struct {
+	Model   string         `json:"model,omitempty"` // 上游模型，留空时沿用映射后的模型
+	Size    string         `json:"size,omitempty"`
+	Quality string         `json:"quality,omitempty"`
+	N       uint           `json:"n,omitempty"`
+	Params  map[string]any `json:"params,omitempty"` // 其他透传参数，例如 steps
+}
+
+// 上游标记图片为 NSFW 时的处理方式
+const (
+	ImageNsfwActionPass  = "pass"  // 原样返回，并在 data[] 中标记 nsfw
+	ImageNsfwActionBlock = "block" // 拒绝整个响应，不计费
+	ImageNsfwActionBlur  = "blur"  // 服务端模糊被标记的图片后返回
+)
+
+// 动图输出转为静态图时选取的帧
+const (
+	ImageStaticFrameFirst  = "first"  // 第一帧
+	ImageStaticFrameMiddle = "middle" // 中间一帧
+)
+
+// 输出图片超过最大尺寸时的处理方式
+const (
+	ImageMaxOutputPolicyReject    = "reject"    // 请求尺寸超限时直接拒绝
+	ImageMaxOutputPolicyDownscale = "downscale" // 生成后等比缩小到限制以内
+)
+
+// ImageMaxOutputSetting 渠道输出图片的最大宽高，0 表示不限制
+type ImageMaxOutputSetting struct {
+	MaxWidth  int    `json:"max_width"`
+	MaxHeight int    `json:"max_height"`
+	Policy    string `json:"policy"` // reject 或 downscale，默认 reject
+}
+
+// ImageWebpQualitySetting 服务端编码 WebP 时的质量（1-100，100 为无损）。开启自动调整时按图片细节在
+// [MinQuality, MaxQuality] 内选择：细节丰富的图片使用较低质量，平滑图片使用较高质量避免色带
+type ImageWebpQualitySetting struct {
+	Auto       bool `json:"auto"`
+	MinQuality int  `json:"min_quality,omitempty"` // 默认 60
+	MaxQuality int  `json:"max_quality,omitempty"` // 默认 100
+	Quality    int  `json:"quality,omitempty"`     // 固定质量，未开启自动调整或分析失败时使用，默认 100
+}
+
+// ImageComplexityRoutingSetting 按提示词复杂度选择上游模型，简单提示词使用便宜的模型
+type ImageComplexityRoutingSetting struct {
+	Enabled    bool                  `json:"enabled"`
+	Keywords   []string              `json:"keywords,omitempty"`    // 命中后提高复杂度的关键词，例如 "photorealistic"、"detailed"
+	FullLength int                   `json:"full_length,omitempty"` // 达到该字符数时长度得分取满分，默认 400
+	Tiers      []ImageComplexityTier `json:"tiers"`                 // 按 MaxScore 从小到大匹配，均不满足时使用最后一档
+}
+
+// ImageComplexityTier 复杂度得分不超过 MaxScore（0-100）时使用的上游模型
+type ImageComplexityTier struct {
+	MaxScore float64 `json:"max_score"`
+	Model    string  `json:"model"`
+}
+
+// ImageRateSmoothingSetting 平滑发往上游的图片请求速率，避免突发请求触发上游限流
+type ImageRateSmoothingSetting struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	MaxWaitSeconds    int     `json:"max_wait_seconds,omitempty"` // 最长排队时间，超过时返回 503，默认 10 秒
+}
+
+// 图片请求重试退避的抖动方式
+const (
+	ImageRetryJitterNone  = "none"  // 固定指数退避
+	ImageRetryJitterFull  = "full"  // 在 [0, 退避时间] 内随机
+	ImageRetryJitterEqual = "equal" // 在 [退避时间/2, 退避时间] 内随机
+)
+
+// ImageRetryBackoffSetting 图片请求失败后切换渠道重试前的等待时间，第 n 次重试的退避时间为
+// min(MaxDelayMs, BaseDelayMs × Multiplier^(n-1))，再按抖动方式随机化，避免上游恢复时客户端同时重试
+type ImageRetryBackoffSetting struct {
+	BaseDelayMs int     `json:"base_delay_ms"`
+	MaxDelayMs  int     `json:"max_delay_ms,omitempty"` // 默认 10000
+	Multiplier  float64 `json:"multiplier,omitempty"`   // 每次重试的退避倍数，默认 2
+	Jitter      string  `json:"jitter,omitempty"`       // none、full、equal，默认 full
+}
+
+// ImageContinuationSetting 上游在响应头中返回后续结果地址时，继续拉取剩余图片并合并到同一响应
+type ImageContinuationSetting struct {
+	Header   string `json:"header"`              // 后续结果地址所在的响应头，值为完整地址或相对渠道 base url 的路径
+	MaxPages int    `json:"max_pages,omitempty"` // 最多额外拉取的次数，默认 5
+}
+
+// ImagePromptInjectionSetting 转发前在提示词前后追加的固定文本（例如微调模型需要的风格前缀），
+// 只发往上游，不出现在返回给客户端的响应与消费日志中
+type ImagePromptInjectionSetting struct {
+	Prefix    string `json:"prefix,omitempty"`
+	Suffix    string `json:"suffix,omitempty"`
+	MaxLength int    `json:"max_length,omitempty"` // 拼接后提示词的最大字符数，超出时截断客户端提示词，0 表示不限制
+}
@@ -7,6 +7,46 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+
+	ImagePromptClassifier           *ImagePromptClassifierSetting  `json:"image_prompt_classifier,omitempty"`            // 图片提示词分类过滤
+	ImagePreferWebp                 bool                           `json:"image_prefer_webp,omitempty"`                  // 客户端支持 WebP 且未指定格式时优先返回 WebP（需要时由服务端转换），否则返回 PNG
+	ImageWebpQuality                *ImageWebpQualitySetting       `json:"image_webp_quality,omitempty"`                 // 服务端转换为 WebP 时的编码质量与按内容自动调整，留空时无损编码
+	ImageAuthHeader                 string                         `json:"image_auth_header,omitempty"`                  // 图片请求鉴权头模板，例如 "x-api-key: {api_key}"
+	ImageUserAgent                  string                         `json:"image_user_agent,omitempty"`                   // 图片请求使用的 User-Agent，留空保持默认
+	ImageSafetyRatings              bool                           `json:"image_safety_ratings,omitempty"`               // 返回上游提供的图片安全评分
+	ImageSafetyThresholds           map[string]float64             `json:"image_safety_thresholds,omitempty"`            // 分类评分阈值，任一图片超过阈值时拒绝整个响应
+	ImageInputAsUrl                 bool                           `json:"image_input_as_url,omitempty"`                 // 上游仅接受图片 URL 时，将 base64 参考图转存为临时地址
+	ImageStorageTTLSeconds          int                            `json:"image_storage_ttl_seconds,omitempty"`          // 临时图片保留时间，留空使用全局配置，同时作为请求覆盖的上限
+	ImageAllowedSizes               []string                       `json:"image_allowed_sizes,omitempty"`                // 允许的尺寸或宽高比白名单，例如 "1024x1024"、"16:9"
+	ImageQualityMapping             map[string]string              `json:"image_quality_mapping,omitempty"`              // 客户端 quality 到上游取值的映射，例如 {"hd": "high"}
+	ImageStyleReferenceField        string                         `json:"image_style_reference_field,omitempty"`        // 上游接收风格参考图的字段名，留空表示不支持
+	ImageSlowRefund                 *ImageSlowRefundSetting        `json:"image_slow_refund,omitempty"`                  // 生成过慢时自动退还部分费用
+	ImageProfiles                   map[string]ImageProfileSetting `json:"image_profiles,omitempty"`                     // 命名的质量/速度档位，例如 fast、balanced、best
+	ImageComplexityRouting          *ImageComplexityRoutingSetting `json:"image_complexity_routing,omitempty"`           // 按提示词复杂度选择上游模型
+	ImageRateSmoothing              *ImageRateSmoothingSetting     `json:"image_rate_smoothing,omitempty"`               // 按渠道平滑发往上游的请求速率
+	ImageNsfwAction                 string                         `json:"image_nsfw_action,omitempty"`                  // 上游标记 NSFW 时的处理方式：pass、block、blur，留空不处理
+	ImageMaxOutput                  *ImageMaxOutputSetting         `json:"image_max_output,omitempty"`                   // 输出图片最大宽高，超限时拒绝或缩小
+	ImageRetryBackoff               *ImageRetryBackoffSetting      `json:"image_retry_backoff,omitempty"`                // 该渠道请求失败后重试前的退避与抖动
+	ImageContinuation               *ImageContinuationSetting      `json:"image_continuation,omitempty"`                 // 按响应头中的后续地址拉取剩余图片
+	ImageCoalesceWindow             *int                           `json:"image_coalesce_window,omitempty"`              // 相同编辑请求的合并窗口（秒），上游返回后在窗口内复用结果；0 表示该渠道不合并，留空只合并进行中的请求
+	ImageStaticFrame                string                         `json:"image_static_frame,omitempty"`                 // 上游返回动图（GIF、动画 WebP）时转为静态 PNG：first、middle，留空不转换
+	ImageKeepAliveSeconds           int                            `json:"image_keep_alive_seconds,omitempty"`           // 客户端接受 SSE 时，等待上游超过该秒数后开始定期发送 ": keep-alive" 注释，0 表示关闭
+	ImageRejectTruncatedPrompt      bool                           `json:"image_reject_truncated_prompt,omitempty"`      // 上游声明截断了提示词时拒绝结果并退还费用，默认仅返回警告
+	ImageStreamToStorage            bool                           `json:"image_stream_to_storage,omitempty"`            // 上游响应边读取边写入图片存储，b64_json 替换为 url，避免大图整体驻留内存
+	MaxConcurrentImages             int                            `json:"max_concurrent_images,omitempty"`              // 该渠道同时进行的图片请求上限（当前节点内），0 表示不限制
+	ImageConcurrencyWaitSeconds     int                            `json:"image_concurrency_wait_seconds,omitempty"`     // 并发已满时的最长排队时间，默认 30 秒
+	ImagePreserveContentCredentials bool                           `json:"image_preserve_content_credentials,omitempty"` // 后处理重新编码图片时保留上游的内容凭证（C2PA），无法保留时返回上游原图
+	ImageModelFallbacks             map[string][]string            `json:"image_model_fallbacks,omitempty"`              // 按请求模型配置的上游模型回退列表，上游返回模型不存在时在同一渠道依次尝试下一个，优先于模型映射
+	ImageTimeoutSeconds             int                            `json:"image_timeout_seconds,omitempty"`              // 图片请求超时（秒），覆盖整个上游请求且不受全局 RELAY_TIMEOUT 限制，0 表示使用模型配置或全局超时
+	ImageMaxUploadFileBytes         int64                          `json:"image_max_upload_file_bytes,omitempty"`        // 编辑请求单个上传文件（图片与蒙版）的最大字节数，0 表示不限制
+	ImageMaxUploadTotalBytes        int64                          `json:"image_max_upload_total_bytes,omitempty"`       // 编辑请求所有上传文件的总字节数上限，0 表示不限制
+	ImageEditPngOnly                bool                           `json:"image_edit_png_only,omitempty"`                // 上游编辑接口只接受 PNG，其他格式的输入图片与蒙版转码为 PNG 后再转发
+	ImageModeration                 *ImageModerationSetting        `json:"image_moderation,omitempty"`                   // 生成结果审核，命中时按策略移除图片或整体失败并退款
+	ImagePromptInjection            *ImagePromptInjectionSetting   `json:"image_prompt_injection,omitempty"`             // 转发前在提示词前后追加的固定文本，客户端提示词为空时不追加
+	ImageDisableUsageNFallback      bool                           `json:"image_disable_usage_n_fallback,omitempty"`     // 上游未返回用量时不再按张数填充 token，用量为 0 时不计费；上游按 token 返回真实用量的渠道应开启
+	ImageHttpClientProfile          string                         `json:"image_http_client_profile,omitempty"`          // 图片请求使用的 HTTP 客户端配置名（见图片设置 http_client_profiles），优先于按模型配置
+	MockLatencyMs                   int                            `json:"mock_latency_ms,omitempty"`                    // Mock 渠道模拟的上游延迟（毫秒）
+	MockErrorRate                   float64                        `json:"mock_error_rate,omitempty"`                    // Mock 渠道返回上游错误的概率（0-1）
 }
 
 type VertexKeyType string
@@ -332,6 +332,8 @@ type GeminiImageParameters struct {
 	AspectRatio      string `json:"aspectRatio,omitempty"`
 	PersonGeneration string `json:"personGeneration,omitempty"`
 	ImageSize        string `json:"imageSize,omitempty"`
+	// 返回每张图片的安全分类评分
+	IncludeSafetyAttributes bool `json:"includeSafetyAttributes,omitempty"`
 }
 
 type GeminiImageResponse struct {
@@ -339,10 +341,16 @@ type GeminiImageResponse struct {
 }
 
 type GeminiImagePrediction struct {
-	MimeType           string `json:"mimeType"`
-	BytesBase64Encoded string `json:"bytesBase64Encoded"`
-	RaiFilteredReason  string `json:"raiFilteredReason,omitempty"`
-	SafetyAttributes   any    `json:"safetyAttributes,omitempty"`
+	MimeType           string                       `json:"mimeType"`
+	BytesBase64Encoded string                       `json:"bytesBase64Encoded"`
+	RaiFilteredReason  string                       `json:"raiFilteredReason,omitempty"`
+	Prompt             string                       `json:"prompt,omitempty"` // 开启提示词增强时返回改写后的提示词
+	SafetyAttributes   *GeminiImageSafetyAttributes `json:"safetyAttributes,omitempty"`
+}
+
+type GeminiImageSafetyAttributes struct {
+	Categories []string  `json:"categories,omitempty"`
+	Scores     []float64 `json:"scores,omitempty"`
 }
 
 // Embedding related structs
@@ -22,13 +22,17 @@ type ImageRequest struct {
 	User              json.RawMessage `json:"user,omitempty"`
 	ExtraFields       json.RawMessage `json:"extra_fields,omitempty"`
 	Background        json.RawMessage `json:"background,omitempty"`
+	InputFidelity     json.RawMessage `json:"input_fidelity,omitempty"`
 	Moderation        json.RawMessage `json:"moderation,omitempty"`
 	OutputFormat      json.RawMessage `json:"output_format,omitempty"`
 	OutputCompression json.RawMessage `json:"output_compression,omitempty"`
 	PartialImages     json.RawMessage `json:"partial_images,omitempty"`
-	// Stream            bool            `json:"stream,omitempty"`
-	Watermark *bool           `json:"watermark,omitempty"`
-	Image     json.RawMessage `json:"image,omitempty"`
+	Stream            *bool           `json:"stream,omitempty"`
+	Watermark         *bool           `json:"watermark,omitempty"`
+	Seed              json.RawMessage `json:"seed,omitempty"`
+	Image             json.RawMessage `json:"image,omitempty"`
+	// 风格参考图（URL 或 base64），由渠道配置决定转发给上游的字段名
+	StyleReference json.RawMessage `json:"style_reference,omitempty"`
 	// 用匿名参数接收额外参数
 	Extra map[string]json.RawMessage `json:"-"`
 }
@@ -171,5 +175,8 @@ type ImageResponse struct {
 type ImageData struct {
 	Url           string `json:"url"`
 	B64Json       string `json:"b64_json"`
-	RevisedPrompt string `json:"revised_prompt"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"` // 上游改写后的提示词，未提供时省略
+	// SafetyRatings 上游返回的安全分类评分，键为 "<provider>.<category>"
+	SafetyRatings map[string]float64 `json:"safety_ratings,omitempty"`
+	Seed          *int64             `json:"seed,omitempty"` // 上游实际使用的随机种子，未返回时省略
 }
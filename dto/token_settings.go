@@ -0,0 +1,20 @@
+package dto
+
+const (
+	ImageFormatPolicyCoerce = "coerce" // 将不允许的格式替换为允许列表中的第一个
+	ImageFormatPolicyReject = "reject" // 拒绝请求
+)
+
+// TokenSetting 令牌的图片限制与权限，只能由管理员通过 PUT /api/token/:id/setting 修改
+type TokenSetting struct {
+	ImageAllowedOutputFormats []string               `json:"image_allowed_output_formats,omitempty"` // ImageAllowedOutputFormats 允许的图片输出格式，留空不限制
+	ImageOutputFormatPolicy   string                 `json:"image_output_format_policy,omitempty"`   // ImageOutputFormatPolicy 请求不允许的格式时的处理方式：coerce（默认）/ reject
+	ImageExposeCost           bool                   `json:"image_expose_cost,omitempty"`            // ImageExposeCost 是否在图片响应头中返回本次实际扣除的额度
+	ImageServerTiming         bool                   `json:"image_server_timing,omitempty"`          // ImageServerTiming 是否在图片响应中返回 Server-Timing 阶段耗时
+	ImageStorageTTLOverride   bool                   `json:"image_storage_ttl_override,omitempty"`   // ImageStorageTTLOverride 是否允许通过 storage_ttl 字段缩短临时图片保留时间
+	ImageRawOutputAllowed     bool                   `json:"image_raw_output_allowed,omitempty"`     // ImageRawOutputAllowed 是否允许通过 X-New-Api-Raw-Output 请求头跳过可选的后处理步骤
+	ImageAttemptHistory       bool                   `json:"image_attempt_history,omitempty"`        // ImageAttemptHistory 图片请求最终失败时是否在错误响应中返回每次尝试的状态码、错误码与耗时，渠道与上游错误只写入日志
+	ImageMaxOutput            *ImageMaxOutputSetting `json:"image_max_output,omitempty"`             // ImageMaxOutput 该令牌可生成的最大宽高，超限时拒绝或缩小，独立于渠道限制
+	ImageReproBundleAllowed   bool                   `json:"image_repro_bundle_allowed,omitempty"`   // ImageReproBundleAllowed 是否允许通过 X-New-Api-Repro-Bundle 请求头获取签名的可复现性包
+	ImagesPerMinute           int                    `json:"images_per_minute,omitempty"`            // ImagesPerMinute 该令牌每分钟最多生成的图片张数，独立于对话接口的频率限制，0 表示不限制
+}
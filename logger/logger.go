@@ -8,6 +8,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -54,6 +56,9 @@ func SetupLogger() {
 	}
 }
 
+// Fields 结构化日志字段，LogFormat 为 json 时作为独立字段输出，否则以 key=value 追加在消息后
+type Fields map[string]any
+
 func LogInfo(ctx context.Context, msg string) {
 	logHelper(ctx, loggerINFO, msg)
 }
@@ -73,7 +78,19 @@ func LogDebug(ctx context.Context, msg string, args ...any) {
 	}
 }
 
+func LogInfoWithFields(ctx context.Context, msg string, fields Fields) {
+	logHelperWithFields(ctx, loggerINFO, msg, fields)
+}
+
+func LogWarnWithFields(ctx context.Context, msg string, fields Fields) {
+	logHelperWithFields(ctx, loggerWarn, msg, fields)
+}
+
 func logHelper(ctx context.Context, level string, msg string) {
+	logHelperWithFields(ctx, level, msg, nil)
+}
+
+func logHelperWithFields(ctx context.Context, level string, msg string, fields Fields) {
 	writer := gin.DefaultErrorWriter
 	if level == loggerINFO {
 		writer = gin.DefaultWriter
@@ -83,7 +100,40 @@ func logHelper(ctx context.Context, level string, msg string) {
 		id = "SYSTEM"
 	}
 	now := time.Now()
+	if common.LogFormat == "json" {
+		entry := make(map[string]any, len(fields)+4)
+		for k, v := range fields {
+			entry[k] = v
+		}
+		entry["level"] = level
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["request_id"] = id
+		entry["msg"] = msg
+		line, err := common.Marshal(entry)
+		if err == nil {
+			_, _ = fmt.Fprintf(writer, "%s\n", line)
+			countLog()
+			return
+		}
+	}
+	if len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var sb strings.Builder
+		sb.WriteString(msg)
+		for _, k := range keys {
+			sb.WriteString(fmt.Sprintf(" %s=%v", k, fields[k]))
+		}
+		msg = sb.String()
+	}
 	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", level, now.Format("2006/01/02 - 15:04:05"), id, msg)
+	countLog()
+}
+
+func countLog() {
 	logCount++ // we don't need accurate count, so no lock here
 	if logCount > maxLogCount && !setupLogWorking {
 		logCount = 0
@@ -58,12 +58,12 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+func getPriority(group string, model string, retry int, excluded []int) (int, error) {
 
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := excludeAbilityChannels(DB.Model(&Ability{}).
 		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
+		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true), excluded).
 		Order("priority DESC").              // 按优先级降序排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
 
@@ -73,6 +73,9 @@ func getPriority(group string, model string, retry int) (int, error) {
 	}
 
 	if len(priorities) == 0 {
+		if len(excluded) > 0 {
+			return 0, errors.New("排除指定渠道后没有可用渠道")
+		}
 		// 如果没有查询到优先级，则返回错误
 		return 0, errors.New("数据库一致性被破坏")
 	}
@@ -88,11 +91,19 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
+// excludeAbilityChannels 过滤掉请求中排除的渠道
+func excludeAbilityChannels(query *gorm.DB, excluded []int) *gorm.DB {
+	if len(excluded) == 0 {
+		return query
+	}
+	return query.Where("channel_id not in (?)", excluded)
+}
+
+func getChannelQuery(group string, model string, retry int, excluded []int) (*gorm.DB, error) {
+	maxPrioritySubQuery := excludeAbilityChannels(DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true), excluded)
 	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, model, retry, excluded)
 		if err != nil {
 			return nil, err
 		} else {
@@ -100,14 +111,18 @@ func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
 		}
 	}
 
-	return channelQuery, nil
+	return excludeAbilityChannels(channelQuery, excluded), nil
 }
 
-func GetChannel(group string, model string, retry int) (*Channel, error) {
+func GetChannel(group string, model string, retry int, excluded map[int]bool) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
+	excludedIds := make([]int, 0, len(excluded))
+	for id := range excluded {
+		excludedIds = append(excludedIds, id)
+	}
+	channelQuery, err := getChannelQuery(group, model, retry, excludedIds)
 	if err != nil {
 		return nil, err
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -93,10 +94,11 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// GetRandomSatisfiedChannel excluded 中的渠道不参与选择，排除后没有可用渠道时返回 nil
+func GetRandomSatisfiedChannel(group string, model string, retry int, excluded map[int]bool) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, retry, excluded)
 	}
 
 	channelSyncLock.RLock()
@@ -111,6 +113,12 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	if len(excluded) > 0 {
+		channels = slices.DeleteFunc(slices.Clone(channels), func(id int) bool {
+			return excluded[id]
+		})
+	}
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
@@ -0,0 +1,31 @@
+package model
+
+// ImageHistory 用户的一次成功图片生成，开启 image_setting.history_enabled 后写入
+type ImageHistory struct {
+	Id         int64     `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
+	UserId     int       `json:"-" gorm:"index:idx_image_history_user_created,priority:1"`
+	CreatedAt  int64     `json:"created_at" gorm:"bigint;index:idx_image_history_user_created,priority:2"`
+	TokenId    int       `json:"token_id"`
+	RequestId  string    `json:"request_id" gorm:"type:varchar(64)"`
+	Model      string    `json:"model" gorm:"type:varchar(128)"`
+	Size       string    `json:"size" gorm:"type:varchar(32)"`
+	Quality    string    `json:"quality" gorm:"type:varchar(32)"`
+	N          int       `json:"n"`
+	PromptHash string    `json:"prompt_hash" gorm:"type:varchar(64)"`
+	Prompt     string    `json:"prompt,omitempty" gorm:"type:text"` // 仅在 history_store_prompt 开启时保存
+	Urls       JSONValue `json:"urls,omitempty" gorm:"type:json"`   // 使用图片存储地址返回时的图片链接
+}
+
+func InsertImageHistory(history *ImageHistory) error {
+	return DB.Create(history).Error
+}
+
+// GetUserImageHistory 按时间倒序分页返回用户的图片历史
+func GetUserImageHistory(userId int, startIdx int, num int) (histories []*ImageHistory, total int64, err error) {
+	tx := DB.Model(&ImageHistory{}).Where("user_id = ?", userId)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&histories).Error
+	return histories, total, err
+}
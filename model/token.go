@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -27,6 +28,7 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	Setting            string         `json:"setting" gorm:"type:text;column:setting"`
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -34,6 +36,17 @@ func (token *Token) Clean() {
 	token.Key = ""
 }
 
+func (token *Token) GetSetting() dto.TokenSetting {
+	setting := dto.TokenSetting{}
+	if token.Setting != "" {
+		err := common.Unmarshal([]byte(token.Setting), &setting)
+		if err != nil {
+			common.SysLog("failed to unmarshal token setting: " + err.Error())
+		}
+	}
+	return setting
+}
+
 func (token *Token) GetIpLimitsMap() map[string]any {
 	// delete empty spaces
 	//split with \n
@@ -189,6 +202,21 @@ func (token *Token) Update() (err error) {
 	return err
 }
 
+// UpdateSetting 只更新令牌设置，其中包含额度、频率等限制，仅供管理员接口调用
+func (token *Token) UpdateSetting() (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	return DB.Model(token).Select("setting").Updates(token).Error
+}
+
 func (token *Token) SelectUpdate() (err error) {
 	defer func() {
 		if shouldUpdateRedis(true, err) {
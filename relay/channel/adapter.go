@@ -31,6 +31,31 @@ type Adaptor interface {
 	ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error)
 }
 
+// ImageRequestBatch 上游不支持一次生成多张图片时，ConvertImageRequest 可以返回该类型，
+// 每个元素作为独立的请求体并发发往上游，响应按顺序合并 data 并累加 usage
+type ImageRequestBatch []any
+
+// ImageErrorRule 上游图片错误的归类规则：StatusCode 为 0 时不限制状态码，
+// Codes 匹配上游错误的 code 或 type，Keywords 匹配错误信息（均不区分大小写），任一命中即归类为 ErrorCode；
+// 两者都为空时只按状态码匹配
+type ImageErrorRule struct {
+	StatusCode int
+	Codes      []string
+	Keywords   []string
+	ErrorCode  types.ErrorCode
+}
+
+// ImageErrorMapper 图片适配器可选实现，按服务商的错误表述提供映射表，未命中的错误保留原有错误码
+type ImageErrorMapper interface {
+	ImageErrorRules() []ImageErrorRule
+}
+
+// ImageChannelProber 图片适配器可选实现，渠道测试时使用不产生费用的校验接口（如查询模型）检查密钥与模型是否可用。
+// 返回 false 表示当前渠道类型没有可用的校验接口，由调用方改为发送最小的生成请求
+type ImageChannelProber interface {
+	ProbeImageChannel(c *gin.Context, info *relaycommon.RelayInfo) (bool, *types.NewAPIError)
+}
+
 type TaskAdaptor interface {
 	Init(info *relaycommon.RelayInfo)
 
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"strings"
 	"time"
@@ -71,27 +70,9 @@ func oaiFormEdit2AliImageEdit(c *gin.Context, info *relaycommon.RelayInfo, reque
 		mf = c.Request.MultipartForm
 	}
 
-	var imageFiles []*multipart.FileHeader
-	var exists bool
-
-	// First check for standard "image" field
-	if imageFiles, exists = mf.File["image"]; !exists || len(imageFiles) == 0 {
-		// If not found, check for "image[]" field
-		if imageFiles, exists = mf.File["image[]"]; !exists || len(imageFiles) == 0 {
-			// If still not found, iterate through all fields to find any that start with "image["
-			foundArrayImages := false
-			for fieldName, files := range mf.File {
-				if strings.HasPrefix(fieldName, "image[") && len(files) > 0 {
-					foundArrayImages = true
-					imageFiles = append(imageFiles, files...)
-				}
-			}
-
-			// If no image fields found at all
-			if !foundArrayImages && (len(imageFiles) == 0) {
-				return nil, errors.New("image is required")
-			}
-		}
+	imageFiles, err := relaycommon.CollectImageFormFiles(mf)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(imageFiles) == 0 {
@@ -290,6 +271,7 @@ func aliImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.Rela
 	}
 
 	fullTextResponse := responseAli2OpenAIImage(c, aliResponse, originRespBody, info, responseFormat)
+	c.Set("image_returned_count", len(fullTextResponse.Data))
 	jsonResponse, err := common.Marshal(fullTextResponse)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeBadResponseBody), nil
@@ -331,6 +313,7 @@ func aliImageEditHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 	var mapResponse map[string]any
 	_ = common.Unmarshal(responseBody, &mapResponse)
 	fullTextResponse.Extra = mapResponse
+	c.Set("image_returned_count", len(fullTextResponse.Data))
 	jsonResponse, err := common.Marshal(fullTextResponse)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeBadResponseBody), nil
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	common2 "github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
@@ -16,6 +17,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -58,6 +60,60 @@ func processHeaderOverride(info *common.RelayInfo) (map[string]string, error) {
 	return headerOverride, nil
 }
 
+// imageAuthHeaders 适配器常用的鉴权头，使用鉴权头模板时全部移除
+var imageAuthHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key", "Api-Key"}
+
+// applyImageAuthHeader 图片请求按渠道配置的鉴权头模板替换适配器设置的鉴权头：常用鉴权头与值中包含密钥的请求头都会移除，
+// 避免密钥同时出现在原有请求头中。模板格式为 "Header-Name: value"，支持的变量：{api_key}、{key}
+func applyImageAuthHeader(info *common.RelayInfo, headers http.Header) error {
+	template := strings.TrimSpace(info.ChannelSetting.ImageAuthHeader)
+	if template == "" {
+		return nil
+	}
+	if info.RelayMode != constant.RelayModeImagesGenerations && info.RelayMode != constant.RelayModeImagesEdits {
+		return nil
+	}
+	name, value, ok := strings.Cut(template, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return types.NewError(fmt.Errorf("invalid image auth header template: %s", template), types.ErrorCodeChannelHeaderOverrideInvalid)
+	}
+	value = strings.TrimSpace(value)
+	value = strings.ReplaceAll(value, "{api_key}", info.ApiKey)
+	value = strings.ReplaceAll(value, "{key}", info.ApiKey)
+	for _, header := range imageAuthHeaders {
+		headers.Del(header)
+	}
+	if info.ApiKey != "" {
+		for header, values := range headers {
+			for _, v := range values {
+				if strings.Contains(v, info.ApiKey) {
+					headers.Del(header)
+					break
+				}
+			}
+		}
+	}
+	headers.Set(name, value)
+	return nil
+}
+
+// applyImageUserAgent 图片请求使用渠道配置的 User-Agent，未配置时保持默认值
+func applyImageUserAgent(info *common.RelayInfo, headers http.Header) error {
+	userAgent := strings.TrimSpace(info.ChannelSetting.ImageUserAgent)
+	if userAgent == "" {
+		return nil
+	}
+	if info.RelayMode != constant.RelayModeImagesGenerations && info.RelayMode != constant.RelayModeImagesEdits {
+		return nil
+	}
+	if strings.IndexFunc(userAgent, unicode.IsControl) != -1 {
+		return types.NewError(errors.New("invalid image user agent: contains control characters"), types.ErrorCodeChannelHeaderOverrideInvalid)
+	}
+	headers.Set("User-Agent", userAgent)
+	return nil
+}
+
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.GetRequestURL(info)
 	if err != nil {
@@ -82,6 +138,12 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	if err = applyImageAuthHeader(info, headers); err != nil {
+		return nil, err
+	}
+	if err = applyImageUserAgent(info, headers); err != nil {
+		return nil, err
+	}
 	resp, err := doRequest(c, req, info)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
@@ -89,6 +151,33 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	return resp, nil
 }
 
+// DoImageContinuationRequest 使用与原请求相同的鉴权头，以 GET 方式拉取上游在响应头中返回的后续图片结果
+func DoImageContinuationRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, continuationURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, continuationURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %w", err)
+	}
+	headers := req.Header
+	headerOverride, err := processHeaderOverride(info)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headerOverride {
+		headers.Set(key, value)
+	}
+	if err = a.SetupRequestHeader(c, &headers, info); err != nil {
+		return nil, fmt.Errorf("setup request header failed: %w", err)
+	}
+	headers.Del("Content-Type")
+	if err = applyImageAuthHeader(info, headers); err != nil {
+		return nil, err
+	}
+	if err = applyImageUserAgent(info, headers); err != nil {
+		return nil, err
+	}
+	return doRequest(c, req, info)
+}
+
 func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.GetRequestURL(info)
 	if err != nil {
@@ -115,6 +204,12 @@ func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBod
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	if err = applyImageAuthHeader(info, headers); err != nil {
+		return nil, err
+	}
+	if err = applyImageUserAgent(info, headers); err != nil {
+		return nil, err
+	}
 	resp, err := doRequest(c, req, info)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
@@ -253,9 +348,26 @@ func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	return doRequest(c, req, info)
 }
 func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
+	// 可取消的图片生成请求
+	if ctx, ok := c.Get("image_cancel_ctx"); ok {
+		req = req.WithContext(ctx.(context.Context))
+	}
+	// 图片请求的链路追踪，上游支持 traceparent 时可以关联到同一条链路
+	if traceparent := c.GetString("image_traceparent"); traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
+		if tracestate := c.GetHeader("tracestate"); tracestate != "" {
+			req.Header.Set("tracestate", tracestate)
+		}
+	}
 	var client *http.Client
 	var err error
-	if info.ChannelSetting.Proxy != "" {
+	if profile, ok := model_setting.GetImageSettings().GetHttpClientProfile(c.GetString("image_http_client_profile")); ok {
+		// 按配置使用独立连接池的客户端，同样支持渠道代理
+		client, err = service.GetProfileHttpClient(c.GetString("image_http_client_profile"), profile, info.ChannelSetting.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("new profile http client failed: %w", err)
+		}
+	} else if info.ChannelSetting.Proxy != "" {
 		client, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
 		if err != nil {
 			return nil, fmt.Errorf("new proxy http client failed: %w", err)
@@ -263,6 +375,12 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	} else {
 		client = service.GetHttpClient()
 	}
+	// 图片请求单独设置了超时时由请求上下文控制，不再受全局超时限制
+	if _, ok := c.Get("image_request_timeout"); ok && client.Timeout > 0 {
+		imageClient := *client
+		imageClient.Timeout = 0
+		client = &imageClient
+	}
 
 	var stopPinger context.CancelFunc
 	if info.IsStream {
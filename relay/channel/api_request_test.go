@@ -0,0 +1,148 @@
+package channel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerTestAdaptor 只实现构造上游请求需要的方法，默认使用 Bearer 鉴权，配置 keyHeaders 时改为在这些请求头中发送密钥
+type headerTestAdaptor struct {
+	Adaptor
+	url        string
+	keyHeaders []string
+}
+
+func (a *headerTestAdaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	return a.url, nil
+}
+
+func (a *headerTestAdaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
+	SetupApiRequestHeader(info, c, req)
+	if len(a.keyHeaders) == 0 {
+		req.Set("Authorization", "Bearer "+info.ApiKey)
+	}
+	for _, header := range a.keyHeaders {
+		req.Set(header, info.ApiKey)
+	}
+	return nil
+}
+
+// sendImageRequestHeaders 向模拟上游发送一次图片请求，返回上游收到的请求头
+func sendImageRequestHeaders(t *testing.T, info *relaycommon.RelayInfo, keyHeaders ...string) http.Header {
+	t.Helper()
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	c.Request.Header.Set("Content-Type", "application/json")
+	resp, err := DoApiRequest(&headerTestAdaptor{url: server.URL, keyHeaders: keyHeaders}, c, info, strings.NewReader(`{"prompt":"a cat"}`))
+	if err != nil {
+		t.Fatalf("request mock upstream: %v", err)
+	}
+	_ = resp.Body.Close()
+	return <-received
+}
+
+func newHeaderTestInfo(relayMode int) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		RelayMode:   relayMode,
+		ChannelMeta: &relaycommon.ChannelMeta{ApiKey: "sk-test"},
+	}
+}
+
+func TestImageAuthHeaderTemplateIsSent(t *testing.T) {
+	info := newHeaderTestInfo(relayconstant.RelayModeImagesGenerations)
+	info.ChannelSetting.ImageAuthHeader = "X-Api-Key: Token {api_key}"
+
+	headers := sendImageRequestHeaders(t, info)
+	if got := headers.Get("X-Api-Key"); got != "Token sk-test" {
+		t.Fatalf("X-Api-Key = %q, want %q", got, "Token sk-test")
+	}
+	if got := headers.Get("Authorization"); got != "" {
+		t.Fatalf("default Authorization header still sent: %q", got)
+	}
+}
+
+func TestImageAuthHeaderTemplateReplacesAdaptorAuthHeaders(t *testing.T) {
+	info := newHeaderTestInfo(relayconstant.RelayModeImagesGenerations)
+	info.ChannelSetting.ImageAuthHeader = "X-Image-Token: {key}"
+
+	headers := sendImageRequestHeaders(t, info, "x-api-key", "x-goog-api-key", "api-key", "X-Custom-Key")
+	if got := headers.Get("X-Image-Token"); got != "sk-test" {
+		t.Fatalf("X-Image-Token = %q, want %q", got, "sk-test")
+	}
+	for name, values := range headers {
+		if name == "X-Image-Token" {
+			continue
+		}
+		for _, value := range values {
+			if strings.Contains(value, "sk-test") {
+				t.Fatalf("channel key still sent in %s: %q", name, value)
+			}
+		}
+	}
+}
+
+func TestImageAuthHeaderTemplateOnlyAppliesToImages(t *testing.T) {
+	info := newHeaderTestInfo(relayconstant.RelayModeChatCompletions)
+	info.ChannelSetting.ImageAuthHeader = "X-Api-Key: {api_key}"
+
+	headers := sendImageRequestHeaders(t, info)
+	if headers.Get("X-Api-Key") != "" || headers.Get("Authorization") != "Bearer sk-test" {
+		t.Fatalf("chat request used the image auth header: %v", headers)
+	}
+}
+
+func TestInvalidImageAuthHeaderTemplateIsRejected(t *testing.T) {
+	info := newHeaderTestInfo(relayconstant.RelayModeImagesGenerations)
+	info.ChannelSetting.ImageAuthHeader = "missing separator"
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	if _, err := DoApiRequest(&headerTestAdaptor{url: "http://127.0.0.1:1"}, c, info, strings.NewReader("{}")); err == nil {
+		t.Fatal("invalid auth header template was accepted")
+	}
+}
+
+func TestImageUserAgentReachesUpstream(t *testing.T) {
+	for _, relayMode := range []int{relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits} {
+		info := newHeaderTestInfo(relayMode)
+		info.ChannelSetting.ImageUserAgent = " image-gateway/1.0 (+https://example.com) "
+
+		headers := sendImageRequestHeaders(t, info)
+		if got := headers.Get("User-Agent"); got != "image-gateway/1.0 (+https://example.com)" {
+			t.Fatalf("relay mode %d: User-Agent = %q", relayMode, got)
+		}
+	}
+
+	// 未配置时以及非图片请求保持默认 User-Agent
+	defaultAgent := sendImageRequestHeaders(t, newHeaderTestInfo(relayconstant.RelayModeImagesGenerations)).Get("User-Agent")
+	info := newHeaderTestInfo(relayconstant.RelayModeChatCompletions)
+	info.ChannelSetting.ImageUserAgent = "image-gateway/1.0"
+	if got := sendImageRequestHeaders(t, info).Get("User-Agent"); got != defaultAgent {
+		t.Fatalf("chat request User-Agent = %q, want default %q", got, defaultAgent)
+	}
+}
+
+func TestImageUserAgentWithControlCharactersIsRejected(t *testing.T) {
+	info := newHeaderTestInfo(relayconstant.RelayModeImagesGenerations)
+	info.ChannelSetting.ImageUserAgent = "image-gateway\r\nX-Injected: 1"
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	if _, err := DoApiRequest(&headerTestAdaptor{url: "http://127.0.0.1:1"}, c, info, strings.NewReader("{}")); err == nil {
+		t.Fatal("user agent with control characters was accepted")
+	}
+}
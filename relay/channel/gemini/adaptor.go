@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/QuantumNous/new-api/dto"
@@ -61,26 +63,7 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 	}
 
 	// convert size to aspect ratio but allow user to specify aspect ratio
-	aspectRatio := "1:1" // default aspect ratio
-	size := strings.TrimSpace(request.Size)
-	if size != "" {
-		if strings.Contains(size, ":") {
-			aspectRatio = size
-		} else {
-			switch size {
-			case "256x256", "512x512", "1024x1024":
-				aspectRatio = "1:1"
-			case "1536x1024":
-				aspectRatio = "3:2"
-			case "1024x1536":
-				aspectRatio = "2:3"
-			case "1024x1792":
-				aspectRatio = "9:16"
-			case "1792x1024":
-				aspectRatio = "16:9"
-			}
-		}
-	}
+	aspectRatio := imagenAspectRatio(request.Size)
 
 	// build gemini imagen request
 	geminiRequest := dto.GeminiImageRequest{
@@ -90,11 +73,14 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 			},
 		},
 		Parameters: dto.GeminiImageParameters{
-			SampleCount:      int(request.N),
+			SampleCount:      max(int(request.N), 1),
 			AspectRatio:      aspectRatio,
 			PersonGeneration: "allow_adult", // default allow adult
 		},
 	}
+	if info.ChannelSetting.ImageSafetyRatings || len(info.ChannelSetting.ImageSafetyThresholds) > 0 {
+		geminiRequest.Parameters.IncludeSafetyAttributes = true
+	}
 
 	// Set imageSize when quality parameter is specified
 	// Map quality parameter to imageSize (only supported by Standard and Ultra models)
@@ -104,12 +90,12 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 	// https://platform.openai.com/docs/api-reference/images/create
 	if request.Quality != "" {
 		imageSize := "1K" // default
-		switch request.Quality {
+		switch strings.ToLower(request.Quality) {
 		case "hd", "high":
 			imageSize = "2K"
-		case "2K":
+		case "2k":
 			imageSize = "2K"
-		case "standard", "medium", "low", "auto", "1K":
+		case "standard", "medium", "low", "auto", "1k":
 			imageSize = "1K"
 		default:
 			// unknown quality value, default to 1K
@@ -121,6 +107,44 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 	return geminiRequest, nil
 }
 
+// imagenAspectRatios Imagen 支持的宽高比
+var imagenAspectRatios = []struct {
+	ratio string
+	value float64
+}{
+	{"1:1", 1},
+	{"3:4", 3.0 / 4},
+	{"4:3", 4.0 / 3},
+	{"9:16", 9.0 / 16},
+	{"16:9", 16.0 / 9},
+}
+
+// imagenAspectRatio Imagen 按宽高比而不是像素尺寸生成图片：客户端直接指定宽高比（如 "16:9"）时原样使用，
+// OpenAI 风格的像素尺寸（如 "1536x1024"）映射为最接近的支持宽高比，未指定或无法解析时使用 1:1
+func imagenAspectRatio(size string) string {
+	size = strings.TrimSpace(size)
+	if strings.Contains(size, ":") {
+		return size
+	}
+	width, height, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return "1:1"
+	}
+	w, errW := strconv.Atoi(strings.TrimSpace(width))
+	h, errH := strconv.Atoi(strings.TrimSpace(height))
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return "1:1"
+	}
+	target := math.Log(float64(w) / float64(h))
+	best, bestDiff := "1:1", math.Inf(1)
+	for _, candidate := range imagenAspectRatios {
+		if diff := math.Abs(math.Log(candidate.value) - target); diff < bestDiff {
+			best, bestDiff = candidate.ratio, diff
+		}
+	}
+	return best
+}
+
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 
 }
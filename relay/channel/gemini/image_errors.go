@@ -0,0 +1,20 @@
+package gemini
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// imageErrorRules Gemini / Imagen 的错误表述，错误的 status 字段作为 type 返回
+var imageErrorRules = []channel.ImageErrorRule{
+	{Keywords: []string{"safety", "responsible ai", "prohibited"}, ErrorCode: types.ErrorCodeImageContentPolicy},
+	{StatusCode: http.StatusBadRequest, Keywords: []string{"aspect ratio", "image size", "sampleimagesize"}, ErrorCode: types.ErrorCodeImageInvalidSize},
+	{StatusCode: http.StatusTooManyRequests, Codes: []string{"RESOURCE_EXHAUSTED"}, Keywords: []string{"quota"}, ErrorCode: types.ErrorCodeImageProviderQuota},
+	{Codes: []string{"UNAVAILABLE"}, Keywords: []string{"overloaded"}, ErrorCode: types.ErrorCodeImageModelOverloaded},
+}
+
+func (a *Adaptor) ImageErrorRules() []channel.ImageErrorRule {
+	return imageErrorRules
+}
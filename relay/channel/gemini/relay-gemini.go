@@ -1159,6 +1159,21 @@ func GeminiEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 	return usage, nil
 }
 
+// geminiImageSafetyRatings 将 Imagen 的 safetyAttributes 转换为带命名空间的评分
+func geminiImageSafetyRatings(attributes *dto.GeminiImageSafetyAttributes) map[string]float64 {
+	if attributes == nil || len(attributes.Categories) == 0 {
+		return nil
+	}
+	ratings := make(map[string]float64, len(attributes.Categories))
+	for i, category := range attributes.Categories {
+		if i >= len(attributes.Scores) {
+			break
+		}
+		ratings["gemini."+strings.ToLower(category)] = attributes.Scores[i]
+	}
+	return ratings
+}
+
 func GeminiImageHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	responseBody, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
@@ -1181,14 +1196,29 @@ func GeminiImageHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.
 		Data:    make([]dto.ImageData, 0, len(geminiResponse.Predictions)),
 	}
 
-	for _, prediction := range geminiResponse.Predictions {
+	for i, prediction := range geminiResponse.Predictions {
 		if prediction.RaiFilteredReason != "" {
 			continue // skip filtered image
 		}
-		openAIResponse.Data = append(openAIResponse.Data, dto.ImageData{
-			B64Json: prediction.BytesBase64Encoded,
-		})
+		imageData := dto.ImageData{
+			B64Json:       prediction.BytesBase64Encoded,
+			RevisedPrompt: prediction.Prompt,
+		}
+		if prediction.Prompt != "" && model_setting.GetImageSettings().LogRevisedPrompt {
+			logger.LogInfo(c, fmt.Sprintf("image %d revised prompt: %s", i, prediction.Prompt))
+		}
+		ratings := geminiImageSafetyRatings(prediction.SafetyAttributes)
+		if category, score, threshold, exceeded := service.CheckImageSafetyThresholds(ratings, info.ChannelSetting.ImageSafetyThresholds); exceeded {
+			logger.LogWarn(c, fmt.Sprintf("image %d rejected by safety threshold, channel #%d, category: %s, score: %.4f, threshold: %.4f", i, info.ChannelId, category, score, threshold))
+			return nil, types.NewErrorWithStatusCode(fmt.Errorf("generated image rejected by safety policy: %s", category), types.ErrorCodeImageSafetyRejected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		if info.ChannelSetting.ImageSafetyRatings {
+			imageData.SafetyRatings = ratings
+		}
+		openAIResponse.Data = append(openAIResponse.Data, imageData)
 	}
+	// 被过滤的图片不计费，按实际返回的张数结算
+	c.Set("image_returned_count", len(openAIResponse.Data))
 
 	jsonResponse, jsonErr := json.Marshal(openAIResponse)
 	if jsonErr != nil {
@@ -0,0 +1,95 @@
+package gemini
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imagenSafetyResponse = `{"predictions":[
+	{"bytesBase64Encoded":"aW1hZ2U=","mimeType":"image/png","safetyAttributes":{"categories":["Violence","Sexual"],"scores":[0.2,0.05]}}
+]}`
+
+func runGeminiImageHandler(t *testing.T, setting dto.ChannelSettings) (*httptest.ResponseRecorder, *types.NewAPIError) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{ChannelId: 3, ChannelSetting: setting}}
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(imagenSafetyResponse))}
+	_, err := GeminiImageHandler(c, info, resp)
+	return recorder, err
+}
+
+func TestGeminiImageHandlerReturnsSafetyRatings(t *testing.T) {
+	recorder, err := runGeminiImageHandler(t, dto.ChannelSettings{
+		ImageSafetyRatings:    true,
+		ImageSafetyThresholds: map[string]float64{"violence": 0.5, "gemini.sexual": 0.1},
+	})
+	if err != nil {
+		t.Fatalf("images under the thresholds were rejected: %v", err)
+	}
+	var response dto.ImageResponse
+	if jsonErr := common.Unmarshal(recorder.Body.Bytes(), &response); jsonErr != nil {
+		t.Fatalf("decode response: %v", jsonErr)
+	}
+	if len(response.Data) != 1 {
+		t.Fatalf("got %d images, want 1", len(response.Data))
+	}
+	ratings := response.Data[0].SafetyRatings
+	if ratings["gemini.violence"] != 0.2 || ratings["gemini.sexual"] != 0.05 {
+		t.Fatalf("safety ratings = %v, want namespaced gemini scores", ratings)
+	}
+}
+
+func TestGeminiImageHandlerRejectsScoreOverThreshold(t *testing.T) {
+	recorder, err := runGeminiImageHandler(t, dto.ChannelSettings{
+		ImageSafetyThresholds: map[string]float64{"gemini.violence": 0.1},
+	})
+	if err == nil {
+		t.Fatal("image over the violence threshold was returned")
+	}
+	// 在写回响应与结算前返回错误，预扣额度由调用方退还
+	if err.GetErrorCode() != types.ErrorCodeImageSafetyRejected || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected error: code %s, status %d", err.GetErrorCode(), err.StatusCode)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("rejected images were written to the client: %s", recorder.Body.String())
+	}
+}
+
+func TestGeminiImageHandlerOmitsRatingsByDefault(t *testing.T) {
+	recorder, err := runGeminiImageHandler(t, dto.ChannelSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(recorder.Body.String(), "safety_ratings") {
+		t.Fatalf("safety ratings returned without opting in: %s", recorder.Body.String())
+	}
+}
+
+func TestGeminiImageHandlerSetsReturnedCount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{ChannelId: 3}}
+	// 被过滤的图片不计入返回张数
+	body := `{"predictions":[{"bytesBase64Encoded":"aW1hZ2U=","mimeType":"image/png"},{"raiFilteredReason":"filtered"}]}`
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+	if _, err := GeminiImageHandler(c, info, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count, ok := c.Get("image_returned_count"); !ok || count.(int) != 1 {
+		t.Fatalf("image_returned_count = %v (set %v), want 1", count, ok)
+	}
+}
@@ -0,0 +1,148 @@
+package channel
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+type xmlImageData struct {
+	Url           string `xml:"url"`
+	B64Json       string `xml:"b64_json"`
+	RevisedPrompt string `xml:"revised_prompt"`
+}
+
+type xmlImageResponse struct {
+	Created int64          `xml:"created"`
+	Data    []xmlImageData `xml:"data"`
+	Images  []xmlImageData `xml:"image"`
+}
+
+// NormalizeImageResponse 部分上游以 XML 或表单编码返回图片结果，统一转换为 OpenAI 的 data[] 格式后再交给适配器处理。
+// 由 ImageHelper 对所有渠道的成功响应调用，JSON 与 SSE 响应原样返回，无法识别的格式返回 502 并记录 Content-Type
+func NormalizeImageResponse(c *gin.Context, resp *http.Response) *types.NewAPIError {
+	if resp == nil {
+		return nil
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "", mediaType == "application/json", strings.HasSuffix(mediaType, "+json"), mediaType == "text/event-stream":
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+
+	var imageResponse *dto.ImageResponse
+	switch {
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		imageResponse, err = parseXMLImageResponse(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		imageResponse, err = parseFormImageResponse(body)
+	case mediaType == "text/plain" && looksLikeJSON(body):
+		// 部分上游返回 JSON 但未设置正确的 Content-Type
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil
+	default:
+		logger.LogError(c, fmt.Sprintf("unsupported image response content type: %s", contentType))
+		return types.NewOpenAIError(fmt.Errorf("unsupported upstream image response content type: %s", contentType), types.ErrorCodeBadResponse, http.StatusBadGateway)
+	}
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("parse %s image response failed: %s", mediaType, err.Error()))
+		return types.NewOpenAIError(fmt.Errorf("invalid upstream image response: %w", err), types.ErrorCodeBadResponseBody, http.StatusBadGateway)
+	}
+
+	jsonBody, err := common.Marshal(imageResponse)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(jsonBody))
+	resp.ContentLength = int64(len(jsonBody))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(jsonBody)))
+	return nil
+}
+
+func looksLikeJSON(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+func parseXMLImageResponse(body []byte) (*dto.ImageResponse, error) {
+	var xmlResponse xmlImageResponse
+	if err := xml.Unmarshal(body, &xmlResponse); err != nil {
+		return nil, err
+	}
+	items := append(xmlResponse.Data, xmlResponse.Images...)
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no images in xml response")
+	}
+	imageResponse := &dto.ImageResponse{
+		Created: xmlResponse.Created,
+		Data:    make([]dto.ImageData, 0, len(items)),
+	}
+	if imageResponse.Created == 0 {
+		imageResponse.Created = common.GetTimestamp()
+	}
+	for _, item := range items {
+		imageResponse.Data = append(imageResponse.Data, dto.ImageData{
+			Url:           strings.TrimSpace(item.Url),
+			B64Json:       strings.TrimSpace(item.B64Json),
+			RevisedPrompt: strings.TrimSpace(item.RevisedPrompt),
+		})
+	}
+	return imageResponse, nil
+}
+
+// parseFormImageResponse 支持 url=...&url=... 以及 data[0][url]=... 两种写法
+func parseFormImageResponse(body []byte) (*dto.ImageResponse, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	imageResponse := &dto.ImageResponse{
+		Created: common.GetTimestamp(),
+	}
+	if created, err := strconv.ParseInt(values.Get("created"), 10, 64); err == nil {
+		imageResponse.Created = created
+	}
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("data[%d]", i)
+		item := dto.ImageData{
+			Url:           values.Get(prefix + "[url]"),
+			B64Json:       values.Get(prefix + "[b64_json]"),
+			RevisedPrompt: values.Get(prefix + "[revised_prompt]"),
+		}
+		if item.Url == "" && item.B64Json == "" {
+			break
+		}
+		imageResponse.Data = append(imageResponse.Data, item)
+	}
+	for _, u := range values["url"] {
+		imageResponse.Data = append(imageResponse.Data, dto.ImageData{Url: u})
+	}
+	for _, b64 := range values["b64_json"] {
+		imageResponse.Data = append(imageResponse.Data, dto.ImageData{B64Json: b64})
+	}
+	if len(imageResponse.Data) == 0 {
+		return nil, fmt.Errorf("no images in form response")
+	}
+	return imageResponse, nil
+}
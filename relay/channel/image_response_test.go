@@ -0,0 +1,114 @@
+package channel
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fetchMockImageResponse 启动按指定 Content-Type 返回固定内容的模拟上游，返回真实的 HTTP 响应
+func fetchMockImageResponse(t *testing.T, contentType string, body string) *http.Response {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	resp, err := http.Post(server.URL+"/v1/images/generations", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request mock provider: %v", err)
+	}
+	return resp
+}
+
+func newImageResponseTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	return c
+}
+
+func decodeNormalizedImageResponse(t *testing.T, resp *http.Response) dto.ImageResponse {
+	t.Helper()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", contentType)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read normalized body: %v", err)
+	}
+	var imageResponse dto.ImageResponse
+	if err = common.Unmarshal(body, &imageResponse); err != nil {
+		t.Fatalf("normalized body %q is not json: %v", body, err)
+	}
+	return imageResponse
+}
+
+func TestNormalizeImageResponseFromXMLProvider(t *testing.T) {
+	resp := fetchMockImageResponse(t, "application/xml; charset=utf-8", `<?xml version="1.0"?>
+<response>
+  <created>1700000000</created>
+  <image><url> https://cdn.example.com/a.png </url><revised_prompt>a red fox</revised_prompt></image>
+  <image><b64_json>aGVsbG8=</b64_json></image>
+</response>`)
+
+	if err := NormalizeImageResponse(newImageResponseTestContext(), resp); err != nil {
+		t.Fatalf("normalize xml response: %v", err)
+	}
+	imageResponse := decodeNormalizedImageResponse(t, resp)
+	if imageResponse.Created != 1700000000 {
+		t.Fatalf("created = %d, want 1700000000", imageResponse.Created)
+	}
+	if len(imageResponse.Data) != 2 {
+		t.Fatalf("data has %d images, want 2", len(imageResponse.Data))
+	}
+	if imageResponse.Data[0].Url != "https://cdn.example.com/a.png" || imageResponse.Data[0].RevisedPrompt != "a red fox" {
+		t.Fatalf("unexpected first image: %+v", imageResponse.Data[0])
+	}
+	if imageResponse.Data[1].B64Json != "aGVsbG8=" {
+		t.Fatalf("unexpected second image: %+v", imageResponse.Data[1])
+	}
+}
+
+func TestNormalizeImageResponseFromFormProvider(t *testing.T) {
+	resp := fetchMockImageResponse(t, "application/x-www-form-urlencoded", "created=1700000001&data[0][url]=https%3A%2F%2Fcdn.example.com%2Fb.png&data[1][b64_json]=aGk%3D")
+
+	if err := NormalizeImageResponse(newImageResponseTestContext(), resp); err != nil {
+		t.Fatalf("normalize form response: %v", err)
+	}
+	imageResponse := decodeNormalizedImageResponse(t, resp)
+	if len(imageResponse.Data) != 2 || imageResponse.Data[0].Url != "https://cdn.example.com/b.png" || imageResponse.Data[1].B64Json != "aGk=" {
+		t.Fatalf("unexpected normalized data: %+v", imageResponse.Data)
+	}
+}
+
+func TestNormalizeImageResponseKeepsJSON(t *testing.T) {
+	const body = `{"created":1,"data":[{"url":"https://cdn.example.com/c.png"}]}`
+	resp := fetchMockImageResponse(t, "application/json", body)
+
+	if err := NormalizeImageResponse(newImageResponseTestContext(), resp); err != nil {
+		t.Fatalf("normalize json response: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if string(data) != body {
+		t.Fatalf("json body changed to %q", data)
+	}
+}
+
+func TestNormalizeImageResponseRejectsUnknownContentType(t *testing.T) {
+	resp := fetchMockImageResponse(t, "application/octet-stream", "\x89PNG")
+
+	err := NormalizeImageResponse(newImageResponseTestContext(), resp)
+	if err == nil {
+		t.Fatal("expected unknown content type to be rejected")
+	}
+	if err.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusBadGateway)
+	}
+}
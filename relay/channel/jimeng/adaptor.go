@@ -77,6 +77,11 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 	if request.ResponseFormat == "" || request.ResponseFormat == "url" {
 		payload.ReturnURL = true // Default to returning image URLs
 	}
+	if len(request.Seed) > 0 {
+		if err := json.Unmarshal(request.Seed, &payload.Seed); err != nil {
+			return nil, fmt.Errorf("invalid seed: %w", err)
+		}
+	}
 
 	if len(request.ExtraFields) > 0 {
 		if err := json.Unmarshal(request.ExtraFields, &payload); err != nil {
@@ -0,0 +1,16 @@
+package channel
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+)
+
+func TestMain(m *testing.M) {
+	// 测试不连接 Redis，上游请求使用共享的 HTTP 客户端
+	common.RedisEnabled = false
+	service.InitHttpClient()
+	os.Exit(m.Run())
+}
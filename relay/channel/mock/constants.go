@@ -0,0 +1,9 @@
+package mock
+
+const (
+	ChannelName = "mock"
+)
+
+var ModelList = []string{
+	"mock-image",
+}
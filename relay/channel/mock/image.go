@@ -0,0 +1,105 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// placeholderImage 1x1 的透明 PNG，每次返回相同内容
+const placeholderImage = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+// doMockImageRequest 等待渠道配置的模拟延迟后生成上游响应，按错误率返回 500，
+// 之后的错误处理、重试与计费都与真实上游一致。请求体（参数覆盖之后）中的 seed 原样回显
+func doMockImageRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	var upstreamRequest struct {
+		Seed *int64 `json:"seed"`
+	}
+	if requestBody != nil {
+		if data, err := io.ReadAll(requestBody); err == nil {
+			_ = common.Unmarshal(data, &upstreamRequest)
+		}
+	}
+	ctx := c.Request.Context()
+	if cancelCtx, ok := c.Get("image_cancel_ctx"); ok {
+		ctx = cancelCtx.(context.Context)
+	}
+	if latency := time.Duration(info.ChannelSetting.MockLatencyMs) * time.Millisecond; latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("mock request cancelled: %w", ctx.Err())
+		}
+	}
+
+	if rate := info.ChannelSetting.MockErrorRate; rate > 0 && rand.Float64() < rate {
+		return newMockResponse(http.StatusInternalServerError, []byte(`{"error":{"message":"mock upstream error","type":"server_error","code":"mock_error"}}`)), nil
+	}
+
+	n := uint(1)
+	if request, ok := info.Request.(*dto.ImageRequest); ok && request.N > 0 {
+		n = request.N
+	}
+	imageResponse := dto.ImageResponse{
+		Created: info.StartTime.Unix(),
+		Data:    make([]dto.ImageData, 0, n),
+	}
+	for i := uint(0); i < n; i++ {
+		imageResponse.Data = append(imageResponse.Data, dto.ImageData{B64Json: placeholderImage, Seed: upstreamRequest.Seed})
+	}
+	body, err := common.Marshal(imageResponse)
+	if err != nil {
+		return nil, err
+	}
+	return newMockResponse(http.StatusOK, body), nil
+}
+
+func newMockResponse(statusCode int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+// mockImageHandler 原样返回模拟响应，不返回用量，由 ImageHelper 按张数计费
+func mockImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.Usage, *types.NewAPIError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	service.CloseResponseBodyGracefully(resp)
+
+	var imageResponse dto.ImageResponse
+	if err = common.Unmarshal(responseBody, &imageResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if len(imageResponse.Data) == 0 {
+		return nil, types.NewOpenAIError(errors.New("mock response contains no images"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	c.Set("image_returned_count", len(imageResponse.Data))
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	if _, err = c.Writer.Write(responseBody); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	return &dto.Usage{}, nil
+}
@@ -0,0 +1,24 @@
+package mock
+
+import (
+	"net/http"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProbeImageChannel 按渠道配置的模拟延迟与错误率返回结果，用于验证健康检查流程
+func (a *Adaptor) ProbeImageChannel(c *gin.Context, info *relaycommon.RelayInfo) (bool, *types.NewAPIError) {
+	resp, err := doMockImageRequest(c, info, nil)
+	if err != nil {
+		return true, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return true, service.RelayErrorHandler(c.Request.Context(), resp, true)
+	}
+	service.CloseResponseBodyGracefully(resp)
+	return true, nil
+}
@@ -430,34 +430,21 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 					continue
 				}
 				for _, value := range values {
+					if key == "prompt" {
+						value = relaycommon.InjectImagePrompt(c, info, value)
+					}
 					writer.WriteField(key, value)
 				}
 			}
 		}
 
 		if mf != nil && mf.File != nil {
-			// Check if "image" field exists in any form, including array notation
-			var imageFiles []*multipart.FileHeader
-			var exists bool
-
-			// First check for standard "image" field
-			if imageFiles, exists = mf.File["image"]; !exists || len(imageFiles) == 0 {
-				// If not found, check for "image[]" field
-				if imageFiles, exists = mf.File["image[]"]; !exists || len(imageFiles) == 0 {
-					// If still not found, iterate through all fields to find any that start with "image["
-					foundArrayImages := false
-					for fieldName, files := range mf.File {
-						if strings.HasPrefix(fieldName, "image[") && len(files) > 0 {
-							foundArrayImages = true
-							imageFiles = append(imageFiles, files...)
-						}
-					}
-
-					// If no image fields found at all
-					if !foundArrayImages && (len(imageFiles) == 0) {
-						return nil, errors.New("image is required")
-					}
-				}
+			imageFiles, err := relaycommon.CollectImageFormFiles(mf)
+			if err != nil {
+				return nil, err
+			}
+			if len(imageFiles) == 0 {
+				return nil, errors.New("image is required")
 			}
 
 			// Process all image files
@@ -473,12 +460,15 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 					fieldName = "image[]"
 				}
 
-				// Determine MIME type based on file extension
-				mimeType := detectImageMimeType(fileHeader.Filename)
+				content, filename, mimeType, err := transcodeImageEditFile(info, fileHeader, file)
+				if err != nil {
+					_ = file.Close()
+					return nil, err
+				}
 
 				// Create a form file with the appropriate content type
 				h := make(textproto.MIMEHeader)
-				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, fileHeader.Filename))
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, filename))
 				h.Set("Content-Type", mimeType)
 
 				part, err := writer.CreatePart(h)
@@ -486,7 +476,7 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 					return nil, fmt.Errorf("create form part failed for image %d: %w", i, err)
 				}
 
-				if _, err := io.Copy(part, file); err != nil {
+				if _, err := io.Copy(part, content); err != nil {
 					return nil, fmt.Errorf("copy file failed for image %d: %w", i, err)
 				}
 
@@ -502,12 +492,15 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 				}
 				// 复制完立即关闭，避免在循环内使用 defer 占用资源
 
-				// Determine MIME type for mask file
-				mimeType := detectImageMimeType(maskFiles[0].Filename)
+				maskContent, filename, mimeType, err := transcodeImageEditFile(info, maskFiles[0], maskFile)
+				if err != nil {
+					_ = maskFile.Close()
+					return nil, err
+				}
 
 				// Create a form file with the appropriate content type
 				h := make(textproto.MIMEHeader)
-				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="mask"; filename="%s"`, maskFiles[0].Filename))
+				h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="mask"; filename="%s"`, filename))
 				h.Set("Content-Type", mimeType)
 
 				maskPart, err := writer.CreatePart(h)
@@ -515,11 +508,34 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 					return nil, errors.New("create form file failed for mask")
 				}
 
-				if _, err := io.Copy(maskPart, maskFile); err != nil {
+				if _, err := io.Copy(maskPart, maskContent); err != nil {
 					return nil, errors.New("copy mask file failed")
 				}
 				_ = maskFile.Close()
 			}
+
+			// 风格参考图按渠道配置的字段名转发
+			if field := info.ChannelSetting.ImageStyleReferenceField; field != "" {
+				if styleFiles, exists := mf.File[field]; exists && len(styleFiles) > 0 {
+					styleFile, err := styleFiles[0].Open()
+					if err != nil {
+						return nil, errors.New("failed to open style reference file")
+					}
+					h := make(textproto.MIMEHeader)
+					h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, styleFiles[0].Filename))
+					h.Set("Content-Type", detectImageMimeType(styleFiles[0].Filename))
+					stylePart, err := writer.CreatePart(h)
+					if err != nil {
+						_ = styleFile.Close()
+						return nil, errors.New("create form file failed for style reference")
+					}
+					if _, err := io.Copy(stylePart, styleFile); err != nil {
+						_ = styleFile.Close()
+						return nil, errors.New("copy style reference file failed")
+					}
+					_ = styleFile.Close()
+				}
+			}
 		} else {
 			return nil, errors.New("no multipart form data found")
 		}
@@ -530,10 +546,30 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 		return &requestBody, nil
 
 	default:
+		// ImageRequest 序列化时不包含 Extra，改名后的风格参考图字段需要单独写入请求体
+		if field := info.ChannelSetting.ImageStyleReferenceField; field != "" {
+			if raw, ok := request.Extra[field]; ok {
+				return withImageRequestField(request, field, raw)
+			}
+		}
 		return request, nil
 	}
 }
 
+// withImageRequestField 在序列化后的图片请求中追加一个字段
+func withImageRequestField(request dto.ImageRequest, field string, value json.RawMessage) (map[string]json.RawMessage, error) {
+	data, err := common.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]json.RawMessage
+	if err = common.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	body[field] = value
+	return body, nil
+}
+
 // detectImageMimeType determines the MIME type based on the file extension
 func detectImageMimeType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
@@ -554,6 +590,27 @@ func detectImageMimeType(filename string) string {
 	}
 }
 
+// transcodeImageEditFile 渠道只接受 PNG 时将其他格式的上传文件转码为 PNG（保留透明通道），
+// 已是 PNG 的文件原样转发；未开启时直接返回原文件
+func transcodeImageEditFile(info *relaycommon.RelayInfo, fileHeader *multipart.FileHeader, file io.Reader) (io.Reader, string, string, error) {
+	if !info.ChannelSetting.ImageEditPngOnly {
+		return file, fileHeader.Filename, detectImageMimeType(fileHeader.Filename), nil
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("read image file %q failed: %w", fileHeader.Filename, err)
+	}
+	if service.SniffImageFormat(data) == "png" {
+		return bytes.NewReader(data), fileHeader.Filename, "image/png", nil
+	}
+	converted, err := service.TranscodeImageToPNG(data)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("transcode image file %q to png failed: %w", fileHeader.Filename, err)
+	}
+	filename := strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename)) + ".png"
+	return bytes.NewReader(converted), filename, "image/png", nil
+}
+
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
 	//  转换模型推理力度后缀
 	effort, originModel := parseReasoningEffortFromModelSuffix(request.Model)
@@ -593,6 +650,20 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	case relayconstant.RelayModeAudioTranscription:
 		err, usage = OpenaiSTTHandler(c, resp, info, a.ResponseFormat)
 	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+		if info.IsStream {
+			if err = applyImagePromptTruncationPolicy(c, info, resp); err != nil {
+				return nil, err
+			}
+			usage, err = OpenaiImageStreamHandler(c, info, resp)
+			break
+		}
+		if err = applyImageNsfwPolicy(c, info, resp); err != nil {
+			return nil, err
+		}
+		if err = applyImagePromptTruncationPolicy(c, info, resp); err != nil {
+			return nil, err
+		}
+		logImageRevisedPrompts(c, resp)
 		usage, err = OpenaiHandlerWithUsage(c, info, resp)
 	case relayconstant.RelayModeRerank:
 		usage, err = common_handler.RerankHandler(c, info, resp)
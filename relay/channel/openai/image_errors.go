@@ -0,0 +1,20 @@
+package openai
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// imageErrorRules OpenAI 图片接口的错误表述
+var imageErrorRules = []channel.ImageErrorRule{
+	{Codes: []string{"content_policy_violation", "moderation_blocked"}, Keywords: []string{"safety system", "content policy"}, ErrorCode: types.ErrorCodeImageContentPolicy},
+	{StatusCode: http.StatusBadRequest, Keywords: []string{"invalid value for 'size'", "invalid size", "'size'"}, ErrorCode: types.ErrorCodeImageInvalidSize},
+	{Codes: []string{"billing_hard_limit_reached", "insufficient_quota"}, Keywords: []string{"billing hard limit", "exceeded your current quota"}, ErrorCode: types.ErrorCodeImageProviderQuota},
+	{Codes: []string{"server_is_overloaded", "engine_overloaded"}, Keywords: []string{"overloaded"}, ErrorCode: types.ErrorCodeImageModelOverloaded},
+}
+
+func (a *Adaptor) ImageErrorRules() []channel.ImageErrorRule {
+	return imageErrorRules
+}
@@ -0,0 +1,122 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 常见上游用于标记 NSFW 的字段，顶层字段为与 data[] 一一对应的布尔数组
+var (
+	imageNsfwListFields = []string{"has_nsfw_concepts", "nsfw_content_detected"}
+	imageNsfwItemFields = []string{"nsfw", "is_nsfw", "has_nsfw_concept"}
+)
+
+// detectImageNsfwFlags 返回 data[] 中被上游标记为 NSFW 的图片下标
+func detectImageNsfwFlags(response map[string]json.RawMessage, items []map[string]json.RawMessage) []int {
+	flagged := make(map[int]bool)
+	for _, field := range imageNsfwListFields {
+		raw, ok := response[field]
+		if !ok {
+			continue
+		}
+		var flags []bool
+		if err := common.Unmarshal(raw, &flags); err != nil {
+			continue
+		}
+		for i, flag := range flags {
+			if flag && i < len(items) {
+				flagged[i] = true
+			}
+		}
+	}
+	for i, item := range items {
+		for _, field := range imageNsfwItemFields {
+			var flag bool
+			if raw, ok := item[field]; ok && common.Unmarshal(raw, &flag) == nil && flag {
+				flagged[i] = true
+			}
+		}
+	}
+	indexes := make([]int, 0, len(flagged))
+	for i := range items {
+		if flagged[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// applyImageNsfwPolicy 按渠道配置处理上游标记为 NSFW 的图片：
+// block 直接返回错误（不计费），pass 与 blur 在对应图片上标记 nsfw，blur 另由响应后处理阶段模糊图片
+func applyImageNsfwPolicy(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) *types.NewAPIError {
+	action := info.ChannelSetting.ImageNsfwAction
+	if resp == nil || action == "" || info.IsStream {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil || len(items) == 0 {
+		return nil
+	}
+	flagged := detectImageNsfwFlags(response, items)
+	if len(flagged) == 0 {
+		return nil
+	}
+	logger.LogWarn(c, fmt.Sprintf("upstream flagged %d of %d images as nsfw, channel #%d, action: %s", len(flagged), len(items), info.ChannelId, action))
+
+	switch action {
+	case dto.ImageNsfwActionBlock:
+		return types.NewErrorWithStatusCode(errors.New("generated image rejected by safety policy: nsfw"), types.ErrorCodeImageSafetyRejected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	case dto.ImageNsfwActionBlur:
+		c.Set("image_nsfw_blur", flagged)
+	case dto.ImageNsfwActionPass:
+	default:
+		return nil
+	}
+
+	for _, i := range flagged {
+		items[i]["nsfw"] = json.RawMessage("true")
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	response["data"] = data
+	body, err = common.Marshal(response)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return nil
+}
@@ -0,0 +1,110 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nsfwFlaggedImageResponse 第二张图片通过顶层 has_nsfw_concepts 标记，第三张通过图片自身的 is_nsfw 标记
+const nsfwFlaggedImageResponse = `{"created":1,"has_nsfw_concepts":[false,true,false],"data":[{"b64_json":"c2FmZQ=="},{"b64_json":"bnNmdw=="},{"url":"https://cdn.example.com/3.png","is_nsfw":true}]}`
+
+func applyNsfwPolicyToResponse(t *testing.T, action string, body string) (*gin.Context, *types.NewAPIError, map[string]any) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.ChannelSetting.ImageNsfwAction = action
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+
+	newAPIError := applyImageNsfwPolicy(c, info, resp)
+	forwarded, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read forwarded body: %v", err)
+	}
+	var response map[string]any
+	if err = common.Unmarshal(forwarded, &response); err != nil {
+		t.Fatalf("decode forwarded body %q: %v", forwarded, err)
+	}
+	return c, newAPIError, response
+}
+
+// nsfwMarks 返回 data[] 中各图片的 nsfw 标记
+func nsfwMarks(response map[string]any) []bool {
+	items, _ := response["data"].([]any)
+	marks := make([]bool, len(items))
+	for i, item := range items {
+		marks[i], _ = item.(map[string]any)["nsfw"].(bool)
+	}
+	return marks
+}
+
+func TestImageNsfwPolicyActions(t *testing.T) {
+	cases := []struct {
+		action  string
+		blocked bool
+		marked  bool
+		blurred bool
+	}{
+		{action: ""},
+		{action: dto.ImageNsfwActionPass, marked: true},
+		{action: dto.ImageNsfwActionBlock, blocked: true},
+		{action: dto.ImageNsfwActionBlur, marked: true, blurred: true},
+	}
+	for _, tc := range cases {
+		t.Run("action "+tc.action, func(t *testing.T) {
+			c, err, response := applyNsfwPolicyToResponse(t, tc.action, nsfwFlaggedImageResponse)
+			if tc.blocked {
+				if err == nil || err.StatusCode != http.StatusBadRequest || err.GetErrorCode() != types.ErrorCodeImageSafetyRejected {
+					t.Fatalf("flagged response not blocked: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply nsfw policy: %v", err)
+			}
+			want := []bool{false, tc.marked, tc.marked}
+			if got := nsfwMarks(response); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+				t.Fatalf("nsfw marks = %v, want %v", got, want)
+			}
+			flagged, ok := c.Get("image_nsfw_blur")
+			if ok != tc.blurred {
+				t.Fatalf("blur requested = %v, want %v", ok, tc.blurred)
+			}
+			if tc.blurred {
+				if indexes, _ := flagged.([]int); len(indexes) != 2 || indexes[0] != 1 || indexes[1] != 2 {
+					t.Fatalf("blur indexes = %v, want [1 2]", flagged)
+				}
+			}
+		})
+	}
+}
+
+func TestImageNsfwPolicyIgnoresCleanResponse(t *testing.T) {
+	for _, action := range []string{dto.ImageNsfwActionPass, dto.ImageNsfwActionBlock, dto.ImageNsfwActionBlur} {
+		c, err, response := applyNsfwPolicyToResponse(t, action, `{"created":1,"has_nsfw_concepts":[false],"data":[{"b64_json":"c2FmZQ==","nsfw":false}]}`)
+		if err != nil {
+			t.Fatalf("%s: clean response rejected: %v", action, err)
+		}
+		if marks := nsfwMarks(response); marks[0] {
+			t.Fatalf("%s: clean image marked as nsfw", action)
+		}
+		if _, ok := c.Get("image_nsfw_blur"); ok {
+			t.Fatalf("%s: blur requested for a clean response", action)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProbeImageChannel 通过 GET /v1/models/{model} 校验密钥与模型，不产生生成费用。只适用于 OpenAI 官方渠道，
+// 其他兼容渠道的模型接口不统一，改为发送最小的生成请求
+func (a *Adaptor) ProbeImageChannel(c *gin.Context, info *relaycommon.RelayInfo) (bool, *types.NewAPIError) {
+	if info.ChannelType != constant.ChannelTypeOpenAI {
+		return false, nil
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/models/%s", info.ChannelBaseUrl, url.PathEscape(info.UpstreamModelName))
+	req, err := http.NewRequest(http.MethodGet, fullRequestURL, nil)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	if err = a.SetupRequestHeader(c, &req.Header, info); err != nil {
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	resp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return true, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return true, service.RelayErrorHandler(c.Request.Context(), resp, true)
+	}
+	service.CloseResponseBodyGracefully(resp)
+	return true, nil
+}
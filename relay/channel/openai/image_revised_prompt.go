@@ -0,0 +1,37 @@
+package openai
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logImageRevisedPrompts 开启 LogRevisedPrompt 时记录上游改写后的提示词，响应体原样转发
+func logImageRevisedPrompts(c *gin.Context, resp *http.Response) {
+	if resp == nil || !model_setting.GetImageSettings().LogRevisedPrompt {
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	var response dto.ImageResponse
+	if common.Unmarshal(body, &response) != nil {
+		return
+	}
+	for i, item := range response.Data {
+		if item.RevisedPrompt != "" {
+			logger.LogInfo(c, fmt.Sprintf("image %d revised prompt: %s", i, item.RevisedPrompt))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dalle3ImageResponse    = `{"created":1,"data":[{"url":"https://cdn.example.com/a.png","revised_prompt":"A watercolor painting of a red fox in snow"}]}`
+	gptImage1ImageResponse = `{"created":1,"data":[{"b64_json":"aW1hZ2U="}],"usage":{"input_tokens":10,"output_tokens":100,"total_tokens":110}}`
+)
+
+// runImageDoResponse 按非流式图片生成调用 DoResponse，返回转发给客户端的 data[]
+func runImageDoResponse(t *testing.T, model string, body string) []map[string]any {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{
+		RelayMode:       relayconstant.RelayModeImagesGenerations,
+		OriginModelName: model,
+		ChannelMeta:     &relaycommon.ChannelMeta{UpstreamModelName: model},
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	if _, err := (&Adaptor{}).DoResponse(c, resp, info); err != nil {
+		t.Fatalf("%s: DoResponse: %v", model, err)
+	}
+	var response struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+		t.Fatalf("%s: decode forwarded body %s: %v", model, recorder.Body.String(), err)
+	}
+	return response.Data
+}
+
+func TestImageRevisedPromptRoundTrip(t *testing.T) {
+	settings := model_setting.GetImageSettings()
+	previous := settings.LogRevisedPrompt
+	settings.LogRevisedPrompt = true
+	t.Cleanup(func() { settings.LogRevisedPrompt = previous })
+
+	data := runImageDoResponse(t, "dall-e-3", dalle3ImageResponse)
+	if data[0]["revised_prompt"] != "A watercolor painting of a red fox in snow" {
+		t.Fatalf("dall-e-3 revised_prompt = %v", data[0]["revised_prompt"])
+	}
+	data = runImageDoResponse(t, "gpt-image-1", gptImage1ImageResponse)
+	if _, ok := data[0]["revised_prompt"]; ok {
+		t.Fatalf("gpt-image-1 response has revised_prompt: %v", data[0])
+	}
+
+	// 经 dto 转换的响应同样保留或省略该字段
+	for body, want := range map[string]string{dalle3ImageResponse: "A watercolor painting of a red fox in snow", gptImage1ImageResponse: ""} {
+		var response dto.ImageResponse
+		if err := common.Unmarshal([]byte(body), &response); err != nil {
+			t.Fatalf("decode %s: %v", body, err)
+		}
+		converted, _ := common.Marshal(response)
+		if response.Data[0].RevisedPrompt != want || strings.Contains(string(converted), "revised_prompt") != (want != "") {
+			t.Fatalf("converted response %s, want revised_prompt %q", converted, want)
+		}
+	}
+}
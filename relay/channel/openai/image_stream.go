@@ -0,0 +1,144 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageStreamEvent gpt-image 系列流式返回的事件，例如 image_generation.partial_image、
+// image_generation.completed、image_edit.partial_image、image_edit.completed
+type imageStreamEvent struct {
+	Type  string             `json:"type"`
+	Usage *dto.Usage         `json:"usage,omitempty"`
+	Error *types.OpenAIError `json:"error,omitempty"`
+}
+
+// imageStreamErrorStatus 按上游错误类型推断状态码，便于状态码映射与重试判断
+func imageStreamErrorStatus(streamErr *types.OpenAIError) int {
+	code := fmt.Sprintf("%v", streamErr.Code)
+	switch {
+	case strings.Contains(code, "rate_limit") || strings.Contains(streamErr.Type, "rate_limit"):
+		return http.StatusTooManyRequests
+	case streamErr.Type == "invalid_request_error" || strings.Contains(code, "content_policy") || strings.Contains(code, "moderation"):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// isImageStreamEventNsfw completed 事件是否被上游标记为 NSFW
+func isImageStreamEventNsfw(data string) bool {
+	var event map[string]json.RawMessage
+	if err := common.UnmarshalJsonStr(data, &event); err != nil {
+		return false
+	}
+	return len(detectImageNsfwFlags(event, []map[string]json.RawMessage{event})) > 0
+}
+
+// OpenaiImageStreamHandler 原样转发流式图片事件，并从 completed 事件中累计用量（n > 1 时每张图片各有一个 completed 事件）。
+// 收到 completed 事件后设置 image_stream_completed，供计费判断流是否正常结束。
+// 上游在 200 之后发送 error 事件时停止转发：尚未生成任何图片时返回错误（不计费），否则按已完成的图片计费并将错误事件转发给客户端
+func OpenaiImageStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	if resp == nil || resp.Body == nil {
+		logger.LogError(c, "invalid response or response body")
+		return nil, types.NewError(fmt.Errorf("invalid response"), types.ErrorCodeBadResponse)
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+
+	usage := &dto.Usage{}
+	completed := 0
+	var streamErr *types.OpenAIError
+	// 渠道要求拦截或模糊 NSFW 图片时（上游未按请求返回流式响应等情况），不转发部分图片，completed 事件检查后再转发
+	guarded := info.ChannelSetting.ImageNsfwAction == dto.ImageNsfwActionBlock || info.ChannelSetting.ImageNsfwAction == dto.ImageNsfwActionBlur
+	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		var event imageStreamEvent
+		if err := common.UnmarshalJsonStr(data, &event); err != nil {
+			logger.LogError(c, "failed to unmarshal image stream event: "+err.Error())
+			return true
+		}
+		if guarded && strings.HasSuffix(event.Type, ".partial_image") {
+			return true
+		}
+		if guarded && strings.HasSuffix(event.Type, ".completed") && isImageStreamEventNsfw(data) {
+			streamErr = &types.OpenAIError{Message: "generated image rejected by safety policy: nsfw", Type: "new_api_error", Code: types.ErrorCodeImageSafetyRejected}
+			if completed > 0 {
+				errorEvent, _ := common.Marshal(gin.H{"type": "error", "error": streamErr})
+				c.Render(-1, common.CustomEvent{Data: "event: error\n"})
+				c.Render(-1, common.CustomEvent{Data: "data: " + string(errorEvent)})
+				_ = helper.FlushWriter(c)
+			}
+			return false
+		}
+		if event.Error != nil || event.Type == "error" {
+			streamErr = event.Error
+			if streamErr == nil {
+				streamErr = &types.OpenAIError{Message: "upstream image stream returned an error event", Type: "upstream_error"}
+			}
+			if completed > 0 {
+				c.Render(-1, common.CustomEvent{Data: "event: error\n"})
+				c.Render(-1, common.CustomEvent{Data: "data: " + data})
+				_ = helper.FlushWriter(c)
+			}
+			return false
+		}
+		if event.Type != "" {
+			c.Render(-1, common.CustomEvent{Data: fmt.Sprintf("event: %s\n", event.Type)})
+		}
+		c.Render(-1, common.CustomEvent{Data: "data: " + data})
+		_ = helper.FlushWriter(c)
+
+		if !strings.HasSuffix(event.Type, ".completed") {
+			return true
+		}
+		completed++
+		if event.Usage != nil {
+			usage.PromptTokens += event.Usage.InputTokens
+			usage.CompletionTokens += event.Usage.OutputTokens
+			usage.TotalTokens += event.Usage.TotalTokens
+			if event.Usage.InputTokensDetails != nil {
+				usage.PromptTokensDetails.ImageTokens += event.Usage.InputTokensDetails.ImageTokens
+				usage.PromptTokensDetails.TextTokens += event.Usage.InputTokensDetails.TextTokens
+			}
+		}
+		return true
+	})
+
+	if streamErr != nil {
+		logger.LogWarn(c, fmt.Sprintf("image stream error event after %d completed images, channel #%d: %s", completed, info.ChannelId, streamErr.Message))
+		if completed == 0 {
+			// 已经向客户端发送了部分事件时无法切换渠道重试
+			var ops []types.NewAPIErrorOptions
+			if c.Writer.Written() {
+				ops = append(ops, types.ErrOptionWithSkipRetry())
+			}
+			status := imageStreamErrorStatus(streamErr)
+			if streamErr.Code == types.ErrorCodeImageSafetyRejected {
+				status = http.StatusBadRequest
+				ops = append(ops, types.ErrOptionWithSkipRetry())
+			}
+			return nil, types.WithOpenAIError(*streamErr, status, ops...)
+		}
+	}
+	// 按 completed 事件数结算按次计费，没有 completed 事件的流视为未返回图片，全额退款
+	c.Set("image_returned_count", completed)
+	if completed == 0 {
+		logger.LogWarn(c, fmt.Sprintf("image stream ended without completed event, channel #%d", info.ChannelId))
+	} else {
+		c.Set("image_stream_completed", true)
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage, nil
+}
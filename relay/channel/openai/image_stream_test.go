@@ -0,0 +1,151 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newImageStreamTest(t *testing.T, nsfwAction string, events ...string) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo, *http.Response) {
+	t.Helper()
+	constant.StreamingTimeout = 30
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{IsStream: true, ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.ChannelSetting.ImageNsfwAction = nsfwAction
+	var body strings.Builder
+	for _, event := range events {
+		body.WriteString("data: " + event + "\n\n")
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	return c, recorder, info, resp
+}
+
+const (
+	partialImageEvent   = `{"type":"image_generation.partial_image","b64_json":"cGFydGlhbA==","partial_image_index":0}`
+	completedImageEvent = `{"type":"image_generation.completed","b64_json":"ZmluYWw=","usage":{"input_tokens":10,"output_tokens":100,"total_tokens":110}}`
+	nsfwCompletedEvent  = `{"type":"image_generation.completed","b64_json":"bnNmdw==","nsfw":true,"usage":{"input_tokens":10,"output_tokens":100,"total_tokens":110}}`
+)
+
+func TestImageStreamBlocksNsfwCompletedEvent(t *testing.T) {
+	c, recorder, info, resp := newImageStreamTest(t, dto.ImageNsfwActionBlock, partialImageEvent, nsfwCompletedEvent)
+
+	usage, err := OpenaiImageStreamHandler(c, info, resp)
+	if err == nil {
+		t.Fatalf("nsfw completed event was not blocked, usage: %+v", usage)
+	}
+	if err.StatusCode != http.StatusBadRequest || !types.IsSkipRetryError(err) {
+		t.Fatalf("unexpected error: status %d, skip retry %v", err.StatusCode, types.IsSkipRetryError(err))
+	}
+	if strings.Contains(recorder.Body.String(), "cGFydGlhbA==") || strings.Contains(recorder.Body.String(), "bnNmdw==") {
+		t.Fatalf("image data reached the client: %s", recorder.Body.String())
+	}
+}
+
+func TestImageStreamGuardedChannelForwardsCleanImages(t *testing.T) {
+	c, recorder, info, resp := newImageStreamTest(t, dto.ImageNsfwActionBlur, partialImageEvent, completedImageEvent)
+
+	usage, err := OpenaiImageStreamHandler(c, info, resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage.TotalTokens != 110 {
+		t.Fatalf("total tokens = %d, want 110", usage.TotalTokens)
+	}
+	body := recorder.Body.String()
+	if strings.Contains(body, "cGFydGlhbA==") {
+		t.Fatalf("partial image was forwarded on a guarded channel: %s", body)
+	}
+	if !strings.Contains(body, "ZmluYWw=") {
+		t.Fatalf("completed image was not forwarded: %s", body)
+	}
+}
+
+func TestImageStreamPassChannelForwardsPartials(t *testing.T) {
+	c, recorder, info, resp := newImageStreamTest(t, dto.ImageNsfwActionPass, partialImageEvent, nsfwCompletedEvent)
+
+	if _, err := OpenaiImageStreamHandler(c, info, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "cGFydGlhbA==") || !strings.Contains(body, "bnNmdw==") {
+		t.Fatalf("pass channel should forward every event: %s", body)
+	}
+}
+
+const rateLimitErrorEvent = `{"type":"error","error":{"message":"too many image requests","type":"rate_limit_error","code":"rate_limit_exceeded"}}`
+
+func TestImageStreamErrorBeforeImage(t *testing.T) {
+	// 守护渠道不转发 partial 事件，客户端尚未收到任何数据，可以重试其他渠道
+	c, recorder, info, resp := newImageStreamTest(t, dto.ImageNsfwActionBlur, partialImageEvent, rateLimitErrorEvent)
+	usage, err := OpenaiImageStreamHandler(c, info, resp)
+	if err == nil {
+		t.Fatalf("trailing error event treated as success, usage: %+v", usage)
+	}
+	if err.StatusCode != http.StatusTooManyRequests || types.IsSkipRetryError(err) || !strings.Contains(err.Error(), "too many image requests") {
+		t.Fatalf("unexpected error: status %d, skip retry %v, %v", err.StatusCode, types.IsSkipRetryError(err), err)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("events reached the client: %s", recorder.Body.String())
+	}
+
+	// 已经转发了 partial 事件时不再重试
+	c, _, info, resp = newImageStreamTest(t, "", partialImageEvent, `{"type":"error"}`)
+	if _, err = OpenaiImageStreamHandler(c, info, resp); err == nil || err.StatusCode != http.StatusBadGateway || !types.IsSkipRetryError(err) {
+		t.Fatalf("error after forwarded partials: %v", err)
+	}
+}
+
+func TestImageStreamErrorAfterCompletedImage(t *testing.T) {
+	c, recorder, info, resp := newImageStreamTest(t, "", completedImageEvent, rateLimitErrorEvent, completedImageEvent)
+	usage, err := OpenaiImageStreamHandler(c, info, resp)
+	if err != nil {
+		t.Fatalf("error after a completed image discarded it: %v", err)
+	}
+	// 按已完成的图片计费，错误事件之后的数据不再转发
+	if usage.TotalTokens != 110 {
+		t.Fatalf("total tokens = %d, want the completed image only", usage.TotalTokens)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "ZmluYWw=") || !strings.Contains(body, "event: error") || !strings.Contains(body, "too many image requests") {
+		t.Fatalf("completed image and error event not forwarded: %s", body)
+	}
+	if strings.Count(body, "ZmluYWw=") != 1 {
+		t.Fatalf("events after the error were forwarded: %s", body)
+	}
+}
+
+func TestImageStreamReturnedCount(t *testing.T) {
+	for name, tc := range map[string]struct {
+		events []string
+		want   int
+	}{
+		// 没有 completed 事件的流按未返回图片结算，全额退款
+		"no completed event": {events: []string{partialImageEvent}, want: 0},
+		"completed":          {events: []string{partialImageEvent, completedImageEvent}, want: 1},
+		"two images":         {events: []string{completedImageEvent, completedImageEvent}, want: 2},
+	} {
+		c, _, info, resp := newImageStreamTest(t, "", tc.events...)
+		if _, err := OpenaiImageStreamHandler(c, info, resp); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if count, ok := c.Get("image_returned_count"); !ok || count.(int) != tc.want {
+			t.Fatalf("%s: image_returned_count = %v (set %v), want %d", name, count, ok, tc.want)
+		}
+	}
+}
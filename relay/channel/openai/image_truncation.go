@@ -0,0 +1,110 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imagePromptTruncatedHeader = "X-New-Api-Prompt-Truncated"
+
+// 常见上游声明提示词被截断的方式：响应头、顶层字段或 data[] 中的字段
+var (
+	imagePromptTruncationHeaders = []string{"X-Prompt-Truncated"}
+	imagePromptTruncationFields  = []string{"prompt_truncated", "truncated_prompt", "is_prompt_truncated"}
+)
+
+// detectImagePromptTruncation 判断上游是否声明截断了提示词
+func detectImagePromptTruncation(resp *http.Response, response map[string]json.RawMessage, items []map[string]json.RawMessage) bool {
+	for _, header := range imagePromptTruncationHeaders {
+		if truncated, err := strconv.ParseBool(resp.Header.Get(header)); err == nil && truncated {
+			return true
+		}
+	}
+	containers := append([]map[string]json.RawMessage{response}, items...)
+	for _, container := range containers {
+		for _, field := range imagePromptTruncationFields {
+			var flag bool
+			if raw, ok := container[field]; ok && common.Unmarshal(raw, &flag) == nil && flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyImagePromptTruncationPolicy 上游截断了提示词时通过响应头与 new_api.prompt_truncated 告知客户端，
+// 渠道要求完整提示词时直接返回错误（不计费）
+func applyImagePromptTruncationPolicy(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) *types.NewAPIError {
+	if resp == nil {
+		return nil
+	}
+	if info.IsStream {
+		// 流式响应转发前只能检查响应头
+		if !detectImagePromptTruncation(resp, nil, nil) {
+			return nil
+		}
+		logger.LogWarn(c, fmt.Sprintf("upstream truncated the image prompt, channel #%d, reject: %t", info.ChannelId, info.ChannelSetting.ImageRejectTruncatedPrompt))
+		if info.ChannelSetting.ImageRejectTruncatedPrompt {
+			return types.NewErrorWithStatusCode(errors.New("prompt was truncated by the upstream provider, generation rejected"), types.ErrorCodeImagePromptTruncated, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		c.Writer.Header().Set(imagePromptTruncatedHeader, "true")
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	var items []map[string]json.RawMessage
+	_ = common.Unmarshal(response["data"], &items)
+	if !detectImagePromptTruncation(resp, response, items) {
+		return nil
+	}
+	logger.LogWarn(c, fmt.Sprintf("upstream truncated the image prompt, channel #%d, reject: %t", info.ChannelId, info.ChannelSetting.ImageRejectTruncatedPrompt))
+	if info.ChannelSetting.ImageRejectTruncatedPrompt {
+		return types.NewErrorWithStatusCode(errors.New("prompt was truncated by the upstream provider, generation rejected"), types.ErrorCodeImagePromptTruncated, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	c.Writer.Header().Set(imagePromptTruncatedHeader, "true")
+	fields := make(map[string]any)
+	if existing, ok := response["new_api"]; ok {
+		_ = common.Unmarshal(existing, &fields)
+	}
+	fields["prompt_truncated"] = true
+	if response["new_api"], err = common.Marshal(fields); err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	body, err = common.Marshal(response)
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return nil
+}
@@ -0,0 +1,101 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTruncationTestResponse 模拟上游响应，header 非空时通过 X-Prompt-Truncated 声明截断
+func newTruncationTestResponse(header string, body string) *http.Response {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	if header != "" {
+		resp.Header.Set("X-Prompt-Truncated", header)
+	}
+	return resp
+}
+
+func newTruncationTestContext(reject bool, stream bool) (*gin.Context, *relaycommon.RelayInfo) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}, IsStream: stream}
+	info.ChannelSetting.ImageRejectTruncatedPrompt = reject
+	return c, info
+}
+
+func TestImagePromptTruncationWarning(t *testing.T) {
+	for name, tc := range map[string]struct {
+		header string
+		body   string
+	}{
+		"header":         {header: "true", body: `{"created":1,"data":[{"url":"https://cdn.example.com/a.png"}]}`},
+		"top-level flag": {body: `{"created":1,"prompt_truncated":true,"data":[{"url":"https://cdn.example.com/a.png"}]}`},
+		"image flag":     {body: `{"created":1,"data":[{"url":"https://cdn.example.com/a.png","truncated_prompt":true}],"new_api":{"request_id":"req-1"}}`},
+	} {
+		c, info := newTruncationTestContext(false, false)
+		resp := newTruncationTestResponse(tc.header, tc.body)
+		if err := applyImagePromptTruncationPolicy(c, info, resp); err != nil {
+			t.Fatalf("%s: truncated response rejected without the channel option: %v", name, err)
+		}
+		if c.Writer.Header().Get(imagePromptTruncatedHeader) != "true" {
+			t.Fatalf("%s: truncation header not set", name)
+		}
+		forwarded, _ := io.ReadAll(resp.Body)
+		var response struct {
+			Data   []map[string]any `json:"data"`
+			NewApi map[string]any   `json:"new_api"`
+		}
+		if err := common.Unmarshal(forwarded, &response); err != nil {
+			t.Fatalf("%s: decode forwarded body %q: %v", name, forwarded, err)
+		}
+		if response.NewApi["prompt_truncated"] != true || len(response.Data) != 1 {
+			t.Fatalf("%s: forwarded body %s, want new_api.prompt_truncated and the image kept", name, forwarded)
+		}
+		// 上游已有的 new_api 字段保留
+		if strings.Contains(tc.body, "req-1") && response.NewApi["request_id"] != "req-1" {
+			t.Fatalf("%s: existing new_api fields dropped: %s", name, forwarded)
+		}
+	}
+}
+
+func TestImagePromptTruncationRejected(t *testing.T) {
+	c, info := newTruncationTestContext(true, false)
+	err := applyImagePromptTruncationPolicy(c, info, newTruncationTestResponse("", `{"created":1,"data":[{"url":"https://cdn.example.com/a.png"}],"is_prompt_truncated":true}`))
+	if err == nil || err.StatusCode != http.StatusBadRequest || err.GetErrorCode() != types.ErrorCodeImagePromptTruncated {
+		t.Fatalf("truncated response not rejected: %v", err)
+	}
+
+	// 流式响应只检查响应头
+	c, info = newTruncationTestContext(true, true)
+	if err = applyImagePromptTruncationPolicy(c, info, newTruncationTestResponse("true", "")); err == nil || err.GetErrorCode() != types.ErrorCodeImagePromptTruncated {
+		t.Fatalf("truncated stream not rejected: %v", err)
+	}
+}
+
+func TestImagePromptTruncationIgnoresFullPrompt(t *testing.T) {
+	const body = `{"created":1,"prompt_truncated":false,"data":[{"url":"https://cdn.example.com/a.png"}]}`
+	c, info := newTruncationTestContext(true, false)
+	resp := newTruncationTestResponse("false", body)
+	if err := applyImagePromptTruncationPolicy(c, info, resp); err != nil {
+		t.Fatalf("untruncated response rejected: %v", err)
+	}
+	if c.Writer.Header().Get(imagePromptTruncatedHeader) != "" {
+		t.Fatal("truncation header set for an untruncated response")
+	}
+	if forwarded, _ := io.ReadAll(resp.Body); string(forwarded) != body {
+		t.Fatalf("untruncated body changed: %s", forwarded)
+	}
+}
@@ -0,0 +1,10 @@
+package stability
+
+const (
+	ChannelName = "stability"
+)
+
+var ModelList = []string{
+	"stable-diffusion-xl-1024-v1-0",
+	"stable-diffusion-v1-6",
+}
@@ -0,0 +1,237 @@
+package stability
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	finishReasonSuccess         = "SUCCESS"
+	finishReasonContentFiltered = "CONTENT_FILTERED"
+)
+
+// passthroughOptions 客户端可通过 extra_fields、顶层额外字段或编辑表单直接传给上游的参数
+var passthroughOptions = []string{"cfg_scale", "steps", "seed", "sampler", "style_preset", "clip_guidance_preset", "image_strength", "init_image_mode", "mask_source"}
+
+type ImageResponse struct {
+	Artifacts []struct {
+		Base64       string `json:"base64"`
+		Seed         int64  `json:"seed"`
+		FinishReason string `json:"finishReason"`
+	} `json:"artifacts"`
+}
+
+// convertImageRequest 将 OpenAI 图片请求转换为 Stability v1 的 multipart 表单：
+// prompt 写入 text_prompts，n 写入 samples，生成请求的 size 拆分为 width/height；
+// 编辑请求的第一张图片作为 init_image，蒙版作为 mask_image 并改用 masking 接口
+func (a *Adaptor) convertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (*bytes.Buffer, error) {
+	var mf *multipart.Form
+	if info.RelayMode == relayconstant.RelayModeImagesEdits {
+		if _, err := c.MultipartForm(); err != nil {
+			return nil, errors.New("failed to parse multipart form")
+		}
+		mf = c.Request.MultipartForm
+	}
+	options, err := collectImageOptions(request, mf)
+	if err != nil {
+		return nil, err
+	}
+
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+	fields := [][2]string{
+		{"text_prompts[0][text]", request.Prompt},
+		{"text_prompts[0][weight]", "1"},
+	}
+	if negative := options["negative_prompt"]; negative != "" {
+		fields = append(fields, [2]string{"text_prompts[1][text]", negative}, [2]string{"text_prompts[1][weight]", "-1"})
+	}
+	fields = append(fields, [2]string{"samples", strconv.Itoa(int(max(request.N, 1)))})
+	if info.RelayMode == relayconstant.RelayModeImagesGenerations && request.Size != "" {
+		width, height, err := parseImageSize(request.Size)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, [2]string{"width", strconv.Itoa(width)}, [2]string{"height", strconv.Itoa(height)})
+	}
+	for _, name := range passthroughOptions {
+		if value := options[name]; value != "" {
+			fields = append(fields, [2]string{name, value})
+		}
+	}
+
+	if mf != nil {
+		imageFiles, err := relaycommon.CollectImageFormFiles(mf)
+		if err != nil {
+			return nil, err
+		}
+		if len(imageFiles) == 0 {
+			return nil, errors.New("image is required")
+		}
+		if err = writeFormFile(writer, "init_image", imageFiles[0]); err != nil {
+			return nil, err
+		}
+		if maskFiles := mf.File["mask"]; len(maskFiles) > 0 {
+			a.masked = true
+			if err = writeFormFile(writer, "mask_image", maskFiles[0]); err != nil {
+				return nil, err
+			}
+			if options["mask_source"] == "" {
+				fields = append(fields, [2]string{"mask_source", "MASK_IMAGE_WHITE"})
+			}
+		}
+	}
+	for _, field := range fields {
+		if err = writer.WriteField(field[0], field[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	a.contentType = writer.FormDataContentType()
+	if mf != nil {
+		// 编辑请求的参数覆盖按请求头中的分界线解析表单
+		c.Request.Header.Set("Content-Type", a.contentType)
+	}
+	return &requestBody, nil
+}
+
+// collectImageOptions 收集额外参数，优先级：编辑表单 > extra_fields > 顶层额外字段
+func collectImageOptions(request dto.ImageRequest, mf *multipart.Form) (map[string]string, error) {
+	options := make(map[string]string)
+	for name, raw := range request.Extra {
+		options[name] = rawOptionValue(raw)
+	}
+	if len(request.Seed) > 0 {
+		options["seed"] = rawOptionValue(request.Seed)
+	}
+	if len(request.ExtraFields) > 0 {
+		var extraFields map[string]any
+		if err := common.Unmarshal(request.ExtraFields, &extraFields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal extra fields: %w", err)
+		}
+		for name, value := range extraFields {
+			data, err := common.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			options[name] = rawOptionValue(data)
+		}
+	}
+	if mf != nil {
+		for name, values := range mf.Value {
+			if len(values) > 0 {
+				options[name] = values[0]
+			}
+		}
+	}
+	return options, nil
+}
+
+func rawOptionValue(raw []byte) string {
+	var text string
+	if err := common.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// parseImageSize 解析 "宽x高"，Stability 要求宽高为 64 的倍数
+func parseImageSize(size string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q, expected <width>x<height>", size)
+	}
+	width, errW := strconv.Atoi(strings.TrimSpace(w))
+	height, errH := strconv.Atoi(strings.TrimSpace(h))
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected <width>x<height>", size)
+	}
+	if width%64 != 0 || height%64 != 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, width and height must be multiples of 64", size)
+	}
+	return width, height, nil
+}
+
+func writeFormFile(writer *multipart.Writer, fieldName string, fileHeader *multipart.FileHeader) error {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s file: %w", fieldName, err)
+	}
+	defer file.Close()
+	part, err := writer.CreateFormFile(fieldName, fileHeader.Filename)
+	if err != nil {
+		return fmt.Errorf("create form file failed for %s: %w", fieldName, err)
+	}
+	if _, err = io.Copy(part, file); err != nil {
+		return fmt.Errorf("copy %s file failed: %w", fieldName, err)
+	}
+	return nil
+}
+
+// stabilityImageHandler 将 artifacts 转换为 OpenAI 图片响应（仅 b64_json）。被内容过滤的图片不返回，
+// 通过 image_returned_count 按实际返回的张数计费；全部被过滤时返回安全策略错误
+func stabilityImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.Usage, *types.NewAPIError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	service.CloseResponseBodyGracefully(resp)
+
+	var stabilityResponse ImageResponse
+	if err = common.Unmarshal(responseBody, &stabilityResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	imageResponse := dto.ImageResponse{
+		Created: info.StartTime.Unix(),
+		Data:    make([]dto.ImageData, 0, len(stabilityResponse.Artifacts)),
+	}
+	filtered := 0
+	for _, artifact := range stabilityResponse.Artifacts {
+		if artifact.FinishReason == finishReasonContentFiltered {
+			filtered++
+			continue
+		}
+		if artifact.FinishReason != "" && artifact.FinishReason != finishReasonSuccess || artifact.Base64 == "" {
+			continue
+		}
+		seed := artifact.Seed
+		imageResponse.Data = append(imageResponse.Data, dto.ImageData{B64Json: artifact.Base64, Seed: &seed})
+	}
+	if filtered > 0 {
+		logger.LogWarn(c, fmt.Sprintf("stability filtered %d of %d images on channel #%d", filtered, len(stabilityResponse.Artifacts), info.ChannelId))
+		if len(imageResponse.Data) == 0 {
+			return nil, types.NewErrorWithStatusCode(errors.New("generated image rejected by safety policy: content filtered"), types.ErrorCodeImageSafetyRejected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+	}
+	c.Set("image_returned_count", len(imageResponse.Data))
+
+	jsonResponse, err := common.Marshal(imageResponse)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	if _, err = c.Writer.Write(jsonResponse); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	return &dto.Usage{}, nil
+}
@@ -0,0 +1,20 @@
+package stability
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// imageErrorRules Stability AI 的错误名称（name 字段）与信息
+var imageErrorRules = []channel.ImageErrorRule{
+	{Codes: []string{"invalid_prompts", "content_moderation"}, Keywords: []string{"flagged by our content moderation"}, ErrorCode: types.ErrorCodeImageContentPolicy},
+	{StatusCode: http.StatusBadRequest, Codes: []string{"invalid_height_or_width", "invalid_sdxl_v222_dimensions", "invalid_sdxl_v1_dimensions"}, Keywords: []string{"dimensions", "height and width"}, ErrorCode: types.ErrorCodeImageInvalidSize},
+	{StatusCode: http.StatusPaymentRequired, Keywords: []string{"balance", "credits"}, ErrorCode: types.ErrorCodeImageProviderQuota},
+	{StatusCode: http.StatusServiceUnavailable, ErrorCode: types.ErrorCodeImageModelOverloaded},
+}
+
+func (a *Adaptor) ImageErrorRules() []channel.ImageErrorRule {
+	return imageErrorRules
+}
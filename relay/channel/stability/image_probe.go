@@ -0,0 +1,50 @@
+package stability
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProbeImageChannel 通过 GET /v1/engines/list 校验密钥，并确认上游模型（engine）在列表中，不产生生成费用
+func (a *Adaptor) ProbeImageChannel(c *gin.Context, info *relaycommon.RelayInfo) (bool, *types.NewAPIError) {
+	req, err := http.NewRequest(http.MethodGet, info.ChannelBaseUrl+"/v1/engines/list", nil)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	if err = a.SetupRequestHeader(c, &req.Header, info); err != nil {
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	resp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return true, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return true, service.RelayErrorHandler(c.Request.Context(), resp, true)
+	}
+	body, err := io.ReadAll(resp.Body)
+	service.CloseResponseBodyGracefully(resp)
+	if err != nil {
+		return true, types.NewOpenAIError(err, types.ErrorCodeReadResponseBodyFailed, http.StatusInternalServerError)
+	}
+	var engines []struct {
+		Id string `json:"id"`
+	}
+	if err = common.Unmarshal(body, &engines); err != nil {
+		return true, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	for _, engine := range engines {
+		if engine.Id == info.UpstreamModelName {
+			return true, nil
+		}
+	}
+	return true, types.NewOpenAIError(fmt.Errorf("engine %s is not available for this api key", info.UpstreamModelName), types.ErrorCodeBadResponse, http.StatusNotFound)
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"errors"
+	"mime/multipart"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// indexedImageFieldPattern 匹配 image[0]、image[1] 形式的字段，不匹配 image[foo] 等其他名称
+var indexedImageFieldPattern = regexp.MustCompile(`^image\[\d+\]$`)
+
+var ErrAmbiguousImageFields = errors.New("ambiguous image fields: image and image[] must not be sent together")
+
+// CollectImageFormFiles 按固定顺序（image、image[]、image[0]、image[1]...）收集编辑请求中的图片文件。
+// 同时出现多种写法时按配置处理：merge 合并全部文件，error 返回 ErrAmbiguousImageFields。
+// 计数与转发都使用该函数，保证两者看到的图片一致
+func CollectImageFormFiles(mf *multipart.Form) ([]*multipart.FileHeader, error) {
+	if mf == nil || mf.File == nil {
+		return nil, nil
+	}
+	var groups [][]*multipart.FileHeader
+	if files := mf.File["image"]; len(files) > 0 {
+		groups = append(groups, files)
+	}
+	if files := mf.File["image[]"]; len(files) > 0 {
+		groups = append(groups, files)
+	}
+
+	var indexedFields []string
+	for fieldName, files := range mf.File {
+		if indexedImageFieldPattern.MatchString(fieldName) && len(files) > 0 {
+			indexedFields = append(indexedFields, fieldName)
+		}
+	}
+	sort.Slice(indexedFields, func(i, j int) bool {
+		a, errA := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(indexedFields[i], "image["), "]"))
+		b, errB := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(indexedFields[j], "image["), "]"))
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return indexedFields[i] < indexedFields[j]
+	})
+	if len(indexedFields) > 0 {
+		var indexed []*multipart.FileHeader
+		for _, fieldName := range indexedFields {
+			indexed = append(indexed, mf.File[fieldName]...)
+		}
+		groups = append(groups, indexed)
+	}
+
+	if len(groups) > 1 && model_setting.GetImageSettings().MultipartDuplicatePolicy == model_setting.ImageMultipartDuplicateError {
+		return nil, ErrAmbiguousImageFields
+	}
+	var imageFiles []*multipart.FileHeader
+	for _, group := range groups {
+		imageFiles = append(imageFiles, group...)
+	}
+	return imageFiles, nil
+}
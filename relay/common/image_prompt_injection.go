@@ -0,0 +1,31 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/logger"
+)
+
+// InjectImagePrompt 按渠道配置在提示词前后追加固定文本，返回发往上游的提示词。
+// 客户端提示词为空时不追加，保持上游原有的校验行为；拼接后超过 MaxLength 时截断客户端提示词，
+// 固定文本本身已超过上限时不追加
+func InjectImagePrompt(ctx context.Context, info *RelayInfo, prompt string) string {
+	injection := info.ChannelSetting.ImagePromptInjection
+	if injection == nil || (injection.Prefix == "" && injection.Suffix == "") || prompt == "" {
+		return prompt
+	}
+	if injection.MaxLength > 0 {
+		fixed := len([]rune(injection.Prefix)) + len([]rune(injection.Suffix))
+		if fixed >= injection.MaxLength {
+			logger.LogWarn(ctx, fmt.Sprintf("image prompt injection of channel #%d exceeds max length %d, skipped", info.ChannelId, injection.MaxLength))
+			return prompt
+		}
+		if runes := []rune(prompt); fixed+len(runes) > injection.MaxLength {
+			prompt = string(runes[:injection.MaxLength-fixed])
+		}
+	}
+	injected := injection.Prefix + prompt + injection.Suffix
+	logger.LogDebug(ctx, "image prompt injected for channel #%d: %s", info.ChannelId, injected)
+	return injected
+}
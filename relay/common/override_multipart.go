@@ -0,0 +1,164 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// multipartPart 暂存的表单分段，文件分段原样转发
+type multipartPart struct {
+	header textproto.MIMEHeader
+	name   string
+	isFile bool
+	data   []byte
+}
+
+// ApplyMultipartParamOverride 对 multipart 请求体的普通字段执行参数覆盖：字段先转为 JSON 对象，
+// 按 ApplyParamOverride 的规则（包括操作格式）修改后重新生成表单。客户端的取值被覆盖，不存在的字段追加到末尾，
+// 被删除的字段不再转发；文件分段保持原样。重新生成时沿用原分界线，请求头中的 Content-Type 无需修改
+func ApplyMultipartParamOverride(body []byte, contentType string, paramOverride map[string]interface{}) ([]byte, error) {
+	if len(paramOverride) == 0 {
+		return body, nil
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("parse multipart content type failed: %w", err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("multipart boundary is missing")
+	}
+
+	var parts []multipartPart
+	values := make(map[string][]string)
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read multipart body failed: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("read multipart field %q failed: %w", part.FormName(), err)
+		}
+		p := multipartPart{header: part.Header, name: part.FormName(), isFile: part.FileName() != "", data: data}
+		if !p.isFile {
+			values[p.name] = append(values[p.name], string(data))
+		}
+		parts = append(parts, p)
+	}
+
+	fields := make(map[string]any, len(values))
+	for name, vs := range values {
+		if len(vs) == 1 {
+			fields[name] = vs[0]
+		} else {
+			fields[name] = vs
+		}
+	}
+	jsonData, err := common.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err = ApplyParamOverride(jsonData, paramOverride)
+	if err != nil {
+		return nil, err
+	}
+	var overridden map[string]any
+	if err = common.Unmarshal(jsonData, &overridden); err != nil {
+		return nil, fmt.Errorf("param override result is not an object: %w", err)
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err = writer.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	written := make(map[string]bool)
+	writeField := func(name string) error {
+		written[name] = true
+		value, ok := overridden[name]
+		if !ok || value == nil {
+			return nil
+		}
+		items, isList := value.([]any)
+		if !isList {
+			items = []any{value}
+		}
+		for _, item := range items {
+			text, err := multipartFieldValue(item)
+			if err != nil {
+				return fmt.Errorf("param override field %q: %w", name, err)
+			}
+			if err = writer.WriteField(name, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, p := range parts {
+		if p.isFile {
+			w, err := writer.CreatePart(p.header)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = w.Write(p.data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if written[p.name] {
+			continue
+		}
+		if err = writeField(p.name); err != nil {
+			return nil, err
+		}
+	}
+	// 覆盖中新增的字段按名称排序追加，保证请求体稳定
+	var added []string
+	for name := range overridden {
+		if !written[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		if err = writeField(name); err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// multipartFieldValue 覆盖值转为表单字段文本，对象与数组按 JSON 写入
+func multipartFieldValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		data, err := common.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
@@ -103,6 +103,7 @@ type RelayInfo struct {
 	AudioUsage             bool
 	ReasoningEffort        string
 	UserSetting            dto.UserSetting
+	TokenSetting           dto.TokenSetting
 	UserEmail              string
 	UserQuota              int
 	RelayFormat            types.RelayFormat
@@ -425,6 +426,11 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		info.UserSetting = userSetting
 	}
 
+	tokenSetting, ok := common.GetContextKeyType[dto.TokenSetting](c, constant.ContextKeyTokenSetting)
+	if ok {
+		info.TokenSetting = tokenSetting
+	}
+
 	return info
 }
 
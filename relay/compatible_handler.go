@@ -47,6 +47,12 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
 
+	if request.Stream {
+		if newAPIError = service.CheckStreamMinimumQuota(c, info); newAPIError != nil {
+			return newAPIError
+		}
+	}
+
 	includeUsage := true
 	// 判断用户是否需要返回使用情况
 	if request.StreamOptions != nil {
@@ -293,6 +299,14 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		extraContent += fmt.Sprintf("Image Generation Call 花费 %s", dImageGenerationCallQuota.String())
 	}
 
+	// 图片放大附加计费
+	var dImageUpscaleQuota decimal.Decimal
+	if upscaleCount := ctx.GetInt("image_upscale_count"); upscaleCount > 0 {
+		dImageUpscaleQuota = decimal.NewFromFloat(ctx.GetFloat64("image_upscale_price")).
+			Mul(decimal.NewFromInt(int64(upscaleCount))).Mul(dGroupRatio).Mul(dQuotaPerUnit)
+		extraContent += fmt.Sprintf("图片放大 %d 张，放大花费 %s", upscaleCount, dImageUpscaleQuota.String())
+	}
+
 	var quotaCalculateDecimal decimal.Decimal
 
 	var audioInputQuota decimal.Decimal
@@ -349,6 +363,22 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(audioInputQuota)
 	// 添加 image generation call 计费
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dImageGenerationCallQuota)
+	// 添加图片放大计费
+	quotaCalculateDecimal = quotaCalculateDecimal.Add(dImageUpscaleQuota)
+
+	// 响应过慢自动退还部分费用
+	quotaCalculateDecimal, refundContent := applySlowRefundQuota(ctx, quotaCalculateDecimal)
+	extraContent += refundContent
+
+	// 命中图片结果缓存时按命中倍率计费
+	if ctx.GetBool(ImageResultCacheHitKey) {
+		hitRatio := model_setting.GetImageSettings().ResultCacheHitRatio
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(hitRatio))
+		if extraContent != "" {
+			extraContent += ", "
+		}
+		extraContent += fmt.Sprintf("命中结果缓存，按 %.0f%% 计费", hitRatio*100)
+	}
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
@@ -371,6 +401,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
 	}
 
+	// 记录实际计费额度，供需要向客户端返回费用的接口使用
+	ctx.Set("consumed_quota", quota)
+
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
 
 	//logger.LogInfo(ctx, fmt.Sprintf("request quota delta: %s", logger.FormatQuota(quotaDelta)))
@@ -451,6 +484,15 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		other["image_generation_call"] = true
 		other["image_generation_call_price"] = imageGenerationCallPrice
 	}
+	if audit, ok := ctx.Get(imageUpstreamAuditKey); ok {
+		other["upstream_audit"] = audit
+	}
+	if imageUsage, ok := ctx.Get(imageUsageLogKey); ok {
+		other["image_usage"] = imageUsage
+	}
+	if ctx.GetBool(imageRequestIdKey) {
+		other["request_id"] = ctx.GetString(common.RequestIdKey)
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
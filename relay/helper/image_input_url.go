@@ -0,0 +1,219 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageInputUrlFields JSON 编辑请求中传入图片地址的字段，不作为普通参数转发
+var imageInputUrlFields = []string{"image_url", "images", "mask_url"}
+
+// convertImageEditUrlInputs 将 JSON 编辑请求中的 image_url（字符串或数组）、images[].image_url 与 mask_url
+// 下载后改写为等价的 multipart 表单，之后的计数、大小限制与各渠道的转换都与上传文件的请求一致。
+// 下载经过 fetch_setting 的 SSRF 防护（私有地址、域名与 IP 白名单/黑名单、端口限制），并限制大小与耗时
+func convertImageEditUrlInputs(c *gin.Context, imageRequest *dto.ImageRequest) error {
+	imageUrls, err := collectImageInputUrls(imageRequest.Extra)
+	if err != nil {
+		return err
+	}
+	var maskUrl string
+	if raw, ok := imageRequest.Extra["mask_url"]; ok {
+		if err = common.Unmarshal(raw, &maskUrl); err != nil {
+			return fmt.Errorf("invalid value for field mask_url: %w", err)
+		}
+	}
+	if len(imageUrls) == 0 && maskUrl == "" {
+		return nil
+	}
+	settings := model_setting.GetImageSettings()
+	if !settings.InputUrlEnabled {
+		return errors.New("image_url is not supported, please upload images as multipart/form-data")
+	}
+	if len(imageUrls) == 0 {
+		return errors.New("image_url is required when mask_url is provided")
+	}
+	if settings.InputUrlMaxCount > 0 && len(imageUrls) > settings.InputUrlMaxCount {
+		return fmt.Errorf("too many image_url values: %d, maximum is %d", len(imageUrls), settings.InputUrlMaxCount)
+	}
+
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err = common.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+	for _, name := range imageInputUrlFields {
+		delete(fields, name)
+		delete(imageRequest.Extra, name)
+	}
+	delete(fields, "image")
+	// 规范化后的参数优先
+	fields["model"], _ = common.Marshal(imageRequest.Model)
+	fields["prompt"], _ = common.Marshal(imageRequest.Prompt)
+	fields["n"], _ = common.Marshal(imageRequest.N)
+	if imageRequest.Size != "" {
+		fields["size"], _ = common.Marshal(imageRequest.Size)
+	}
+	if imageRequest.Quality != "" {
+		fields["quality"], _ = common.Marshal(imageRequest.Quality)
+	}
+	if len(imageRequest.OutputFormat) > 0 {
+		fields["output_format"] = imageRequest.OutputFormat
+	}
+	for name, value := range map[string]json.RawMessage{"background": imageRequest.Background, "input_fidelity": imageRequest.InputFidelity} {
+		if len(value) == 0 {
+			delete(fields, name)
+		}
+	}
+
+	timeout := time.Duration(settings.InputUrlTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	var formBody bytes.Buffer
+	writer := multipart.NewWriter(&formBody)
+	for name, raw := range fields {
+		if err = writer.WriteField(name, imageFormFieldValue(raw)); err != nil {
+			return err
+		}
+	}
+	imageField := "image"
+	if len(imageUrls) > 1 {
+		imageField = "image[]"
+	}
+	for i, imageUrl := range imageUrls {
+		if err = writeImageUrlFile(ctx, writer, imageField, fmt.Sprintf("image_%d", i), imageUrl, settings.InputUrlMaxBytes); err != nil {
+			return fmt.Errorf("image_url %d: %w", i, err)
+		}
+	}
+	if maskUrl != "" {
+		if err = writeImageUrlFile(ctx, writer, "mask", "mask", maskUrl, settings.InputUrlMaxBytes); err != nil {
+			return fmt.Errorf("mask_url: %w", err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	data := formBody.Bytes()
+	form, err := multipart.NewReader(bytes.NewReader(data), writer.Boundary()).ReadForm(32 << 20)
+	if err != nil {
+		return fmt.Errorf("build image edit form failed: %w", err)
+	}
+	// 后续处理（包括重试与透传）都按上传文件的请求处理
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Request.MultipartForm = form
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	c.Set(common.KeyRequestBody, data)
+	logger.LogInfo(c, fmt.Sprintf("fetched %d image_url inputs for image edit request", len(imageUrls)))
+	return nil
+}
+
+// collectImageInputUrls 按顺序收集 image_url 与 images[].image_url
+func collectImageInputUrls(extra map[string]json.RawMessage) ([]string, error) {
+	var urls []string
+	if raw, ok := extra["image_url"]; ok {
+		var single string
+		if err := common.Unmarshal(raw, &single); err == nil {
+			urls = append(urls, single)
+		} else {
+			var list []string
+			if err = common.Unmarshal(raw, &list); err != nil {
+				return nil, errors.New("invalid value for field image_url: must be a string or an array of strings")
+			}
+			urls = append(urls, list...)
+		}
+	}
+	if raw, ok := extra["images"]; ok {
+		var images []struct {
+			ImageUrl string `json:"image_url"`
+		}
+		if err := common.Unmarshal(raw, &images); err != nil {
+			return nil, errors.New("invalid value for field images: must be an array of {\"image_url\": ...}")
+		}
+		for _, image := range images {
+			urls = append(urls, image.ImageUrl)
+		}
+	}
+	for i, u := range urls {
+		if strings.TrimSpace(u) == "" {
+			return nil, fmt.Errorf("image_url %d is empty", i)
+		}
+	}
+	return urls, nil
+}
+
+// writeImageUrlFile 下载图片（data URI 直接解码）并写入表单文件字段
+func writeImageUrlFile(ctx context.Context, writer *multipart.Writer, fieldName string, baseName string, imageUrl string, maxBytes int64) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(imageUrl, "data:") {
+		idx := strings.Index(imageUrl, ",")
+		if idx == -1 {
+			return errors.New("invalid data uri")
+		}
+		data, err = base64.StdEncoding.DecodeString(imageUrl[idx+1:])
+		if err == nil && maxBytes > 0 && int64(len(data)) > maxBytes {
+			err = fmt.Errorf("image size %d exceeds maximum allowed size of %d bytes", len(data), maxBytes)
+		}
+	} else if strings.HasPrefix(imageUrl, "http://") || strings.HasPrefix(imageUrl, "https://") {
+		data, err = service.DownloadImage(ctx, imageUrl, maxBytes)
+	} else {
+		err = errors.New("only http(s) urls and data uris are supported")
+	}
+	if err != nil {
+		return err
+	}
+	contentType := service.ImageContentType(ctx, data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return errors.New("fetched content is not a supported image")
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s.%s"`, fieldName, baseName, strings.TrimPrefix(contentType, "image/")))
+	h.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
+
+// imageFormFieldValue JSON 字段转为表单文本，字符串取原值，其他类型按 JSON 写入
+func imageFormFieldValue(raw json.RawMessage) string {
+	var text string
+	if err := common.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var number json.Number
+	if err := common.Unmarshal(raw, &number); err == nil {
+		return number.String()
+	}
+	if b, err := strconv.ParseBool(string(raw)); err == nil {
+		return strconv.FormatBool(b)
+	}
+	return string(raw)
+}
@@ -0,0 +1,215 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var imageSizePattern = regexp.MustCompile(`^(\d+)\s*[xX×*]\s*(\d+)$`)
+
+// normalizeImageSizeAndQuality 规范化 size 与 quality：quality 转为小写，size 统一为 "宽x高"，
+// 并按模型配置的允许尺寸校验，避免大小写或分隔符不一致的参数直接发往上游
+func normalizeImageSizeAndQuality(imageRequest *dto.ImageRequest) error {
+	imageRequest.Quality = strings.ToLower(strings.TrimSpace(imageRequest.Quality))
+	size := strings.TrimSpace(imageRequest.Size)
+	if match := imageSizePattern.FindStringSubmatch(size); match != nil {
+		size = match[1] + "x" + match[2]
+	} else if strings.EqualFold(size, "auto") {
+		size = "auto"
+	}
+	imageRequest.Size = size
+
+	if size == "" {
+		return nil
+	}
+	allowed, configured := model_setting.GetImageSettings().GetModelAllowedSizes(imageRequest.Model)
+	if !configured || slices.Contains(allowed, size) {
+		return nil
+	}
+	return types.NewErrorWithStatusCode(
+		fmt.Errorf("invalid value for field size: %s is not supported by model %s, supported sizes: %s", size, imageRequest.Model, strings.Join(allowed, ", ")),
+		types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// normalizeImageN 未指定 n 时按 OpenAI 的语义取 1，并按模型配置的最大张数校验：
+// 默认拒绝超出范围的请求，配置为 clamp 时改为最大张数并记录警告。校验在预扣费之前执行，避免异常的 n 放大费用
+func normalizeImageN(c *gin.Context, imageRequest *dto.ImageRequest) error {
+	if imageRequest.N == 0 {
+		imageRequest.N = 1
+	}
+	settings := model_setting.GetImageSettings()
+	maxN := settings.GetModelMaxN(imageRequest.Model)
+	if maxN <= 0 || imageRequest.N <= uint(maxN) {
+		return nil
+	}
+	if settings.NOverflowPolicy == model_setting.ImageNOverflowClamp {
+		logger.LogWarn(c, fmt.Sprintf("image request n %d exceeds the maximum %d of model %s, clamped", imageRequest.N, maxN, imageRequest.Model))
+		imageRequest.N = uint(maxN)
+		return nil
+	}
+	return types.NewErrorWithStatusCode(
+		fmt.Errorf("invalid value for field n: %d, model %s supports n between 1 and %d", imageRequest.N, imageRequest.Model, maxN),
+		types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// syncImageFormParams 将规范化后的 size 与 quality 写回表单，表单字段会原样转发给上游
+func syncImageFormParams(c *gin.Context, imageRequest *dto.ImageRequest) {
+	forms := []map[string][]string{c.Request.PostForm}
+	if c.Request.MultipartForm != nil {
+		forms = append(forms, c.Request.MultipartForm.Value)
+	}
+	for _, form := range forms {
+		if form == nil {
+			continue
+		}
+		if _, ok := form["size"]; ok {
+			form["size"] = []string{imageRequest.Size}
+		}
+		if _, ok := form["quality"]; ok {
+			form["quality"] = []string{imageRequest.Quality}
+		}
+		if _, ok := form["n"]; ok {
+			form["n"] = []string{strconv.FormatUint(uint64(imageRequest.N), 10)}
+		}
+	}
+}
+
+// imageOptionalParamValues 可选参数允许的取值
+var imageOptionalParamValues = map[string][]string{
+	"background":     {"transparent", "opaque", "auto"},
+	"input_fidelity": {"high", "low"},
+}
+
+// normalizeImageOptionalParams 校验 background 与 input_fidelity 的取值，并按模型支持的参数处理：
+// 不支持时默认移除并记录警告，配置为 error 时返回错误。未设置的参数不做任何改动，请求体与之前一致
+func normalizeImageOptionalParams(c *gin.Context, imageRequest *dto.ImageRequest) error {
+	settings := model_setting.GetImageSettings()
+	params := []struct {
+		name  string
+		value *json.RawMessage
+	}{
+		{"background", &imageRequest.Background},
+		{"input_fidelity", &imageRequest.InputFidelity},
+	}
+	for _, param := range params {
+		if len(*param.value) == 0 {
+			continue
+		}
+		var value string
+		if err := common.Unmarshal(*param.value, &value); err != nil || !slices.Contains(imageOptionalParamValues[param.name], value) {
+			return types.NewErrorWithStatusCode(
+				fmt.Errorf("invalid value for field %s: %s, supported values: %s", param.name, string(*param.value), strings.Join(imageOptionalParamValues[param.name], ", ")),
+				types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		if settings.IsModelParamSupported(imageRequest.Model, param.name) {
+			continue
+		}
+		if settings.UnsupportedParamPolicy == model_setting.ImageUnsupportedParamError {
+			return types.NewErrorWithStatusCode(
+				fmt.Errorf("field %s is not supported by model %s", param.name, imageRequest.Model),
+				types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		logger.LogWarn(c, fmt.Sprintf("field %s is not supported by model %s, removed", param.name, imageRequest.Model))
+		*param.value = nil
+		removeImageFormParam(c, param.name)
+	}
+	return nil
+}
+
+// normalizeImageSeed 校验 seed 为整数，并按模型支持的参数处理：不支持时默认返回错误，
+// UnsupportedSeedPolicy 配置为 strip 时移除并记录警告。渠道的参数覆盖在转换请求体之后执行，仍可设置或覆盖 seed
+func normalizeImageSeed(c *gin.Context, imageRequest *dto.ImageRequest) error {
+	if len(imageRequest.Seed) == 0 {
+		return nil
+	}
+	if _, err := strconv.ParseInt(strings.TrimSpace(string(imageRequest.Seed)), 10, 64); err != nil {
+		return newImageParamError(fmt.Errorf("invalid value for field seed: %s, must be an integer", string(imageRequest.Seed)))
+	}
+	settings := model_setting.GetImageSettings()
+	if settings.IsModelParamSupported(imageRequest.Model, "seed") {
+		return nil
+	}
+	if settings.UnsupportedSeedPolicy == model_setting.ImageUnsupportedParamStrip {
+		logger.LogWarn(c, fmt.Sprintf("field seed is not supported by model %s, removed", imageRequest.Model))
+		imageRequest.Seed = nil
+		removeImageFormParam(c, "seed")
+		return nil
+	}
+	return types.NewErrorWithStatusCode(
+		fmt.Errorf("model %s does not support seed, remove the seed field or use a model that supports reproducible generation", imageRequest.Model),
+		types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// removeImageFormParam 从表单中移除参数，表单字段会原样转发给上游
+func removeImageFormParam(c *gin.Context, name string) {
+	if c.Request.PostForm != nil {
+		delete(c.Request.PostForm, name)
+	}
+	if c.Request.MultipartForm != nil {
+		delete(c.Request.MultipartForm.Value, name)
+	}
+}
+
+// normalizeImageOutputFormat 规范化 output_format（小写，jpg 视为 jpeg）并按模型允许的格式校验，不支持时直接拒绝，
+// 避免上游忽略参数后返回 PNG。output_compression 必须为 0-100 的整数，且只适用于 jpeg 与 webp
+func normalizeImageOutputFormat(c *gin.Context, imageRequest *dto.ImageRequest) error {
+	var format string
+	if len(imageRequest.OutputFormat) > 0 {
+		if err := common.Unmarshal(imageRequest.OutputFormat, &format); err != nil {
+			return newImageParamError(fmt.Errorf("invalid value for field output_format: %s, must be a string", string(imageRequest.OutputFormat)))
+		}
+		normalized := strings.ToLower(strings.TrimSpace(format))
+		if normalized == "jpg" {
+			normalized = "jpeg"
+		}
+		if allowed, configured := model_setting.GetImageSettings().GetModelOutputFormats(imageRequest.Model); configured && !slices.Contains(allowed, normalized) {
+			return newImageParamError(fmt.Errorf("invalid value for field output_format: %s is not supported by model %s, supported formats: %s", format, imageRequest.Model, strings.Join(allowed, ", ")))
+		}
+		if normalized != format {
+			imageRequest.OutputFormat, _ = common.Marshal(normalized)
+			setImageFormParam(c, "output_format", normalized)
+		}
+		format = normalized
+	}
+	if len(imageRequest.OutputCompression) == 0 {
+		return nil
+	}
+	var compression float64
+	if err := common.Unmarshal(imageRequest.OutputCompression, &compression); err != nil || compression < 0 || compression > 100 || compression != float64(int(compression)) {
+		return newImageParamError(fmt.Errorf("invalid value for field output_compression: %s, must be an integer between 0 and 100", string(imageRequest.OutputCompression)))
+	}
+	if format != "jpeg" && format != "webp" {
+		return newImageParamError(errors.New("output_compression is only supported when output_format is jpeg or webp"))
+	}
+	return nil
+}
+
+func newImageParamError(err error) error {
+	return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// setImageFormParam 更新表单中已有的参数，表单字段会原样转发给上游
+func setImageFormParam(c *gin.Context, name string, value string) {
+	if _, ok := c.Request.PostForm[name]; ok {
+		c.Request.PostForm[name] = []string{value}
+	}
+	if c.Request.MultipartForm != nil {
+		if _, ok := c.Request.MultipartForm.Value[name]; ok {
+			c.Request.MultipartForm.Value[name] = []string{value}
+		}
+	}
+}
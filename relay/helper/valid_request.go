@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
@@ -138,20 +139,56 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 			formData := c.Request.PostForm
 			imageRequest.Prompt = formData.Get("prompt")
 			imageRequest.Model = formData.Get("model")
-			imageRequest.N = uint(common.String2Int(formData.Get("n")))
+			n := common.String2Int(formData.Get("n"))
+			if n < 0 {
+				return nil, fmt.Errorf("invalid value for field n: %d, must be a positive integer", n)
+			}
+			imageRequest.N = uint(n)
 			imageRequest.Quality = formData.Get("quality")
 			imageRequest.Size = formData.Get("size")
 			if imageValue := formData.Get("image"); imageValue != "" {
 				imageRequest.Image, _ = json.Marshal(imageValue)
 			}
+			if formData.Has("background") {
+				imageRequest.Background, _ = json.Marshal(formData.Get("background"))
+			}
+			if formData.Has("input_fidelity") {
+				imageRequest.InputFidelity, _ = json.Marshal(formData.Get("input_fidelity"))
+			}
+			if formData.Has("output_format") {
+				imageRequest.OutputFormat, _ = json.Marshal(formData.Get("output_format"))
+			}
+			if formData.Has("output_compression") {
+				imageRequest.OutputCompression = json.RawMessage(strings.TrimSpace(formData.Get("output_compression")))
+			}
+			if formData.Has("seed") {
+				imageRequest.Seed = json.RawMessage(strings.TrimSpace(formData.Get("seed")))
+			}
+			if err := normalizeImageSizeAndQuality(imageRequest); err != nil {
+				return nil, err
+			}
+			if err := normalizeImageN(c, imageRequest); err != nil {
+				return nil, err
+			}
+			syncImageFormParams(c, imageRequest)
+			if err := normalizeImageOptionalParams(c, imageRequest); err != nil {
+				return nil, err
+			}
+			if err := normalizeImageOutputFormat(c, imageRequest); err != nil {
+				return nil, err
+			}
+			if err := normalizeImageSeed(c, imageRequest); err != nil {
+				return nil, err
+			}
 
 			if imageRequest.Model == "gpt-image-1" {
 				if imageRequest.Quality == "" {
 					imageRequest.Quality = "standard"
 				}
 			}
-			if imageRequest.N == 0 {
-				imageRequest.N = 1
+			if formData.Has("stream") {
+				stream, _ := strconv.ParseBool(formData.Get("stream"))
+				imageRequest.Stream = &stream
 			}
 
 			hasWatermark := formData.Has("watermark")
@@ -173,8 +210,8 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 			return nil, errors.New("model is required")
 		}
 
-		if strings.Contains(imageRequest.Size, "×") {
-			return nil, errors.New("size an unexpected error occurred in the parameter, please use 'x' instead of the multiplication sign '×'")
+		if err := normalizeImageSizeAndQuality(imageRequest); err != nil {
+			return nil, err
 		}
 
 		// Not "256x256", "512x512", or "1024x1024"
@@ -205,8 +242,22 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 		//	return nil, errors.New("prompt is required")
 		//}
 
-		if imageRequest.N == 0 {
-			imageRequest.N = 1
+		if err := normalizeImageN(c, imageRequest); err != nil {
+			return nil, err
+		}
+		if err := normalizeImageOptionalParams(c, imageRequest); err != nil {
+			return nil, err
+		}
+		if err := normalizeImageOutputFormat(c, imageRequest); err != nil {
+			return nil, err
+		}
+		if err := normalizeImageSeed(c, imageRequest); err != nil {
+			return nil, err
+		}
+		if relayMode == relayconstant.RelayModeImagesEdits {
+			if err := convertImageEditUrlInputs(c, imageRequest); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -0,0 +1,64 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// staticFrameImageResponse 将响应中的 base64 动图按渠道配置取一帧转为静态 PNG，
+// 静态图片与 URL 形式的图片保持原样，处理失败时返回原图
+func staticFrameImageResponse(c *gin.Context, info *relaycommon.RelayInfo, body []byte) []byte {
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body
+	}
+	converted := 0
+	for i, item := range items {
+		var b64 string
+		if err := common.Unmarshal(item["b64_json"], &b64); err != nil || b64 == "" {
+			continue
+		}
+		static, ok, err := service.StaticFrameBase64Image(b64, info.ChannelSetting.ImageStaticFrame)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("convert animated image %d to static failed, return original: %s", i, err.Error()))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		raw, err := common.Marshal(static)
+		if err != nil {
+			continue
+		}
+		item["b64_json"] = raw
+		converted++
+	}
+	if converted == 0 {
+		return body
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body
+	}
+	response["data"] = data
+	// 全部图片都已转换时同步修正上游返回的 output_format
+	if _, ok := response["output_format"]; ok && converted == len(items) {
+		response["output_format"] = json.RawMessage(`"png"`)
+	}
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
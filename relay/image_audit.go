@@ -0,0 +1,112 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageUpstreamAuditKey = "image_upstream_audit"
+
+// imageAuditElideLength 超过该长度的 base64 / data URI 字符串在审计记录中被省略
+const imageAuditElideLength = 256
+
+// auditImageUpstreamRequest 开启上游审计时记录实际发往上游的请求体（透传与转换后的请求都适用），
+// 请求体被读取后替换为等价的 reader
+func auditImageUpstreamRequest(c *gin.Context, info *relaycommon.RelayInfo, bodies []io.Reader) []io.Reader {
+	settings := model_setting.GetImageSettings()
+	if !settings.UpstreamAuditEnabled {
+		return bodies
+	}
+	audited := make([]io.Reader, len(bodies))
+	requests := make([]string, 0, len(bodies))
+	for i, body := range bodies {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			// 读取失败时保留已读取的部分，由上游请求报告错误
+			audited[i] = io.MultiReader(bytes.NewReader(data), body)
+			continue
+		}
+		audited[i] = bytes.NewReader(data)
+		requests = append(requests, sanitizeImageAuditBody(redactImagePromptBody(c, data), settings.UpstreamAuditMaxBytes))
+	}
+	c.Set(imageUpstreamAuditKey, map[string]any{
+		"pass_through": model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled,
+		"requests":     requests,
+	})
+	return audited
+}
+
+// auditImageUpstreamResponse 记录上游原始响应，流式响应不记录
+func auditImageUpstreamResponse(c *gin.Context, resp *http.Response, isStream bool) {
+	value, ok := c.Get(imageUpstreamAuditKey)
+	if !ok || resp == nil || isStream {
+		return
+	}
+	audit := value.(map[string]any)
+	audit["status"] = resp.StatusCode
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		audit["response_error"] = err.Error()
+	}
+	audit["response"] = sanitizeImageAuditBody(redactImagePromptBody(c, data), model_setting.GetImageSettings().UpstreamAuditMaxBytes)
+}
+
+// sanitizeImageAuditBody JSON 中的 base64 图片数据替换为长度说明，其余内容原样保留，最后按 maxBytes 截断
+func sanitizeImageAuditBody(data []byte, maxBytes int) string {
+	var value any
+	if err := common.Unmarshal(data, &value); err == nil {
+		if elided, err := common.Marshal(elideImageAuditValue(value)); err == nil {
+			data = elided
+		}
+	}
+	if maxBytes <= 0 {
+		maxBytes = 4096
+	}
+	text := string(data)
+	if len(text) > maxBytes {
+		text = text[:maxBytes] + fmt.Sprintf("...(truncated, %d bytes total)", len(data))
+	}
+	return strings.ToValidUTF8(text, "?")
+}
+
+func elideImageAuditValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = elideImageAuditValue(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = elideImageAuditValue(item)
+		}
+		return v
+	case string:
+		if len(v) > imageAuditElideLength && (strings.HasPrefix(v, "data:") || looksLikeBase64(v)) {
+			return fmt.Sprintf("<elided %d bytes of base64>", len(v))
+		}
+		return v
+	}
+	return value
+}
+
+// looksLikeBase64 抽查前 imageAuditElideLength 个字符是否都属于 base64 字符集
+func looksLikeBase64(s string) bool {
+	for _, r := range s[:imageAuditElideLength] {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '/' || r == '=') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageBudgetWarningHeader = "X-New-Api-Image-Budget-Warning"
+
+// checkUserImageBudget 检查用户当前周期的图片消费预算，超出预算时拒绝请求，接近预算时在响应头中提示
+func checkUserImageBudget(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	settings := model_setting.GetImageSettings()
+	budget := settings.GetUserImageBudget(info.UserId)
+	if budget <= 0 {
+		return nil
+	}
+	budgetQuota := budget * common.QuotaPerUnit
+	spent := float64(service.GetUserImageBudgetSpent(info.UserId))
+	if spent >= budgetQuota {
+		logger.LogWarn(c, fmt.Sprintf("user #%d image budget exceeded: spent %.0f of %.0f", info.UserId, spent, budgetQuota))
+		return types.NewErrorWithStatusCode(errors.New("image generation budget exceeded for the current cycle"), types.ErrorCodeImageBudgetExceeded, http.StatusPaymentRequired, types.ErrOptionWithSkipRetry())
+	}
+	if settings.BudgetWarningPercent > 0 && spent >= budgetQuota*settings.BudgetWarningPercent/100 {
+		c.Header(imageBudgetWarningHeader, fmt.Sprintf("%.0f%% of image budget used", spent/budgetQuota*100))
+	}
+	return nil
+}
+
+// recordUserImageBudgetSpend 计费完成后累加用户的图片消费
+func recordUserImageBudgetSpend(c *gin.Context, info *relaycommon.RelayInfo) {
+	if model_setting.GetImageSettings().GetUserImageBudget(info.UserId) <= 0 {
+		return
+	}
+	service.AddUserImageBudgetSpent(info.UserId, c.GetInt("consumed_quota"))
+}
@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// spendImageBudget 模拟一次计费完成后的预算累加
+func spendImageBudget(info *relaycommon.RelayInfo, usd float64) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Set("consumed_quota", int(usd*common.QuotaPerUnit))
+	recordUserImageBudgetSpend(c, info)
+}
+
+func TestUserImageBudgetThresholds(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.DefaultUserBudget = 1
+		settings.BudgetCycle = model_setting.ImageBudgetCycleDaily
+		settings.BudgetWarningPercent = 80
+	})
+	info := &relaycommon.RelayInfo{UserId: 910001}
+
+	steps := []struct {
+		spend   float64
+		warning string
+		blocked bool
+	}{
+		{spend: 0.5},
+		{spend: 0.35, warning: "85% of image budget used"},
+		{spend: 0.15, blocked: true},
+	}
+	for _, step := range steps {
+		spendImageBudget(info, step.spend)
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		err := checkUserImageBudget(c, info)
+		if step.blocked {
+			if err == nil || err.StatusCode != http.StatusPaymentRequired || err.GetErrorCode() != types.ErrorCodeImageBudgetExceeded {
+				t.Fatalf("budget exhausted: %v, want 402 image budget exceeded", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("request within budget rejected: %v", err)
+		}
+		if got := c.Writer.Header().Get(imageBudgetWarningHeader); got != step.warning {
+			t.Fatalf("budget warning = %q, want %q", got, step.warning)
+		}
+	}
+}
+
+func TestUserImageBudgetOverrides(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.DefaultUserBudget = 1
+		settings.UserBudgets = map[string]float64{"910002": 10, "910003": 0}
+		settings.BudgetCycle = model_setting.ImageBudgetCycleDaily
+		settings.BudgetWarningPercent = 80
+	})
+
+	// 单独配置了更高预算的用户不受默认预算限制，预算为 0 的用户不限制且不累加消费
+	for _, userId := range []int{910002, 910003} {
+		info := &relaycommon.RelayInfo{UserId: userId}
+		spendImageBudget(info, 2)
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		if err := checkUserImageBudget(c, info); err != nil {
+			t.Fatalf("user #%d: %v", userId, err)
+		}
+		if got := c.Writer.Header().Get(imageBudgetWarningHeader); got != "" {
+			t.Fatalf("user #%d: unexpected budget warning %q", userId, got)
+		}
+	}
+
+	// 关闭提示后接近预算不再返回提示头
+	model_setting.GetImageSettings().BudgetWarningPercent = 0
+	info := &relaycommon.RelayInfo{UserId: 910004}
+	spendImageBudget(info, 0.9)
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err := checkUserImageBudget(c, info); err != nil || c.Writer.Header().Get(imageBudgetWarningHeader) != "" {
+		t.Fatalf("warning disabled: %v, header %q", err, c.Writer.Header().Get(imageBudgetWarningHeader))
+	}
+}
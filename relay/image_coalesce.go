@@ -0,0 +1,315 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// imageEditGroup 合并并发的相同图片编辑请求，只向上游发送一次
+var imageEditGroup singleflight.Group
+
+// 渠道配置了合并窗口时，上游成功返回后在窗口内继续复用结果
+var (
+	imageEditCacheLock sync.Mutex
+	imageEditCache     = make(map[string]*coalescedImageResponse)
+)
+
+// coalescedImageResponse 共享的上游响应，每个等待者基于它构造独立的 http.Response 并各自计费
+type coalescedImageResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	expiresAt  time.Time
+	// 拆分请求部分失败时的实际张数与响应提示，用于等待者各自计费与返回
+	partialCount any
+	meta         map[string]any
+}
+
+// applyTo 将共享调用产生的部分张数与响应提示写入等待者自己的上下文
+func (r *coalescedImageResponse) applyTo(c *gin.Context) {
+	if r.partialCount != nil {
+		c.Set("image_partial_count", r.partialCount)
+	}
+	for key, value := range r.meta {
+		setImageResponseMeta(c, key, value)
+	}
+}
+
+func (r *coalescedImageResponse) toHttpResponse() *http.Response {
+	return &http.Response{
+		StatusCode:    r.statusCode,
+		Header:        r.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+	}
+}
+
+// shouldCoalesceImageEdit 仅合并非流式的图片编辑请求，渠道合并窗口为 0 时不合并
+func shouldCoalesceImageEdit(c *gin.Context, info *relaycommon.RelayInfo) bool {
+	if !model_setting.GetImageSettings().EditCoalesceEnabled || info.RelayMode != relayconstant.RelayModeImagesEdits {
+		return false
+	}
+	if window := info.ChannelSetting.ImageCoalesceWindow; window != nil && *window <= 0 {
+		return false
+	}
+	if mf := c.Request.MultipartForm; mf != nil {
+		for _, value := range mf.Value["stream"] {
+			if stream, err := strconv.ParseBool(value); err == nil && stream {
+				return false
+			}
+		}
+	}
+	return !info.IsStream
+}
+
+// imageEditCoalesceKey 由渠道、模型、表单字段与上传图片内容的哈希组成合并键
+func imageEditCoalesceKey(c *gin.Context, info *relaycommon.RelayInfo) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "channel:%d\nmodel:%s\n", info.ChannelId, info.UpstreamModelName)
+	if err := hashImageEditForm(c, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashImageEditForm 按字段名排序写入表单字段（model 除外）与上传图片内容的哈希
+func hashImageEditForm(c *gin.Context, h io.Writer) error {
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		if _, err := c.MultipartForm(); err != nil {
+			return err
+		}
+		mf = c.Request.MultipartForm
+	}
+
+	valueKeys := make([]string, 0, len(mf.Value))
+	for key := range mf.Value {
+		valueKeys = append(valueKeys, key)
+	}
+	sort.Strings(valueKeys)
+	for _, key := range valueKeys {
+		if key == "model" {
+			continue
+		}
+		for _, value := range mf.Value[key] {
+			fmt.Fprintf(h, "value:%s=%d:%s\n", key, len(value), value)
+		}
+	}
+
+	fileKeys := make([]string, 0, len(mf.File))
+	for key := range mf.File {
+		fileKeys = append(fileKeys, key)
+	}
+	sort.Strings(fileKeys)
+	for _, key := range fileKeys {
+		for _, fileHeader := range mf.File[key] {
+			file, err := fileHeader.Open()
+			if err != nil {
+				return err
+			}
+			fileHash := sha256.New()
+			_, err = io.Copy(fileHash, file)
+			_ = file.Close()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "file:%s=%x\n", key, fileHash.Sum(nil))
+		}
+	}
+	return nil
+}
+
+// doImageUpstreamRequest 发送图片上游请求，开启合并时相同的并发编辑请求共享同一次上游调用，
+// 适配器返回多个请求体或张数超过上游限制时在共享调用内并发发送，合并后的结果由每个等待者各自处理
+func doImageUpstreamRequest(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.ImageRequest, requestBodies []io.Reader) (any, error) {
+	sendWith := func(c *gin.Context) (any, error) {
+		if len(requestBodies) > 1 {
+			return doImageUpstreamBatch(c, info, adaptor, requestBodies)
+		}
+		return adaptor.DoRequest(c, info, requestBodies[0])
+	}
+	if limit := getImageUpstreamBatchLimit(info, request); limit > 0 {
+		sendWith = func(c *gin.Context) (any, error) {
+			return doSplitImageUpstreamRequest(c, info, adaptor, request, limit)
+		}
+	}
+	if !shouldCoalesceImageEdit(c, info) {
+		return sendWith(c)
+	}
+	key, err := imageEditCoalesceKey(c, info)
+	if err != nil {
+		logger.LogWarn(c, "build image edit coalesce key failed: "+err.Error())
+		return sendWith(c)
+	}
+	if cached := loadCoalescedImageEdit(key); cached != nil {
+		logger.LogInfo(c, fmt.Sprintf("image edit request served from coalesce window, channel #%d, key: %s", info.ChannelId, key[:16]))
+		cached.applyTo(c)
+		return cached.toHttpResponse(), nil
+	}
+	flight, callKey := joinImageEditFlight(c, info, key)
+	defer leaveImageEditFlight(key, flight)
+	// 共享调用运行在复制的上下文上，由独立的 flight 上下文控制，任一客户端断开或取消都不会中止其他等待者的请求
+	flightCtx := c.Copy()
+	flightCtx.Set("image_cancel_ctx", flight.ctx)
+	ch := imageEditGroup.DoChan(callKey, func() (any, error) {
+		defer finishImageEditFlight(key, flight)
+		resp, err := sendWith(flightCtx)
+		if err != nil {
+			return nil, err
+		}
+		httpResp, ok := resp.(*http.Response)
+		if !ok || httpResp == nil {
+			return nil, fmt.Errorf("unexpected image response type %T", resp)
+		}
+		defer httpResp.Body.Close()
+		body, err := io.ReadAll(httpResp.Body)
+		if err != nil {
+			return nil, err
+		}
+		coalesced := &coalescedImageResponse{
+			statusCode: httpResp.StatusCode,
+			header:     httpResp.Header.Clone(),
+			body:       body,
+		}
+		// 拆分请求部分失败时写入的张数与提示只存在于复制的上下文中，随结果带给每个等待者
+		if count, ok := flightCtx.Get("image_partial_count"); ok {
+			coalesced.partialCount = count
+		}
+		if meta, ok := flightCtx.Get(imageResponseMetaKey); ok {
+			coalesced.meta, _ = meta.(map[string]any)
+		}
+		if window := info.ChannelSetting.ImageCoalesceWindow; window != nil && *window > 0 && coalesced.statusCode == http.StatusOK {
+			storeCoalescedImageEdit(key, coalesced, time.Duration(*window)*time.Second)
+		}
+		return coalesced, nil
+	})
+	// 每个等待者只响应自己的取消与超时，由 ImageHelper 按各自的上下文返回取消或超时错误
+	waitCtx := c.Request.Context()
+	if ctx, ok := c.Get("image_cancel_ctx"); ok {
+		waitCtx = ctx.(context.Context)
+	}
+	var result singleflight.Result
+	select {
+	case result = <-ch:
+	case <-waitCtx.Done():
+		return nil, fmt.Errorf("coalesced image edit wait cancelled: %w", waitCtx.Err())
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.Shared {
+		logger.LogInfo(c, fmt.Sprintf("image edit request coalesced, channel #%d, key: %s", info.ChannelId, key[:16]))
+	}
+	coalesced := result.Val.(*coalescedImageResponse)
+	coalesced.applyTo(c)
+	return coalesced.toHttpResponse(), nil
+}
+
+// imageEditFlight 一次共享的上游调用，等待者全部离开后才取消
+type imageEditFlight struct {
+	id      uint64
+	waiters int
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+var (
+	imageEditFlightsLock sync.Mutex
+	imageEditFlights     = make(map[string]*imageEditFlight)
+	imageEditFlightSeq   uint64
+)
+
+// joinImageEditFlight 加入进行中的共享调用，没有时新建。singleflight 的键带上调用序号，
+// 已取消或已结束的调用不会再被新的请求加入
+func joinImageEditFlight(c *gin.Context, info *relaycommon.RelayInfo, key string) (*imageEditFlight, string) {
+	imageEditFlightsLock.Lock()
+	defer imageEditFlightsLock.Unlock()
+	flight, ok := imageEditFlights[key]
+	if !ok {
+		imageEditFlightSeq++
+		ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+		if timeout := getImageRequestTimeout(info); timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			parentCancel := cancel
+			cancel = func() {
+				cancelTimeout()
+				parentCancel()
+			}
+		}
+		flight = &imageEditFlight{id: imageEditFlightSeq, ctx: ctx, cancel: cancel}
+		imageEditFlights[key] = flight
+	}
+	flight.waiters++
+	return flight, fmt.Sprintf("%s#%d", key, flight.id)
+}
+
+// leaveImageEditFlight 等待者离开，最后一个等待者离开时取消仍在进行的上游调用
+func leaveImageEditFlight(key string, flight *imageEditFlight) {
+	imageEditFlightsLock.Lock()
+	defer imageEditFlightsLock.Unlock()
+	flight.waiters--
+	if flight.waiters > 0 {
+		return
+	}
+	flight.cancel()
+	if imageEditFlights[key] == flight {
+		delete(imageEditFlights, key)
+	}
+}
+
+// finishImageEditFlight 上游调用结束后移除登记，之后的相同请求发起新的调用
+func finishImageEditFlight(key string, flight *imageEditFlight) {
+	imageEditFlightsLock.Lock()
+	defer imageEditFlightsLock.Unlock()
+	if imageEditFlights[key] == flight {
+		delete(imageEditFlights, key)
+	}
+}
+
+func loadCoalescedImageEdit(key string) *coalescedImageResponse {
+	imageEditCacheLock.Lock()
+	defer imageEditCacheLock.Unlock()
+	cached, ok := imageEditCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(cached.expiresAt) {
+		delete(imageEditCache, key)
+		return nil
+	}
+	return cached
+}
+
+// storeCoalescedImageEdit 保存成功的上游响应，写入时顺带清理已过期的结果
+func storeCoalescedImageEdit(key string, response *coalescedImageResponse, window time.Duration) {
+	now := time.Now()
+	response.expiresAt = now.Add(window)
+	imageEditCacheLock.Lock()
+	defer imageEditCacheLock.Unlock()
+	for k, cached := range imageEditCache {
+		if now.After(cached.expiresAt) {
+			delete(imageEditCache, k)
+		}
+	}
+	imageEditCache[key] = response
+}
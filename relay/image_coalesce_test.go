@@ -0,0 +1,337 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/mock"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockingImageAdaptor 记录上游调用次数，上游请求阻塞到 release 关闭或上下文取消
+type blockingImageAdaptor struct {
+	mock.Adaptor
+	calls    atomic.Int32
+	release  chan struct{}
+	upstream chan context.Context
+}
+
+func (a *blockingImageAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	a.calls.Add(1)
+	value, _ := c.Get("image_cancel_ctx")
+	ctx := value.(context.Context)
+	a.upstream <- ctx
+	select {
+	case <-a.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"data":[{"b64_json":"aGVsbG8="}]}`)),
+	}, nil
+}
+
+func newImageEditTestContext(t *testing.T) (*gin.Context, context.CancelFunc) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("model", "gpt-image-1")
+	_ = writer.WriteField("prompt", "add a hat")
+	part, err := writer.CreateFormFile("image", "cat.png")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	_, _ = part.Write([]byte("same image bytes"))
+	_ = writer.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/edits", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Set("image_cancel_ctx", ctx)
+	return c, cancel
+}
+
+func waitForImageEditWaiters(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		imageEditFlightsLock.Lock()
+		waiters := 0
+		for _, flight := range imageEditFlights {
+			waiters += flight.waiters
+		}
+		imageEditFlightsLock.Unlock()
+		if waiters == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d coalesced waiters", want)
+}
+
+type coalesceResult struct {
+	resp any
+	err  error
+}
+
+func startCoalescedImageEdit(c *gin.Context, adaptor *blockingImageAdaptor) chan coalesceResult {
+	done := make(chan coalesceResult, 1)
+	go func() {
+		resp, err := doImageUpstreamRequest(c, newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesEdits), infoChannelId(1), infoUpstreamModel("gpt-image-1")), adaptor, nil, []io.Reader{strings.NewReader("{}")})
+		done <- coalesceResult{resp: resp, err: err}
+	}()
+	return done
+}
+
+func TestCoalescedImageEditSurvivesLeaderCancellation(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.EditCoalesceEnabled = true
+	})
+	adaptor := &blockingImageAdaptor{release: make(chan struct{}), upstream: make(chan context.Context, 2)}
+
+	leader, cancelLeader := newImageEditTestContext(t)
+	defer cancelLeader()
+	leaderDone := startCoalescedImageEdit(leader, adaptor)
+	upstreamCtx := <-adaptor.upstream
+
+	follower, cancelFollower := newImageEditTestContext(t)
+	defer cancelFollower()
+	followerDone := startCoalescedImageEdit(follower, adaptor)
+	waitForImageEditWaiters(t, 2)
+
+	cancelLeader()
+	result := <-leaderDone
+	if !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("leader error = %v, want context.Canceled", result.err)
+	}
+	if upstreamCtx.Err() != nil {
+		t.Fatal("leader cancellation aborted the shared upstream request")
+	}
+
+	close(adaptor.release)
+	result = <-followerDone
+	if result.err != nil {
+		t.Fatalf("follower failed after leader cancelled: %v", result.err)
+	}
+	if resp := result.resp.(*http.Response); resp.StatusCode != http.StatusOK {
+		t.Fatalf("follower status = %d, want 200", resp.StatusCode)
+	}
+	if calls := adaptor.calls.Load(); calls != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls)
+	}
+}
+
+func TestCoalescedImageEditCancelledWhenAllWaitersLeave(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.EditCoalesceEnabled = true
+	})
+	adaptor := &blockingImageAdaptor{release: make(chan struct{}), upstream: make(chan context.Context, 2)}
+	defer close(adaptor.release)
+
+	first, cancelFirst := newImageEditTestContext(t)
+	firstDone := startCoalescedImageEdit(first, adaptor)
+	upstreamCtx := <-adaptor.upstream
+	second, cancelSecond := newImageEditTestContext(t)
+	secondDone := startCoalescedImageEdit(second, adaptor)
+	waitForImageEditWaiters(t, 2)
+
+	cancelFirst()
+	<-firstDone
+	if upstreamCtx.Err() != nil {
+		t.Fatal("shared upstream request cancelled while a waiter remains")
+	}
+	cancelSecond()
+	<-secondDone
+	select {
+	case <-upstreamCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("shared upstream request was not cancelled after every waiter left")
+	}
+
+	// 已取消的调用不会被新的相同请求加入
+	third, cancelThird := newImageEditTestContext(t)
+	defer cancelThird()
+	thirdDone := startCoalescedImageEdit(third, adaptor)
+	if ctx := <-adaptor.upstream; ctx.Err() != nil {
+		t.Fatal("new request joined a cancelled upstream call")
+	}
+	cancelThird()
+	<-thirdDone
+}
+
+// batchLimitImageAdaptor 记录每次上游请求的张数并返回对应数量的图片，上游请求阻塞到 release 关闭
+type batchLimitImageAdaptor struct {
+	mock.Adaptor
+	mu       sync.Mutex
+	sizes    []int
+	release  chan struct{}
+	upstream chan struct{}
+}
+
+func (a *batchLimitImageAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	var request dto.ImageRequest
+	body, _ := io.ReadAll(requestBody)
+	if err := common.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.sizes = append(a.sizes, int(request.N))
+	a.mu.Unlock()
+	a.upstream <- struct{}{}
+	<-a.release
+	response := dto.ImageResponse{Created: 1}
+	for i := 0; i < int(request.N); i++ {
+		response.Data = append(response.Data, dto.ImageData{Url: fmt.Sprintf("n%d-%d", request.N, i)})
+	}
+	data, _ := common.Marshal(response)
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestCoalescedImageEditSplitByUpstreamBatchLimit(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.EditCoalesceEnabled = true
+		settings.MaxUpstreamBatchSize = map[string]int{"gpt-image-1": 2}
+		settings.UpstreamSplitConcurrency = 4
+	})
+	adaptor := &batchLimitImageAdaptor{release: make(chan struct{}), upstream: make(chan struct{}, 6)}
+	start := func(c *gin.Context) chan coalesceResult {
+		done := make(chan coalesceResult, 1)
+		go func() {
+			request := &dto.ImageRequest{Model: "gpt-image-1", Prompt: "add a hat", N: 5}
+			resp, err := doImageUpstreamRequest(c, newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesEdits), infoChannelId(1), infoUpstreamModel("gpt-image-1")), adaptor, request, []io.Reader{strings.NewReader("{}")})
+			done <- coalesceResult{resp: resp, err: err}
+		}()
+		return done
+	}
+
+	first, cancelFirst := newImageEditTestContext(t)
+	defer cancelFirst()
+	firstDone := start(first)
+	<-adaptor.upstream
+	second, cancelSecond := newImageEditTestContext(t)
+	defer cancelSecond()
+	secondDone := start(second)
+	waitForImageEditWaiters(t, 2)
+	close(adaptor.release)
+
+	// 两个合并的请求共 5 张，上游每次最多 2 张，只拆分为 2+2+1 三次上游调用
+	want := []string{"n2-0", "n2-1", "n2-0", "n2-1", "n1-0"}
+	for name, done := range map[string]chan coalesceResult{"first": firstDone, "second": secondDone} {
+		result := <-done
+		if result.err != nil {
+			t.Fatalf("%s request failed: %v", name, result.err)
+		}
+		var response dto.ImageResponse
+		body, _ := io.ReadAll(result.resp.(*http.Response).Body)
+		if err := common.Unmarshal(body, &response); err != nil {
+			t.Fatalf("%s: decode merged response %s: %v", name, body, err)
+		}
+		if len(response.Data) != len(want) {
+			t.Fatalf("%s received %d images, want %d", name, len(response.Data), len(want))
+		}
+		for i, data := range response.Data {
+			if data.Url != want[i] {
+				t.Fatalf("%s image %d is %q, want %q", name, i, data.Url, want[i])
+			}
+		}
+	}
+	sort.Ints(adaptor.sizes)
+	if len(adaptor.sizes) != 3 || adaptor.sizes[0] != 1 || adaptor.sizes[1] != 2 || adaptor.sizes[2] != 2 {
+		t.Fatalf("upstream calls sent n = %v, want [1 2 2]", adaptor.sizes)
+	}
+	if _, ok := first.Request.MultipartForm.Value["n"]; ok {
+		t.Fatal("split left a rewritten n in the edit form")
+	}
+}
+
+func TestCoalescedImageEditRespectsChannelWindow(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.EditCoalesceEnabled = true
+	})
+	adaptor := &blockingImageAdaptor{release: make(chan struct{}), upstream: make(chan context.Context, 4)}
+	close(adaptor.release)
+	window := 30
+	info := newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesEdits), infoChannelId(51), infoUpstreamModel("gpt-image-1"),
+		infoChannelSetting(dto.ChannelSettings{ImageCoalesceWindow: &window}))
+	send := func() {
+		t.Helper()
+		c, cancel := newImageEditTestContext(t)
+		defer cancel()
+		resp, err := doImageUpstreamRequest(c, info, adaptor, nil, []io.Reader{strings.NewReader("{}")})
+		if err != nil || resp.(*http.Response).StatusCode != http.StatusOK {
+			t.Fatalf("coalesced edit: %v", err)
+		}
+	}
+	t.Cleanup(resetImageEditCache)
+
+	// 上游返回后在窗口内复用结果，窗口长度取渠道配置
+	send()
+	send()
+	if calls := adaptor.calls.Load(); calls != 1 {
+		t.Fatalf("upstream calls within the window = %d, want 1", calls)
+	}
+	imageEditCacheLock.Lock()
+	if len(imageEditCache) != 1 {
+		imageEditCacheLock.Unlock()
+		t.Fatalf("cached %d coalesced responses, want 1", len(imageEditCache))
+	}
+	for _, cached := range imageEditCache {
+		if remaining := time.Until(cached.expiresAt); remaining <= 25*time.Second || remaining > 30*time.Second {
+			imageEditCacheLock.Unlock()
+			t.Fatalf("coalesced response expires in %v, want the 30s channel window", remaining)
+		}
+		// 模拟窗口结束
+		cached.expiresAt = time.Now().Add(-time.Millisecond)
+	}
+	imageEditCacheLock.Unlock()
+	send()
+	if calls := adaptor.calls.Load(); calls != 2 {
+		t.Fatalf("upstream calls after the window = %d, want 2", calls)
+	}
+
+	// 窗口为 0 的渠道不合并，未配置时只合并进行中的请求
+	resetImageEditCache()
+	window = 0
+	c, cancel := newImageEditTestContext(t)
+	defer cancel()
+	if shouldCoalesceImageEdit(c, info) {
+		t.Fatal("channel with a zero window was coalesced")
+	}
+	info.ChannelSetting.ImageCoalesceWindow = nil
+	if !shouldCoalesceImageEdit(c, info) {
+		t.Fatal("channel without a window setting was not coalesced")
+	}
+	send()
+	send()
+	if calls := adaptor.calls.Load(); calls != 4 {
+		t.Fatalf("upstream calls without a window = %d, want 4", calls)
+	}
+}
+
+func resetImageEditCache() {
+	imageEditCacheLock.Lock()
+	imageEditCache = make(map[string]*coalescedImageResponse)
+	imageEditCacheLock.Unlock()
+}
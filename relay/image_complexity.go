@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyImageComplexityRouting 渠道开启复杂度路由时，按提示词复杂度替换上游模型。
+// 档位中的模型为上游模型名，不再经过模型映射；计费仍按客户端请求的模型
+func applyImageComplexityRouting(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	routing := info.ChannelSetting.ImageComplexityRouting
+	if routing == nil || !routing.Enabled || len(routing.Tiers) == 0 {
+		return
+	}
+	tiers := make([]dto.ImageComplexityTier, len(routing.Tiers))
+	copy(tiers, routing.Tiers)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MaxScore < tiers[j].MaxScore
+	})
+	score := service.ScoreImagePromptComplexity(request.Prompt, routing.Keywords, routing.FullLength)
+	selected := tiers[len(tiers)-1]
+	for _, tier := range tiers {
+		if score <= tier.MaxScore {
+			selected = tier
+			break
+		}
+	}
+	if selected.Model == "" {
+		return
+	}
+	info.UpstreamModelName = selected.Model
+	request.SetModelName(selected.Model)
+	logger.LogInfo(c, fmt.Sprintf("image prompt complexity %.1f, channel #%d selected model %s", score, info.ChannelId, selected.Model))
+}
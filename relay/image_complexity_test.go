@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestImageComplexityRoutingSimpleVsComplexPrompt(t *testing.T) {
+	// 档位故意乱序配置，按 MaxScore 从小到大匹配
+	routing := &dto.ImageComplexityRoutingSetting{
+		Enabled:    true,
+		Keywords:   []string{"photorealistic", "detailed", "cinematic lighting"},
+		FullLength: 200,
+		Tiers: []dto.ImageComplexityTier{
+			{MaxScore: 100, Model: "gpt-image-1"},
+			{MaxScore: 30, Model: "dall-e-2"},
+		},
+	}
+	tests := []struct {
+		name   string
+		prompt string
+		want   string
+	}{
+		{name: "simple", prompt: "a red apple", want: "dall-e-2"},
+		{name: "keywords", prompt: "a photorealistic, highly detailed portrait with cinematic lighting", want: "gpt-image-1"},
+		{name: "long", prompt: strings.Repeat("a quiet harbor town at dawn, ", 8), want: "gpt-image-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newImageTestInfo(infoChannelId(3), infoUpstreamModel("dall-e-3"), infoChannelSetting(dto.ChannelSettings{ImageComplexityRouting: routing}))
+			request := &dto.ImageRequest{Model: "dall-e-3", Prompt: tt.prompt}
+			applyImageComplexityRouting(newImageTestContext(http.MethodPost, "/v1/images/generations"), info, request)
+			if info.UpstreamModelName != tt.want || request.Model != tt.want {
+				t.Fatalf("routed to %q (request model %q), want %q", info.UpstreamModelName, request.Model, tt.want)
+			}
+			// 计费仍按客户端请求的模型
+			if info.OriginModelName != "" {
+				t.Fatalf("origin model changed to %q", info.OriginModelName)
+			}
+		})
+	}
+}
+
+func TestImageComplexityRoutingDisabled(t *testing.T) {
+	tiers := []dto.ImageComplexityTier{{MaxScore: 100, Model: "dall-e-2"}}
+	for _, routing := range []*dto.ImageComplexityRoutingSetting{
+		nil,
+		{Tiers: tiers},
+		{Enabled: true},
+		{Enabled: true, Tiers: []dto.ImageComplexityTier{{MaxScore: 100}}},
+	} {
+		info := newImageTestInfo(infoChannelId(3), infoUpstreamModel("dall-e-3"), infoChannelSetting(dto.ChannelSettings{ImageComplexityRouting: routing}))
+		request := &dto.ImageRequest{Model: "dall-e-3", Prompt: "a red apple"}
+		applyImageComplexityRouting(newImageTestContext(http.MethodPost, "/v1/images/generations"), info, request)
+		if info.UpstreamModelName != "dall-e-3" || request.Model != "dall-e-3" {
+			t.Fatalf("routing %+v changed model to %q", routing, info.UpstreamModelName)
+		}
+	}
+}
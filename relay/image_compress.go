@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// acceptedImageResponseEncoding 根据 Accept-Encoding 选择压缩方式，优先 br，未接受时返回空字符串
+func acceptedImageResponseEncoding(c *gin.Context) string {
+	gzipAccepted := false
+	for _, part := range strings.Split(c.GetHeader("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "br":
+			return "br"
+		case "gzip":
+			gzipAccepted = true
+		}
+	}
+	if gzipAccepted {
+		return "gzip"
+	}
+	return ""
+}
+
+// shouldCompressImageResponse 开启响应压缩且客户端接受压缩时才需要暂存响应
+func shouldCompressImageResponse(c *gin.Context) bool {
+	// 已经以 SSE 形式开始响应时不再压缩
+	return model_setting.GetImageSettings().ResponseCompressionEnabled && acceptedImageResponseEncoding(c) != "" &&
+		!c.GetBool(ImageKeepAliveStartedKey)
+}
+
+// isCompressibleImageContentType 只压缩 JSON 响应。上游直接返回的图片字节（PNG/JPEG/WebP 已经是压缩格式）
+// 与 SSE 事件流不压缩，未返回 Content-Type 的响应按 JSON 处理
+func isCompressibleImageContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// compressImageResponse 压缩超过阈值的 JSON 响应体，压缩失败或内容已被编码时原样返回。
+// 响应体由 flushTo 整体写回并按压缩后的长度设置 Content-Length
+func compressImageResponse(c *gin.Context, recorder *imageResponseRecorder, body []byte) []byte {
+	if !shouldCompressImageResponse(c) || recorder.header.Get("Content-Encoding") != "" ||
+		!isCompressibleImageContentType(recorder.header.Get("Content-Type")) {
+		return body
+	}
+	// 是否压缩取决于 Accept-Encoding，未达到阈值时同样需要告知缓存
+	recorder.header.Add("Vary", "Accept-Encoding")
+	if len(body) < model_setting.GetImageSettings().ResponseCompressionMinBytes {
+		return body
+	}
+	encoding := acceptedImageResponseEncoding(c)
+	var buf bytes.Buffer
+	var err error
+	switch encoding {
+	case "br":
+		writer := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+		if _, err = writer.Write(body); err == nil {
+			err = writer.Close()
+		}
+	case "gzip":
+		writer := gzip.NewWriter(&buf)
+		if _, err = writer.Write(body); err == nil {
+			err = writer.Close()
+		}
+	}
+	if err != nil {
+		logger.LogWarn(c, "compress image response failed: "+err.Error())
+		return body
+	}
+	recorder.header.Set("Content-Encoding", encoding)
+	return buf.Bytes()
+}
@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newLargeB64ImageResponse 生成 b64_json 为 size 字节图片数据的上游响应，数据带有重复内容以便压缩
+func newLargeB64ImageResponse(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	body, err := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{{B64Json: base64.StdEncoding.EncodeToString(data)}}})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return body
+}
+
+// serveCompressedImageResponse 按 Accept-Encoding 处理暂存的上游响应并写回客户端
+func serveCompressedImageResponse(t *testing.T, acceptEncoding, contentType string, upstream []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	writer := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(writer)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	c.Request.Header.Set("Accept-Encoding", acceptEncoding)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.header.Set("Content-Type", contentType)
+	recorder.body.Write(upstream)
+	body, err := processRecordedImageResponse(c, info, recorder)
+	if err != nil {
+		t.Fatalf("process response: %v", err)
+	}
+	recorder.flushTo(c.Writer, body)
+	return writer
+}
+
+func withImageResponseCompression(t *testing.T, minBytes int) {
+	t.Helper()
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.ResponseCompressionEnabled = true
+		settings.ResponseCompressionMinBytes = minBytes
+	})
+}
+
+func TestLargeB64ImageResponseIsGzipped(t *testing.T) {
+	withImageResponseCompression(t, 64*1024)
+	upstream := newLargeB64ImageResponse(t, 256*1024)
+
+	writer := serveCompressedImageResponse(t, "gzip, deflate", "application/json", upstream)
+	if got := writer.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := writer.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("Vary = %q, want Accept-Encoding", got)
+	}
+	compressed := writer.Body.Bytes()
+	if writer.Header().Get("Content-Length") != strconv.Itoa(len(compressed)) || len(compressed) >= len(upstream) {
+		t.Fatalf("Content-Length %s, compressed %d bytes of %d", writer.Header().Get("Content-Length"), len(compressed), len(upstream))
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("open gzip body: %v", err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(decompressed, upstream) {
+		t.Fatalf("decompressed body differs from the upstream response (%v)", err)
+	}
+}
+
+func TestImageResponseCompressionSkipped(t *testing.T) {
+	withImageResponseCompression(t, 64*1024)
+	large := newLargeB64ImageResponse(t, 256*1024)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		upstream       []byte
+		vary           bool
+	}{
+		{name: "below threshold", acceptEncoding: "gzip", contentType: "application/json", upstream: newLargeB64ImageResponse(t, 1024), vary: true},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0", contentType: "application/json", upstream: large},
+		{name: "no accept-encoding", contentType: "application/json", upstream: large},
+		{name: "raw image bytes", acceptEncoding: "gzip", contentType: "image/png", upstream: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := serveCompressedImageResponse(t, tt.acceptEncoding, tt.contentType, tt.upstream)
+			if got := writer.Header().Get("Content-Encoding"); got != "" {
+				t.Fatalf("Content-Encoding = %q, want uncompressed", got)
+			}
+			if !bytes.Equal(writer.Body.Bytes(), tt.upstream) {
+				t.Fatal("uncompressed body differs from the upstream response")
+			}
+			if got := writer.Header().Get("Vary") == "Accept-Encoding"; got != tt.vary {
+				t.Fatalf("Vary Accept-Encoding = %v, want %v", got, tt.vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acquireImageConcurrencySlot 渠道配置了 max_concurrent_images 时，在请求上游前占用一个并发名额，
+// 名额已满时排队等待，超时返回 429（允许重试，由其他渠道处理）
+func acquireImageConcurrencySlot(c *gin.Context, info *relaycommon.RelayInfo) (func(), *types.NewAPIError) {
+	limit := info.ChannelSetting.MaxConcurrentImages
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	wait := time.Duration(info.ChannelSetting.ImageConcurrencyWaitSeconds) * time.Second
+	if wait <= 0 {
+		wait = 30 * time.Second
+	}
+	channelQueue := service.GetChannelImageQueueStats(info.ChannelId)
+	setImageQueueHeaders(c, channelQueue.Queued, channelQueue.EstimatedWaitMs)
+	start := time.Now()
+	release, err := service.AcquireChannelConcurrencySlot(c.Request.Context(), info.ChannelId, limit, wait)
+	if err != nil {
+		if errors.Is(err, service.ErrChannelConcurrencyWaitTimeout) {
+			return nil, types.NewErrorWithStatusCode(fmt.Errorf("channel concurrency limit of %d image requests reached, waited %s", limit, wait), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)
+		}
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
+	if waited := time.Since(start); waited > time.Second {
+		logger.LogInfo(c, fmt.Sprintf("waited %s for channel #%d concurrency slot", waited.Round(time.Millisecond), info.ChannelId))
+	}
+	return release, nil
+}
+
+// acquireGlobalImageSlot 在转换请求前占用全局图片并发名额，直到 ImageHelper 返回才归还，
+// 覆盖转换、上游请求与响应处理期间的内存占用。队列已满或等待超时返回 503，换渠道重试同样受限，因此不重试
+func acquireGlobalImageSlot(c *gin.Context) (func(), *types.NewAPIError) {
+	if stats := service.GetGlobalImageLimiterStats(); stats.MaxConcurrent > 0 {
+		c.Set(imageGlobalQueueStatsKey, stats)
+		setImageQueueHeaders(c, 0, 0)
+	}
+	start := time.Now()
+	release, err := service.AcquireGlobalImageSlot(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrGlobalImageQueueFull) || errors.Is(err, service.ErrGlobalImageWaitTimeout) {
+			logger.LogWarn(c, fmt.Sprintf("image request rejected by global concurrency limit: %s", err.Error()))
+		}
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeImageServerBusy, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+	}
+	if waited := time.Since(start); waited > time.Second {
+		logger.LogInfo(c, fmt.Sprintf("waited %s for global image concurrency slot", waited.Round(time.Millisecond)))
+	}
+	return release, nil
+}
+
+const (
+	imageQueueDepthHeader    = "X-New-Api-Image-Queue-Depth"
+	imageEstimatedWaitHeader = "X-New-Api-Image-Estimated-Wait-Ms"
+	imageGlobalQueueStatsKey = "image_global_queue_stats"
+)
+
+// setImageQueueHeaders 在响应头中返回请求进入排队时前方的请求数与按最近请求耗时估算的等待时间，
+// 渠道排队（channelQueued、channelWaitMs）叠加在全局排队之上。重试换渠道时按新渠道重新计算，不暴露渠道信息
+func setImageQueueHeaders(c *gin.Context, channelQueued int, channelWaitMs int64) {
+	depth, waitMs := channelQueued, channelWaitMs
+	if value, ok := c.Get(imageGlobalQueueStatsKey); ok {
+		global := value.(service.GlobalImageLimiterStats)
+		depth += global.Queued
+		waitMs += global.EstimatedWaitMs
+	}
+	c.Header(imageQueueDepthHeader, strconv.Itoa(depth))
+	c.Header(imageEstimatedWaitHeader, strconv.FormatInt(waitMs, 10))
+}
@@ -0,0 +1,94 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveImageContinuationURL 解析后续结果地址，相对路径基于渠道 base url，
+// 完整地址必须与渠道位于同一主机，避免携带渠道密钥请求任意地址
+func resolveImageContinuationURL(baseUrl string, next string) (string, error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid channel base url: %w", err)
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("invalid continuation url %q: %w", next, err)
+	}
+	target := base.ResolveReference(ref)
+	if target.Host != base.Host || (target.Scheme != "http" && target.Scheme != "https") {
+		return "", fmt.Errorf("continuation url %q is not on the channel host", next)
+	}
+	return target.String(), nil
+}
+
+// followImageContinuation 上游在响应头中返回后续结果地址时依次拉取（最多 MaxPages 次），
+// 合并为一个响应交给 DoResponse 处理。后续请求失败时返回该次响应，由调用方按上游错误处理
+func followImageContinuation(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, resp *http.Response) (*http.Response, error) {
+	setting := info.ChannelSetting.ImageContinuation
+	if setting == nil || setting.Header == "" || info.IsStream {
+		return resp, nil
+	}
+	next := resp.Header.Get(setting.Header)
+	if next == "" {
+		return resp, nil
+	}
+	maxPages := setting.MaxPages
+	if maxPages <= 0 {
+		maxPages = 5
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var merger imageResponseMerger
+	if err = merger.add(body); err != nil {
+		return nil, err
+	}
+	header := resp.Header.Clone()
+	pages := 0
+	for ; next != "" && pages < maxPages; pages++ {
+		target, err := resolveImageContinuationURL(info.ChannelBaseUrl, next)
+		if err != nil {
+			return nil, err
+		}
+		pageResp, err := channel.DoImageContinuationRequest(adaptor, c, info, target)
+		if err != nil {
+			return nil, err
+		}
+		if pageResp.StatusCode != http.StatusOK {
+			return pageResp, nil
+		}
+		pageBody, err := io.ReadAll(pageResp.Body)
+		_ = pageResp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err = merger.add(pageBody); err != nil {
+			return nil, err
+		}
+		next = pageResp.Header.Get(setting.Header)
+	}
+	if next != "" {
+		logger.LogWarn(c, fmt.Sprintf("image continuation stopped after %d pages on channel #%d, remaining results dropped", pages, info.ChannelId))
+	} else {
+		logger.LogInfo(c, fmt.Sprintf("image continuation fetched %d extra pages on channel #%d", pages, info.ChannelId))
+	}
+	header.Del(setting.Header)
+	merged, err := merger.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return newMergedImageResponse(header, merged), nil
+}
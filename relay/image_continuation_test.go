@@ -0,0 +1,152 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+)
+
+const imageContinuationHeader = "X-Next-Results"
+
+// newImageContinuationServer 模拟分页返回图片的上游：/page/2 返回第三张并指向 /page/3，/page/3 返回最后一张，
+// /page/error 返回错误。记录后续请求是否带上渠道密钥
+func newImageContinuationServer(t *testing.T, unauthorized *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Authorization") != "Bearer sk-test" {
+			unauthorized.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/page/2":
+			w.Header().Set(imageContinuationHeader, "/page/3")
+			_, _ = w.Write([]byte(`{"created":2,"data":[{"url":"https://cdn.example.com/c.png"}],"usage":{"total_tokens":10}}`))
+		case "/page/3":
+			_, _ = w.Write([]byte(`{"created":3,"data":[{"url":"https://cdn.example.com/d.png"}],"usage":{"total_tokens":10}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":{"message":"page expired"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// firstImagePage 上游首次响应返回两张图片，并在响应头中指向后续结果
+func firstImagePage(next string) *http.Response {
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if next != "" {
+		header.Set(imageContinuationHeader, next)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body: io.NopCloser(strings.NewReader(
+			`{"created":1,"data":[{"url":"https://cdn.example.com/a.png"},{"url":"https://cdn.example.com/b.png"}],"usage":{"total_tokens":20}}`)),
+	}
+}
+
+func decodeContinuationResponse(t *testing.T, resp *http.Response) ([]string, float64) {
+	t.Helper()
+	body, _ := io.ReadAll(resp.Body)
+	var response struct {
+		Created int64 `json:"created"`
+		Data    []struct {
+			Url string `json:"url"`
+		} `json:"data"`
+		Usage map[string]float64 `json:"usage"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decode merged response %s: %v", body, err)
+	}
+	if response.Created != 1 {
+		t.Fatalf("created = %d, want the first page's value", response.Created)
+	}
+	urls := make([]string, len(response.Data))
+	for i, data := range response.Data {
+		urls[i] = strings.TrimSuffix(strings.TrimPrefix(data.Url, "https://cdn.example.com/"), ".png")
+	}
+	return urls, response.Usage["total_tokens"]
+}
+
+func TestImageContinuationFollowsHeader(t *testing.T) {
+	var unauthorized atomic.Int32
+	server := newImageContinuationServer(t, &unauthorized)
+	generations, endpoint := infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelEndpoint(server.URL, "sk-test")
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+
+	tests := []struct {
+		name    string
+		setting *dto.ImageContinuationSetting
+		next    string
+		want    string
+		tokens  float64
+	}{
+		{name: "all pages", setting: &dto.ImageContinuationSetting{Header: imageContinuationHeader}, next: "/page/2", want: "a,b,c,d", tokens: 40},
+		{name: "absolute url on channel host", setting: &dto.ImageContinuationSetting{Header: imageContinuationHeader}, next: server.URL + "/page/3", want: "a,b,d", tokens: 30},
+		// 达到拉取上限后停止，剩余结果丢弃
+		{name: "page limit", setting: &dto.ImageContinuationSetting{Header: imageContinuationHeader, MaxPages: 1}, next: "/page/2", want: "a,b,c", tokens: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := followImageContinuation(c, newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{ImageContinuation: tt.setting})), &openai.Adaptor{}, firstImagePage(tt.next))
+			if err != nil {
+				t.Fatalf("follow continuation: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || resp.Header.Get(imageContinuationHeader) != "" {
+				t.Fatalf("merged response status %d, continuation header %q", resp.StatusCode, resp.Header.Get(imageContinuationHeader))
+			}
+			urls, tokens := decodeContinuationResponse(t, resp)
+			if strings.Join(urls, ",") != tt.want || tokens != tt.tokens {
+				t.Fatalf("merged images %v with %v tokens, want %s with %v tokens", urls, tokens, tt.want, tt.tokens)
+			}
+		})
+	}
+	if n := unauthorized.Load(); n != 0 {
+		t.Fatalf("%d continuation requests were sent without the channel key", n)
+	}
+}
+
+func TestImageContinuationFailures(t *testing.T) {
+	var unauthorized atomic.Int32
+	server := newImageContinuationServer(t, &unauthorized)
+	generations, endpoint := infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelEndpoint(server.URL, "sk-test")
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	setting := &dto.ImageContinuationSetting{Header: imageContinuationHeader}
+
+	// 后续请求失败时返回该次响应，由调用方按上游错误处理
+	resp, err := followImageContinuation(c, newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{ImageContinuation: setting})), &openai.Adaptor{}, firstImagePage("/page/error"))
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("failed page: status %v, err %v, want the upstream 500", resp, err)
+	}
+
+	// 不在渠道主机上的地址不会携带密钥请求
+	for _, next := range []string{"https://attacker.example.com/page/2", "ftp://" + strings.TrimPrefix(server.URL, "http://") + "/page/2"} {
+		if _, err = followImageContinuation(c, newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{ImageContinuation: setting})), &openai.Adaptor{}, firstImagePage(next)); err == nil {
+			t.Fatalf("continuation url %q was followed", next)
+		}
+	}
+
+	// 未配置、响应头为空或流式请求时原样返回
+	stream := newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{ImageContinuation: setting}))
+	stream.IsStream = true
+	for name, info := range map[string]*relaycommon.RelayInfo{
+		"not configured": newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{})),
+		"other header":   newImageTestInfo(generations, endpoint, infoChannelSetting(dto.ChannelSettings{ImageContinuation: &dto.ImageContinuationSetting{Header: "X-Other"}})),
+		"stream":         stream,
+	} {
+		first := firstImagePage("/page/2")
+		if resp, err = followImageContinuation(c, info, &openai.Adaptor{}, first); err != nil || resp != first {
+			t.Fatalf("%s: response replaced without continuation: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotImageUpstreamBody 渠道要求保留内容凭证时，在重新编码图片的后处理之前保存上游原始响应
+func snapshotImageUpstreamBody(recorder *imageResponseRecorder) {
+	recorder.upstream = bytes.Clone(recorder.body.Bytes())
+}
+
+// restoreImageContentCredentials 后处理重新编码图片时会丢失全部元数据，这里把上游图片中的内容凭证写回处理后的图片。
+// 处理后格式变化等无法保留凭证的情况下恢复为上游原图，保证凭证不会被移除
+func restoreImageContentCredentials(c *gin.Context, upstream []byte, body []byte) []byte {
+	originals := imageResponseBase64Items(upstream)
+	if len(originals) == 0 {
+		return body
+	}
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body
+	}
+	changed := false
+	for i, item := range items {
+		if i >= len(originals) || originals[i] == "" {
+			continue
+		}
+		var b64 string
+		if err := common.Unmarshal(item["b64_json"], &b64); err != nil || b64 == "" || b64 == originals[i] {
+			continue
+		}
+		original, err := base64.StdEncoding.DecodeString(originals[i])
+		if err != nil {
+			continue
+		}
+		format, segments := service.ExtractContentCredentials(original)
+		if len(segments) == 0 {
+			continue
+		}
+		processed, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			continue
+		}
+		restored, err := service.InjectContentCredentials(processed, format, segments)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("cannot preserve content credentials of image %d, return upstream original: %s", i, err.Error()))
+			restored = original
+		}
+		item["b64_json"], _ = common.Marshal(base64.StdEncoding.EncodeToString(restored))
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body
+	}
+	response["data"] = data
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+// imageResponseBase64Items 按顺序返回响应中每张图片的 base64 数据，URL 形式的图片为空字符串
+func imageResponseBase64Items(body []byte) []string {
+	var response struct {
+		Data []struct {
+			B64Json string `json:"b64_json"`
+		} `json:"data"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	items := make([]string, len(response.Data))
+	for i, item := range response.Data {
+		items[i] = item.B64Json
+	}
+	return items
+}
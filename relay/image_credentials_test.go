@@ -0,0 +1,92 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+func newPngChunk(chunkType string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngChunkTypes 返回 PNG 中的块类型及 caBX 块的原始数据
+func pngChunkTypes(data []byte) (types map[string]bool, credentials [][]byte) {
+	types = make(map[string]bool)
+	for offset := 8; offset+12 <= len(data); {
+		end := offset + 12 + int(binary.BigEndian.Uint32(data[offset:offset+4]))
+		chunkType := string(data[offset+4 : offset+8])
+		types[chunkType] = true
+		if chunkType == "caBX" {
+			credentials = append(credentials, data[offset:end])
+		}
+		offset = end
+	}
+	return types, credentials
+}
+
+func TestContentCredentialsSurviveMetadataStripping(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	// 上游图片在 IHDR 之后带有普通文本元数据与 C2PA 内容凭证
+	credentials := newPngChunk("caBX", []byte("c2pa manifest store"))
+	var upstream bytes.Buffer
+	upstream.Write(encoded.Bytes()[:33])
+	upstream.Write(newPngChunk("tEXt", []byte("Author\x00upstream provider")))
+	upstream.Write(credentials)
+	upstream.Write(encoded.Bytes()[33:])
+
+	for _, preserve := range []bool{true, false} {
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+		// 缩小图片会重新编码，去掉全部元数据
+		info.ChannelSetting.ImageMaxOutput = &dto.ImageMaxOutputSetting{MaxWidth: 8, MaxHeight: 8, Policy: dto.ImageMaxOutputPolicyDownscale}
+		info.ChannelSetting.ImagePreserveContentCredentials = preserve
+		recorder := newImageResponseRecorder(c.Writer)
+		recorder.body.WriteString(`{"created":1,"data":[{"b64_json":"` + base64.StdEncoding.EncodeToString(upstream.Bytes()) + `"}]}`)
+		if preserve {
+			snapshotImageUpstreamBody(recorder)
+		}
+		body, newAPIError := processRecordedImageResponse(c, info, recorder)
+		if newAPIError != nil {
+			t.Fatalf("preserve %v: process response: %v", preserve, newAPIError)
+		}
+
+		var response dto.ImageResponse
+		if err := common.Unmarshal(body, &response); err != nil || len(response.Data) != 1 {
+			t.Fatalf("preserve %v: decode response %s: %v", preserve, body, err)
+		}
+		data, _ := base64.StdEncoding.DecodeString(response.Data[0].B64Json)
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width != 8 {
+			t.Fatalf("preserve %v: processed image %dx%d (%v), want it downscaled to 8 wide", preserve, config.Width, config.Height, err)
+		}
+		chunkTypes, kept := pngChunkTypes(data)
+		if chunkTypes["tEXt"] {
+			t.Fatalf("preserve %v: text metadata was not stripped", preserve)
+		}
+		if !preserve {
+			if len(kept) != 0 {
+				t.Fatal("content credentials kept without the channel option")
+			}
+			continue
+		}
+		if len(kept) != 1 || !bytes.Equal(kept[0], credentials) {
+			t.Fatalf("content credentials = %q, want the upstream caBX chunk", kept)
+		}
+	}
+}
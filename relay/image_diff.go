@@ -0,0 +1,127 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imagePreviousGenerationField = "previous_generation_id"
+
+// imageParamChange 单个参数的变化，参数不存在时为 null
+type imageParamChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// imageParamDiff 响应 new_api.param_diff 字段
+type imageParamDiff struct {
+	PreviousGenerationId string                      `json:"previous_generation_id"`
+	Found                bool                        `json:"found"`
+	Changed              map[string]imageParamChange `json:"changed,omitempty"`
+}
+
+// parseImagePreviousGeneration 读取客户端引用的上一次生成 ID，并从请求中移除该字段
+func parseImagePreviousGeneration(c *gin.Context, request *dto.ImageRequest) *types.NewAPIError {
+	if !model_setting.GetImageSettings().GenerationDiffEnabled {
+		return nil
+	}
+	previousId, err := popImageStringField(c, request, imagePreviousGenerationField)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if previousId != "" {
+		c.Set("image_previous_generation_id", previousId)
+	}
+	return nil
+}
+
+// snapshotImageGenerationParams 提取实际发往上游的生成参数，跳过输入图片等大字段
+func snapshotImageGenerationParams(request *dto.ImageRequest) map[string]string {
+	fields := make(map[string]json.RawMessage)
+	if raw, err := common.Marshal(request); err == nil {
+		_ = common.Unmarshal(raw, &fields)
+	}
+	for k, v := range request.Extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	delete(fields, "image")
+	delete(fields, "style_reference")
+	params := make(map[string]string, len(fields))
+	for k, v := range fields {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, v); err == nil {
+			params[k] = compacted.String()
+		} else {
+			params[k] = string(v)
+		}
+	}
+	return params
+}
+
+// diffImageGenerationParams 记录本次生成参数，引用了上一次生成时对比参数并写入响应的 new_api.param_diff，
+// 需要在参数改写完成后、发往上游前调用
+func diffImageGenerationParams(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	if !model_setting.GetImageSettings().GenerationDiffEnabled {
+		return
+	}
+	params := snapshotImageGenerationParams(request)
+	c.Set("image_generation_params", params)
+
+	previousId := c.GetString("image_previous_generation_id")
+	if previousId == "" {
+		return
+	}
+	diff := imageParamDiff{PreviousGenerationId: previousId}
+	previous, ok := service.LoadImageGenerationParams(info.UserId, previousId)
+	if !ok {
+		logger.LogInfo(c, fmt.Sprintf("previous image generation %s not found, param diff skipped", previousId))
+		setImageResponseMeta(c, "param_diff", diff)
+		return
+	}
+	diff.Found = true
+	diff.Changed = make(map[string]imageParamChange)
+	for k, v := range params {
+		if prev, ok := previous[k]; !ok || prev != v {
+			change := imageParamChange{To: json.RawMessage(v)}
+			if ok {
+				change.From = json.RawMessage(prev)
+			}
+			diff.Changed[k] = change
+		}
+	}
+	for k, prev := range previous {
+		if _, ok := params[k]; !ok {
+			diff.Changed[k] = imageParamChange{From: json.RawMessage(prev)}
+		}
+	}
+	setImageResponseMeta(c, "param_diff", diff)
+}
+
+// saveImageGenerationParams 生成成功后按请求 ID 保存参数，供后续生成引用
+func saveImageGenerationParams(c *gin.Context, info *relaycommon.RelayInfo) {
+	value, ok := c.Get("image_generation_params")
+	if !ok {
+		return
+	}
+	params, _ := value.(map[string]string)
+	ttl := time.Duration(model_setting.GetImageSettings().GenerationParamTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	service.SaveImageGenerationParams(info.UserId, c.GetString(common.RequestIdKey), params, ttl)
+}
@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runImageGenerationDiff 按请求处理流程记录参数并对比，成功后以 requestId 保存参数，返回写入响应的 param_diff
+func runImageGenerationDiff(t *testing.T, userId int, requestId string, request *dto.ImageRequest) (imageParamDiff, bool) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Set(common.RequestIdKey, requestId)
+	info := &relaycommon.RelayInfo{UserId: userId}
+	if err := parseImagePreviousGeneration(c, request); err != nil {
+		t.Fatalf("parse previous generation: %v", err)
+	}
+	diffImageGenerationParams(c, info, request)
+	saveImageGenerationParams(c, info)
+	return imageParamDiffMeta(c)
+}
+
+func imageParamDiffMeta(c *gin.Context) (imageParamDiff, bool) {
+	meta, _ := c.Get(imageResponseMetaKey)
+	fields, _ := meta.(map[string]any)
+	diff, ok := fields["param_diff"].(imageParamDiff)
+	return diff, ok
+}
+
+func TestImageGenerationParamDiff(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.GenerationDiffEnabled = true
+	})
+	if _, ok := runImageGenerationDiff(t, 42, "req-diff-1", &dto.ImageRequest{
+		Model: "dall-e-3", Prompt: "a cat", Size: "1024x1024", Quality: "hd", N: 1,
+	}); ok {
+		t.Fatal("param diff reported for a request without previous_generation_id")
+	}
+
+	// 修改尺寸、去掉品质、新增 seed，提示词不变
+	previous, _ := common.Marshal("req-diff-1")
+	request := &dto.ImageRequest{Model: "dall-e-3", Prompt: "a cat", Size: "1792x1024", N: 1,
+		Extra: map[string]json.RawMessage{imagePreviousGenerationField: previous, "seed": json.RawMessage(`7`)}}
+	diff, ok := runImageGenerationDiff(t, 42, "req-diff-2", request)
+	if !ok || !diff.Found || diff.PreviousGenerationId != "req-diff-1" {
+		t.Fatalf("param diff = %+v (present %v), want the previous generation found", diff, ok)
+	}
+	if _, forwarded := request.Extra[imagePreviousGenerationField]; forwarded {
+		t.Fatal("previous_generation_id forwarded upstream")
+	}
+	want := map[string][2]string{
+		"size":    {`"1024x1024"`, `"1792x1024"`},
+		"quality": {`"hd"`, ""},
+		"seed":    {"", "7"},
+	}
+	if len(diff.Changed) != len(want) {
+		t.Fatalf("changed params = %v, want %v", diff.Changed, want)
+	}
+	for key, change := range want {
+		got, ok := diff.Changed[key]
+		if !ok || string(got.From) != change[0] || string(got.To) != change[1] {
+			t.Fatalf("%s changed from %s to %s, want %s to %s", key, got.From, got.To, change[0], change[1])
+		}
+	}
+
+	// 上一次生成属于其他用户或不存在时只标记未找到
+	for userId, id := range map[int]string{43: "req-diff-1", 42: "req-missing"} {
+		raw, _ := common.Marshal(id)
+		diff, ok = runImageGenerationDiff(t, userId, "req-diff-3", &dto.ImageRequest{Model: "dall-e-3", Prompt: "a cat",
+			Extra: map[string]json.RawMessage{imagePreviousGenerationField: raw}})
+		if !ok || diff.Found || diff.Changed != nil {
+			t.Fatalf("user %d previous %s: param diff = %+v, want not found", userId, id, diff)
+		}
+	}
+}
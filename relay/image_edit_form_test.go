@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newImageEditFormContext 构造编辑请求表单，按 fieldNames 顺序各上传一个文件，
+// 第 i 个文件名为字段名、内容为 i+1 个字节，解析后返回上下文
+func newImageEditFormContext(t *testing.T, fieldNames ...string) *gin.Context {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("prompt", "add a hat")
+	for i, fieldName := range fieldNames {
+		part, err := writer.CreateFormFile(fieldName, fieldName)
+		if err != nil {
+			t.Fatalf("create form file %s: %v", fieldName, err)
+		}
+		_, _ = part.Write(bytes.Repeat([]byte("x"), i+1))
+	}
+	_ = writer.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/edits", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	if _, err := c.MultipartForm(); err != nil {
+		t.Fatalf("parse form: %v", err)
+	}
+	return c
+}
+
+func TestDuplicateImageFieldsAreMerged(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.MultipartDuplicatePolicy = model_setting.ImageMultipartDuplicateMerge
+	})
+	// 表单中 image[] 在 image 之前，合并后仍按 image、image[] 的固定顺序排列
+	c := newImageEditFormContext(t, "image[]", "image", "image[]")
+
+	files, err := relaycommon.CollectImageFormFiles(c.Request.MultipartForm)
+	if err != nil {
+		t.Fatalf("collect image files: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Filename)
+	}
+	if got := strings.Join(names, ","); got != "image,image[],image[]" {
+		t.Fatalf("collected files = %s, want image,image[],image[]", got)
+	}
+	if count, size := getInputImageCountAndBytes(c); count != 3 || size != 1+2+3 {
+		t.Fatalf("counted %d images of %d bytes, want 3 images of 6 bytes", count, size)
+	}
+}
+
+func TestDuplicateImageFieldsAreRejected(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.MultipartDuplicatePolicy = model_setting.ImageMultipartDuplicateError
+	})
+	c := newImageEditFormContext(t, "image", "image[]")
+
+	if _, err := relaycommon.CollectImageFormFiles(c.Request.MultipartForm); !errors.Is(err, relaycommon.ErrAmbiguousImageFields) {
+		t.Fatalf("err = %v, want ErrAmbiguousImageFields", err)
+	}
+	if count, size := getInputImageCountAndBytes(c); count != 0 || size != 0 {
+		t.Fatalf("ambiguous form counted %d images of %d bytes", count, size)
+	}
+
+	// 只使用一种写法时不受该策略影响
+	c = newImageEditFormContext(t, "image[]", "image[]")
+	if count, _ := getInputImageCountAndBytes(c); count != 2 {
+		t.Fatalf("counted %d images for repeated image[], want 2", count)
+	}
+}
+
+func TestMixedImageFieldsSizeInfo(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.MultipartDuplicatePolicy = model_setting.ImageMultipartDuplicateMerge
+	})
+	// imageFoo、image[foo] 与 mask 不是图片数组字段，不计入
+	c := newImageEditFormContext(t, "image", "image[]", "image[0]", "image[10]", "image[2]", "imageFoo", "image[foo]", "mask")
+
+	files, err := relaycommon.CollectImageFormFiles(c.Request.MultipartForm)
+	if err != nil {
+		t.Fatalf("collect image files: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Filename)
+	}
+	if got := strings.Join(names, ","); got != "image,image[],image[0],image[2],image[10]" {
+		t.Fatalf("collected files = %s, want image,image[],image[0],image[2],image[10]", got)
+	}
+	if count, size := getInputImageCountAndBytes(c); count != 5 || size != 1+2+3+4+5 {
+		t.Fatalf("counted %d images of %d bytes, want 5 images of 15 bytes", count, size)
+	}
+
+	// 后端未记录文件大小时读取文件计数
+	for _, fieldFiles := range c.Request.MultipartForm.File {
+		for _, file := range fieldFiles {
+			file.Size = 0
+		}
+	}
+	if count, size := getInputImageCountAndBytes(c); count != 5 || size != 15 || formatImageByteSize(size) != "15 B" {
+		t.Fatalf("counted %d images of %d bytes without recorded sizes, want 5 images of 15 bytes", count, size)
+	}
+}
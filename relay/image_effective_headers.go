@@ -0,0 +1,43 @@
+package relay
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageEffectiveChannelHeader = "X-New-Api-Channel"
+	imageEffectiveModelHeader   = "X-New-Api-Model"
+	imageEffectiveChargeHeader  = "X-New-Api-Charge"
+)
+
+func shouldSetImageEffectiveHeaders() bool {
+	return model_setting.GetImageSettings().EffectiveHeadersEnabled
+}
+
+// setImageEffectiveHeaders 在适配器写出响应前返回实际渠道与上游模型。流式响应的响应头随第一个事件发出，
+// 此时尚未计费，扣除额度按预估额度返回；非流式响应的额度由 setImageEffectiveChargeHeader 在计费后写入
+func setImageEffectiveHeaders(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	if !shouldSetImageEffectiveHeaders() {
+		return
+	}
+	header := c.Writer.Header()
+	header.Set(imageEffectiveChannelHeader, strconv.Itoa(info.ChannelId))
+	header.Set(imageEffectiveModelHeader, info.UpstreamModelName)
+	if info.IsStream {
+		header.Set(imageEffectiveChargeHeader, strconv.Itoa(estimateImageQuota(c, info, request)))
+	}
+}
+
+// setImageEffectiveChargeHeader 返回本次请求实际扣除的额度，必须在计费完成后调用
+func setImageEffectiveChargeHeader(c *gin.Context, recorder *imageResponseRecorder) {
+	if !shouldSetImageEffectiveHeaders() {
+		return
+	}
+	recorder.header.Set(imageEffectiveChargeHeader, strconv.Itoa(c.GetInt("consumed_quota")))
+}
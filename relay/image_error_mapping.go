@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mapImageUpstreamError 按适配器的映射表将上游错误归类为稳定的图片错误码，便于客户端分别处理。
+// 上游错误信息、type 与状态码保持不变，未命中或适配器未提供映射表时原样返回
+func mapImageUpstreamError(c *gin.Context, adaptor channel.Adaptor, newAPIError *types.NewAPIError) *types.NewAPIError {
+	mapper, ok := adaptor.(channel.ImageErrorMapper)
+	if !ok || newAPIError == nil {
+		return newAPIError
+	}
+	openAIError := newAPIError.ToOpenAIError()
+	upstreamCode := fmt.Sprintf("%v", openAIError.Code)
+	message := strings.ToLower(openAIError.Message)
+	for _, rule := range mapper.ImageErrorRules() {
+		if rule.StatusCode != 0 && rule.StatusCode != newAPIError.StatusCode {
+			continue
+		}
+		if !matchImageErrorRule(rule, upstreamCode, openAIError.Type, message) {
+			continue
+		}
+		logger.LogInfo(c, fmt.Sprintf("image upstream error code %s mapped to %s", upstreamCode, rule.ErrorCode))
+		openAIError.Code = rule.ErrorCode
+		return types.WithOpenAIError(openAIError, newAPIError.StatusCode)
+	}
+	return newAPIError
+}
+
+// matchImageErrorRule 规则没有 Codes 与 Keywords 时只按状态码匹配
+func matchImageErrorRule(rule channel.ImageErrorRule, code string, errorType string, message string) bool {
+	if len(rule.Codes) == 0 && len(rule.Keywords) == 0 {
+		return rule.StatusCode != 0
+	}
+	for _, item := range rule.Codes {
+		if strings.EqualFold(item, code) || strings.EqualFold(item, errorType) {
+			return true
+		}
+	}
+	for _, keyword := range rule.Keywords {
+		if strings.Contains(message, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageEstimateHeader 请求头或同名查询参数 estimate_only 为 true 时只返回预估费用，不请求上游也不扣费
+const ImageEstimateHeader = "X-Estimate-Only"
+
+// imageEstimateResponse 预估结果，模型、尺寸与品质均为映射与规范化后发往上游的取值
+type imageEstimateResponse struct {
+	Object         string `json:"object"`
+	Model          string `json:"model"`
+	UpstreamModel  string `json:"upstream_model"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	N              uint   `json:"n"`
+	BillingType    string `json:"billing_type"` // per_call 按次计费，per_token 按量计费（按预扣额度估算）
+	EstimatedQuota int    `json:"estimated_quota"`
+}
+
+// IsImageEstimateRequest 客户端是否只请求预估费用
+func IsImageEstimateRequest(c *gin.Context) bool {
+	value := c.GetHeader(ImageEstimateHeader)
+	if value == "" {
+		value = c.Query("estimate_only")
+	}
+	estimate, _ := strconv.ParseBool(value)
+	return estimate
+}
+
+// respondImageEstimate 沿用实际计费的价格档位折算预估费用，按次计费的预估与实际扣费一致；
+// 按量计费的模型用量只有上游返回后才能确定，按预扣额度返回
+func respondImageEstimate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	response := imageEstimateResponse{
+		Object:        "image.estimate",
+		Model:         info.OriginModelName,
+		UpstreamModel: info.UpstreamModelName,
+		Size:          request.Size,
+		Quality:       request.Quality,
+		N:             request.N,
+		BillingType:   "per_token",
+	}
+	if info.PriceData.UsePrice {
+		response.BillingType = "per_call"
+	}
+	response.EstimatedQuota = estimateImageQuota(c, info, request)
+	c.JSON(http.StatusOK, response)
+}
+
+// estimateImageQuota 按实际计费的价格档位折算预估额度，不修改 info.PriceData；按量计费时返回预扣额度
+func estimateImageQuota(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) int {
+	if !info.PriceData.UsePrice {
+		return info.PriceData.QuotaToPreConsume
+	}
+	priceData := info.PriceData
+	defer func() {
+		info.PriceData = priceData
+	}()
+	applyImageSizePriceTier(c, info, request)
+	return int(info.PriceData.ModelPrice * common.QuotaPerUnit * info.PriceData.GroupRatioInfo.GroupRatio)
+}
+
+// holdImageQuota 发往上游前按预估额度预授权：已预扣的额度（包括信任额度免预扣的情况）不足预估时追加预扣，
+// 余额不足时直接拒绝。结算时按实际费用多退少补，失败时由调用方统一返还
+func holdImageQuota(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	if info.PriceData.FreeModel {
+		return nil
+	}
+	estimate := estimateImageQuota(c, info, request)
+	return service.HoldAdditionalQuota(c, estimate-info.FinalPreConsumedQuota, info)
+}
@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"maps"
+	"mime/multipart"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageFormSnapshotKey = "image_form_snapshot"
+
+// resetImageMultipartForm 图片请求处理过程中会改写已解析的表单（移除网关字段、调整 n 等），
+// 首次处理时保存表单副本，重试时恢复，保证每次尝试使用的都是客户端原始表单。
+// 文件内容保存在 FileHeader 中，可重复打开
+func resetImageMultipartForm(c *gin.Context) {
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		return
+	}
+	if snapshot, ok := c.Get(imageFormSnapshotKey); ok {
+		original := snapshot.(*multipart.Form)
+		mf.Value = cloneFormValues(original.Value)
+		mf.File = cloneFormFiles(original.File)
+		return
+	}
+	c.Set(imageFormSnapshotKey, &multipart.Form{
+		Value: cloneFormValues(mf.Value),
+		File:  cloneFormFiles(mf.File),
+	})
+}
+
+func cloneFormValues(values map[string][]string) map[string][]string {
+	cloned := maps.Clone(values)
+	for k, v := range cloned {
+		cloned[k] = slices.Clone(v)
+	}
+	return cloned
+}
+
+func cloneFormFiles(files map[string][]*multipart.FileHeader) map[string][]*multipart.FileHeader {
+	cloned := maps.Clone(files)
+	for k, v := range cloned {
+		cloned[k] = slices.Clone(v)
+	}
+	return cloned
+}
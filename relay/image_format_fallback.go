@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageRequestedOutputFormatKey = "image_requested_output_format"
+	imageFormatFallbackHeader     = "X-New-Api-Image-Format-Fallback"
+)
+
+// rememberRequestedImageOutputFormat 记录客户端显式要求（或令牌强制）的输出格式，WebP 优先自动设置的格式不在此列
+func rememberRequestedImageOutputFormat(c *gin.Context, request *dto.ImageRequest) {
+	var format string
+	if err := common.Unmarshal(request.OutputFormat, &format); err != nil || format == "" {
+		return
+	}
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	c.Set(imageRequestedOutputFormatKey, format)
+}
+
+// enforceImageOutputFormat 上游返回的 base64 图片与要求的格式不一致时，服务端能编码则直接转换，
+// 否则按 OutputFormatFallbackPolicy 返回上游格式并通过响应头告警，或返回错误（可重试其他渠道）
+func enforceImageOutputFormat(c *gin.Context, info *relaycommon.RelayInfo, recorder *imageResponseRecorder) *types.NewAPIError {
+	requested := c.GetString(imageRequestedOutputFormatKey)
+	if requested == "" || recorder.status != http.StatusOK {
+		return nil
+	}
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(recorder.body.Bytes(), &response); err != nil {
+		return nil
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return nil
+	}
+
+	converted := 0
+	fallback := ""
+	for i, item := range items {
+		var b64 string
+		if err := common.Unmarshal(item["b64_json"], &b64); err != nil || b64 == "" {
+			continue
+		}
+		actual := service.SniffBase64ImageFormat(b64)
+		if actual == "" || actual == requested {
+			continue
+		}
+		if service.CanEncodeImageFormat(requested) {
+			result, err := convertChannelImageFormat(info, recorder, b64, requested)
+			if err == nil {
+				item["b64_json"], _ = common.Marshal(result)
+				converted++
+				continue
+			}
+			logger.LogWarn(c, fmt.Sprintf("convert image %d from %s to %s failed: %s", i, actual, requested, err.Error()))
+		}
+		fallback = actual
+	}
+
+	if fallback != "" {
+		if model_setting.GetImageSettings().OutputFormatFallbackPolicy == model_setting.ImageFormatFallbackError {
+			return types.NewErrorWithStatusCode(fmt.Errorf("upstream returned %s image but output_format %s was requested and cannot be converted on the server", fallback, requested),
+				types.ErrorCodeImageFormatUnavailable, http.StatusBadGateway)
+		}
+		recorder.header.Set(imageFormatFallbackHeader, fallback)
+		logger.LogWarn(c, fmt.Sprintf("output_format %s unavailable, returned upstream format %s", requested, fallback))
+	}
+	if converted == 0 {
+		return nil
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return nil
+	}
+	response["data"] = data
+	if _, ok := response["output_format"]; ok && fallback == "" {
+		response["output_format"], _ = common.Marshal(requested)
+	}
+	result, err := common.Marshal(response)
+	if err != nil {
+		return nil
+	}
+	recorder.body.Reset()
+	recorder.body.Write(result)
+	return nil
+}
@@ -0,0 +1,66 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// enforceRequestedImageFormat 客户端要求 format，上游返回 PNG 时执行格式校验，返回处理后的响应与图片格式
+func enforceRequestedImageFormat(t *testing.T, format string) (*imageResponseRecorder, string, *types.NewAPIError) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	raw, _ := common.Marshal(format)
+	rememberRequestedImageOutputFormat(c, &dto.ImageRequest{OutputFormat: json.RawMessage(raw)})
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.WriteString(`{"created":1,"data":[{"b64_json":"` + encodeTestPNG(t, 4, 4) + `"}]}`)
+	newAPIError := enforceImageOutputFormat(c, &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}, recorder)
+
+	var response dto.ImageResponse
+	if err := common.Unmarshal(recorder.body.Bytes(), &response); err != nil || len(response.Data) != 1 {
+		t.Fatalf("decode response %s: %v", recorder.body.String(), err)
+	}
+	return recorder, service.SniffBase64ImageFormat(response.Data[0].B64Json), newAPIError
+}
+
+func TestImageFormatFallbackWhenEncoderUnavailable(t *testing.T) {
+	// 服务端没有 GIF 编码器，与未编译 WebP 编码器时的情况相同
+	if service.CanEncodeImageFormat("gif") {
+		t.Fatal("gif encoder unexpectedly available")
+	}
+
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.OutputFormatFallbackPolicy = model_setting.ImageFormatFallbackReturn
+	})
+	recorder, format, err := enforceRequestedImageFormat(t, "gif")
+	if err != nil {
+		t.Fatalf("fallback policy return rejected the response: %v", err)
+	}
+	if format != "png" || recorder.header.Get(imageFormatFallbackHeader) != "png" {
+		t.Fatalf("returned %s image with fallback header %q, want png with the header", format, recorder.header.Get(imageFormatFallbackHeader))
+	}
+
+	model_setting.GetImageSettings().OutputFormatFallbackPolicy = model_setting.ImageFormatFallbackError
+	if _, _, err = enforceRequestedImageFormat(t, "gif"); err == nil || err.StatusCode != http.StatusBadGateway || err.GetErrorCode() != types.ErrorCodeImageFormatUnavailable {
+		t.Fatalf("fallback policy error returned %v", err)
+	}
+}
+
+func TestImageFormatConvertedWhenEncoderAvailable(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.OutputFormatFallbackPolicy = model_setting.ImageFormatFallbackError
+	})
+	for _, requested := range []string{"webp", "jpeg", "png"} {
+		recorder, format, err := enforceRequestedImageFormat(t, requested)
+		if err != nil || format != requested || recorder.header.Get(imageFormatFallbackHeader) != "" {
+			t.Fatalf("requested %s: returned %s image, fallback header %q, err %v", requested, format, recorder.header.Get(imageFormatFallbackHeader), err)
+		}
+	}
+}
@@ -2,30 +2,70 @@ package relay
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
+	"github.com/shopspring/decimal"
+
 	"github.com/gin-gonic/gin"
 )
 
+// logImageHelperPhase 记录 ImageHelper 各阶段的耗时
+func logImageHelperPhase(c *gin.Context, info *relaycommon.RelayInfo, phase string, timeCost time.Duration) {
+	logger.LogInfoWithFields(c, "#ImageHelper#", logger.Fields{
+		"model":      info.OriginModelName,
+		"phase":      phase,
+		"timeCostMs": timeCost.Milliseconds(),
+	})
+}
+
 func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.NewAPIError) {
 	startTime := time.Now()
-	logger.LogInfo(c, "#ImageHelper#start, tokenId:"+string(info.TokenId)+", userId:"+string(info.UserId))
+	applyImageRequestId(c)
+	logImageHelperPhase(c, info, "start", 0)
 
 	info.InitChannelMeta(c)
+	resetImageMultipartForm(c)
+	resetImageSlowRefund(c)
+	trace := startImageTrace(c, info, startTime)
+	defer func() {
+		trace.end(newAPIError)
+	}()
+	defer func() {
+		newAPIError = redactImageError(c, newAPIError)
+	}()
+
+	if !service.AllowImageChannel(info.ChannelId) {
+		// 允许重试，由其他渠道处理
+		return types.NewErrorWithStatusCode(errors.New("image channel is temporarily unavailable due to repeated failures"), types.ErrorCodeImageCircuitOpen, http.StatusServiceUnavailable, types.ErrOptionWithNoRecordErrorLog())
+	}
+	upstreamAttempted := false
+	defer func() {
+		if !upstreamAttempted {
+			service.ReleaseImageChannelProbe(info.ChannelId)
+			return
+		}
+		service.RecordImageChannelResult(info.ChannelId, !isImageChannelFailure(newAPIError))
+	}()
 
 	imageReq, ok := info.Request.(*dto.ImageRequest)
 	if !ok {
@@ -36,13 +76,138 @@ func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 	if err != nil {
 		return types.NewError(fmt.Errorf("failed to copy request to ImageRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
 	}
+	rememberImagePrompt(c, request.Prompt)
 	deepCopyTime := time.Now()
-	logger.LogInfo(c, "#ImageHelper#deep copy, tokenId:"+string(info.TokenId)+", userId:"+string(info.UserId)+", timeCost:"+(deepCopyTime.Sub(startTime)/1000).String())
+	logImageHelperPhase(c, info, "deep_copy", deepCopyTime.Sub(startTime))
+	service.ObserveImagePhase(info.OriginModelName, info.ChannelId, "deepcopy", deepCopyTime.Sub(startTime))
+	trace.phase("image.deep_copy", deepCopyTime.Sub(startTime), startTime)
 
 	err = helper.ModelMappedHelper(c, info, request)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
 	}
+	trace.phase("image.model_mapping", time.Since(deepCopyTime), deepCopyTime)
+	applyImageModelFallback(c, info, request)
+	if newAPIError = validateMappedImageModel(c, info); newAPIError != nil {
+		return newAPIError
+	}
+	if info.IsStream || isStreamImageRequest(request) {
+		if newAPIError = checkImageStreamSafetyPolicy(info); newAPIError != nil {
+			return newAPIError
+		}
+		if newAPIError = service.CheckStreamMinimumQuota(c, info); newAPIError != nil {
+			return newAPIError
+		}
+	}
+
+	applyImageComplexityRouting(c, info, request)
+
+	if info.RelayMode == relayconstant.RelayModeImagesEdits {
+		imageFiles, err := relaycommon.CollectImageFormFiles(c.Request.MultipartForm)
+		if err != nil {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		if newAPIError = checkImageUploadSize(c, info, imageFiles); newAPIError != nil {
+			return newAPIError
+		}
+		// 流式编辑在首个事件发出前就要确定输入用量，上传文件在发往上游之前统计完毕
+		accounting := prepareImageEditInputAccounting(c, imageFiles)
+		if isStreamImageRequest(request) {
+			logger.LogDebug(c, fmt.Sprintf("stream image edit input: %d images, %d bytes, %d estimated tokens", accounting.count, accounting.bytes, accounting.tokens))
+		}
+	}
+
+	if newAPIError = checkImageEmptyPrompt(info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = parseImagePreviousGeneration(c, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = resolveImageProfile(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = applyImageQualityMapping(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = checkImageSizeAllowed(info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = checkImageOutputSizeLimit(info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = handleOversizedImagePrompt(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+	if newAPIError = checkImagePromptLength(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = checkImagePromptCategory(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = parseImageUpscaleFactor(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = parseImageRawOutput(c, info); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = parseImageReproBundle(c, info); newAPIError != nil {
+		return newAPIError
+	}
+
+	clientOutputFormat := len(request.OutputFormat) > 0
+	applyPreferredImageFormat(c, info, request)
+
+	if newAPIError = checkTokenImageOutputFormat(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+	if clientOutputFormat || c.GetBool("image_output_format_coerced") {
+		rememberRequestedImageOutputFormat(c, request)
+	}
+
+	if newAPIError = applyImageStyleReference(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	if newAPIError = parseImageStorageTTL(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+
+	storedInputIds, newAPIError := convertImageInputToUrl(c, info, request)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	if len(storedInputIds) > 0 {
+		defer cleanupStoredImages(c, storedInputIds)
+	}
+
+	if newAPIError = applyMultipartImageResponse(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+	applyRawImageResponse(c, request)
+
+	if IsImageEstimateRequest(c) {
+		respondImageEstimate(c, info, request)
+		return nil
+	}
+
+	diffImageGenerationParams(c, info, request)
+	snapshotImageReproRequest(c, request)
+
+	releaseGlobalSlot, newAPIError := acquireGlobalImageSlot(c)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	defer releaseGlobalSlot()
 
 	adaptor := GetAdaptor(info.ApiType)
 	if adaptor == nil {
@@ -50,162 +215,751 @@ func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 	}
 	adaptor.Init(info)
 
-	var requestBody io.Reader
+	requestBodies, newAPIError := buildImageRequestBodies(c, info, adaptor, request)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	requestBodies = auditImageUpstreamRequest(c, info, requestBodies)
 
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
-		body, err := common.GetRequestBody(c)
-		if err != nil {
-			return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
-		}
-		requestBody = bytes.NewBuffer(body)
-	} else {
-		convertedRequest, err := adaptor.ConvertImageRequest(c, info, *request)
-		if err != nil {
-			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
-		}
+	if newAPIError = checkTokenImageRate(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+	if newAPIError = checkUserImageBudget(c, info); newAPIError != nil {
+		return newAPIError
+	}
+	if newAPIError = holdImageQuota(c, info, request); newAPIError != nil {
+		return newAPIError
+	}
+	releaseImageJobWorker, newAPIError := acceptImageJob(c, info, request)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	defer releaseImageJobWorker()
 
-		switch convertedRequest.(type) {
-		case *bytes.Buffer:
-			requestBody = convertedRequest.(io.Reader)
-		default:
-			jsonData, err := common.Marshal(convertedRequest)
-			if err != nil {
-				return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
-			}
+	resultCacheKey := imageResultCacheKey(c, info, request)
+	cachedResult := lookupImageResultCache(c, resultCacheKey)
+	similarResultKey := imageSimilarResultKey(info, request)
+	if cachedResult == nil && !info.IsStream && !isStreamImageRequest(request) {
+		sendImageCachedPreview(c, similarResultKey)
+	}
 
-			// apply param override
-			if len(info.ParamOverride) > 0 {
-				jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride)
-				if err != nil {
-					return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
-				}
-			}
+	if smoothing := info.ChannelSetting.ImageRateSmoothing; smoothing != nil && cachedResult == nil {
+		maxWait := time.Duration(smoothing.MaxWaitSeconds) * time.Second
+		if maxWait <= 0 {
+			maxWait = 10 * time.Second
+		}
+		if err := service.WaitChannelRateSlot(c.Request.Context(), info.ChannelId, smoothing.RequestsPerSecond, maxWait); err != nil {
+			// 允许重试，由其他渠道处理
+			return types.NewErrorWithStatusCode(fmt.Errorf("upstream is busy, please retry later: %w", err), types.ErrorCodeUpstreamRateLimited, http.StatusServiceUnavailable)
+		}
+	}
 
-			if common.DebugEnabled {
-				logger.LogDebug(c, fmt.Sprintf("image request body: %s", string(jsonData)))
-			}
-			requestBody = bytes.NewBuffer(jsonData)
+	releaseConcurrency := func() {}
+	if cachedResult == nil {
+		if releaseConcurrency, newAPIError = acquireImageConcurrencySlot(c, info); newAPIError != nil {
+			return newAPIError
 		}
 	}
+	defer releaseConcurrency()
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 
 	requestStartTime := time.Now()
-	logger.LogInfo(c, "#ImageHelper#start request, tokenId:"+string(info.TokenId)+", userId:"+string(info.UserId)+", timeCost:"+(requestStartTime.Sub(deepCopyTime)/1000).String())
-	resp, err := adaptor.DoRequest(c, info, requestBody)
+	upstreamAttempted = cachedResult == nil
+	defer func() {
+		if cachedResult != nil {
+			return
+		}
+		// 客户端错误不计入渠道 SLA
+		service.RecordImageSlaSample(info.ChannelId, time.Since(requestStartTime), newAPIError == nil || newAPIError.StatusCode < http.StatusInternalServerError)
+		service.RecordImageModelOutcome(info.OriginModelName, newAPIError == nil)
+	}()
+	logImageHelperPhase(c, info, "start_request", requestStartTime.Sub(deepCopyTime))
+	upstreamSpan := trace.startUpstream(c, info, requestStartTime)
+	// 客户端可以通过 /v1/images/generations/:id/cancel 取消进行中的请求
+	generationId := c.GetString(common.RequestIdKey)
+	cancelCtx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	defer cancel()
+	service.RegisterImageGeneration(generationId, info.UserId, cancel)
+	defer service.UnregisterImageGeneration(generationId)
+	// 上游返回前客户端断开连接时按取消处理：中止上游请求且不计费。上游返回后 CompleteImageGeneration 已标记完成，
+	// 之后的断开（包括流式响应正常结束后连接关闭）不会被当作取消
+	stopDisconnectWatch := context.AfterFunc(c.Request.Context(), func() {
+		if service.AbortImageGeneration(generationId) {
+			logger.LogWarn(c, fmt.Sprintf("client disconnected before channel #%d returned, upstream image request aborted", info.ChannelId))
+		}
+	})
+	defer stopDisconnectWatch()
+	requestTimeout := getImageRequestTimeout(info)
+	if requestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		cancelCtx, cancelTimeout = context.WithTimeout(cancelCtx, requestTimeout)
+		defer cancelTimeout()
+		c.Set(ImageRequestTimeoutKey, requestTimeout)
+	}
+	c.Set("image_cancel_ctx", cancelCtx)
+	if profile := getImageHttpClientProfile(info); profile != "" {
+		if _, ok := model_setting.GetImageSettings().GetHttpClientProfile(profile); !ok && profile != model_setting.ImageHttpClientProfileDefault {
+			logger.LogWarn(c, fmt.Sprintf("image http client profile %q is not defined, using shared client", profile))
+		}
+		c.Set(ImageHttpClientProfileKey, profile)
+	}
+
+	stopKeepAlive := func() {}
+	if shouldImageKeepAlive(c, info, request) {
+		stopKeepAlive = startImageKeepAlive(c, info)
+	}
+	var resp any
+	if cachedResult != nil {
+		resp = cachedImageHttpResponse(cachedResult)
+	} else {
+		resp, err = doImageUpstreamRequest(c, info, adaptor, request, requestBodies)
+		resp, err = retryImageModelFallbacks(c, info, adaptor, request, resp, err)
+	}
+	stopKeepAlive()
 	requestEndTime := time.Now()
-	logger.LogInfo(c, "#ImageHelper#end request, tokenId:"+string(info.TokenId)+", userId:"+string(info.UserId)+", timeCost:"+(requestEndTime.Sub(requestStartTime)/1000).String())
+	logImageHelperPhase(c, info, "end_request", requestEndTime.Sub(requestStartTime))
+	// 失败的请求同样记录，上游错误与超时的耗时也计入
+	service.ObserveImagePhase(info.OriginModelName, info.ChannelId, "upstream", requestEndTime.Sub(requestStartTime))
+	endImageSpan(upstreamSpan, requestEndTime, err)
+	defer func() {
+		service.ObserveImagePhase(info.OriginModelName, info.ChannelId, "response", time.Since(requestEndTime))
+		trace.phase("image.response", time.Since(requestEndTime), requestEndTime)
+	}()
 
 	if err != nil {
+		if service.IsImageGenerationCancelled(generationId) {
+			return newImageCancelledError(c)
+		}
+		if requestTimeout > 0 && errors.Is(cancelCtx.Err(), context.DeadlineExceeded) {
+			return newImageTimeoutError(requestTimeout)
+		}
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
+	// 上游已返回结果后不再接受取消；取消与完成同时发生时以先到者为准
+	if !service.CompleteImageGeneration(generationId) {
+		if httpResp, ok := resp.(*http.Response); ok && httpResp != nil {
+			service.CloseResponseBodyGracefully(httpResp)
+		}
+		return newImageCancelledError(c)
+	}
 	var httpResp *http.Response
 	if resp != nil {
 		httpResp = resp.(*http.Response)
-		info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if cachedResult == nil {
+			forwardImageUpstreamHeaders(c, httpResp)
+		}
+		info.IsStream = info.IsStream || detectImageStreamResponse(request, httpResp)
+		if httpResp.StatusCode == http.StatusOK {
+			if newAPIError = channel.NormalizeImageResponse(c, httpResp); newAPIError != nil {
+				return newAPIError
+			}
+			if httpResp, err = followImageContinuation(c, info, adaptor, httpResp); err != nil {
+				return types.NewOpenAIError(err, types.ErrorCodeBadResponse, http.StatusInternalServerError)
+			}
+		}
 		if httpResp.StatusCode != http.StatusOK {
 			newAPIError = service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			newAPIError = mapImageUpstreamError(c, adaptor, newAPIError)
 			// reset status code 重置状态码
 			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 			return newAPIError
 		}
+		// 仅对成功的上游响应退费，失败的慢请求由重试的渠道重新计时
+		applyImageSlowRefund(c, info, requestEndTime.Sub(requestStartTime))
+		auditImageUpstreamResponse(c, httpResp, info.IsStream)
+		setImageEffectiveHeaders(c, info, request)
+		if cachedResult == nil && !info.IsStream {
+			storeImageResultCache(c, resultCacheKey, similarResultKey, httpResp)
+		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	var recorder *imageResponseRecorder
+	if shouldRecordImageResponse(c, info) {
+		recorder = newImageResponseRecorder(c.Writer)
+		c.Writer = recorder
+	}
+	var usage any
+	if httpResp != nil && shouldStreamImageResponseToStorage(info) {
+		usage, newAPIError = doImageResponseToStorage(c, info, httpResp)
+	} else {
+		usage, newAPIError = adaptor.DoResponse(c, httpResp, info)
+	}
+	releaseConcurrency()
+	if recorder != nil {
+		c.Writer = recorder.ResponseWriter
+	}
+	if newAPIError != nil && requestTimeout > 0 && errors.Is(cancelCtx.Err(), context.DeadlineExceeded) {
+		// 读取响应体期间超时
+		newAPIError = newImageTimeoutError(requestTimeout)
+	}
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 		return newAPIError
 	}
 
-	if usage.(*dto.Usage).TotalTokens == 0 {
-		usage.(*dto.Usage).TotalTokens = int(request.N)
+	var recordedBody []byte
+	if recorder != nil {
+		if info.ChannelSetting.ImagePreserveContentCredentials {
+			snapshotImageUpstreamBody(recorder)
+		}
+		if newAPIError = enforceImageOutputFormat(c, info, recorder); newAPIError != nil {
+			return newAPIError
+		}
+		if newAPIError = moderateImageResponse(c, info, recorder); newAPIError != nil {
+			return newAPIError
+		}
+		if recorder.status == http.StatusOK {
+			attachImageReproBundle(c, info)
+		}
+		if recordedBody, newAPIError = processRecordedImageResponse(c, info, recorder); newAPIError != nil {
+			return newAPIError
+		}
+		if info.TokenSetting.ImageServerTiming {
+			setImageServerTimingHeader(recorder, requestStartTime.Sub(deepCopyTime), requestEndTime.Sub(requestStartTime), time.Since(requestEndTime))
+		}
+		if !info.TokenSetting.ImageExposeCost && !shouldSetImageEffectiveHeaders() {
+			flushImageResponse(c, recorder, recordedBody)
+			recorder = nil
+		}
 	}
-	if usage.(*dto.Usage).PromptTokens == 0 {
-		usage.(*dto.Usage).PromptTokens = int(request.N)
+
+	// 流式响应只有在收到 completed 事件后才能确定用量
+	if !info.IsStream || c.GetBool("image_stream_completed") {
+		// 上游未返回用量时，编辑请求按参考图尺寸估算输入 token
+		if usage.(*dto.Usage).PromptTokens == 0 && info.RelayMode == relayconstant.RelayModeImagesEdits {
+			if imageTokens := getImageEditInputTokens(c); imageTokens > 0 {
+				usage.(*dto.Usage).PromptTokens = imageTokens
+				usage.(*dto.Usage).PromptTokensDetails.ImageTokens = imageTokens
+				usage.(*dto.Usage).TotalTokens = max(usage.(*dto.Usage).TotalTokens, imageTokens+usage.(*dto.Usage).CompletionTokens)
+			}
+		}
+		imageCount := int(request.N)
+		if count, ok := c.Get("image_partial_count"); ok {
+			imageCount = count.(int)
+		} else if count, ok := c.Get("image_returned_count"); ok && count.(int) < imageCount {
+			imageCount = count.(int)
+		}
+		if imageCount == 0 && request.N > 0 {
+			// 上游返回 200 但没有图片，不计费
+			*usage.(*dto.Usage) = dto.Usage{}
+		}
+		// 按张数填充用量只适用于不返回 usage 的上游（DALL·E、即梦等按次计费的接口）。gpt-image-1、Gemini 等
+		// 按 token 返回真实用量的上游在部分失败时可能返回 0，这类渠道应开启 image_disable_usage_n_fallback，
+		// 避免把张数当作 token 计费
+		if info.ChannelSetting.ImageDisableUsageNFallback {
+			if usage.(*dto.Usage).TotalTokens == 0 {
+				logger.LogWarn(c, fmt.Sprintf("upstream returned zero usage for model %s on channel #%d, not charged", info.OriginModelName, info.ChannelId))
+			}
+		} else {
+			if usage.(*dto.Usage).TotalTokens == 0 {
+				usage.(*dto.Usage).TotalTokens = imageCount
+			}
+			if usage.(*dto.Usage).PromptTokens == 0 {
+				usage.(*dto.Usage).PromptTokens = imageCount
+			}
+		}
+	}
+
+	if imageUsage, ok := usage.(*dto.Usage); ok && imageUsage != nil {
+		trace.setAttributes(map[string]any{
+			"image.upstream_model":      info.UpstreamModelName,
+			"image.usage.prompt_tokens": imageUsage.PromptTokens,
+			"image.usage.total_tokens":  imageUsage.TotalTokens,
+			"image.returned_count":      c.GetInt("image_returned_count"),
+		})
 	}
 
 	quality := "standard"
-	if request.Quality == "hd" {
-		quality = "hd"
+	if request.Quality == "hd" || c.GetBool("image_quality_mapped") {
+		// 渠道映射后的品质按上游取值记录
+		quality = request.Quality
 	}
 
 	dealRespTime := time.Now()
-	logger.LogInfo(c, "#ImageHelper#deal resp, tokenId:"+string(info.TokenId)+", userId:"+string(info.UserId)+", timeCost:"+(dealRespTime.Sub(requestEndTime)/1000).String())
+	logImageHelperPhase(c, info, "deal_resp", dealRespTime.Sub(requestEndTime))
+
+	inputImageCount, inputBytes := getInputImageCountAndBytes(c)
+	var background, inputFidelity string
+	_ = common.Unmarshal(request.Background, &background)
+	_ = common.Unmarshal(request.InputFidelity, &inputFidelity)
+	c.Set(imageUsageLogKey, imageUsageLog{
+		Model:           info.UpstreamModelName,
+		Size:            request.Size,
+		Quality:         quality,
+		N:               request.N,
+		InputImageCount: inputImageCount,
+		InputBytes:      inputBytes,
+		Background:      background,
+		InputFidelity:   inputFidelity,
+	})
 
 	var logContent string
 	if len(request.Size) > 0 {
 		logContent = fmt.Sprintf("大小 %s, 品质 %s, 张数 %d", request.Size, quality, request.N)
 
 		// 添加图片张数和大小信息
-		imageCount, imageSizeInfo := getImageCountAndSizeInfo(c)
-		if imageCount > 0 {
-			logContent += fmt.Sprintf(", 输入图片 %d 张", imageCount)
-			if imageSizeInfo != "" {
+		if inputImageCount > 0 {
+			logContent += fmt.Sprintf(", 输入图片 %d 张", inputImageCount)
+			if imageSizeInfo := formatImageByteSize(inputBytes); imageSizeInfo != "" {
 				logContent += fmt.Sprintf(" (%s)", imageSizeInfo)
 			}
 		}
 	}
 
+	if background != "" || inputFidelity != "" {
+		var params []string
+		if background != "" {
+			params = append(params, "背景 "+background)
+		}
+		if inputFidelity != "" {
+			params = append(params, "输入保真度 "+inputFidelity)
+		}
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += strings.Join(params, ", ")
+	}
+
+	if c.GetBool("image_style_reference") {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += "风格参考图 1 张"
+	}
+
+	if upscaleCount := c.GetInt("image_upscale_count"); upscaleCount > 0 {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += fmt.Sprintf("放大 %dx %d 张, 输出尺寸 %s", c.GetInt("image_upscale_factor"), upscaleCount, c.GetString("image_upscale_size"))
+	}
+
+	if tier := applyImageSizePriceTier(c, info, request); tier > 0 {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += fmt.Sprintf("价格档位倍率 %.2f", tier)
+	}
+
+	if count, ok := applyImagePartialBatch(c, info, request); ok {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += fmt.Sprintf("部分子请求失败，实际返回 %d/%d 张", count, request.N)
+	}
+
+	if count, ok := applyImageReturnedCount(c, info, request); ok {
+		if logContent != "" {
+			logContent += ", "
+		}
+		if count == 0 {
+			logContent += "上游未返回图片，全额退款"
+		} else {
+			logContent += fmt.Sprintf("实际返回 %d/%d 张，按实际张数计费", count, request.N)
+		}
+	}
+
+	if usedImageModelFallback(c) {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += fmt.Sprintf("模型回退至 %s", info.UpstreamModelName)
+	}
+
+	if model_setting.GetImageSettings().PolicyVersionEnabled {
+		if logContent != "" {
+			logContent += ", "
+		}
+		logContent += fmt.Sprintf("策略版本 %s, 上游模型 %s", getImagePolicyVersion(c, info), info.UpstreamModelName)
+	}
+
 	postConsumeQuota(c, info, usage.(*dto.Usage), logContent)
+	recordImageJobFinalQuota(c)
+	recordUserImageBudgetSpend(c, info)
+	saveImageGenerationParams(c, info)
+	recordImageHistory(c, info, request, quality, recordedBody)
+	if recorder != nil {
+		// 费用在计费完成后才能确定，因此延后写回响应
+		if info.TokenSetting.ImageExposeCost {
+			setImageCostHeaders(c, recorder)
+		}
+		setImageEffectiveChargeHeader(c, recorder)
+		flushImageResponse(c, recorder, recordedBody)
+	}
+	return nil
+}
+
+// buildImageRequestBodies 将图片请求转换为上游请求体，开启透传时直接使用原始请求体。
+// 适配器返回 channel.ImageRequestBatch 时每个元素对应一个请求体
+func buildImageRequestBodies(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.ImageRequest) ([]io.Reader, *types.NewAPIError) {
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		return []io.Reader{bytes.NewBuffer(body)}, nil
+	}
+	// 渠道固定文本只追加到发往上游的副本，响应与消费日志使用客户端原始提示词
+	upstreamRequest := *request
+	upstreamRequest.Prompt = relaycommon.InjectImagePrompt(c, info, request.Prompt)
+	rememberImagePrompt(c, upstreamRequest.Prompt)
+	convertedRequest, err := adaptor.ConvertImageRequest(c, info, upstreamRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	batch, ok := convertedRequest.(channel.ImageRequestBatch)
+	if !ok {
+		batch = channel.ImageRequestBatch{convertedRequest}
+	}
+	// 流式响应无法合并
+	if len(batch) > 1 && info.IsStream {
+		return nil, types.NewErrorWithStatusCode(errors.New("stream is not supported when the image request is split into multiple upstream requests"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	bodies := make([]io.Reader, 0, len(batch))
+	for _, converted := range batch {
+		body, newAPIError := marshalImageRequestBody(c, info, converted)
+		if newAPIError != nil {
+			return nil, newAPIError
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+func marshalImageRequestBody(c *gin.Context, info *relaycommon.RelayInfo, convertedRequest any) (io.Reader, *types.NewAPIError) {
+	if buffer, ok := convertedRequest.(*bytes.Buffer); ok {
+		// multipart 表单（编辑请求）按表单字段执行参数覆盖
+		contentType := c.Request.Header.Get("Content-Type")
+		if len(info.ParamOverride) == 0 || !strings.HasPrefix(contentType, "multipart/") {
+			return buffer, nil
+		}
+		body, err := relaycommon.ApplyMultipartParamOverride(buffer.Bytes(), contentType, info.ParamOverride)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+		return bytes.NewBuffer(body), nil
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	// apply param override
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+
+	if common.DebugEnabled {
+		logger.LogDebug(c, fmt.Sprintf("image request body: %s", string(redactImagePromptBody(c, jsonData))))
+	}
+	return bytes.NewBuffer(jsonData), nil
+}
+
+// checkImageEmptyPrompt 文生图请求的提示词不能为空（仅含空白也视为空），配置允许空提示词的模型除外
+func checkImageEmptyPrompt(info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	if info.RelayMode != relayconstant.RelayModeImagesGenerations || strings.TrimSpace(request.Prompt) != "" ||
+		model_setting.GetImageSettings().IsEmptyPromptAllowed(info.OriginModelName) {
+		return nil
+	}
+	return types.NewErrorWithStatusCode(errors.New("prompt is required"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// validateMappedImageModel 模型映射配置错误时（映射为空或包含空白、控制字符）提前返回配置错误，
+// 详细原因仅记录在日志中，客户端只收到通用提示
+func validateMappedImageModel(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	upstreamModel := info.UpstreamModelName
+	valid := upstreamModel != "" && strings.IndexFunc(upstreamModel, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) == -1
+	if valid {
+		return nil
+	}
+	logger.LogError(c, fmt.Sprintf("invalid upstream model %q mapped from %q on channel #%d, please check the model mapping", upstreamModel, info.OriginModelName, info.ChannelId))
+	return types.NewErrorWithStatusCode(fmt.Errorf("channel #%d maps model %q to invalid model %q", info.ChannelId, info.OriginModelName, upstreamModel), types.ErrorCodeChannelModelMappedError, http.StatusInternalServerError,
+		types.ErrOptionWithHideErrMsg("channel configuration error, please contact the administrator"))
+}
+
+// checkImageUploadSize 按渠道配置限制编辑请求上传文件的单个与总字节数，超过时在发往上游前拒绝。
+// 允许重试，其他渠道的限制可能更宽松
+func checkImageUploadSize(c *gin.Context, info *relaycommon.RelayInfo, imageFiles []*multipart.FileHeader) *types.NewAPIError {
+	maxFile, maxTotal := info.ChannelSetting.ImageMaxUploadFileBytes, info.ChannelSetting.ImageMaxUploadTotalBytes
+	if maxFile <= 0 && maxTotal <= 0 {
+		return nil
+	}
+	files := imageFiles
+	if mf := c.Request.MultipartForm; mf != nil {
+		files = append(files, mf.File["mask"]...)
+	}
+	var total int64
+	for _, file := range files {
+		if maxFile > 0 && file.Size > maxFile {
+			return types.NewErrorWithStatusCode(fmt.Errorf("file %s is %d bytes, exceeding the maximum of %d bytes per file", file.Filename, file.Size, maxFile), types.ErrorCodeInvalidRequest, http.StatusRequestEntityTooLarge)
+		}
+		total += file.Size
+	}
+	if maxTotal > 0 && total > maxTotal {
+		return types.NewErrorWithStatusCode(fmt.Errorf("uploaded files total %d bytes, exceeding the maximum of %d bytes", total, maxTotal), types.ErrorCodeInvalidRequest, http.StatusRequestEntityTooLarge)
+	}
 	return nil
 }
 
-// getImageCountAndSizeInfo 获取图片张数和大小信息
-func getImageCountAndSizeInfo(c *gin.Context) (int, string) {
+// resetImageSlowRefund 清除上一次尝试记录的退费，重试复用同一个 gin.Context
+func resetImageSlowRefund(c *gin.Context) {
+	c.Set("slow_refund_percent", 0.0)
+	c.Set("slow_refund_elapsed", 0.0)
+}
+
+// applyImageSlowRefund 上游耗时超过渠道配置的阈值时，记录退还比例，由计费时按比例减免
+func applyImageSlowRefund(c *gin.Context, info *relaycommon.RelayInfo, elapsed time.Duration) {
+	refund := info.ChannelSetting.ImageSlowRefund
+	if refund == nil || refund.ThresholdSeconds <= 0 || refund.RefundPercent <= 0 {
+		return
+	}
+	if elapsed <= time.Duration(refund.ThresholdSeconds)*time.Second {
+		return
+	}
+	percent := min(refund.RefundPercent, 100)
+	c.Set("slow_refund_percent", percent)
+	c.Set("slow_refund_elapsed", elapsed.Seconds())
+	logger.LogInfo(c, fmt.Sprintf("image request took %.1fs, exceeding %ds on channel #%d, refund %.0f%%", elapsed.Seconds(), refund.ThresholdSeconds, info.ChannelId, percent))
+}
+
+// applySlowRefundQuota 按记录的退费比例减免费用，返回减免后的费用与日志说明；未记录退费时原样返回
+func applySlowRefundQuota(c *gin.Context, quota decimal.Decimal) (decimal.Decimal, string) {
+	refundPercent := c.GetFloat64("slow_refund_percent")
+	if refundPercent <= 0 {
+		return quota, ""
+	}
+	refundQuota := quota.Mul(decimal.NewFromFloat(refundPercent)).Div(decimal.NewFromInt(100))
+	return quota.Sub(refundQuota), fmt.Sprintf("响应耗时 %.1f 秒超过阈值，退还 %.0f%%（%s）", c.GetFloat64("slow_refund_elapsed"), refundPercent, refundQuota.StringFixed(0))
+}
+
+// handleOversizedImagePrompt 提示词超过配置长度时调用摘要模型压缩，失败时按配置截断或拒绝
+func handleOversizedImagePrompt(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	settings := model_setting.GetImageSettings()
+	if !settings.PromptSummarizeEnabled || settings.PromptSummarizeMaxLength <= 0 {
+		return nil
+	}
+	promptLength := utf8.RuneCountInString(request.Prompt)
+	if promptLength <= settings.PromptSummarizeMaxLength {
+		return nil
+	}
+	summary, err := service.SummarizeImagePrompt(c.Request.Context(), settings, request.Prompt)
+	if err == nil {
+		logger.LogInfo(c, fmt.Sprintf("image prompt summarized from %d to %d chars, model: %s", promptLength, utf8.RuneCountInString(summary), settings.PromptSummarizeModel))
+		setImagePrompt(c, request, summary)
+		return nil
+	}
+	logger.LogWarn(c, fmt.Sprintf("image prompt summarize failed, length: %d, fallback: %s, error: %s", promptLength, settings.PromptSummarizeFallback, redactImagePromptText(c, err.Error())))
+	if settings.PromptSummarizeFallback == model_setting.ImagePromptOverflowTruncate {
+		setImagePrompt(c, request, service.TruncatePromptRunes(request.Prompt, settings.PromptSummarizeMaxLength))
+		return nil
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("prompt is too long (%d characters, max %d)", promptLength, settings.PromptSummarizeMaxLength), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// checkImagePromptLength 按模型的提示词长度上限（字符数）在发往上游前校验，计入渠道追加的固定文本，
+// 避免上游返回难以理解的错误
+func checkImagePromptLength(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	settings := model_setting.GetImageSettings()
+	model := info.UpstreamModelName
+	maxLength := settings.GetModelPromptMaxLength(model)
+	if maxLength <= 0 && model != info.OriginModelName {
+		model = info.OriginModelName
+		maxLength = settings.GetModelPromptMaxLength(model)
+	}
+	if maxLength <= 0 {
+		return nil
+	}
+	promptLength := utf8.RuneCountInString(relaycommon.InjectImagePrompt(c, info, request.Prompt))
+	if promptLength <= maxLength {
+		return nil
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("prompt is too long (%d characters), model %s supports at most %d characters", promptLength, model, maxLength), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// setImagePrompt 同步更新请求体与 multipart 表单中的提示词，编辑接口会直接使用表单字段转发
+func setImagePrompt(c *gin.Context, request *dto.ImageRequest, prompt string) {
+	request.Prompt = prompt
+	rememberImagePrompt(c, prompt)
+	if mf := c.Request.MultipartForm; mf != nil {
+		if _, ok := mf.Value["prompt"]; ok {
+			mf.Value["prompt"] = []string{prompt}
+		}
+	}
+}
+
+// checkImagePromptCategory 渠道开启提示词分类过滤时，拒绝不在允许分类中的请求
+func checkImagePromptCategory(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	classifier := info.ChannelSetting.ImagePromptClassifier
+	if classifier == nil || !classifier.Enabled || strings.TrimSpace(request.Prompt) == "" {
+		return nil
+	}
+	category, err := service.ClassifyImagePrompt(c.Request.Context(), classifier, request.Prompt)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("image prompt classify failed, channel #%d: %s", info.ChannelId, redactImagePromptText(c, err.Error())))
+		if classifier.FailOpen {
+			return nil
+		}
+		return types.NewErrorWithStatusCode(errors.New("image prompt classification is unavailable"), types.ErrorCodePromptClassifyFailed, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+	}
+	if category == "" {
+		logger.LogInfo(c, fmt.Sprintf("image prompt category: unrecognized classifier output, channel #%d", info.ChannelId))
+		return types.NewErrorWithStatusCode(fmt.Errorf("prompt category could not be determined, allowed categories: %s", strings.Join(classifier.AllowedCategories, ", ")), types.ErrorCodePromptBlocked, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	logger.LogInfo(c, fmt.Sprintf("image prompt category: %s, channel #%d", category, info.ChannelId))
+	if !service.IsImagePromptCategoryAllowed(classifier, category) {
+		return types.NewErrorWithStatusCode(fmt.Errorf("prompt category %q is not allowed, allowed categories: %s", category, strings.Join(classifier.AllowedCategories, ", ")), types.ErrorCodePromptBlocked, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	return nil
+}
+
+// estimateImageEditInputTokens 按上传参考图的尺寸估算输入 token，只读取文件头部；无法识别的文件跳过
+func estimateImageEditInputTokens(c *gin.Context) int {
 	mf := c.Request.MultipartForm
 	if mf == nil {
-		if _, err := c.MultipartForm(); err != nil {
-			return 0, ""
+		return 0
+	}
+	imageFiles, err := relaycommon.CollectImageFormFiles(mf)
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for i, fileHeader := range imageFiles {
+		file, err := fileHeader.Open()
+		if err != nil {
+			continue
 		}
-		mf = c.Request.MultipartForm
+		width, height, err := service.DecodeImageHeader(file)
+		_ = file.Close()
+		if err != nil {
+			logger.LogDebug(c, fmt.Sprintf("skip input image %d for token estimation: %s", i, err.Error()))
+			continue
+		}
+		total += service.EstimateImageInputTokens(width, height)
 	}
+	return total
+}
 
-	var imageFiles []*multipart.FileHeader
-	var exists bool
-
-	// First check for standard "image" field
-	if imageFiles, exists = mf.File["image"]; !exists || len(imageFiles) == 0 {
-		// If not found, check for "image[]" field
-		if imageFiles, exists = mf.File["image[]"]; !exists || len(imageFiles) == 0 {
-			// If still not found, iterate through all fields to find any that start with "image["
-			foundArrayImages := false
-			for fieldName, files := range mf.File {
-				if strings.HasPrefix(fieldName, "image[") && len(files) > 0 {
-					foundArrayImages = true
-					imageFiles = append(imageFiles, files...)
-				}
-			}
+const imageEditInputAccountingKey = "image_edit_input_accounting"
 
-			// If no image fields found at all
-			if !foundArrayImages && (len(imageFiles) == 0) {
-				return 0, ""
-			}
+// imageEditInputAccounting 编辑请求上传参考图的统计结果。流式响应开始后上游可能在读取完请求体前就返回事件，
+// 因此在发往上游前统计并缓存，计费与日志都使用该结果
+type imageEditInputAccounting struct {
+	count  int
+	bytes  int64
+	tokens int // 按参考图尺寸估算的输入 token
+}
+
+// prepareImageEditInputAccounting 统计已解析完成的上传文件并缓存到上下文
+func prepareImageEditInputAccounting(c *gin.Context, imageFiles []*multipart.FileHeader) imageEditInputAccounting {
+	accounting := imageEditInputAccounting{count: len(imageFiles), tokens: estimateImageEditInputTokens(c)}
+	for _, file := range imageFiles {
+		accounting.bytes += imageFormFileSize(file)
+	}
+	c.Set(imageEditInputAccountingKey, accounting)
+	return accounting
+}
+
+// getImageEditInputTokens 返回编辑请求的估算输入 token，未预先统计时直接读取上传文件
+func getImageEditInputTokens(c *gin.Context) int {
+	if accounting, ok := c.Get(imageEditInputAccountingKey); ok {
+		return accounting.(imageEditInputAccounting).tokens
+	}
+	return estimateImageEditInputTokens(c)
+}
+
+const imageUsageLogKey = "image_usage_log"
+
+// imageUsageLog 记录在消费日志 other.image_usage 中的结构化用量，取值与日志内容中的文字描述一致，便于统计
+type imageUsageLog struct {
+	Model           string `json:"model"` // 发往上游的模型
+	Size            string `json:"size"`
+	Quality         string `json:"quality"`
+	N               uint   `json:"n"`
+	InputImageCount int    `json:"input_image_count"`
+	InputBytes      int64  `json:"input_bytes"`
+	Background      string `json:"background,omitempty"`
+	InputFidelity   string `json:"input_fidelity,omitempty"`
+}
+
+// imageFormFileSizeLimit 表单未记录文件大小时最多读取的字节数，超出部分不计入
+const imageFormFileSizeLimit = 64 << 20
+
+// imageFormFileSize 返回上传文件的大小。部分后端把文件写入临时目录后 Size 为 0，此时打开文件读取计数，
+// 最多读取 imageFormFileSizeLimit 字节，避免为了记录日志完整读取超大文件
+func imageFormFileSize(file *multipart.FileHeader) int64 {
+	if file.Size > 0 {
+		return file.Size
+	}
+	f, err := file.Open()
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n, _ := io.Copy(io.Discard, io.LimitReader(f, imageFormFileSizeLimit))
+	return n
+}
+
+// getInputImageCountAndBytes 获取上传图片的张数和总字节数，优先使用发往上游前的统计结果
+func getInputImageCountAndBytes(c *gin.Context) (int, int64) {
+	if accounting, ok := c.Get(imageEditInputAccountingKey); ok {
+		return accounting.(imageEditInputAccounting).count, accounting.(imageEditInputAccounting).bytes
+	}
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		if _, err := c.MultipartForm(); err != nil {
+			return 0, 0
 		}
+		mf = c.Request.MultipartForm
 	}
 
-	if len(imageFiles) == 0 {
-		return 0, ""
+	imageFiles, err := relaycommon.CollectImageFormFiles(mf)
+	if err != nil {
+		return 0, 0
 	}
 
-	// 计算图片大小信息
 	var totalSize int64
-	var sizeInfo string
-
 	for _, file := range imageFiles {
-		totalSize += file.Size
+		totalSize += imageFormFileSize(file)
+	}
+	return len(imageFiles), totalSize
+}
+
+// formatImageByteSize 格式化图片大小信息
+func formatImageByteSize(totalSize int64) string {
+	if totalSize <= 0 {
+		return ""
+	}
+	if totalSize < 1024 {
+		return fmt.Sprintf("%d B", totalSize)
+	} else if totalSize < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(totalSize)/1024)
 	}
+	return fmt.Sprintf("%.1f MB", float64(totalSize)/(1024*1024))
+}
 
-	// 格式化大小信息
-	if totalSize > 0 {
-		if totalSize < 1024 {
-			sizeInfo = fmt.Sprintf("%d B", totalSize)
-		} else if totalSize < 1024*1024 {
-			sizeInfo = fmt.Sprintf("%.1f KB", float64(totalSize)/1024)
-		} else {
-			sizeInfo = fmt.Sprintf("%.1f MB", float64(totalSize)/(1024*1024))
-		}
+// newImageCancelledError 请求被取消时返回的错误，预扣的额度随错误退还。客户端已断开时不记录错误日志
+func newImageCancelledError(c *gin.Context) *types.NewAPIError {
+	if c.Request.Context().Err() != nil {
+		return types.NewErrorWithStatusCode(errors.New("client disconnected, image generation aborted"), types.ErrorCodeImageCancelled, http.StatusBadRequest, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 	}
+	return types.NewErrorWithStatusCode(errors.New("image generation cancelled by client"), types.ErrorCodeImageCancelled, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
 
-	return len(imageFiles), sizeInfo
+// isImageChannelFailure 判断错误是否应计入渠道熔断：上游 5xx、鉴权失败与限流，客户端参数错误不计入
+func isImageChannelFailure(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return err.StatusCode >= http.StatusInternalServerError
 }
@@ -0,0 +1,220 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckImagePromptCategory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = common.DecodeJson(r.Body, &request)
+		output := map[string]string{
+			"sneaker":  "product",
+			"portrait": "people",
+			"mixed":    "people, product",
+		}[request.Messages[len(request.Messages)-1].Content]
+		data, _ := common.Marshal(map[string]any{"choices": []map[string]any{{"message": map[string]string{"content": output}}}})
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.ChannelSetting.ImagePromptClassifier = &dto.ImagePromptClassifierSetting{
+		Enabled:           true,
+		BaseUrl:           server.URL,
+		Categories:        []string{"product", "people", "other"},
+		AllowedCategories: []string{"product"},
+	}
+
+	cases := map[string]bool{"sneaker": true, "portrait": false, "mixed": false}
+	for prompt, allowed := range cases {
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		err := checkImagePromptCategory(c, info, &dto.ImageRequest{Prompt: prompt})
+		if allowed && err != nil {
+			t.Errorf("prompt %q was rejected: %v", prompt, err)
+		}
+		if !allowed && (err == nil || err.StatusCode != http.StatusBadRequest) {
+			t.Errorf("prompt %q should be rejected with 400, got %v", prompt, err)
+		}
+	}
+}
+
+// newPromptCompletionServer 模拟摘要模型，返回固定内容；status 非 200 时返回错误
+func newPromptCompletionServer(t *testing.T, status int, content string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		data, _ := common.Marshal(map[string]any{"choices": []map[string]any{{"message": map[string]string{"content": content}}}})
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleOversizedImagePromptSummarizes(t *testing.T) {
+	server := newPromptCompletionServer(t, http.StatusOK, "  a red fox in snow  ")
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.PromptSummarizeEnabled = true
+		settings.PromptSummarizeBaseUrl = server.URL
+		settings.PromptSummarizeMaxLength = 30
+		settings.PromptSummarizeFallback = model_setting.ImagePromptOverflowReject
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	request := &dto.ImageRequest{Prompt: strings.Repeat("a very detailed red fox standing in deep snow, ", 3)}
+
+	if err := handleOversizedImagePrompt(c, &relaycommon.RelayInfo{}, request); err != nil {
+		t.Fatalf("summarize over-limit prompt: %v", err)
+	}
+	if request.Prompt != "a red fox in snow" {
+		t.Fatalf("prompt = %q, want the trimmed summary", request.Prompt)
+	}
+
+	// 未超限的提示词不调用摘要模型
+	request.Prompt = "short prompt"
+	if err := handleOversizedImagePrompt(c, &relaycommon.RelayInfo{}, request); err != nil || request.Prompt != "short prompt" {
+		t.Fatalf("prompt within the limit changed to %q (%v)", request.Prompt, err)
+	}
+}
+
+func TestHandleOversizedImagePromptFallback(t *testing.T) {
+	server := newPromptCompletionServer(t, http.StatusInternalServerError, "")
+	longPrompt := strings.Repeat("x", 40)
+	for _, fallback := range []string{model_setting.ImagePromptOverflowTruncate, model_setting.ImagePromptOverflowReject} {
+		withImageSettings(t, func(settings *model_setting.ImageSettings) {
+			settings.PromptSummarizeEnabled = true
+			settings.PromptSummarizeBaseUrl = server.URL
+			settings.PromptSummarizeMaxLength = 30
+			settings.PromptSummarizeFallback = fallback
+		})
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		request := &dto.ImageRequest{Prompt: longPrompt}
+		err := handleOversizedImagePrompt(c, &relaycommon.RelayInfo{}, request)
+		switch fallback {
+		case model_setting.ImagePromptOverflowTruncate:
+			if err != nil || request.Prompt != longPrompt[:30] {
+				t.Fatalf("truncate fallback: prompt %q, error %v", request.Prompt, err)
+			}
+		default:
+			if err == nil || err.StatusCode != http.StatusBadRequest {
+				t.Fatalf("reject fallback should return 400, got %v", err)
+			}
+		}
+	}
+}
+
+func TestImageSlowRefundReducesQuota(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	resetImageSlowRefund(c)
+	applyImageSlowRefund(c, newImageTestInfo(infoChannelSetting(dto.ChannelSettings{ImageSlowRefund: &dto.ImageSlowRefundSetting{ThresholdSeconds: 10, RefundPercent: 30}})), 15*time.Second)
+
+	quota, content := applySlowRefundQuota(c, decimal.NewFromInt(1000))
+	if !quota.Equal(decimal.NewFromInt(700)) {
+		t.Fatalf("quota = %s, want 700 after a 30%% refund", quota)
+	}
+	if !strings.Contains(content, "30%") {
+		t.Fatalf("refund is not described in the log: %q", content)
+	}
+}
+
+func TestImageSlowRefundIsClearedBetweenAttempts(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := newImageTestInfo(infoChannelSetting(dto.ChannelSettings{ImageSlowRefund: &dto.ImageSlowRefundSetting{ThresholdSeconds: 10, RefundPercent: 30}}))
+
+	// 第一次尝试很慢，响应处理失败后重试；第二次尝试很快且成功
+	resetImageSlowRefund(c)
+	applyImageSlowRefund(c, info, 15*time.Second)
+	resetImageSlowRefund(c)
+	applyImageSlowRefund(c, info, 2*time.Second)
+
+	quota, content := applySlowRefundQuota(c, decimal.NewFromInt(1000))
+	if !quota.Equal(decimal.NewFromInt(1000)) || content != "" {
+		t.Fatalf("fast retry was refunded: quota %s, %q", quota, content)
+	}
+}
+
+// mapImageModel 按渠道模型映射解析上游模型并校验映射结果
+func mapImageModel(t *testing.T, modelMapping string) (*relaycommon.RelayInfo, *types.NewAPIError) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Set("model_mapping", modelMapping)
+	info := &relaycommon.RelayInfo{OriginModelName: "gpt-image-1", ChannelMeta: &relaycommon.ChannelMeta{ChannelId: 3, UpstreamModelName: "gpt-image-1"}}
+	request := &dto.ImageRequest{Model: "gpt-image-1"}
+	if err := helper.ModelMappedHelper(c, info, request); err != nil {
+		t.Fatalf("map model: %v", err)
+	}
+	return info, validateMappedImageModel(c, info)
+}
+
+func TestImageModelMappedToEmptyModel(t *testing.T) {
+	// 映射为空字符串视为未映射，沿用请求的模型
+	info, err := mapImageModel(t, `{"gpt-image-1":""}`)
+	if err != nil || info.UpstreamModelName != "gpt-image-1" || info.IsModelMapped {
+		t.Fatalf("empty mapping: upstream %q, mapped %v, err %v", info.UpstreamModelName, info.IsModelMapped, err)
+	}
+
+	for _, mapping := range []string{`{"gpt-image-1":" "}`, `{"gpt-image-1":"gpt-image-1\n"}`, `{"gpt-image-1":"dall e 3"}`} {
+		_, err = mapImageModel(t, mapping)
+		if err == nil {
+			t.Fatalf("invalid mapping %s was accepted", mapping)
+		}
+		if err.StatusCode != http.StatusInternalServerError || err.GetErrorCode() != types.ErrorCodeChannelModelMappedError {
+			t.Fatalf("mapping %s: status %d, code %s", mapping, err.StatusCode, err.GetErrorCode())
+		}
+		// 客户端只收到通用提示，不暴露渠道配置
+		if strings.Contains(err.Error(), "channel #3") {
+			t.Fatalf("mapping details leaked to the client: %v", err)
+		}
+	}
+
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err = validateMappedImageModel(c, &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}); err == nil {
+		t.Fatal("empty upstream model was accepted")
+	}
+}
+
+func TestCheckImageEmptyPrompt(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.AllowEmptyPromptModels = []string{"sd-unconditional"}
+	})
+	generation := &relaycommon.RelayInfo{RelayMode: relayconstant.RelayModeImagesGenerations, OriginModelName: "gpt-image-1"}
+	for _, prompt := range []string{"", "   ", "\n\t"} {
+		err := checkImageEmptyPrompt(generation, &dto.ImageRequest{Prompt: prompt})
+		if err == nil || err.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "prompt is required") {
+			t.Fatalf("empty prompt %q: %v, want 400 prompt is required", prompt, err)
+		}
+	}
+	if err := checkImageEmptyPrompt(generation, &dto.ImageRequest{Prompt: "a cat"}); err != nil {
+		t.Fatalf("non-empty prompt was rejected: %v", err)
+	}
+
+	// 配置允许空提示词的模型放行，编辑请求不检查
+	allowed := &relaycommon.RelayInfo{RelayMode: relayconstant.RelayModeImagesGenerations, OriginModelName: "sd-unconditional"}
+	if err := checkImageEmptyPrompt(allowed, &dto.ImageRequest{}); err != nil {
+		t.Fatalf("empty prompt rejected for an allowed model: %v", err)
+	}
+	edit := &relaycommon.RelayInfo{RelayMode: relayconstant.RelayModeImagesEdits, OriginModelName: "gpt-image-1"}
+	if err := checkImageEmptyPrompt(edit, &dto.ImageRequest{}); err != nil {
+		t.Fatalf("empty edit prompt was rejected: %v", err)
+	}
+}
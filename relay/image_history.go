@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordImageHistory 计费完成后写入用户的图片历史。body 为返回给客户端的响应，其中的 url 作为图片链接保存；
+// 流式响应与上游未返回图片的请求不记录
+func recordImageHistory(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest, quality string, body []byte) {
+	settings := model_setting.GetImageSettings()
+	if !settings.HistoryEnabled || info.IsStream {
+		return
+	}
+	if count, ok := c.Get("image_returned_count"); ok && count.(int) == 0 {
+		return
+	}
+	promptHash := sha256.Sum256([]byte(request.Prompt))
+	history := &model.ImageHistory{
+		UserId:     info.UserId,
+		CreatedAt:  time.Now().Unix(),
+		TokenId:    info.TokenId,
+		RequestId:  c.GetString(common.RequestIdKey),
+		Model:      info.OriginModelName,
+		Size:       request.Size,
+		Quality:    quality,
+		N:          int(request.N),
+		PromptHash: hex.EncodeToString(promptHash[:]),
+	}
+	// 开启 redact_prompts 时不保存提示词原文
+	if settings.HistoryStorePrompt && !model_setting.GetGlobalSettings().RedactPrompts {
+		history.Prompt = request.Prompt
+	}
+	if urls := collectImageResponseUrls(body); len(urls) > 0 {
+		if data, err := common.Marshal(urls); err == nil {
+			history.Urls = data
+		}
+	}
+	service.RecordImageHistory(history)
+}
+
+// collectImageResponseUrls 提取响应 data[].url，b64_json 返回的图片没有链接
+func collectImageResponseUrls(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	var response struct {
+		Data []struct {
+			Url string `json:"url"`
+		} `json:"data"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	var urls []string
+	for _, item := range response.Data {
+		if item.Url != "" {
+			urls = append(urls, item.Url)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,151 @@
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// convertImageInputToUrl 渠道仅接受图片 URL 时，将请求中的 base64 参考图转存到临时存储并替换为短期地址，
+// 返回的 id 需要在请求结束后清理
+func convertImageInputToUrl(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) ([]string, *types.NewAPIError) {
+	if !info.ChannelSetting.ImageInputAsUrl || len(request.Image) == 0 {
+		return nil, nil
+	}
+	var images []string
+	isArray := false
+	if err := common.Unmarshal(request.Image, &images); err == nil {
+		isArray = true
+	} else {
+		var image string
+		if err := common.Unmarshal(request.Image, &image); err != nil {
+			return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image field: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		images = []string{image}
+	}
+
+	ttl := getImageStorageTTL(c, info)
+	metadata := buildImageStorageMetadata(c, info)
+	var storedIds []string
+	for i, image := range images {
+		if image == "" || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+			continue
+		}
+		b64 := image
+		contentType := ""
+		if strings.HasPrefix(b64, "data:") {
+			if idx := strings.Index(b64, ","); idx != -1 {
+				contentType = strings.TrimSuffix(strings.TrimPrefix(b64[:idx], "data:"), ";base64")
+				b64 = b64[idx+1:]
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			cleanupStoredImages(c, storedIds)
+			return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid base64 image at index %d", i), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		if format := service.SniffImageFormat(data); format != "" {
+			contentType = "image/" + format
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		id, err := service.SaveImageWithRetry(c.Request.Context(), data, contentType, ttl, metadata)
+		if err != nil {
+			if model_setting.GetImageSettings().StorageWriteFallback == model_setting.ImageStorageFallbackOriginal {
+				// 保留原始 base64 数据，由上游自行处理
+				logger.LogWarn(c, fmt.Sprintf("store input image %d failed, forwarding original data: %s", i, err.Error()))
+				continue
+			}
+			cleanupStoredImages(c, storedIds)
+			return nil, types.NewError(fmt.Errorf("store input image failed: %w", err), types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+		storedIds = append(storedIds, id)
+		images[i] = service.GetStoredImageUrl(id)
+	}
+	if len(storedIds) == 0 {
+		return nil, nil
+	}
+
+	var raw json.RawMessage
+	var err error
+	if isArray {
+		raw, err = common.Marshal(images)
+	} else {
+		raw, err = common.Marshal(images[0])
+	}
+	if err != nil {
+		cleanupStoredImages(c, storedIds)
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	request.Image = raw
+	logger.LogInfo(c, fmt.Sprintf("uploaded %d input images to temporary storage for channel #%d", len(storedIds), info.ChannelId))
+	return storedIds, nil
+}
+
+// getImageStorageTTL 返回本次请求写入临时存储的保留时间：请求覆盖值优先，渠道配置次之，最后使用全局配置
+func getImageStorageTTL(c *gin.Context, info *relaycommon.RelayInfo) time.Duration {
+	seconds := info.ChannelSetting.ImageStorageTTLSeconds
+	if seconds <= 0 {
+		seconds = model_setting.GetImageSettings().TempUrlTTLSeconds
+	}
+	if override := c.GetInt("image_storage_ttl"); override > 0 && override < seconds {
+		seconds = override
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseImageStorageTTL 解析请求中的 storage_ttl（秒），仅对开启 ImageStorageTTLOverride 的令牌生效，
+// 超过渠道保留时间的值按渠道保留时间处理。该字段不会转发给上游
+func parseImageStorageTTL(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	var rawTTL string
+	if raw, ok := request.Extra["storage_ttl"]; ok {
+		rawTTL = strings.Trim(string(raw), "\"")
+		delete(request.Extra, "storage_ttl")
+	}
+	if mf := c.Request.MultipartForm; mf != nil {
+		if values, ok := mf.Value["storage_ttl"]; ok {
+			if rawTTL == "" && len(values) > 0 {
+				rawTTL = values[0]
+			}
+			delete(mf.Value, "storage_ttl")
+		}
+	}
+	if rawTTL == "" {
+		return nil
+	}
+	if !info.TokenSetting.ImageStorageTTLOverride {
+		return types.NewErrorWithStatusCode(errors.New("storage_ttl is not allowed for this token"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry())
+	}
+	ttl, err := strconv.Atoi(rawTTL)
+	if err != nil || ttl <= 0 {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid storage_ttl: %s", rawTTL), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	c.Set("image_storage_ttl", ttl)
+	return nil
+}
+
+// cleanupStoredImages 删除请求过程中转存的临时图片
+func cleanupStoredImages(c *gin.Context, ids []string) {
+	storage := service.GetImageStorage()
+	for _, id := range ids {
+		if err := storage.Delete(id); err != nil {
+			logger.LogWarn(c, fmt.Sprintf("delete stored image %s failed: %s", id, err.Error()))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+func withServerAddress(t *testing.T, address string) {
+	t.Helper()
+	previous := system_setting.ServerAddress
+	system_setting.ServerAddress = address
+	t.Cleanup(func() { system_setting.ServerAddress = previous })
+}
+
+// storedImageIdFromUrl 从转存地址中取出临时图片 id
+func storedImageIdFromUrl(t *testing.T, url string) string {
+	t.Helper()
+	id, ok := strings.CutPrefix(url, "https://gateway.example.com"+service.ImageStoragePath)
+	if !ok {
+		t.Fatalf("forwarded image %q is not a temporary storage url", url)
+	}
+	return id
+}
+
+func TestImageInputUploadedAndUrlForwarded(t *testing.T) {
+	withServerAddress(t, "https://gateway.example.com/")
+	upload := []byte(encodeTestPNG(t, 8, 8))
+	data, _ := base64.StdEncoding.DecodeString(string(upload))
+	images, _ := common.Marshal([]string{"data:image/png;base64," + string(upload), "https://cdn.example.com/ref.png"})
+	request := &dto.ImageRequest{Model: "flux-kontext", Image: images}
+	c := newImageTestContext(http.MethodPost, "/v1/images/edits")
+
+	storedIds, err := convertImageInputToUrl(c, newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true})), request)
+	if err != nil {
+		t.Fatalf("convert image input: %v", err)
+	}
+	if len(storedIds) != 1 {
+		t.Fatalf("stored %d images, want only the base64 input", len(storedIds))
+	}
+	var forwarded []string
+	if err := common.Unmarshal(request.Image, &forwarded); err != nil {
+		t.Fatalf("decode forwarded images: %v", err)
+	}
+	if id := storedImageIdFromUrl(t, forwarded[0]); id != storedIds[0] {
+		t.Fatalf("forwarded url points to %s, want %s", id, storedIds[0])
+	}
+	if forwarded[1] != "https://cdn.example.com/ref.png" {
+		t.Fatalf("existing url was changed: %s", forwarded[1])
+	}
+	stored, loadErr := service.GetImageStorage().Load(storedIds[0])
+	if loadErr != nil {
+		t.Fatalf("load uploaded input: %v", loadErr)
+	}
+	if !bytes.Equal(stored.Data, data) || stored.ContentType != "image/png" {
+		t.Fatalf("uploaded input differs from the request image (content type %s)", stored.ContentType)
+	}
+
+	// 请求结束后清理临时图片
+	cleanupStoredImages(c, storedIds)
+	if _, loadErr = service.GetImageStorage().Load(storedIds[0]); loadErr == nil {
+		t.Fatal("temporary input was not cleaned up")
+	}
+}
+
+func TestImageInputKeptForChannelsAcceptingUploads(t *testing.T) {
+	image, _ := common.Marshal("data:image/png;base64," + encodeTestPNG(t, 8, 8))
+	request := &dto.ImageRequest{Model: "flux-kontext", Image: image}
+	info := newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true}))
+	info.ChannelSetting.ImageInputAsUrl = false
+
+	storedIds, err := convertImageInputToUrl(newImageTestContext(http.MethodPost, "/v1/images/edits"), info, request)
+	if err != nil || storedIds != nil {
+		t.Fatalf("input was uploaded for a channel that accepts uploads: %v, %v", storedIds, err)
+	}
+	if !bytes.Equal(request.Image, json.RawMessage(image)) {
+		t.Fatalf("image field was changed: %s", request.Image)
+	}
+}
+
+func TestImageStorageMetadataAttachedToStoredObject(t *testing.T) {
+	withServerAddress(t, "https://gateway.example.com")
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.StorageMetadata = map[string]string{
+			"user":     "user_id",
+			"model":    "model",
+			"campaign": "header:X-Campaign",
+			"secret":   "header:authorization",
+		}
+	})
+	image, _ := common.Marshal("data:image/png;base64," + encodeTestPNG(t, 8, 8))
+	request := &dto.ImageRequest{Model: "flux-kontext", Image: image}
+	c := newImageTestContext(http.MethodPost, "/v1/images/edits")
+	c.Request.Header.Set("X-Campaign", "spring-sale")
+	c.Request.Header.Set("Authorization", "Bearer sk-secret")
+
+	storedIds, err := convertImageInputToUrl(c, newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true})), request)
+	if err != nil || len(storedIds) != 1 {
+		t.Fatalf("convert image input: %v, %v", storedIds, err)
+	}
+	defer cleanupStoredImages(c, storedIds)
+	stored, loadErr := service.GetImageStorage().Load(storedIds[0])
+	if loadErr != nil {
+		t.Fatalf("load stored image: %v", loadErr)
+	}
+	want := map[string]string{"user": "7", "model": "flux-kontext", "campaign": "spring-sale"}
+	if len(stored.Metadata) != len(want) {
+		t.Fatalf("metadata = %v, want %v", stored.Metadata, want)
+	}
+	for key, value := range want {
+		if stored.Metadata[key] != value {
+			t.Fatalf("metadata[%s] = %q, want %q", key, stored.Metadata[key], value)
+		}
+	}
+}
+
+// storeInputWithTTL 按请求中的 storage_ttl 转存一张参考图，返回存储记录
+func storeInputWithTTL(t *testing.T, info *relaycommon.RelayInfo, storageTTL string) (*service.StoredImage, string) {
+	t.Helper()
+	image, _ := common.Marshal("data:image/png;base64," + encodeTestPNG(t, 8, 8))
+	request := &dto.ImageRequest{Model: "flux-kontext", Image: image,
+		Extra: map[string]json.RawMessage{"storage_ttl": json.RawMessage(storageTTL)}}
+	c := newImageTestContext(http.MethodPost, "/v1/images/edits")
+	if err := parseImageStorageTTL(c, info, request); err != nil {
+		t.Fatalf("parse storage_ttl %s: %v", storageTTL, err)
+	}
+	if _, ok := request.Extra["storage_ttl"]; ok {
+		t.Fatal("storage_ttl forwarded upstream")
+	}
+	storedIds, err := convertImageInputToUrl(c, info, request)
+	if err != nil || len(storedIds) != 1 {
+		t.Fatalf("convert image input: %v, %v", storedIds, err)
+	}
+	t.Cleanup(func() { cleanupStoredImages(c, storedIds) })
+	stored, loadErr := service.GetImageStorage().Load(storedIds[0])
+	if loadErr != nil {
+		t.Fatalf("load stored image: %v", loadErr)
+	}
+	return stored, storedIds[0]
+}
+
+func TestImageStorageTTLOverrideSetsExpiry(t *testing.T) {
+	withServerAddress(t, "https://gateway.example.com")
+	info := newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true}))
+	info.ChannelSetting.ImageStorageTTLSeconds = 3600
+	info.TokenSetting.ImageStorageTTLOverride = true
+
+	// 覆盖值短于渠道保留时间时生效，超过时按渠道保留时间
+	for _, tc := range []struct {
+		storageTTL string
+		want       time.Duration
+	}{
+		{storageTTL: `120`, want: 120 * time.Second},
+		{storageTTL: `"600"`, want: 600 * time.Second},
+		{storageTTL: `86400`, want: time.Hour},
+	} {
+		stored, _ := storeInputWithTTL(t, info, tc.storageTTL)
+		if ttl := time.Until(stored.ExpiresAt); ttl > tc.want || ttl < tc.want-2*time.Second {
+			t.Fatalf("storage_ttl %s expires in %s, want %s", tc.storageTTL, ttl, tc.want)
+		}
+	}
+
+	// 过期后清理流程读取不到该图片
+	_, id := storeInputWithTTL(t, info, `1`)
+	time.Sleep(2 * time.Second)
+	if _, err := service.GetImageStorage().Load(id); err == nil {
+		t.Fatal("image still available after the overridden retention expired")
+	}
+}
+
+func TestImageStorageTTLOverrideRequiresToken(t *testing.T) {
+	request := &dto.ImageRequest{Extra: map[string]json.RawMessage{"storage_ttl": json.RawMessage(`60`)}}
+	err := parseImageStorageTTL(newImageTestContext(http.MethodPost, "/v1/images/edits"), newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true})), request)
+	if err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("override without token permission: %v, want 403", err)
+	}
+
+	info := newImageTestInfo(infoUserId(7), infoOriginModel("flux-kontext"), infoChannelId(4), infoChannelSetting(dto.ChannelSettings{ImageInputAsUrl: true}))
+	info.TokenSetting.ImageStorageTTLOverride = true
+	for _, value := range []string{`0`, `-5`, `"soon"`} {
+		request = &dto.ImageRequest{Extra: map[string]json.RawMessage{"storage_ttl": json.RawMessage(value)}}
+		if err = parseImageStorageTTL(newImageTestContext(http.MethodPost, "/v1/images/edits"), info, request); err == nil || err.StatusCode != http.StatusBadRequest {
+			t.Fatalf("storage_ttl %s: %v, want 400", value, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acceptImageJob 异步任务通过校验并完成预扣费后通知提交方返回 job_id，再等待后台工作槽位。
+// 普通请求直接返回；返回的函数用于释放槽位。接受前按计费档位记录预估额度，供查询任务时返回
+func acceptImageJob(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) (func(), *types.NewAPIError) {
+	job := service.ImageJobFromContext(c.Request.Context())
+	if job == nil {
+		return func() {}, nil
+	}
+	if info.IsStream || isStreamImageRequest(request) {
+		return nil, types.NewErrorWithStatusCode(errors.New("stream is not supported for async image jobs"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	job.SetEstimatedQuota(estimateImageQuota(c, info, request))
+	job.Accept()
+	release, err := service.AcquireImageJobWorker(c.Request.Context(), job)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("image job timed out waiting for a worker: %w", err), types.ErrorCodeDoRequestFailed, http.StatusGatewayTimeout, types.ErrOptionWithSkipRetry())
+	}
+	return release, nil
+}
+
+// recordImageJobFinalQuota 结算后将实际扣费记录到异步任务，普通请求不处理
+func recordImageJobFinalQuota(c *gin.Context) {
+	if job := service.ImageJobFromContext(c.Request.Context()); job != nil {
+		job.SetFinalQuota(c.GetInt("consumed_quota"))
+	}
+}
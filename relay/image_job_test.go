@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func TestAcceptImageJobRecordsEstimatedQuota(t *testing.T) {
+	job, err := service.NewImageJob(7)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	t.Cleanup(func() { service.RemoveImageJob(job.Id) })
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request = c.Request.WithContext(service.WithImageJob(c.Request.Context(), job))
+	info := &relaycommon.RelayInfo{
+		OriginModelName: "dall-e-3",
+		PriceData: types.PriceData{
+			UsePrice:       true,
+			ModelPrice:     0.04,
+			GroupRatioInfo: types.GroupRatioInfo{GroupRatio: 2},
+		},
+	}
+
+	release, apiErr := acceptImageJob(c, info, &dto.ImageRequest{Model: "dall-e-3", N: 1})
+	if apiErr != nil {
+		t.Fatalf("accept job: %v", apiErr)
+	}
+	release()
+
+	snapshot, err := service.GetImageJob(job.Id, job.UserId)
+	if err != nil {
+		t.Fatalf("load job: %v", err)
+	}
+	if want := int(0.04 * common.QuotaPerUnit * 2); snapshot.EstimatedQuota != want {
+		t.Fatalf("estimated quota = %d, want %d", snapshot.EstimatedQuota, want)
+	}
+	if snapshot.FinalQuota != nil {
+		t.Fatalf("final quota set before settlement: %d", *snapshot.FinalQuota)
+	}
+
+	c.Set("consumed_quota", 12345)
+	recordImageJobFinalQuota(c)
+	job.Complete(http.StatusOK, "application/json", []byte(`{"data":[]}`))
+	if snapshot, _ = service.GetImageJob(job.Id, job.UserId); snapshot.FinalQuota == nil || *snapshot.FinalQuota != 12345 {
+		t.Fatalf("final quota = %v, want 12345", snapshot.FinalQuota)
+	}
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageKeepAliveStartedKey 已经以 SSE 形式开始响应，之后的结果与错误都需要作为 SSE 事件返回
+const ImageKeepAliveStartedKey = "image_keep_alive_started"
+
+// shouldImageKeepAlive 渠道开启保活且客户端接受 SSE（Accept: text/event-stream 或请求 stream）时才发送保活注释，
+// 普通 JSON 客户端不受影响
+func shouldImageKeepAlive(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) bool {
+	if info.ChannelSetting.ImageKeepAliveSeconds <= 0 {
+		return false
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream") || isStreamImageRequest(request)
+}
+
+// startImageKeepAlive 等待上游期间每隔一个间隔发送一次 ": keep-alive" 注释，上游在第一个间隔内返回时不会改变响应格式。
+// 返回的函数停止发送并等待发送协程退出，之后才能安全地写入响应
+func startImageKeepAlive(c *gin.Context, info *relaycommon.RelayInfo) func() {
+	interval := time.Duration(info.ChannelSetting.ImageKeepAliveSeconds) * time.Second
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !c.GetBool(ImageKeepAliveStartedKey) {
+					helper.SetEventStreamHeaders(c)
+					c.Writer.WriteHeader(http.StatusOK)
+					c.Set(ImageKeepAliveStartedKey, true)
+					logger.LogInfo(c, "image generation is slow, switched to SSE keep-alive")
+				}
+				if _, err := c.Writer.Write([]byte(": keep-alive\n\n")); err != nil {
+					return
+				}
+				_ = helper.FlushWriter(c)
+			case <-stop:
+				return
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// flushImageResponse 写回暂存的响应，已开始发送保活注释时将响应体作为最后一个 SSE 事件发送
+func flushImageResponse(c *gin.Context, recorder *imageResponseRecorder, body []byte) {
+	if !c.GetBool(ImageKeepAliveStartedKey) {
+		recorder.flushTo(c.Writer, body)
+		return
+	}
+	_, _ = c.Writer.Write([]byte("data: "))
+	_, _ = c.Writer.Write(body)
+	_, _ = c.Writer.Write([]byte("\n\n"))
+	_ = helper.FlushWriter(c)
+}
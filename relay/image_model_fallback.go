@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageModelFallbackIndexKey = "image_model_fallback_index"
+
+// applyImageModelFallback 渠道为请求模型配置了回退列表时，使用列表中的第一个模型作为上游模型。
+// 每次进入渠道都从头开始，换渠道重试时不沿用上一个渠道的进度
+func applyImageModelFallback(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	c.Set(imageModelFallbackIndexKey, 0)
+	fallbacks := info.ChannelSetting.ImageModelFallbacks[info.OriginModelName]
+	if len(fallbacks) == 0 || fallbacks[0] == "" {
+		return
+	}
+	info.UpstreamModelName = fallbacks[0]
+	request.SetModelName(fallbacks[0])
+}
+
+// usedImageModelFallback 本次请求是否按回退列表换用过上游模型
+func usedImageModelFallback(c *gin.Context) bool {
+	return c.GetInt(imageModelFallbackIndexKey) > 0
+}
+
+// retryImageModelFallbacks 上游返回模型不存在时，按回退列表在同一渠道依次换用下一个模型重新请求，
+// 其他错误（包括内容策略拒绝）原样返回。透传请求体时无法替换模型，不做回退
+func retryImageModelFallbacks(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.ImageRequest, resp any, err error) (any, error) {
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+		return resp, err
+	}
+	fallbacks := info.ChannelSetting.ImageModelFallbacks[info.OriginModelName]
+	for idx := c.GetInt(imageModelFallbackIndexKey) + 1; err == nil && idx < len(fallbacks); idx++ {
+		httpResp, ok := resp.(*http.Response)
+		if !ok || !isImageModelNotFoundResponse(httpResp) {
+			break
+		}
+		service.CloseResponseBodyGracefully(httpResp)
+		logger.LogWarn(c, fmt.Sprintf("model %s not found on channel #%d, fallback to %s", info.UpstreamModelName, info.ChannelId, fallbacks[idx]))
+		info.UpstreamModelName = fallbacks[idx]
+		request.SetModelName(fallbacks[idx])
+		c.Set(imageModelFallbackIndexKey, idx)
+		requestBodies, newAPIError := buildImageRequestBodies(c, info, adaptor, request)
+		if newAPIError != nil {
+			return nil, newAPIError
+		}
+		requestBodies = auditImageUpstreamRequest(c, info, requestBodies)
+		resp, err = doImageUpstreamRequest(c, info, adaptor, request, requestBodies)
+	}
+	return resp, err
+}
+
+// isImageModelNotFoundResponse 判断上游是否因模型不存在拒绝请求，读取后恢复响应体供后续错误处理使用
+func isImageModelNotFoundResponse(resp *http.Response) bool {
+	if resp == nil || (resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusBadRequest) {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	var errResponse struct {
+		Error types.OpenAIError `json:"error"`
+	}
+	if common.Unmarshal(body, &errResponse) != nil {
+		return false
+	}
+	code := strings.ToLower(fmt.Sprint(errResponse.Error.Code))
+	errType := strings.ToLower(errResponse.Error.Type)
+	if strings.Contains(code, "content_policy") || strings.Contains(errType, "content_policy") {
+		return false
+	}
+	if code == "model_not_found" || errType == "model_not_found" {
+		return true
+	}
+	message := strings.ToLower(errResponse.Error.Message)
+	return strings.Contains(message, "model") && (strings.Contains(message, "not found") || strings.Contains(message, "does not exist"))
+}
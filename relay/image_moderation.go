@@ -0,0 +1,134 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// imageFlaggedError 整体失败策略下第一张命中的图片，用于提前结束其他审核请求
+type imageFlaggedError struct {
+	index  int
+	result *service.ImageModerationResult
+}
+
+func (e *imageFlaggedError) Error() string {
+	return fmt.Sprintf("image %d flagged by moderation", e.index)
+}
+
+// shouldModerateImageResponse 渠道开启了生成结果审核，流式响应无法在返回前拦截，不做审核
+func shouldModerateImageResponse(info *relaycommon.RelayInfo) bool {
+	setting := info.ChannelSetting.ImageModeration
+	return setting != nil && setting.Enabled && setting.Endpoint != ""
+}
+
+func newImageModerationRejectedError(result *service.ImageModerationResult) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(fmt.Errorf("generated image rejected by moderation: %s", service.FormatImageModerationCategories(result)),
+		types.ErrorCodeImageSafetyRejected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// moderateImageResponse 并发审核暂存响应中的每张图片。fail 策略下任意一张命中立即取消其余审核并整体失败，
+// drop 策略下移除命中的图片并按实际返回张数计费，全部命中时整体失败。返回错误时预扣费由调用方退还
+func moderateImageResponse(c *gin.Context, info *relaycommon.RelayInfo, recorder *imageResponseRecorder) *types.NewAPIError {
+	if !shouldModerateImageResponse(info) || recorder.status != http.StatusOK {
+		return nil
+	}
+	setting := info.ChannelSetting.ImageModeration
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(recorder.body.Bytes(), &response); err != nil {
+		return nil
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil || len(items) == 0 {
+		return nil
+	}
+
+	timeout := 10 * time.Second
+	if setting.TimeoutSeconds > 0 {
+		timeout = time.Duration(setting.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	failFast := setting.Policy != dto.ImageModerationPolicyDrop
+	results := make([]*service.ImageModerationResult, len(items))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i, item := range items {
+		var b64, url string
+		_ = common.Unmarshal(item["b64_json"], &b64)
+		_ = common.Unmarshal(item["url"], &url)
+		imageType, image := "b64_json", b64
+		if b64 == "" {
+			if url == "" {
+				continue
+			}
+			imageType, image = "url", url
+		}
+		group.Go(func() error {
+			result, err := service.ModerateImage(groupCtx, setting, info.UpstreamModelName, imageType, image)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+			if result.Flagged && failFast {
+				return &imageFlaggedError{index: i, result: result}
+			}
+			return nil
+		})
+	}
+	err := group.Wait()
+	var flaggedErr *imageFlaggedError
+	if errors.As(err, &flaggedErr) {
+		logger.LogWarn(c, fmt.Sprintf("image %d rejected by moderation on channel #%d: %s", flaggedErr.index, info.ChannelId, service.FormatImageModerationCategories(flaggedErr.result)))
+		return newImageModerationRejectedError(flaggedErr.result)
+	}
+	if err != nil {
+		if setting.FailOpen {
+			logger.LogWarn(c, "image moderation failed, fail open: "+err.Error())
+			return nil
+		}
+		return types.NewErrorWithStatusCode(fmt.Errorf("image moderation failed: %w", err), types.ErrorCodeBadResponse, http.StatusBadGateway, types.ErrOptionWithSkipRetry())
+	}
+
+	kept := make([]map[string]json.RawMessage, 0, len(items))
+	var lastFlagged *service.ImageModerationResult
+	for i, item := range items {
+		if results[i] != nil && results[i].Flagged {
+			lastFlagged = results[i]
+			logger.LogWarn(c, fmt.Sprintf("image %d dropped by moderation on channel #%d: %s", i, info.ChannelId, service.FormatImageModerationCategories(results[i])))
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if lastFlagged == nil {
+		return nil
+	}
+	if len(kept) == 0 {
+		return newImageModerationRejectedError(lastFlagged)
+	}
+	data, err := common.Marshal(kept)
+	if err != nil {
+		return nil
+	}
+	response["data"] = data
+	body, err := common.Marshal(response)
+	if err != nil {
+		return nil
+	}
+	recorder.body.Reset()
+	recorder.body.Write(body)
+	c.Set("image_returned_count", len(kept))
+	return nil
+}
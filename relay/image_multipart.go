@@ -0,0 +1,156 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageMultipartMediaType = "multipart/mixed"
+	imageIndexHeader        = "X-Image-Index"
+)
+
+// wantsMultipartImageResponse 客户端通过 Accept: multipart/mixed 声明希望直接接收图片二进制
+func wantsMultipartImageResponse(c *gin.Context) bool {
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == imageMultipartMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMultipartImageResponse 需要返回 multipart/mixed 时要求上游返回 base64 数据，以便组装二进制响应
+func applyMultipartImageResponse(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	if !wantsMultipartImageResponse(c) {
+		return nil
+	}
+	if isStreamImageRequest(request) {
+		return types.NewErrorWithStatusCode(errors.New("multipart/mixed response is not supported for streaming image requests"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	// gpt-image 系列始终返回 base64 且不接受 response_format 参数
+	if !strings.HasPrefix(request.Model, "gpt-image") && request.ResponseFormat != "b64_json" {
+		request.ResponseFormat = "b64_json"
+	}
+	return nil
+}
+
+// isStreamImageRequest 请求是否要求以 SSE 流式返回图片
+func isStreamImageRequest(request *dto.ImageRequest) bool {
+	return request.Stream != nil && *request.Stream
+}
+
+// imageFilenameAllocator 按模板生成文件名，并保证同一响应内文件名不重复
+type imageFilenameAllocator struct {
+	template string
+	vars     map[string]string
+	used     map[string]bool
+}
+
+func newImageFilenameAllocator(template string, vars map[string]string) *imageFilenameAllocator {
+	return &imageFilenameAllocator{template: template, vars: vars, used: make(map[string]bool)}
+}
+
+// next 返回第 index 张图片的文件名，模板为空时返回空字符串；重名时在扩展名前追加 -1、-2 …
+func (a *imageFilenameAllocator) next(index int, ext string) string {
+	if a.template == "" {
+		return ""
+	}
+	name := a.template
+	for k, v := range a.vars {
+		name = strings.ReplaceAll(name, "{"+k+"}", v)
+	}
+	name = strings.ReplaceAll(name, "{index}", strconv.Itoa(index))
+	name = strings.ReplaceAll(name, "{ext}", ext)
+	// 文件名不允许包含路径
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if !a.used[name] {
+		a.used[name] = true
+		return name
+	}
+	base, suffix := name, ""
+	if dot := strings.LastIndex(name, "."); dot > 0 {
+		base, suffix = name[:dot], name[dot:]
+	}
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, n, suffix)
+		if !a.used[candidate] {
+			a.used[candidate] = true
+			return candidate
+		}
+	}
+}
+
+// buildMultipartImageResponse 将 JSON 图片响应转换为 multipart/mixed，每张图片一个 part，
+// 返回响应体与 Content-Type。存在无法内联的图片（仅有 URL）时返回错误，由调用方回退为原始 JSON 响应
+func buildMultipartImageResponse(body []byte, filenames *imageFilenameAllocator) ([]byte, string, error) {
+	var imageResponse dto.ImageResponse
+	if err := common.Unmarshal(body, &imageResponse); err != nil {
+		return nil, "", fmt.Errorf("parse image response failed: %w", err)
+	}
+	if len(imageResponse.Data) == 0 {
+		return nil, "", errors.New("image response contains no data")
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for i, item := range imageResponse.Data {
+		if item.B64Json == "" {
+			return nil, "", fmt.Errorf("image %d has no inline data", i)
+		}
+		b64 := item.B64Json
+		if strings.HasPrefix(b64, "data:") {
+			if idx := strings.Index(b64, ","); idx != -1 {
+				b64 = b64[idx+1:]
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode image %d failed: %w", i, err)
+		}
+		contentType := "application/octet-stream"
+		ext := "bin"
+		if format := service.SniffImageFormat(data); format != "" {
+			contentType = "image/" + format
+			ext = format
+		}
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Type", contentType)
+		if filename := filenames.next(i, ext); filename != "" {
+			partHeader.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		}
+		partHeader.Set("Content-Length", strconv.Itoa(len(data)))
+		partHeader.Set(imageIndexHeader, strconv.Itoa(i))
+		if item.RevisedPrompt != "" {
+			partHeader.Set("X-Revised-Prompt", mime.QEncoding.Encode("utf-8", item.RevisedPrompt))
+		}
+		part, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = part.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), imageMultipartMediaType + "; boundary=" + writer.Boundary(), nil
+}
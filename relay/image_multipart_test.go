@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// newMultiImageResponse 生成包含一张 png 与一张 webp 的上游响应，返回响应体与两张图片的原始数据
+func newMultiImageResponse(t *testing.T) ([]byte, [][]byte) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	var pngBuf, webpBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if err := service.EncodeWebp(&webpBuf, img); err != nil {
+		t.Fatalf("encode webp: %v", err)
+	}
+	images := [][]byte{pngBuf.Bytes(), webpBuf.Bytes()}
+	body, err := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{
+		{B64Json: base64.StdEncoding.EncodeToString(images[0]), RevisedPrompt: "a small square"},
+		{B64Json: "data:image/webp;base64," + base64.StdEncoding.EncodeToString(images[1])},
+	}})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return body, images
+}
+
+func processMultipartImageResponse(t *testing.T, upstream []byte) (*imageResponseRecorder, []byte) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set("Accept", "multipart/mixed, application/json;q=0.5")
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	if !shouldRecordImageResponse(c, info) {
+		t.Fatal("multipart request did not record the upstream response")
+	}
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.Write(upstream)
+	body, err := processRecordedImageResponse(c, info, recorder)
+	if err != nil {
+		t.Fatalf("process response: %v", err)
+	}
+	return recorder, body
+}
+
+func TestMultipartImageResponseRoundTrip(t *testing.T) {
+	upstream, images := newMultiImageResponse(t)
+	recorder, body := processMultipartImageResponse(t, upstream)
+
+	mediaType, params, err := mime.ParseMediaType(recorder.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/mixed", recorder.header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	wantTypes := []string{"image/png", "image/webp"}
+	for i := range images {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("read part %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(part)
+		if !bytes.Equal(data, images[i]) {
+			t.Fatalf("part %d bytes differ from the upstream image", i)
+		}
+		if got := part.Header.Get("Content-Type"); got != wantTypes[i] {
+			t.Fatalf("part %d Content-Type = %q, want %q", i, got, wantTypes[i])
+		}
+		if got := part.Header.Get(imageIndexHeader); got != strconv.Itoa(i) {
+			t.Fatalf("part %d index = %q", i, got)
+		}
+		if got := part.Header.Get("Content-Length"); got != strconv.Itoa(len(images[i])) {
+			t.Fatalf("part %d Content-Length = %q, want %d", i, got, len(images[i]))
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Fatalf("expected exactly %d parts, got extra part (%v)", len(images), err)
+	}
+}
+
+func TestMultipartImageResponseFallsBackForUrlResults(t *testing.T) {
+	upstream := []byte(`{"created":1,"data":[{"url":"https://cdn.example.com/a.png"}]}`)
+	recorder, body := processMultipartImageResponse(t, upstream)
+	if mediaType, _, _ := mime.ParseMediaType(recorder.header.Get("Content-Type")); mediaType == "multipart/mixed" {
+		t.Fatal("url-only result was returned as multipart/mixed")
+	}
+	if !bytes.Equal(body, upstream) {
+		t.Fatalf("fallback body = %s, want the original json", body)
+	}
+}
+
+func TestMultipartImageResponseRejectsStream(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set("Accept", "multipart/mixed")
+	stream := true
+	request := &dto.ImageRequest{Model: "dall-e-3", Stream: &stream}
+	if err := applyMultipartImageResponse(c, &relaycommon.RelayInfo{}, request); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("streaming multipart request was not rejected: %v", err)
+	}
+
+	request = &dto.ImageRequest{Model: "dall-e-3"}
+	if err := applyMultipartImageResponse(c, &relaycommon.RelayInfo{}, request); err != nil || request.ResponseFormat != "b64_json" {
+		t.Fatalf("multipart request did not ask upstream for b64_json: %v, %q", err, request.ResponseFormat)
+	}
+}
+
+func TestImageFilenameAllocatorAvoidsCollisions(t *testing.T) {
+	filenames := newImageFilenameAllocator("{model}/{request_id}.{ext}", map[string]string{"model": "dall-e-3", "request_id": "req1"})
+	// 模板不含 {index} 时同一响应内的文件名自动追加序号，路径分隔符被替换
+	want := []string{"dall-e-3_req1.png", "dall-e-3_req1-1.png", "dall-e-3_req1.webp", "dall-e-3_req1-2.png"}
+	exts := []string{"png", "png", "webp", "png"}
+	for i, ext := range exts {
+		if got := filenames.next(i, ext); got != want[i] {
+			t.Fatalf("filename %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// 没有扩展名时在末尾追加序号；模板渲染结果与已追加序号的文件名相同时继续递增
+	filenames = newImageFilenameAllocator("image{index}", nil)
+	for i, want := range []string{"image0", "image1"} {
+		if got := filenames.next(i, "png"); got != want {
+			t.Fatalf("filename %d = %q, want %q", i, got, want)
+		}
+	}
+	filenames = newImageFilenameAllocator("image", nil)
+	filenames.used["image-1"] = true
+	if first, second := filenames.next(0, "png"), filenames.next(1, "png"); first != "image" || second != "image-2" {
+		t.Fatalf("filenames = %q, %q, want image, image-2", first, second)
+	}
+
+	if got := newImageFilenameAllocator("", nil).next(0, "png"); got != "" {
+		t.Fatalf("empty template produced filename %q", got)
+	}
+}
+
+func TestMultipartImageResponseFilenames(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.MultipartFilenameTemplate = "image-{timestamp}.png"
+	})
+	upstream, images := newMultiImageResponse(t)
+	recorder, body := processMultipartImageResponse(t, upstream)
+
+	_, params, _ := mime.ParseMediaType(recorder.header.Get("Content-Type"))
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	seen := make(map[string]bool)
+	for i := range images {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("read part %d: %v", i, err)
+		}
+		filename := part.FileName()
+		if !strings.HasPrefix(filename, "image-") || !strings.HasSuffix(filename, ".png") || seen[filename] {
+			t.Fatalf("part %d filename %q is missing or duplicated", i, filename)
+		}
+		seen[filename] = true
+	}
+}
@@ -0,0 +1,79 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkImageStreamSafetyPolicy 流式响应在生成完成前就会转发部分图片，无法执行渠道的 NSFW 拦截、模糊与截断拒绝策略，
+// 配置了这些策略的渠道拒绝流式请求
+func checkImageStreamSafetyPolicy(info *relaycommon.RelayInfo) *types.NewAPIError {
+	if action := info.ChannelSetting.ImageNsfwAction; action == dto.ImageNsfwActionBlock || action == dto.ImageNsfwActionBlur {
+		return types.NewErrorWithStatusCode(fmt.Errorf("stream is not supported on this channel: nsfw action %q needs the complete image", action), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if info.ChannelSetting.ImageRejectTruncatedPrompt {
+		return types.NewErrorWithStatusCode(fmt.Errorf("stream is not supported on this channel: truncated prompts are rejected after generation"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	return nil
+}
+
+// blurNsfwImageResponse 模糊上游标记为 NSFW 的图片。仅返回 URL 的图片无法在服务端处理，
+// 为避免返回未经处理的内容，将其地址清空
+func blurNsfwImageResponse(c *gin.Context, body []byte) []byte {
+	flagged, ok := c.Get("image_nsfw_blur")
+	if !ok {
+		return body
+	}
+	indexes, _ := flagged.([]int)
+	if len(indexes) == 0 {
+		return body
+	}
+
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body
+	}
+	for _, i := range indexes {
+		if i >= len(items) {
+			continue
+		}
+		var b64 string
+		_ = common.Unmarshal(items[i]["b64_json"], &b64)
+		if b64 == "" {
+			items[i]["url"] = json.RawMessage(`""`)
+			logger.LogWarn(c, fmt.Sprintf("nsfw image %d has no inline data to blur, url removed", i))
+			continue
+		}
+		blurred, _, err := service.BlurBase64Image(b64)
+		if err != nil {
+			blurred = ""
+			logger.LogWarn(c, fmt.Sprintf("blur nsfw image %d failed, image removed: %s", i, err.Error()))
+		}
+		raw, _ := common.Marshal(blurred)
+		items[i]["b64_json"] = raw
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body
+	}
+	response["data"] = data
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+func TestCheckImageStreamSafetyPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		action   string
+		truncate bool
+		refused  bool
+	}{
+		{name: "no policy", refused: false},
+		{name: "pass", action: dto.ImageNsfwActionPass, refused: false},
+		{name: "block", action: dto.ImageNsfwActionBlock, refused: true},
+		{name: "blur", action: dto.ImageNsfwActionBlur, refused: true},
+		{name: "reject truncated prompt", truncate: true, refused: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+			info.ChannelSetting.ImageNsfwAction = tc.action
+			info.ChannelSetting.ImageRejectTruncatedPrompt = tc.truncate
+			err := checkImageStreamSafetyPolicy(info)
+			if (err != nil) != tc.refused {
+				t.Fatalf("refused = %v, want %v", err != nil, tc.refused)
+			}
+			if err != nil && err.StatusCode != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestBlurNsfwImageResponse(t *testing.T) {
+	// 棋盘格图片模糊后相邻像素趋于一致
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	original := base64.StdEncoding.EncodeToString(buf.Bytes())
+	body, _ := common.Marshal(map[string]any{"created": 1, "data": []map[string]any{
+		{"b64_json": original},
+		{"b64_json": original, "nsfw": true},
+		{"url": "https://cdn.example.com/2.png", "nsfw": true},
+	}})
+
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Set("image_nsfw_blur", []int{1, 2})
+	var response dto.ImageResponse
+	if err := common.Unmarshal(blurNsfwImageResponse(c, body), &response); err != nil {
+		t.Fatalf("decode blurred response: %v", err)
+	}
+	if response.Data[0].B64Json != original {
+		t.Fatal("unflagged image was modified")
+	}
+	if response.Data[2].Url != "" {
+		t.Fatalf("flagged url-only image kept its url: %q", response.Data[2].Url)
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Data[1].B64Json)
+	if err != nil {
+		t.Fatalf("decode blurred image: %v", err)
+	}
+	blurred, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("blurred image is not a png: %v", err)
+	}
+	if blurred.Bounds() != img.Bounds() {
+		t.Fatalf("blurred bounds = %v, want %v", blurred.Bounds(), img.Bounds())
+	}
+	if blurred.At(0, 0) != blurred.At(1, 0) {
+		t.Fatal("flagged image was not blurred")
+	}
+
+	// 未标记需要模糊时响应原样返回
+	if got := blurNsfwImageResponse(newImageTestContext(http.MethodPost, "/v1/images/generations"), body); !bytes.Equal(got, body) {
+		t.Fatal("response changed without flagged images")
+	}
+}
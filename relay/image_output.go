@@ -0,0 +1,248 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageFormatHeader      = "X-New-Api-Image-Format"
+	imageWebpQualityHeader = "X-New-Api-Image-Webp-Quality"
+)
+
+// imageModelSupportsOutputFormat 上游是否支持 output_format 参数
+func imageModelSupportsOutputFormat(model string) bool {
+	return strings.HasPrefix(model, "gpt-image")
+}
+
+// imagePreferredOutputFormatKey WebP 优先按 Accept 选择的输出格式，上游未按该格式返回时由服务端转换
+const imagePreferredOutputFormatKey = "image_preferred_output_format"
+
+// applyPreferredImageFormat 渠道开启 WebP 优先且客户端未显式指定格式时，按 Accept 选择输出格式：
+// 声明支持 WebP 时优先返回 WebP，否则返回 PNG。支持 output_format 的上游直接请求 WebP，其余在返回前转换
+func applyPreferredImageFormat(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) {
+	if !info.ChannelSetting.ImagePreferWebp || len(request.OutputFormat) > 0 {
+		return
+	}
+	if isImagePostProcessSkipped(c, model_setting.ImagePostProcessPreferWebp) {
+		return
+	}
+	if !acceptsWebpImage(c.GetHeader("Accept")) {
+		c.Set(imagePreferredOutputFormatKey, "png")
+		return
+	}
+	c.Set(imagePreferredOutputFormatKey, "webp")
+	// 渠道配置了 WebP 质量时由服务端编码，上游直接返回的 WebP 无法控制质量
+	if imageModelSupportsOutputFormat(request.Model) && info.ChannelSetting.ImageWebpQuality == nil {
+		request.OutputFormat = json.RawMessage(`"webp"`)
+	}
+}
+
+// acceptsWebpImage 客户端显式列出 image/webp 且其 q 值大于 0、不低于 PNG 的 q 值时返回 true。
+// PNG 的 q 值取 image/png、image/*、*/* 中最具体的一项，均未列出时视为不接受
+func acceptsWebpImage(accept string) bool {
+	webpQuality, pngQuality := -1.0, 0.0
+	pngSpecificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		specificity := -1
+		switch mediaRange {
+		case "image/webp":
+			webpQuality = quality
+			continue
+		case "image/png":
+			specificity = 2
+		case "image/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		}
+		if specificity > pngSpecificity {
+			pngSpecificity, pngQuality = specificity, quality
+		}
+	}
+	return webpQuality > 0 && webpQuality >= pngQuality
+}
+
+// convertPreferredImageFormat 将 base64 图片转换为 WebP 优先选择的格式。PNG 等无损图片转为 WebP，
+// 转换失败或结果更大时保留上游图片（通常为 PNG）；JPEG 已是有损压缩，无损 WebP 不会更小，原样返回。
+// 不接受 WebP 的客户端收到的 WebP 图片转为 PNG。客户端或令牌指定了格式时由 enforceImageOutputFormat 处理，URL 形式的图片原样返回
+func convertPreferredImageFormat(c *gin.Context, info *relaycommon.RelayInfo, recorder *imageResponseRecorder, body []byte) []byte {
+	preferred := c.GetString(imagePreferredOutputFormatKey)
+	if preferred == "" || c.GetString(imageRequestedOutputFormatKey) != "" {
+		return body
+	}
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body
+	}
+	converted, inline := 0, 0
+	for i, item := range items {
+		var b64 string
+		if err := common.Unmarshal(item["b64_json"], &b64); err != nil || b64 == "" {
+			continue
+		}
+		inline++
+		actual := service.SniffBase64ImageFormat(b64)
+		if actual == "" || actual == preferred || actual == "jpeg" || (preferred == "png" && actual != "webp") {
+			continue
+		}
+		result, err := convertChannelImageFormat(info, recorder, b64, preferred)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("convert image %d from %s to %s failed, returning %s: %s", i, actual, preferred, actual, err.Error()))
+			continue
+		}
+		if preferred == "webp" && len(result) >= len(b64) {
+			continue
+		}
+		item["b64_json"], _ = common.Marshal(result)
+		converted++
+	}
+	if converted == 0 {
+		return body
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body
+	}
+	response["data"] = data
+	if _, ok := response["output_format"]; ok && converted == inline {
+		response["output_format"], _ = common.Marshal(preferred)
+	}
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+// convertChannelImageFormat 转换 base64 图片格式，WebP 按渠道的质量设置编码，配置了质量时将实际使用的质量写入响应头
+func convertChannelImageFormat(info *relaycommon.RelayInfo, recorder *imageResponseRecorder, b64 string, format string) (string, error) {
+	if format != "webp" {
+		return service.ConvertBase64ImageFormat(b64, format)
+	}
+	result, quality, err := service.ConvertBase64ImageToWebp(b64, info.ChannelSetting.ImageWebpQuality)
+	if err != nil {
+		return "", err
+	}
+	if info.ChannelSetting.ImageWebpQuality != nil {
+		recorder.header.Add(imageWebpQualityHeader, strconv.Itoa(quality))
+	}
+	return result, nil
+}
+
+// detectImageResponseFormat 从图片响应中识别实际返回的图片格式
+func detectImageResponseFormat(body []byte) string {
+	var imageResponse struct {
+		OutputFormat string          `json:"output_format"`
+		Data         []dto.ImageData `json:"data"`
+	}
+	if err := common.Unmarshal(body, &imageResponse); err != nil {
+		return ""
+	}
+	if imageResponse.OutputFormat != "" {
+		return strings.ToLower(imageResponse.OutputFormat)
+	}
+	for _, item := range imageResponse.Data {
+		if item.B64Json != "" {
+			if format := service.SniffBase64ImageFormat(item.B64Json); format != "" {
+				return format
+			}
+		}
+		if item.Url != "" {
+			if parsed, err := url.Parse(item.Url); err == nil {
+				switch strings.ToLower(path.Ext(parsed.Path)) {
+				case ".png":
+					return "png"
+				case ".jpg", ".jpeg":
+					return "jpeg"
+				case ".webp":
+					return "webp"
+				case ".gif":
+					return "gif"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkTokenImageOutputFormat 令牌限制了输出格式时，按令牌配置将不允许的格式替换为允许的格式或拒绝请求。
+// 未显式指定格式的请求总是替换，避免上游默认返回不允许的格式。不支持 output_format 的模型按其固定返回的格式
+// （通常为 PNG）判断，替换时不改写上游请求，由服务端在返回前转换
+func checkTokenImageOutputFormat(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	allowed := info.TokenSetting.ImageAllowedOutputFormats
+	if len(allowed) == 0 {
+		return nil
+	}
+	var format string
+	if len(request.OutputFormat) > 0 {
+		if err := common.Unmarshal(request.OutputFormat, &format); err != nil {
+			return types.NewErrorWithStatusCode(fmt.Errorf("invalid output_format"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+	}
+	supportsOutputFormat := imageModelSupportsOutputFormat(request.Model)
+	effective := format
+	if !supportsOutputFormat {
+		effective = imageModelNativeOutputFormat(request.Model)
+	}
+	for _, item := range allowed {
+		if strings.EqualFold(item, effective) {
+			return nil
+		}
+	}
+	if (format != "" || !supportsOutputFormat) && info.TokenSetting.ImageOutputFormatPolicy == dto.ImageFormatPolicyReject {
+		return types.NewErrorWithStatusCode(fmt.Errorf("output_format %q is not allowed for this token, allowed formats: %s", effective, strings.Join(allowed, ", ")), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	coerced := strings.ToLower(allowed[0])
+	c.Set("image_output_format_coerced", true)
+	logger.LogInfo(c, fmt.Sprintf("image output_format coerced from %q to %q by token setting", effective, coerced))
+	if !supportsOutputFormat {
+		request.OutputFormat = nil
+		c.Set(imageRequestedOutputFormatKey, coerced)
+		return nil
+	}
+	raw, err := common.Marshal(coerced)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	request.OutputFormat = raw
+	return nil
+}
+
+// imageModelNativeOutputFormat 不支持 output_format 的模型返回的图片格式，取 ModelOutputFormats 中配置的第一个，未配置时视为 PNG
+func imageModelNativeOutputFormat(model string) string {
+	if formats, configured := model_setting.GetImageSettings().GetModelOutputFormats(model); configured && len(formats) > 0 {
+		return strings.ToLower(formats[0])
+	}
+	return "png"
+}
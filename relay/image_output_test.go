@@ -0,0 +1,315 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newImageTestContext(method, target string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, target, nil)
+	return c
+}
+
+// imageTestInfoOption 修改 newImageTestInfo 构造的 RelayInfo
+type imageTestInfoOption func(info *relaycommon.RelayInfo)
+
+// newImageTestInfo 构造带空渠道信息的 RelayInfo，按 opts 设置各测试关心的字段
+func newImageTestInfo(opts ...imageTestInfoOption) *relaycommon.RelayInfo {
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	for _, opt := range opts {
+		opt(info)
+	}
+	return info
+}
+
+func infoRelayMode(relayMode int) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.RelayMode = relayMode }
+}
+
+func infoUserId(userId int) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.UserId = userId }
+}
+
+func infoOriginModel(model string) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.OriginModelName = model }
+}
+
+func infoUpstreamModel(model string) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.UpstreamModelName = model }
+}
+
+func infoChannelId(channelId int) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.ChannelId = channelId }
+}
+
+func infoChannelEndpoint(baseUrl string, apiKey string) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.ChannelBaseUrl, info.ApiKey = baseUrl, apiKey }
+}
+
+func infoChannelSetting(setting dto.ChannelSettings) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.ChannelSetting = setting }
+}
+
+func infoTokenSetting(setting dto.TokenSetting) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.TokenSetting = setting }
+}
+
+// infoModelPrice 按次计费的模型价格
+func infoModelPrice(price float64) imageTestInfoOption {
+	return func(info *relaycommon.RelayInfo) { info.PriceData = types.PriceData{UsePrice: true, ModelPrice: price} }
+}
+
+func TestCheckTokenImageOutputFormatRejectsDisallowedFormat(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := &relaycommon.RelayInfo{TokenSetting: dto.TokenSetting{
+		ImageAllowedOutputFormats: []string{"jpeg", "webp"},
+		ImageOutputFormatPolicy:   dto.ImageFormatPolicyReject,
+	}}
+	request := &dto.ImageRequest{Model: "gpt-image-1", OutputFormat: json.RawMessage(`"png"`)}
+
+	err := checkTokenImageOutputFormat(c, info, request)
+	if err == nil {
+		t.Fatal("expected png to be rejected for a jpeg/webp-only token")
+	}
+	if err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusBadRequest)
+	}
+	if string(request.OutputFormat) != `"png"` {
+		t.Fatalf("rejected request was modified: %s", request.OutputFormat)
+	}
+}
+
+func TestCheckTokenImageOutputFormatCoercesDisallowedFormat(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := &relaycommon.RelayInfo{TokenSetting: dto.TokenSetting{
+		ImageAllowedOutputFormats: []string{"JPEG", "webp"},
+	}}
+	request := &dto.ImageRequest{Model: "gpt-image-1", OutputFormat: json.RawMessage(`"png"`)}
+
+	if err := checkTokenImageOutputFormat(c, info, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(request.OutputFormat) != `"jpeg"` {
+		t.Fatalf("output_format = %s, want \"jpeg\"", request.OutputFormat)
+	}
+	if !c.GetBool("image_output_format_coerced") {
+		t.Fatal("coercion was not recorded on the context")
+	}
+}
+
+func TestCheckTokenImageOutputFormatAllowsPermittedFormat(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := &relaycommon.RelayInfo{TokenSetting: dto.TokenSetting{
+		ImageAllowedOutputFormats: []string{"webp"},
+		ImageOutputFormatPolicy:   dto.ImageFormatPolicyReject,
+	}}
+	request := &dto.ImageRequest{Model: "gpt-image-1", OutputFormat: json.RawMessage(`"WEBP"`)}
+
+	if err := checkTokenImageOutputFormat(c, info, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(request.OutputFormat) != `"WEBP"` {
+		t.Fatalf("allowed format was modified: %s", request.OutputFormat)
+	}
+}
+
+func TestCheckTokenImageOutputFormatAppliesToModelsWithoutOutputFormat(t *testing.T) {
+	setting := dto.TokenSetting{ImageAllowedOutputFormats: []string{"jpeg", "webp"}}
+
+	// 默认替换：不改写上游请求，由服务端将上游返回的 PNG 转换为允许的格式
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	request := &dto.ImageRequest{Model: "dall-e-3"}
+	if err := checkTokenImageOutputFormat(c, &relaycommon.RelayInfo{TokenSetting: setting}, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(request.OutputFormat) > 0 {
+		t.Fatalf("output_format was sent to an upstream without output_format support: %s", request.OutputFormat)
+	}
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.status = http.StatusOK
+	recorder.body.Write(newTestImageResponse(t, "png"))
+	if err := enforceImageOutputFormat(c, info, recorder); err != nil {
+		t.Fatalf("enforce output format: %v", err)
+	}
+	if format := firstImageFormat(t, recorder.body.Bytes()); format != "jpeg" {
+		t.Fatalf("returned image format = %q, want jpeg", format)
+	}
+
+	setting.ImageOutputFormatPolicy = dto.ImageFormatPolicyReject
+	c = newImageTestContext(http.MethodPost, "/v1/images/generations")
+	err := checkTokenImageOutputFormat(c, &relaycommon.RelayInfo{TokenSetting: setting}, &dto.ImageRequest{Model: "dall-e-3"})
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("png-only model must be rejected for a jpeg/webp-only token, got %v", err)
+	}
+
+	setting.ImageAllowedOutputFormats = []string{"png"}
+	if err := checkTokenImageOutputFormat(c, &relaycommon.RelayInfo{TokenSetting: setting}, &dto.ImageRequest{Model: "flux-1"}); err != nil {
+		t.Fatalf("png model was rejected for a png token: %v", err)
+	}
+}
+
+// newTestImageResponse 返回包含一张带轻微噪点的渐变图片的上游图片响应，format 为 png 或 webp
+func newTestImageResponse(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			noise := uint8(rng.Intn(5))
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x*2) + noise, G: uint8(y*2) + noise, B: 128 + noise, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	encode := png.Encode
+	if format == "webp" {
+		encode = service.EncodeWebp
+	}
+	if err := encode(&buf, img); err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	body, err := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{{B64Json: base64.StdEncoding.EncodeToString(buf.Bytes())}}})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return body
+}
+
+func processPreferredImageFormat(t *testing.T, accept string, upstream []byte) (*imageResponseRecorder, []byte) {
+	t.Helper()
+	return processPreferredImageFormatWithSetting(t, accept, upstream, dto.ChannelSettings{ImagePreferWebp: true})
+}
+
+func processPreferredImageFormatWithSetting(t *testing.T, accept string, upstream []byte, setting dto.ChannelSettings) (*imageResponseRecorder, []byte) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if accept != "" {
+		c.Request.Header.Set("Accept", accept)
+	}
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{ChannelSetting: setting}}
+	request := &dto.ImageRequest{Model: "dall-e-3"}
+	applyPreferredImageFormat(c, info, request)
+	if len(request.OutputFormat) > 0 {
+		t.Fatalf("output_format was set for an upstream without output_format support: %s", request.OutputFormat)
+	}
+
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.Write(upstream)
+	body, err := processRecordedImageResponse(c, info, recorder)
+	if err != nil {
+		t.Fatalf("process response: %v", err)
+	}
+	return recorder, body
+}
+
+func firstImageFormat(t *testing.T, body []byte) string {
+	t.Helper()
+	var response dto.ImageResponse
+	if err := common.Unmarshal(body, &response); err != nil || len(response.Data) == 0 {
+		t.Fatalf("decode response %s: %v", body, err)
+	}
+	return service.SniffBase64ImageFormat(response.Data[0].B64Json)
+}
+
+func TestPreferredImageFormatConvertsToWebpForAcceptingClients(t *testing.T) {
+	recorder, body := processPreferredImageFormat(t, "image/avif,image/webp,*/*", newTestImageResponse(t, "png"))
+	if format := firstImageFormat(t, body); format != "webp" {
+		t.Fatalf("returned image format = %q, want webp", format)
+	}
+	if header := recorder.header.Get(imageFormatHeader); header != "webp" {
+		t.Fatalf("%s = %q, want webp", imageFormatHeader, header)
+	}
+}
+
+func TestAcceptsWebpImage(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "image/avif,image/webp,*/*", want: true},
+		{accept: "image/webp", want: true},
+		{accept: "image/webp;q=0.9, image/png;q=0.8", want: true},
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "image/webp;q=0", want: false},
+		{accept: "image/webp; q=0.0, */*", want: false},
+		{accept: "image/png,image/webp;q=0.8", want: false},
+		{accept: "image/*;q=0.5,image/webp;q=0.4", want: false},
+		// image/png 比 */* 更具体，按其 q 值比较
+		{accept: "*/*,image/png;q=0.1,image/webp;q=0.5", want: true},
+	}
+	for _, tt := range tests {
+		if got := acceptsWebpImage(tt.accept); got != tt.want {
+			t.Fatalf("acceptsWebpImage(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestPreferredImageFormatKeepsPngForOtherClients(t *testing.T) {
+	recorder, body := processPreferredImageFormat(t, "application/json", newTestImageResponse(t, "png"))
+	if format := firstImageFormat(t, body); format != "png" {
+		t.Fatalf("returned image format = %q, want png", format)
+	}
+	if header := recorder.header.Get(imageFormatHeader); header != "png" {
+		t.Fatalf("%s = %q, want png", imageFormatHeader, header)
+	}
+}
+
+func TestPreferredImageFormatConvertsWebpToPngForOtherClients(t *testing.T) {
+	recorder, body := processPreferredImageFormat(t, "", newTestImageResponse(t, "webp"))
+	if format := firstImageFormat(t, body); format != "png" {
+		t.Fatalf("returned image format = %q, want png", format)
+	}
+	if header := recorder.header.Get(imageFormatHeader); header != "png" {
+		t.Fatalf("%s = %q, want png", imageFormatHeader, header)
+	}
+}
+
+func TestPreferredImageFormatRequestsWebpFromCapableUpstreams(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set("Accept", "image/webp")
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{ChannelSetting: dto.ChannelSettings{ImagePreferWebp: true}}}
+	request := &dto.ImageRequest{Model: "gpt-image-1"}
+
+	applyPreferredImageFormat(c, info, request)
+	if string(request.OutputFormat) != `"webp"` {
+		t.Fatalf("output_format = %s, want \"webp\"", request.OutputFormat)
+	}
+}
+
+func TestPreferredImageFormatUsesContentAwareWebpQuality(t *testing.T) {
+	setting := dto.ChannelSettings{
+		ImagePreferWebp:  true,
+		ImageWebpQuality: &dto.ImageWebpQualitySetting{Auto: true, MinQuality: 40, MaxQuality: 90},
+	}
+	recorder, body := processPreferredImageFormatWithSetting(t, "image/webp", newTestImageResponse(t, "png"), setting)
+	if format := firstImageFormat(t, body); format != "webp" {
+		t.Fatalf("returned image format = %q, want webp", format)
+	}
+	quality, err := strconv.Atoi(recorder.header.Get(imageWebpQualityHeader))
+	if err != nil {
+		t.Fatalf("%s = %q: %v", imageWebpQualityHeader, recorder.header.Get(imageWebpQualityHeader), err)
+	}
+	if quality < 40 || quality > 90 {
+		t.Fatalf("webp quality %d outside configured range [40, 90]", quality)
+	}
+}
@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getImagePolicyVersion 返回本次请求生效的策略版本：全局图片配置版本 + 渠道审核相关配置的哈希，
+// 同一请求内只计算一次，保证响应与日志记录一致
+func getImagePolicyVersion(c *gin.Context, info *relaycommon.RelayInfo) string {
+	if version := c.GetString("image_policy_version"); version != "" {
+		return version
+	}
+	channelPolicy, _ := common.Marshal(map[string]any{
+		"prompt_classifier": info.ChannelSetting.ImagePromptClassifier,
+		"safety_thresholds": info.ChannelSetting.ImageSafetyThresholds,
+		"nsfw_action":       info.ChannelSetting.ImageNsfwAction,
+		"max_output":        info.ChannelSetting.ImageMaxOutput,
+	})
+	sum := sha256.Sum256(channelPolicy)
+	version := model_setting.GetImageSettingsVersion() + "." + hex.EncodeToString(sum[:4])
+	c.Set("image_policy_version", version)
+	return version
+}
+
+// setImagePolicyMeta 在响应的 new_api 字段中记录策略版本与实际使用的上游模型
+func setImagePolicyMeta(c *gin.Context, info *relaycommon.RelayInfo) {
+	setImageResponseMeta(c, "policy_version", getImagePolicyVersion(c, info))
+	setImageResponseMeta(c, "model_version", info.UpstreamModelName)
+}
@@ -0,0 +1,75 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func TestImagePolicyVersionRecordedInResponse(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.PolicyVersionEnabled = true
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := newImageTestInfo(infoUpstreamModel("gpt-image-1-2025-04-15"), infoChannelSetting(dto.ChannelSettings{ImageNsfwAction: dto.ImageNsfwActionBlock}))
+	if !shouldRecordImageResponse(c, info) {
+		t.Fatal("policy version did not record the upstream response")
+	}
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.WriteString(`{"created":1,"data":[{"url":"https://cdn.example.com/a.png"}]}`)
+	body, err := processRecordedImageResponse(c, info, recorder)
+	if err != nil {
+		t.Fatalf("process response: %v", err)
+	}
+
+	var response struct {
+		NewApi struct {
+			PolicyVersion string `json:"policy_version"`
+			ModelVersion  string `json:"model_version"`
+		} `json:"new_api"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decode response %s: %v", body, err)
+	}
+	// 响应与日志使用同一个版本号
+	if response.NewApi.PolicyVersion == "" || response.NewApi.PolicyVersion != getImagePolicyVersion(c, info) {
+		t.Fatalf("policy_version = %q, want %q", response.NewApi.PolicyVersion, getImagePolicyVersion(c, info))
+	}
+	if response.NewApi.ModelVersion != "gpt-image-1-2025-04-15" {
+		t.Fatalf("model_version = %q, want the upstream model", response.NewApi.ModelVersion)
+	}
+}
+
+func TestImagePolicyVersionChangesWithPolicy(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.PolicyVersionEnabled = true
+	})
+	version := func(nsfwAction string) string {
+		return getImagePolicyVersion(newImageTestContext(http.MethodPost, "/v1/images/generations"), newImageTestInfo(infoUpstreamModel("gpt-image-1-2025-04-15"), infoChannelSetting(dto.ChannelSettings{ImageNsfwAction: nsfwAction})))
+	}
+	base := version(dto.ImageNsfwActionBlock)
+	if again := version(dto.ImageNsfwActionBlock); again != base {
+		t.Fatalf("same policy produced versions %q and %q", base, again)
+	}
+	// 渠道审核配置变化
+	if other := version(dto.ImageNsfwActionBlur); other == base {
+		t.Fatal("policy version unchanged after the channel nsfw action changed")
+	}
+	// 全局图片配置变化
+	model_setting.GetImageSettings().BudgetWarningPercent++
+	if other := version(dto.ImageNsfwActionBlock); other == base {
+		t.Fatal("policy version unchanged after the image settings changed")
+	}
+
+	// 同一请求内配置变化不影响已记录的版本
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := newImageTestInfo(infoUpstreamModel("gpt-image-1-2025-04-15"), infoChannelSetting(dto.ChannelSettings{ImageNsfwAction: dto.ImageNsfwActionBlock}))
+	recorded := getImagePolicyVersion(c, info)
+	model_setting.GetImageSettings().BudgetWarningPercent++
+	if got := getImagePolicyVersion(c, info); got != recorded {
+		t.Fatalf("policy version changed within a request: %q -> %q", recorded, got)
+	}
+}
@@ -0,0 +1,39 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageRawOutputHeader = "X-New-Api-Raw-Output"
+
+// parseImageRawOutput 客户端通过 X-New-Api-Raw-Output: true 请求上游原始输出。需要运营方开启 RawOutputEnabled，
+// 且令牌由管理员授予 ImageRawOutputAllowed
+func parseImageRawOutput(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	if !strings.EqualFold(strings.TrimSpace(c.GetHeader(imageRawOutputHeader)), "true") {
+		return nil
+	}
+	if !model_setting.GetImageSettings().RawOutputEnabled {
+		return types.NewErrorWithStatusCode(errors.New("raw output is disabled"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry())
+	}
+	if !info.TokenSetting.ImageRawOutputAllowed {
+		return types.NewErrorWithStatusCode(errors.New("raw output is not allowed for this token"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry())
+	}
+	c.Set("image_raw_output", true)
+	logger.LogInfo(c, fmt.Sprintf("image post-processing bypassed by client, mandatory steps: %v", model_setting.GetImageSettings().MandatoryPostProcessSteps))
+	return nil
+}
+
+// isImagePostProcessSkipped 客户端请求原始输出且该步骤不是运营方强制的步骤时跳过
+func isImagePostProcessSkipped(c *gin.Context, step string) bool {
+	return c.GetBool("image_raw_output") && !model_setting.GetImageSettings().IsPostProcessStepMandatory(step)
+}
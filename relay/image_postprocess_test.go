@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// withImageSettings 修改全局图片设置，测试结束后还原
+func withImageSettings(t *testing.T, update func(settings *model_setting.ImageSettings)) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	update(settings)
+	t.Cleanup(func() { *settings = previous })
+}
+
+func TestParseImageRawOutputRequiresOperatorSetting(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.RawOutputEnabled = false
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set(imageRawOutputHeader, "true")
+	info := &relaycommon.RelayInfo{TokenSetting: dto.TokenSetting{ImageRawOutputAllowed: true}}
+
+	err := parseImageRawOutput(c, info)
+	if err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("raw output must be refused while RawOutputEnabled is off, got %v", err)
+	}
+	if c.GetBool("image_raw_output") {
+		t.Fatal("raw output flag was set")
+	}
+}
+
+func TestParseImageRawOutputRequiresTokenGrant(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.RawOutputEnabled = true
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set(imageRawOutputHeader, "true")
+
+	err := parseImageRawOutput(c, &relaycommon.RelayInfo{})
+	if err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("raw output must be refused for tokens without the grant, got %v", err)
+	}
+}
+
+func TestRawOutputKeepsMandatorySteps(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.RawOutputEnabled = true
+		settings.MandatoryPostProcessSteps = []string{model_setting.ImagePostProcessNsfwBlur, model_setting.ImagePostProcessMaxOutput}
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set(imageRawOutputHeader, "true")
+	info := &relaycommon.RelayInfo{TokenSetting: dto.TokenSetting{ImageRawOutputAllowed: true}}
+
+	if err := parseImageRawOutput(c, info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if isImagePostProcessSkipped(c, model_setting.ImagePostProcessNsfwBlur) {
+		t.Fatal("nsfw_blur is mandatory and must not be skipped")
+	}
+	if isImagePostProcessSkipped(c, model_setting.ImagePostProcessMaxOutput) {
+		t.Fatal("max_output is mandatory and must not be skipped")
+	}
+	if !isImagePostProcessSkipped(c, model_setting.ImagePostProcessStaticFrame) {
+		t.Fatal("optional static_frame step should be skipped for raw output")
+	}
+}
+
+func TestDefaultMandatoryPostProcessStepsIncludeMaxOutput(t *testing.T) {
+	settings := model_setting.GetImageSettings()
+	if settings.RawOutputEnabled {
+		t.Fatal("raw output must be disabled by default")
+	}
+	if !settings.IsPostProcessStepMandatory(model_setting.ImagePostProcessMaxOutput) {
+		t.Fatal("max_output should be mandatory by default")
+	}
+}
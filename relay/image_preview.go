@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imagePreviewSentKey 本次请求已经返回过预览，换渠道重试时不再重复发送
+const imagePreviewSentKey = "image_preview_sent"
+
+// imagePromptStopWords 判断提示词是否相近时忽略的虚词
+var imagePromptStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "and": true, "with": true, "in": true, "on": true,
+}
+
+// imagePreviewResponse 预览事件，preview 恒为 true，客户端应在收到完整结果后替换
+type imagePreviewResponse struct {
+	Object  string          `json:"object"`
+	Preview bool            `json:"preview"`
+	Created int64           `json:"created"`
+	Data    []dto.ImageData `json:"data"`
+}
+
+// imageSimilarResultKey 由用户、渠道类型、API 类型、上游模型与归一化后的提示词计算相似请求键，尺寸、张数等参数不参与，
+// 未开启预览或不是生成请求时返回空
+func imageSimilarResultKey(info *relaycommon.RelayInfo, request *dto.ImageRequest) string {
+	settings := model_setting.GetImageSettings()
+	if !settings.ResultCacheEnabled || !settings.ResultCachePreviewEnabled || info.RelayMode != relayconstant.RelayModeImagesGenerations {
+		return ""
+	}
+	prompt := normalizeImagePromptWords(request.Prompt)
+	if prompt == "" {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "user:%d\nchannel_type:%d\napi_type:%d\nmodel:%s\nprompt:%s\n", info.UserId, info.ChannelType, info.ApiType, info.UpstreamModelName, prompt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeImagePromptWords 转为小写并按字母数字切分，去掉虚词后去重排序，忽略标点与词序的差异
+func normalizeImagePromptWords(prompt string) string {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words = slices.DeleteFunc(words, func(word string) bool {
+		return imagePromptStopWords[word]
+	})
+	slices.Sort(words)
+	return strings.Join(slices.Compact(words), " ")
+}
+
+// sendImageCachedPreview 客户端接受 SSE 且相似请求的结果仍在缓存中时，立即以 SSE 返回其缩略图作为预览。
+// 之后的完整结果与错误都作为 SSE 事件返回；预览不计入缓存命中，计费只按实际生成进行
+func sendImageCachedPreview(c *gin.Context, similarKey string) {
+	if similarKey == "" || c.GetBool(imagePreviewSentKey) || !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		return
+	}
+	entry := service.LoadSimilarImageResult(similarKey)
+	if entry == nil {
+		return
+	}
+	preview, ok := buildImagePreview(c, entry)
+	if !ok {
+		return
+	}
+	if !c.GetBool(ImageKeepAliveStartedKey) {
+		helper.SetEventStreamHeaders(c)
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Set(ImageKeepAliveStartedKey, true)
+	}
+	c.Set(imagePreviewSentKey, true)
+	if err := helper.ObjectData(c, preview); err != nil {
+		logger.LogWarn(c, "send cached image preview failed: "+err.Error())
+		return
+	}
+	logger.LogInfo(c, fmt.Sprintf("sent cached image preview, key: %s", similarKey[:16]))
+}
+
+// buildImagePreview 将缓存结果缩小为预览，URL 形式的结果原样返回；无法解析或没有图片时 ok 为 false
+func buildImagePreview(c *gin.Context, entry *service.ImageResultCacheEntry) (preview imagePreviewResponse, ok bool) {
+	var cached dto.ImageResponse
+	if err := common.Unmarshal(entry.Body, &cached); err != nil {
+		return preview, false
+	}
+	maxSize := model_setting.GetImageSettings().ResultCachePreviewMaxSize
+	preview = imagePreviewResponse{Object: "image.preview", Preview: true, Created: time.Now().Unix()}
+	for _, data := range cached.Data {
+		switch {
+		case data.B64Json != "":
+			thumbnail, _, err := service.DownscaleBase64Image(data.B64Json, maxSize, maxSize)
+			if err != nil {
+				logger.LogWarn(c, "build cached image preview failed: "+err.Error())
+				continue
+			}
+			preview.Data = append(preview.Data, dto.ImageData{B64Json: thumbnail})
+		case data.Url != "":
+			preview.Data = append(preview.Data, dto.ImageData{Url: data.Url})
+		}
+	}
+	return preview, len(preview.Data) > 0
+}
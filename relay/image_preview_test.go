@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheImagePreviewSource 模拟一次已完成的生成，512x512 的结果写入结果缓存
+func cacheImagePreviewSource(t *testing.T, prompt string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 512, 512))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	body, _ := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{{B64Json: base64.StdEncoding.EncodeToString(buf.Bytes())}}})
+	request := &dto.ImageRequest{Model: "gpt-image-1", Prompt: prompt, Size: "1024x1024"}
+	info := newImageTestInfo(infoUserId(7), infoRelayMode(relayconstant.RelayModeImagesGenerations), infoUpstreamModel("gpt-image-1"))
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	httpResp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+	storeImageResultCache(c, imageResultCacheKey(c, info, request), imageSimilarResultKey(info, request), httpResp)
+}
+
+func enableImagePreview(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.ResultCacheEnabled = true
+		settings.ResultCacheTTLSeconds = 60
+		settings.ResultCachePreviewEnabled = true
+		settings.ResultCachePreviewMaxSize = 128
+	})
+}
+
+func TestCachedImagePreviewPrecedesFullResult(t *testing.T) {
+	enableImagePreview(t)
+	cacheImagePreviewSource(t, "A red fox in the snow.")
+
+	gin.SetMode(gin.TestMode)
+	writer := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(writer)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	c.Request.Header.Set("Accept", "text/event-stream")
+	info := newImageTestInfo(infoUserId(7), infoRelayMode(relayconstant.RelayModeImagesGenerations), infoUpstreamModel("gpt-image-1"))
+	// 提示词不同但相近，尺寸也不同，不会命中完整结果缓存
+	request := &dto.ImageRequest{Model: "gpt-image-1", Prompt: "snow, red fox", Size: "512x512"}
+	if lookupImageResultCache(c, imageResultCacheKey(c, info, request)) != nil {
+		t.Fatal("similar request must not hit the exact result cache")
+	}
+
+	sendImageCachedPreview(c, imageSimilarResultKey(info, request))
+	full := []byte(`{"created":1,"data":[{"url":"https://cdn.example.com/full.png"}]}`)
+	flushImageResponse(c, newImageResponseRecorder(c.Writer), full)
+
+	if contentType := writer.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", contentType)
+	}
+	var events []string
+	for _, event := range strings.Split(strings.TrimSpace(writer.Body.String()), "\n\n") {
+		events = append(events, strings.TrimPrefix(event, "data: "))
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want preview then full result: %q", len(events), writer.Body.String())
+	}
+	var preview imagePreviewResponse
+	if err := common.UnmarshalJsonStr(events[0], &preview); err != nil {
+		t.Fatalf("decode preview event %q: %v", events[0], err)
+	}
+	if !preview.Preview || preview.Object != "image.preview" || len(preview.Data) != 1 {
+		t.Fatalf("first event is not a flagged preview: %+v", preview)
+	}
+	thumbnail, err := base64.StdEncoding.DecodeString(preview.Data[0].B64Json)
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil || config.Width != 128 || config.Height != 128 {
+		t.Fatalf("thumbnail is %dx%d (%v), want 128x128", config.Width, config.Height, err)
+	}
+	if events[1] != string(full) {
+		t.Fatalf("second event = %q, want the full result", events[1])
+	}
+	// 预览不算缓存命中，完整生成按原价计费
+	if c.GetBool(ImageResultCacheHitKey) {
+		t.Fatal("preview marked the request as a result cache hit")
+	}
+}
+
+func TestCachedImagePreviewRequiresEventStreamClient(t *testing.T) {
+	enableImagePreview(t)
+	cacheImagePreviewSource(t, "a lighthouse at dusk")
+
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	request := &dto.ImageRequest{Model: "gpt-image-1", Prompt: "lighthouse at dusk"}
+	sendImageCachedPreview(c, imageSimilarResultKey(newImageTestInfo(infoUserId(7), infoRelayMode(relayconstant.RelayModeImagesGenerations), infoUpstreamModel("gpt-image-1")), request))
+	if c.GetBool(ImageKeepAliveStartedKey) || c.Writer.Written() {
+		t.Fatal("preview was sent to a client that does not accept SSE")
+	}
+}
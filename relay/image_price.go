@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyImageSizePriceTier 按次计费时按尺寸/品质价格档位重新折算价格，在计费前调用。
+// 模型配置了档位但未包含当前尺寸时沿用内置倍率并记录警告，便于管理员补全配置。
+// 返回命中的档位倍率，未命中时返回 0
+func applyImageSizePriceTier(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) float64 {
+	if !info.PriceData.UsePrice {
+		return 0
+	}
+	multiplier, found, configured := model_setting.GetImageSettings().GetImageSizePriceTier(info.OriginModelName, request.Size, request.Quality)
+	if !configured {
+		return 0
+	}
+	if !found || multiplier <= 0 {
+		logger.LogWarn(c, fmt.Sprintf("no size price tier for model %s, size %s, quality %s, fallback to default image ratio", info.OriginModelName, request.Size, request.Quality))
+		return 0
+	}
+	oldPriceRatio := request.GetTokenCountMeta().ImagePriceRatio
+	if oldPriceRatio == 0 {
+		return 0
+	}
+	n := max(request.N, 1)
+	info.PriceData.ModelPrice = info.PriceData.ModelPrice / oldPriceRatio * multiplier * float64(n)
+	return multiplier
+}
+
+// applyImagePartialBatch 拆分后的子请求部分失败且按配置返回了部分图片时，按次计费只收取实际返回的张数。
+// 返回实际返回的张数，未发生部分失败时 ok 为 false
+func applyImagePartialBatch(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) (count int, ok bool) {
+	value, ok := c.Get("image_partial_count")
+	if !ok {
+		return 0, false
+	}
+	count, _ = value.(int)
+	if info.PriceData.UsePrice && request.N > 0 {
+		info.PriceData.ModelPrice = info.PriceData.ModelPrice * float64(count) / float64(request.N)
+	}
+	return count, true
+}
+
+// applyImageReturnedCount 上游实际返回的图片少于请求张数（例如部分图片被内容策略过滤）时，按次计费只收取实际返回的张数，
+// 预扣的差额在结算时退还。拆分请求的部分失败由 applyImagePartialBatch 处理。
+// 返回实际返回的张数，未少返时 ok 为 false
+func applyImageReturnedCount(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) (count int, ok bool) {
+	if _, partial := c.Get("image_partial_count"); partial {
+		return 0, false
+	}
+	value, exists := c.Get("image_returned_count")
+	if !exists || request.N == 0 {
+		return 0, false
+	}
+	count, _ = value.(int)
+	if count >= int(request.N) {
+		return 0, false
+	}
+	if count == 0 {
+		logger.LogError(c, fmt.Sprintf("upstream returned HTTP 200 with no images for model %s on channel #%d, requested %d, refund in full", info.OriginModelName, info.ChannelId, request.N))
+	}
+	if info.PriceData.UsePrice {
+		info.PriceData.ModelPrice = info.PriceData.ModelPrice * float64(count) / float64(request.N)
+	}
+	return count, true
+}
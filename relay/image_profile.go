@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageProfileField = "profile"
+
+// popImageStringField 读取客户端传入的网关控制字段，并从请求中移除该字段，避免透传到上游
+func popImageStringField(c *gin.Context, request *dto.ImageRequest, field string) (string, error) {
+	value := ""
+	if raw, ok := request.Extra[field]; ok {
+		if err := common.Unmarshal(raw, &value); err != nil {
+			return "", fmt.Errorf("invalid %s field: %w", field, err)
+		}
+		delete(request.Extra, field)
+	}
+	if mf := c.Request.MultipartForm; mf != nil {
+		if values, ok := mf.Value[field]; ok {
+			if value == "" && len(values) > 0 {
+				value = values[0]
+			}
+			delete(mf.Value, field)
+		}
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// resolveImageProfile 将客户端选择的档位展开为渠道配置的具体参数，
+// 展开后的尺寸/品质/张数会重新参与按次计费的价格计算
+func resolveImageProfile(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	name, err := popImageStringField(c, request, imageProfileField)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if name == "" {
+		return nil
+	}
+	profile, ok := info.ChannelSetting.ImageProfiles[name]
+	if !ok {
+		available := make([]string, 0, len(info.ChannelSetting.ImageProfiles))
+		for k := range info.ChannelSetting.ImageProfiles {
+			available = append(available, k)
+		}
+		sort.Strings(available)
+		return types.NewErrorWithStatusCode(fmt.Errorf("unknown image profile %q, available profiles: %s", name, strings.Join(available, ", ")), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	oldPriceRatio := request.GetTokenCountMeta().ImagePriceRatio
+	if profile.Model != "" {
+		request.Model = profile.Model
+		info.UpstreamModelName = profile.Model
+	}
+	if profile.Size != "" {
+		request.Size = profile.Size
+	}
+	if profile.Quality != "" {
+		request.Quality = profile.Quality
+	}
+	if profile.N > 0 {
+		request.N = profile.N
+	}
+	if len(profile.Params) > 0 && request.Extra == nil {
+		request.Extra = make(map[string]json.RawMessage)
+	}
+	for k, v := range profile.Params {
+		raw, err := common.Marshal(v)
+		if err != nil {
+			return types.NewError(fmt.Errorf("invalid param %q in image profile %q: %w", k, name, err), types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+		request.Extra[k] = raw
+	}
+	syncImageProfileForm(c, request, profile)
+
+	repriceImageRequest(info, request, oldPriceRatio)
+	logger.LogInfo(c, fmt.Sprintf("image profile %q resolved on channel #%d: model %s, size %s, quality %s, n %d", name, info.ChannelId, request.Model, request.Size, request.Quality, request.N))
+	return nil
+}
+
+// repriceImageRequest 按次计费时价格已包含原始参数的尺寸/品质倍率，参数被改写后按新参数重新折算
+func repriceImageRequest(info *relaycommon.RelayInfo, request *dto.ImageRequest, oldPriceRatio float64) {
+	newPriceRatio := request.GetTokenCountMeta().ImagePriceRatio
+	if info.PriceData.UsePrice && oldPriceRatio != 0 && newPriceRatio != oldPriceRatio {
+		info.PriceData.ModelPrice = info.PriceData.ModelPrice / oldPriceRatio * newPriceRatio
+	}
+}
+
+// syncImageProfileForm 编辑接口以表单转发，需要同步更新表单中的参数
+func syncImageProfileForm(c *gin.Context, request *dto.ImageRequest, profile dto.ImageProfileSetting) {
+	mf := c.Request.MultipartForm
+	if mf == nil {
+		return
+	}
+	if profile.Model != "" {
+		mf.Value["model"] = []string{request.Model}
+	}
+	if profile.Size != "" {
+		mf.Value["size"] = []string{request.Size}
+	}
+	if profile.Quality != "" {
+		mf.Value["quality"] = []string{request.Quality}
+	}
+	if profile.N > 0 {
+		mf.Value["n"] = []string{strconv.FormatUint(uint64(request.N), 10)}
+	}
+	for k, v := range profile.Params {
+		if s, ok := v.(string); ok {
+			mf.Value[k] = []string{s}
+		} else {
+			mf.Value[k] = []string{fmt.Sprintf("%v", v)}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+var testImageProfiles = map[string]dto.ImageProfileSetting{
+	"draft": {Size: "256x256"},
+	"hd":    {Size: "1792x1024", Quality: "hd"},
+	"batch": {N: 4},
+	"sdxl":  {Model: "sdxl-turbo", Params: map[string]any{"steps": 30, "sampler": "euler"}},
+}
+
+func newImageProfileTestRequest(profile string) *dto.ImageRequest {
+	return &dto.ImageRequest{
+		Model:   "dall-e-3",
+		Prompt:  "a cat",
+		Size:    "1024x1024",
+		Quality: "standard",
+		N:       1,
+		Extra:   map[string]json.RawMessage{imageProfileField: json.RawMessage(`"` + profile + `"`)},
+	}
+}
+
+func TestResolveEachImageProfile(t *testing.T) {
+	tests := []struct {
+		profile       string
+		model         string
+		size          string
+		quality       string
+		n             uint
+		price         float64
+		upstreamExtra map[string]string
+	}{
+		{profile: "draft", model: "dall-e-3", size: "256x256", quality: "standard", n: 1, price: 0.04 * 0.4},
+		{profile: "hd", model: "dall-e-3", size: "1792x1024", quality: "hd", n: 1, price: 0.04 * 2 * 1.5},
+		{profile: "batch", model: "dall-e-3", size: "1024x1024", quality: "standard", n: 4, price: 0.04 * 4},
+		// 切换到非 dall-e 模型后不再有尺寸倍率，价格不变
+		{profile: "sdxl", model: "sdxl-turbo", size: "1024x1024", quality: "standard", n: 1, price: 0.04,
+			upstreamExtra: map[string]string{"steps": "30", "sampler": `"euler"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+			info := newImageTestInfo(infoUpstreamModel("dall-e-3"), infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageProfiles: testImageProfiles}))
+			request := newImageProfileTestRequest(tt.profile)
+
+			if err := resolveImageProfile(c, info, request); err != nil {
+				t.Fatalf("resolve profile: %v", err)
+			}
+			if request.Model != tt.model || info.UpstreamModelName != tt.model || request.Size != tt.size || request.Quality != tt.quality || request.N != tt.n {
+				t.Fatalf("resolved model %s (upstream %s), size %s, quality %s, n %d", request.Model, info.UpstreamModelName, request.Size, request.Quality, request.N)
+			}
+			if math.Abs(info.PriceData.ModelPrice-tt.price) > 1e-9 {
+				t.Fatalf("model price = %v, want %v", info.PriceData.ModelPrice, tt.price)
+			}
+			if _, ok := request.Extra[imageProfileField]; ok {
+				t.Fatal("profile field forwarded upstream")
+			}
+			for key, want := range tt.upstreamExtra {
+				if got := string(request.Extra[key]); got != want {
+					t.Fatalf("param %s = %s, want %s", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveImageProfileSyncsEditForm(t *testing.T) {
+	c := newImageEditFormContext(t, "image")
+	c.Request.MultipartForm.Value[imageProfileField] = []string{"sdxl"}
+	info := newImageTestInfo(infoUpstreamModel("dall-e-3"), infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageProfiles: testImageProfiles}))
+	request := newImageProfileTestRequest("")
+	delete(request.Extra, imageProfileField)
+
+	if err := resolveImageProfile(c, info, request); err != nil {
+		t.Fatalf("resolve profile: %v", err)
+	}
+	form := c.Request.MultipartForm.Value
+	if form["model"][0] != "sdxl-turbo" || form["steps"][0] != "30" || form["sampler"][0] != "euler" {
+		t.Fatalf("edit form not updated: %v", form)
+	}
+	if _, ok := form[imageProfileField]; ok {
+		t.Fatal("profile field forwarded upstream")
+	}
+}
+
+func TestResolveUnknownImageProfile(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	err := resolveImageProfile(c, newImageTestInfo(infoUpstreamModel("dall-e-3"), infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageProfiles: testImageProfiles})), newImageProfileTestRequest("ultra"))
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown profile err = %v, want 400", err)
+	}
+	if !strings.Contains(err.Error(), "available profiles: batch, draft, hd, sdxl") {
+		t.Fatalf("error does not list the available profiles: %v", err)
+	}
+
+	// 未选择档位时请求保持不变
+	request := newImageProfileTestRequest("")
+	delete(request.Extra, imageProfileField)
+	if err = resolveImageProfile(c, newImageTestInfo(infoUpstreamModel("dall-e-3"), infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageProfiles: testImageProfiles})), request); err != nil || request.Size != "1024x1024" {
+		t.Fatalf("request without profile changed: %v, size %s", err, request.Size)
+	}
+}
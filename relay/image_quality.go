@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyImageQualityMapping 按渠道配置将客户端的 quality 映射为上游使用的取值，
+// 配置了映射但请求的 quality 不在映射中时返回 400；未指定 quality 时使用映射中的 "default"（如有）
+func applyImageQualityMapping(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	mapping := info.ChannelSetting.ImageQualityMapping
+	if len(mapping) == 0 {
+		return nil
+	}
+	quality := strings.TrimSpace(request.Quality)
+	key := quality
+	if key == "" {
+		key = "default"
+	}
+	mapped, ok := mapping[key]
+	if !ok {
+		if quality == "" {
+			return nil
+		}
+		supported := make([]string, 0, len(mapping))
+		for k := range mapping {
+			supported = append(supported, k)
+		}
+		sort.Strings(supported)
+		return types.NewErrorWithStatusCode(fmt.Errorf("quality %q is not supported on this channel, supported values: %s", quality, strings.Join(supported, ", ")), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if mapped == quality {
+		return nil
+	}
+	oldPriceRatio := request.GetTokenCountMeta().ImagePriceRatio
+	request.Quality = mapped
+	if mf := c.Request.MultipartForm; mf != nil {
+		mf.Value["quality"] = []string{mapped}
+	}
+	repriceImageRequest(info, request, oldPriceRatio)
+	c.Set("image_quality_mapped", true)
+	return nil
+}
@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestImageQualityMappingWithCustomMapping(t *testing.T) {
+	mapping := map[string]string{"low": "standard", "standard": "standard", "high": "hd", "default": "standard"}
+	tests := []struct {
+		quality string
+		want    string
+		price   float64
+		mapped  bool
+	}{
+		{quality: "high", want: "hd", price: 0.08, mapped: true},
+		{quality: " low ", want: "standard", price: 0.04, mapped: true},
+		{quality: "", want: "standard", price: 0.04, mapped: true},
+		// 映射结果与请求相同时不做改写
+		{quality: "standard", want: "standard", price: 0.04},
+	}
+	for _, tt := range tests {
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		info := newImageTestInfo(infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageQualityMapping: mapping}))
+		request := &dto.ImageRequest{Model: "dall-e-3", Size: "1024x1024", Quality: tt.quality, N: 1}
+
+		if err := applyImageQualityMapping(c, info, request); err != nil {
+			t.Fatalf("quality %q: %v", tt.quality, err)
+		}
+		if request.Quality != tt.want || c.GetBool("image_quality_mapped") != tt.mapped {
+			t.Fatalf("quality %q mapped to %q (mapped %v), want %q", tt.quality, request.Quality, c.GetBool("image_quality_mapped"), tt.want)
+		}
+		// 按次计费的价格按映射后的品质重新折算
+		if math.Abs(info.PriceData.ModelPrice-tt.price) > 1e-9 {
+			t.Fatalf("quality %q: model price = %v, want %v", tt.quality, info.PriceData.ModelPrice, tt.price)
+		}
+	}
+}
+
+func TestImageQualityMappingRejectsUnmappedQuality(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	request := &dto.ImageRequest{Model: "gpt-image-1", Quality: "ultra"}
+	err := applyImageQualityMapping(c, newImageTestInfo(infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageQualityMapping: map[string]string{"low": "fast", "high": "quality"}})), request)
+	if err == nil || err.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "supported values: high, low") {
+		t.Fatalf("unmapped quality: %v, want 400 with the supported values", err)
+	}
+
+	// 没有 default 时未指定 quality 的请求保持不变；渠道未配置映射时不做处理
+	request = &dto.ImageRequest{Model: "gpt-image-1"}
+	if err = applyImageQualityMapping(c, newImageTestInfo(infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageQualityMapping: map[string]string{"high": "quality"}})), request); err != nil || request.Quality != "" {
+		t.Fatalf("request without quality: %q, %v", request.Quality, err)
+	}
+	request = &dto.ImageRequest{Model: "gpt-image-1", Quality: "ultra"}
+	if err = applyImageQualityMapping(c, newImageTestInfo(infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{})), request); err != nil || request.Quality != "ultra" {
+		t.Fatalf("channel without mapping changed quality: %q, %v", request.Quality, err)
+	}
+}
+
+func TestImageQualityMappingUpdatesEditForm(t *testing.T) {
+	c := newImageEditFormContext(t, "image")
+	c.Request.MultipartForm.Value["quality"] = []string{"high"}
+	request := &dto.ImageRequest{Model: "gpt-image-1", Quality: "high"}
+	if err := applyImageQualityMapping(c, newImageTestInfo(infoModelPrice(0.04), infoChannelSetting(dto.ChannelSettings{ImageQualityMapping: map[string]string{"high": "quality"}})), request); err != nil {
+		t.Fatalf("map edit quality: %v", err)
+	}
+	if got := c.Request.MultipartForm.Value["quality"]; len(got) != 1 || got[0] != "quality" {
+		t.Fatalf("edit form quality = %v, want [quality]", got)
+	}
+}
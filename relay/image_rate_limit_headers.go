@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageRetryAfterKey 上游 429 响应中 retry-after 指定的等待时间，切换渠道重试前至少等待该时间
+const ImageRetryAfterKey = "image_retry_after"
+
+// forwardImageUpstreamHeaders 将允许列表内的上游响应头复制到客户端响应，必须在写入响应体之前调用。
+// 每次上游请求先清除上一次尝试复制的响应头，避免失败渠道的限流信息出现在最终响应中
+func forwardImageUpstreamHeaders(c *gin.Context, httpResp *http.Response) {
+	c.Set(ImageRetryAfterKey, time.Duration(0))
+	if httpResp == nil || c.Writer.Written() {
+		return
+	}
+	header := c.Writer.Header()
+	for _, name := range model_setting.GetImageSettings().ForwardResponseHeaders {
+		header.Del(name)
+		if value := httpResp.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	if httpResp.StatusCode == http.StatusTooManyRequests {
+		maxWait := time.Duration(model_setting.GetImageSettings().RetryAfterMaxSeconds) * time.Second
+		if retryAfter := service.ParseRetryAfter(httpResp.Header.Get("Retry-After"), time.Now()); retryAfter > 0 && maxWait > 0 {
+			c.Set(ImageRetryAfterKey, min(retryAfter, maxWait))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageRawResponseKey 客户端要求直接返回图片二进制而不是 JSON
+const imageRawResponseKey = "image_raw_response"
+
+// applyRawImageResponse 单张、非流式请求且 Accept 为图片类型（不含 application/json）时直接返回图片二进制，
+// 多张图片或接受 JSON 时保持原有的 JSON 响应
+func applyRawImageResponse(c *gin.Context, request *dto.ImageRequest) {
+	if request.N > 1 || isStreamImageRequest(request) || wantsMultipartImageResponse(c) {
+		return
+	}
+	wantsImage := false
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return
+		}
+		if strings.HasPrefix(mediaType, "image/") {
+			wantsImage = true
+		}
+	}
+	if wantsImage {
+		c.Set(imageRawResponseKey, true)
+	}
+}
+
+// buildRawImageResponse 取响应中的第一张图片，base64 直接解码，url 下载后返回，并按文件头确定 Content-Type
+func buildRawImageResponse(c *gin.Context, body []byte) ([]byte, string, error) {
+	var response struct {
+		Data []map[string]json.RawMessage `json:"data"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil, "", err
+	}
+	if len(response.Data) == 0 {
+		return nil, "", errors.New("no image in response")
+	}
+	var b64, url string
+	_ = common.Unmarshal(response.Data[0]["b64_json"], &b64)
+	_ = common.Unmarshal(response.Data[0]["url"], &url)
+	var data []byte
+	var err error
+	switch {
+	case b64 != "":
+		data, err = base64.StdEncoding.DecodeString(b64)
+	case url != "":
+		settings := model_setting.GetImageSettings()
+		timeout := time.Duration(settings.ResponseFormatTimeoutSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		data, err = service.DownloadImage(ctx, url, int64(settings.ResponseFormatMaxBytes))
+	default:
+		return nil, "", errors.New("image has neither b64_json nor url")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, service.ImageContentType(c, data), nil
+}
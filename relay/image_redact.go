@@ -0,0 +1,56 @@
+package relay
+
+import (
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageRedactPromptsKey = "image_redact_prompts"
+
+// rememberImagePrompt 开启 redact_prompts 时记录需要脱敏的提示词：客户端原文、摘要或截断后的提示词，以及追加渠道固定文本后发往上游的提示词
+func rememberImagePrompt(c *gin.Context, prompt string) {
+	if !model_setting.GetGlobalSettings().RedactPrompts || prompt == "" {
+		return
+	}
+	prompts := c.GetStringSlice(imageRedactPromptsKey)
+	for _, item := range prompts {
+		if item == prompt {
+			return
+		}
+	}
+	c.Set(imageRedactPromptsKey, append(prompts, prompt))
+}
+
+// redactImagePromptText 未开启 redact_prompts 时原样返回
+func redactImagePromptText(c *gin.Context, text string) string {
+	if !model_setting.GetGlobalSettings().RedactPrompts {
+		return text
+	}
+	return service.RedactPromptText(text, c.GetStringSlice(imageRedactPromptsKey)...)
+}
+
+// redactImagePromptBody 脱敏请求体或响应体中的提示词字段，用于调试日志与上游审计记录
+func redactImagePromptBody(c *gin.Context, data []byte) []byte {
+	if !model_setting.GetGlobalSettings().RedactPrompts {
+		return data
+	}
+	return service.RedactPromptJSON(data, c.GetStringSlice(imageRedactPromptsKey)...)
+}
+
+// redactImageError 上游错误信息可能回显提示词，在错误被记录与返回之前脱敏，错误码、状态码与重试选项不变
+func redactImageError(c *gin.Context, newAPIError *types.NewAPIError) *types.NewAPIError {
+	if newAPIError == nil || !model_setting.GetGlobalSettings().RedactPrompts {
+		return newAPIError
+	}
+	if newAPIError.Err != nil {
+		newAPIError.SetMessage(redactImagePromptText(c, newAPIError.Err.Error()))
+	}
+	if openAIError, ok := newAPIError.RelayError.(types.OpenAIError); ok {
+		openAIError.Message = redactImagePromptText(c, openAIError.Message)
+		newAPIError.RelayError = openAIError
+	}
+	return newAPIError
+}
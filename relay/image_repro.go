@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageReproBundleHeader = "X-New-Api-Repro-Bundle"
+
+// imageReproBundle 可复现性包：实际生效的请求参数、模型版本、种子与策略版本。
+// 不包含密钥、渠道地址与输入图片，Signature 为去掉签名字段后 JSON 的 HMAC-SHA256
+type imageReproBundle struct {
+	GenerationId  string            `json:"generation_id"`
+	CreatedAt     int64             `json:"created_at"`
+	Model         string            `json:"model"`
+	ModelVersion  string            `json:"model_version"`
+	Seed          json.RawMessage   `json:"seed,omitempty"`
+	PolicyVersion string            `json:"policy_version"`
+	Request       map[string]string `json:"request"`
+	SignatureAlg  string            `json:"signature_alg"`
+	Signature     string            `json:"signature,omitempty"`
+}
+
+// parseImageReproBundle 客户端通过 X-New-Api-Repro-Bundle: true 请求可复现性包，仅允许开启 ImageReproBundleAllowed 的令牌使用
+func parseImageReproBundle(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	if !strings.EqualFold(strings.TrimSpace(c.GetHeader(imageReproBundleHeader)), "true") {
+		return nil
+	}
+	if !info.TokenSetting.ImageReproBundleAllowed {
+		return types.NewErrorWithStatusCode(errors.New("reproducibility bundle is not allowed for this token"), types.ErrorCodeAccessDenied, http.StatusForbidden, types.ErrOptionWithSkipRetry())
+	}
+	if model_setting.GetImageSettings().ReproBundleSecret == "" {
+		return types.NewErrorWithStatusCode(errors.New("reproducibility bundle is not configured on this server"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	c.Set("image_repro_bundle", true)
+	return nil
+}
+
+// snapshotImageReproRequest 在参数改写完成后、发往上游前记录实际生效的请求参数
+func snapshotImageReproRequest(c *gin.Context, request *dto.ImageRequest) {
+	if c.GetBool("image_repro_bundle") {
+		c.Set("image_repro_request", snapshotImageGenerationParams(request))
+	}
+}
+
+// attachImageReproBundle 生成成功后签名并保存可复现性包，下载地址写入响应的 new_api.repro_bundle_url
+func attachImageReproBundle(c *gin.Context, info *relaycommon.RelayInfo) {
+	value, ok := c.Get("image_repro_request")
+	if !ok {
+		return
+	}
+	params, _ := value.(map[string]string)
+	bundle := imageReproBundle{
+		GenerationId:  c.GetString(common.RequestIdKey),
+		CreatedAt:     time.Now().Unix(),
+		Model:         info.OriginModelName,
+		ModelVersion:  info.UpstreamModelName,
+		PolicyVersion: getImagePolicyVersion(c, info),
+		Request:       params,
+		SignatureAlg:  "hmac-sha256",
+	}
+	if seed, ok := params["seed"]; ok {
+		bundle.Seed = json.RawMessage(seed)
+	}
+	data, err := signImageReproBundle(&bundle, model_setting.GetImageSettings().ReproBundleSecret)
+	if err != nil {
+		logger.LogWarn(c, "build reproducibility bundle failed: "+err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	id, err := service.SaveImageWithRetry(ctx, data, "application/json", getImageStorageTTL(c, info), buildImageStorageMetadata(c, info))
+	if err != nil {
+		logger.LogWarn(c, "store reproducibility bundle failed: "+err.Error())
+		return
+	}
+	setImageResponseMeta(c, "repro_bundle_url", service.GetStoredImageUrl(id))
+	setImageResponseMeta(c, "repro_bundle_signature", bundle.Signature)
+}
+
+// signImageReproBundle 对去掉签名字段的 JSON 计算签名，返回带签名的完整 JSON。
+// 校验时去掉 signature 字段后按相同方式序列化并比对
+func signImageReproBundle(bundle *imageReproBundle, secret string) ([]byte, error) {
+	bundle.Signature = ""
+	payload, err := common.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("marshal bundle failed: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	bundle.Signature = hex.EncodeToString(mac.Sum(nil))
+	return common.Marshal(bundle)
+}
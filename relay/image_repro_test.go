@@ -0,0 +1,112 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+const imageReproTestSecret = "repro-test-secret"
+
+func TestImageReproBundleContentsAndSignature(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.ReproBundleSecret = imageReproTestSecret
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Header.Set(imageReproBundleHeader, "true")
+	c.Set(common.RequestIdKey, "req-repro-1")
+	info := newImageTestInfo(infoOriginModel("gpt-image-1"), infoUpstreamModel("gpt-image-1-2025-04-15"),
+		infoChannelEndpoint("https://upstream.example.com", "sk-channel-secret"), infoTokenSetting(dto.TokenSetting{ImageReproBundleAllowed: true}))
+	if err := parseImageReproBundle(c, info); err != nil {
+		t.Fatalf("parse repro bundle: %v", err)
+	}
+	snapshotImageReproRequest(c, &dto.ImageRequest{Model: "gpt-image-1", Prompt: "a lighthouse", Size: "1024x1024", N: 1,
+		Extra: map[string]json.RawMessage{"seed": json.RawMessage(`42`)}})
+	attachImageReproBundle(c, info)
+
+	meta, _ := c.Get(imageResponseMetaKey)
+	fields, _ := meta.(map[string]any)
+	url, _ := fields["repro_bundle_url"].(string)
+	if url == "" {
+		t.Fatalf("response meta %v has no repro_bundle_url", fields)
+	}
+	stored, err := service.GetImageStorage().Load(path.Base(url))
+	if err != nil {
+		t.Fatalf("load bundle from %s: %v", url, err)
+	}
+	t.Cleanup(func() { _ = service.GetImageStorage().Delete(path.Base(url)) })
+	if stored.ContentType != "application/json" {
+		t.Fatalf("bundle content type = %q", stored.ContentType)
+	}
+
+	// 不包含渠道密钥与地址
+	for _, secret := range []string{"sk-channel-secret", "upstream.example.com", imageReproTestSecret} {
+		if strings.Contains(string(stored.Data), secret) {
+			t.Fatalf("bundle leaks %q: %s", secret, stored.Data)
+		}
+	}
+	var bundle imageReproBundle
+	if err = common.Unmarshal(stored.Data, &bundle); err != nil {
+		t.Fatalf("decode bundle %s: %v", stored.Data, err)
+	}
+	if bundle.GenerationId != "req-repro-1" || bundle.Model != "gpt-image-1" || bundle.ModelVersion != "gpt-image-1-2025-04-15" || string(bundle.Seed) != "42" {
+		t.Fatalf("bundle = %+v", bundle)
+	}
+	if bundle.PolicyVersion == "" || bundle.PolicyVersion != getImagePolicyVersion(c, info) {
+		t.Fatalf("bundle policy version = %q, want %q", bundle.PolicyVersion, getImagePolicyVersion(c, info))
+	}
+	if bundle.Request["prompt"] != `"a lighthouse"` || bundle.Request["size"] != `"1024x1024"` || bundle.Request["seed"] != "42" {
+		t.Fatalf("bundle request = %v", bundle.Request)
+	}
+	if fields["repro_bundle_signature"] != bundle.Signature {
+		t.Fatalf("response signature %v differs from the bundle's %s", fields["repro_bundle_signature"], bundle.Signature)
+	}
+
+	// 按相同方式重新签名可以校验，修改任一字段或更换密钥后签名不再匹配
+	verify := func(bundle imageReproBundle, secret string) bool {
+		signature := bundle.Signature
+		if _, err := signImageReproBundle(&bundle, secret); err != nil {
+			t.Fatalf("sign bundle: %v", err)
+		}
+		return hmac.Equal([]byte(bundle.Signature), []byte(signature))
+	}
+	if !verify(bundle, imageReproTestSecret) {
+		t.Fatal("bundle signature does not verify")
+	}
+	tampered := bundle
+	tampered.Seed = json.RawMessage(`43`)
+	if verify(tampered, imageReproTestSecret) {
+		t.Fatal("tampered bundle still verifies")
+	}
+	if verify(bundle, "other-secret") {
+		t.Fatal("bundle verifies with another secret")
+	}
+}
+
+func TestImageReproBundleRequiresPrivilege(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.ReproBundleSecret = imageReproTestSecret
+	})
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err := parseImageReproBundle(c, newImageTestInfo()); err != nil || c.GetBool("image_repro_bundle") {
+		t.Fatalf("bundle enabled without the request header: %v", err)
+	}
+
+	c.Request.Header.Set(imageReproBundleHeader, "true")
+	if err := parseImageReproBundle(c, newImageTestInfo()); err == nil || err.StatusCode != http.StatusForbidden {
+		t.Fatalf("token without the privilege: %v", err)
+	}
+	model_setting.GetImageSettings().ReproBundleSecret = ""
+	if err := parseImageReproBundle(c, newImageTestInfo(infoTokenSetting(dto.TokenSetting{ImageReproBundleAllowed: true}))); err == nil || err.GetErrorCode() != types.ErrorCodeInvalidRequest {
+		t.Fatalf("server without a signing secret: %v", err)
+	}
+}
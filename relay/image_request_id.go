@@ -0,0 +1,32 @@
+package relay
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageRequestIdHeader 图片接口的请求 ID 响应头，客户端可以通过同名请求头指定
+const ImageRequestIdHeader = "X-Request-Id"
+
+// imageRequestIdKey 本次请求已按客户端请求头确定请求 ID，换渠道重试时不再重复处理
+const imageRequestIdKey = "image_request_id_applied"
+
+var imageRequestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{8,128}$`)
+
+// applyImageRequestId 客户端提供了合法的 X-Request-Id（8-128 位字母、数字或 ._:-）时沿用，否则使用服务端生成的请求 ID。
+// 该 ID 同时用于日志、消费记录与取消接口，并写入响应头便于客户端反馈问题时关联
+func applyImageRequestId(c *gin.Context) {
+	if !c.GetBool(imageRequestIdKey) {
+		c.Set(imageRequestIdKey, true)
+		if id := c.GetHeader(ImageRequestIdHeader); imageRequestIdPattern.MatchString(id) {
+			c.Set(common.RequestIdKey, id)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), common.RequestIdKey, id))
+			c.Header(common.RequestIdKey, id)
+		}
+	}
+	c.Header(ImageRequestIdHeader, c.GetString(common.RequestIdKey))
+}
@@ -0,0 +1,235 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	imageCostQuotaHeader = "X-New-Api-Cost-Quota"
+	imageCostUsdHeader   = "X-New-Api-Cost-Usd"
+
+	// 网关附加信息写入响应的 new_api 字段
+	imageResponseNamespace = "new_api"
+	imageResponseMetaKey   = "image_response_meta"
+)
+
+// imageResponseRecorder 暂存适配器写出的图片响应，便于在返回客户端前对响应进行检查和后处理
+type imageResponseRecorder struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// upstream 后处理之前的上游响应，仅在需要保留内容凭证时记录
+	upstream []byte
+}
+
+func newImageResponseRecorder(w gin.ResponseWriter) *imageResponseRecorder {
+	return &imageResponseRecorder{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		status:         http.StatusOK,
+	}
+}
+
+func (r *imageResponseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *imageResponseRecorder) WriteHeader(code int) {
+	if code > 0 {
+		r.status = code
+	}
+}
+
+func (r *imageResponseRecorder) WriteHeaderNow() {}
+
+func (r *imageResponseRecorder) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
+
+func (r *imageResponseRecorder) WriteString(s string) (int, error) {
+	return r.body.WriteString(s)
+}
+
+func (r *imageResponseRecorder) Status() int {
+	return r.status
+}
+
+func (r *imageResponseRecorder) Size() int {
+	return r.body.Len()
+}
+
+func (r *imageResponseRecorder) Written() bool {
+	return r.body.Len() > 0
+}
+
+func (r *imageResponseRecorder) Flush() {}
+
+// flushTo 将暂存的响应写入真实的 ResponseWriter
+func (r *imageResponseRecorder) flushTo(w gin.ResponseWriter, body []byte) {
+	for k, v := range r.header {
+		if k == "Content-Length" {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(r.status)
+	_, _ = w.Write(body)
+}
+
+// shouldRecordImageResponse 仅在需要对非流式响应做检查或后处理时才暂存响应
+func shouldRecordImageResponse(c *gin.Context, info *relaycommon.RelayInfo) bool {
+	if info.IsStream {
+		return false
+	}
+	return info.ChannelSetting.ImagePreferWebp || wantsMultipartImageResponse(c) || c.GetInt("image_upscale_factor") > 0 ||
+		info.TokenSetting.ImageExposeCost || info.TokenSetting.ImageServerTiming || info.ChannelSetting.ImageNsfwAction == dto.ImageNsfwActionBlur ||
+		shouldDownscaleImageOutput(info) || info.ChannelSetting.ImageStaticFrame != "" || shouldCompressImageResponse(c) ||
+		shouldCheckImageUrlExpiry() || model_setting.GetImageSettings().PolicyVersionEnabled || hasImageResponseMeta(c) ||
+		c.GetBool(ImageKeepAliveStartedKey) || requestedImageResponseFormat(info) != "" || c.GetString(imageRequestedOutputFormatKey) != "" ||
+		c.GetBool("image_repro_bundle") || shouldModerateImageResponse(info) || c.GetBool(imageRawResponseKey) ||
+		shouldSetImageEffectiveHeaders()
+}
+
+// processRecordedImageResponse 对暂存的图片响应进行后处理，返回最终写回客户端的响应体
+func processRecordedImageResponse(c *gin.Context, info *relaycommon.RelayInfo, recorder *imageResponseRecorder) ([]byte, *types.NewAPIError) {
+	body := recorder.body.Bytes()
+	if recorder.status == http.StatusOK {
+		if !isImagePostProcessSkipped(c, model_setting.ImagePostProcessNsfwBlur) {
+			body = blurNsfwImageResponse(c, body)
+		}
+		if maxWidth, maxHeight, ok := imageDownscaleLimit(info, !isImagePostProcessSkipped(c, model_setting.ImagePostProcessMaxOutput)); ok {
+			body = downscaleImageResponse(c, body, maxWidth, maxHeight)
+		}
+		if info.ChannelSetting.ImageStaticFrame != "" && !isImagePostProcessSkipped(c, model_setting.ImagePostProcessStaticFrame) {
+			body = staticFrameImageResponse(c, info, body)
+		}
+	}
+	if recorder.status == http.StatusOK && c.GetInt("image_upscale_factor") > 0 {
+		body = upscaleImageResponse(c, recorder, body)
+	}
+	// 保留内容凭证时不转换格式，凭证元数据只能写回原格式的图片
+	if recorder.status == http.StatusOK && info.ChannelSetting.ImagePreferWebp && !info.ChannelSetting.ImagePreserveContentCredentials {
+		body = convertPreferredImageFormat(c, info, recorder, body)
+	}
+	if recorder.status == http.StatusOK && recorder.upstream != nil {
+		body = restoreImageContentCredentials(c, recorder.upstream, body)
+	}
+	if recorder.status == http.StatusOK && requestedImageResponseFormat(info) != "" {
+		var newAPIError *types.NewAPIError
+		if body, newAPIError = convertImageResponseFormat(c, info, recorder, body); newAPIError != nil {
+			return nil, newAPIError
+		}
+	}
+	if info.ChannelSetting.ImagePreferWebp {
+		if format := detectImageResponseFormat(body); format != "" {
+			recorder.header.Set(imageFormatHeader, format)
+		}
+	}
+	if recorder.status == http.StatusOK && shouldCheckImageUrlExpiry() {
+		setImageUrlExpiryHeader(recorder, body)
+	}
+	if recorder.status == http.StatusOK {
+		if model_setting.GetImageSettings().PolicyVersionEnabled {
+			setImagePolicyMeta(c, info)
+		}
+		body = appendImageResponseMeta(c, body)
+	}
+	if recorder.status == http.StatusOK && c.GetBool(imageRawResponseKey) && !c.GetBool(ImageKeepAliveStartedKey) {
+		data, contentType, err := buildRawImageResponse(c, body)
+		if err == nil {
+			recorder.header.Set("Content-Type", contentType)
+			recorder.header.Del("Content-Encoding")
+			return data, nil
+		}
+		logger.LogWarn(c, "build raw image response failed, fallback to json: "+err.Error())
+	}
+	if recorder.status == http.StatusOK && wantsMultipartImageResponse(c) && !c.GetBool(ImageKeepAliveStartedKey) {
+		filenames := newImageFilenameAllocator(model_setting.GetImageSettings().MultipartFilenameTemplate, map[string]string{
+			"model":      info.OriginModelName,
+			"request_id": c.GetString(common.RequestIdKey),
+			"timestamp":  strconv.FormatInt(time.Now().Unix(), 10),
+		})
+		multipartBody, contentType, err := buildMultipartImageResponse(body, filenames)
+		if err == nil {
+			recorder.header.Set("Content-Type", contentType)
+			return multipartBody, nil
+		}
+		logger.LogWarn(c, "build multipart image response failed, fallback to json: "+err.Error())
+	}
+	return compressImageResponse(c, recorder, body), nil
+}
+
+// setImageResponseMeta 记录需要写入响应 new_api 字段的网关信息
+func setImageResponseMeta(c *gin.Context, key string, value any) {
+	meta, _ := c.Get(imageResponseMetaKey)
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		fields = make(map[string]any)
+		c.Set(imageResponseMetaKey, fields)
+	}
+	fields[key] = value
+}
+
+func hasImageResponseMeta(c *gin.Context) bool {
+	_, ok := c.Get(imageResponseMetaKey)
+	return ok
+}
+
+// appendImageResponseMeta 将网关信息写入 JSON 响应的 new_api 字段，与上游字段隔离
+func appendImageResponseMeta(c *gin.Context, body []byte) []byte {
+	meta, ok := c.Get(imageResponseMetaKey)
+	if !ok {
+		return body
+	}
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	fields := make(map[string]any)
+	// 适配器可能已经写入了 new_api 字段，合并而不是覆盖
+	if existing, ok := response[imageResponseNamespace]; ok {
+		_ = common.Unmarshal(existing, &fields)
+	}
+	for k, v := range meta.(map[string]any) {
+		fields[k] = v
+	}
+	raw, err := common.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	response[imageResponseNamespace] = raw
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+// setImageCostHeaders 返回本次请求实际扣除的额度（已包含分组倍率），必须在计费完成后调用
+func setImageCostHeaders(c *gin.Context, recorder *imageResponseRecorder) {
+	quota := c.GetInt("consumed_quota")
+	recorder.header.Set(imageCostQuotaHeader, strconv.Itoa(quota))
+	recorder.header.Set(imageCostUsdHeader, strconv.FormatFloat(float64(quota)/common.QuotaPerUnit, 'f', 6, 64))
+}
+
+// setImageServerTimingHeader 以 Server-Timing 格式返回请求转换、上游请求与响应处理的耗时（毫秒）
+func setImageServerTimingHeader(recorder *imageResponseRecorder, convert, upstream, process time.Duration) {
+	recorder.header.Set("Server-Timing", fmt.Sprintf("convert;dur=%.1f, upstream;dur=%.1f, process;dur=%.1f",
+		float64(convert.Microseconds())/1000, float64(upstream.Microseconds())/1000, float64(process.Microseconds())/1000))
+}
@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageStorageFallbackHeader 图片存储不可用、按 ResponseFormatStorageFallback 改为返回 b64_json 的图片数量
+const imageStorageFallbackHeader = "X-New-Api-Image-Storage-Fallback"
+
+var errImageStorageUnavailable = errors.New("image storage unavailable")
+
+// requestedImageResponseFormat 返回客户端原始请求的 response_format，未开启转换或未指定时返回空
+func requestedImageResponseFormat(info *relaycommon.RelayInfo) string {
+	if !model_setting.GetImageSettings().ResponseFormatConversionEnabled {
+		return ""
+	}
+	request, ok := info.Request.(*dto.ImageRequest)
+	if !ok {
+		return ""
+	}
+	switch request.ResponseFormat {
+	case "url", "b64_json":
+		return request.ResponseFormat
+	}
+	return ""
+}
+
+// convertImageResponseFormat 上游返回的图片格式与客户端要求不一致时转换：base64 转存后返回 url，url 下载后内联为 b64_json。
+// 单张转换失败时保留上游原始结果并记录警告；写入存储失败时按 ResponseFormatStorageFallback 返回 b64_json 并通过响应头告警，
+// 或返回错误（生成已成功，但错误会使本次请求退款）
+func convertImageResponseFormat(c *gin.Context, info *relaycommon.RelayInfo, recorder *imageResponseRecorder, body []byte) ([]byte, *types.NewAPIError) {
+	format := requestedImageResponseFormat(info)
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body, nil
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body, nil
+	}
+
+	settings := model_setting.GetImageSettings()
+	timeout := time.Duration(settings.ResponseFormatTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	maxBytes := int64(settings.ResponseFormatMaxBytes)
+
+	converted := 0
+	storageFallbacks := 0
+	for i, item := range items {
+		var b64, url string
+		_ = common.Unmarshal(item["b64_json"], &b64)
+		_ = common.Unmarshal(item["url"], &url)
+		var err error
+		switch {
+		case format == "url" && b64 != "" && url == "":
+			url, err = storeImageResponseData(ctx, c, info, b64, maxBytes)
+			if err == nil {
+				item["url"], err = common.Marshal(url)
+				delete(item, "b64_json")
+			}
+		case format == "b64_json" && url != "" && b64 == "":
+			var data []byte
+			data, err = service.DownloadImage(ctx, url, maxBytes)
+			if err == nil {
+				item["b64_json"], err = common.Marshal(base64.StdEncoding.EncodeToString(data))
+				delete(item, "url")
+			}
+		default:
+			continue
+		}
+		if errors.Is(err, errImageStorageUnavailable) {
+			if settings.ResponseFormatStorageFallback == model_setting.ImageStorageFallbackError {
+				return body, types.NewErrorWithStatusCode(err, types.ErrorCodeImageStorageUnavailable, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+			}
+			storageFallbacks++
+		}
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("convert image %d to %s failed, return upstream result: %s", i, format, err.Error()))
+			continue
+		}
+		converted++
+	}
+	if storageFallbacks > 0 {
+		recorder.header.Set(imageStorageFallbackHeader, strconv.Itoa(storageFallbacks))
+	}
+	if converted == 0 {
+		return body, nil
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body, nil
+	}
+	response["data"] = data
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body, nil
+	}
+	logger.LogInfo(c, fmt.Sprintf("converted %d images to response_format %s", converted, format))
+	return result, nil
+}
+
+// storeImageResponseData 将 base64 图片写入图片存储并返回对外地址
+func storeImageResponseData(ctx context.Context, c *gin.Context, info *relaycommon.RelayInfo, b64 string, maxBytes int64) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", fmt.Errorf("decode base64 image failed: %w", err)
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return "", fmt.Errorf("image size %d exceeds maximum allowed size of %d bytes", len(data), maxBytes)
+	}
+	id, err := service.SaveImageWithRetry(ctx, data, service.ImageContentType(c, data), getImageStorageTTL(c, info), buildImageStorageMetadata(c, info))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errImageStorageUnavailable, err.Error())
+	}
+	return service.GetStoredImageUrl(id), nil
+}
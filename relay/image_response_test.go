@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupBillingTestDB 使用内存 SQLite 存放用户、渠道与消费日志，测试结束后还原
+func setupBillingTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err = db.AutoMigrate(&model.User{}, &model.Channel{}, &model.Log{}); err != nil {
+		t.Fatalf("migrate billing tables: %v", err)
+	}
+	previousDB, previousLogDB, previousLogConsume := model.DB, model.LOG_DB, common.LogConsumeEnabled
+	model.DB, model.LOG_DB, common.LogConsumeEnabled = db, db, true
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB, common.LogConsumeEnabled = previousDB, previousLogDB, previousLogConsume
+	})
+	return db
+}
+
+func TestImageCostHeadersMatchBilledQuota(t *testing.T) {
+	db := setupBillingTestDB(t)
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := newImageTestInfo(infoChannelSetting(dto.ChannelSettings{ImageSlowRefund: &dto.ImageSlowRefundSetting{ThresholdSeconds: 10, RefundPercent: 30}}))
+	info.UserId, info.ChannelId = 1, 1
+	info.OriginModelName = "gpt-image-1"
+	info.StartTime = time.Now()
+	info.TokenSetting.ImageExposeCost = true
+	info.PriceData = types.PriceData{UsePrice: true, ModelPrice: 0.04, GroupRatioInfo: types.GroupRatioInfo{GroupRatio: 1.5}}
+	// 响应过慢退还 30%，返回的费用必须是退还后的实际扣费
+	billed := int(0.04 * common.QuotaPerUnit * 1.5 * 0.7)
+	info.FinalPreConsumedQuota = billed
+
+	resetImageSlowRefund(c)
+	applyImageSlowRefund(c, info, 15*time.Second)
+	postConsumeQuota(c, info, &dto.Usage{PromptTokens: 10, TotalTokens: 10}, "")
+	recorder := newImageResponseRecorder(c.Writer)
+	setImageCostHeaders(c, recorder)
+
+	var logged model.Log
+	if err := db.Where("type = ?", model.LogTypeConsume).First(&logged).Error; err != nil {
+		t.Fatalf("consume log not recorded: %v", err)
+	}
+	if logged.Quota != billed {
+		t.Fatalf("logged quota = %d, want %d", logged.Quota, billed)
+	}
+	if got := recorder.header.Get(imageCostQuotaHeader); got != strconv.Itoa(logged.Quota) {
+		t.Fatalf("%s = %q, billed quota %d", imageCostQuotaHeader, got, logged.Quota)
+	}
+	wantUsd := strconv.FormatFloat(float64(logged.Quota)/common.QuotaPerUnit, 'f', 6, 64)
+	if got := recorder.header.Get(imageCostUsdHeader); got != wantUsd {
+		t.Fatalf("%s = %q, want %q", imageCostUsdHeader, got, wantUsd)
+	}
+}
+
+// serverTimingPattern 匹配 Server-Timing 头中的各阶段，dur 为保留一位小数的毫秒数
+var serverTimingPattern = regexp.MustCompile(`^convert;dur=(\d+\.\d), upstream;dur=(\d+\.\d), process;dur=(\d+\.\d)$`)
+
+func TestImageServerTimingHeader(t *testing.T) {
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.TokenSetting.ImageServerTiming = true
+	gin.SetMode(gin.TestMode)
+	writer := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(writer)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	if !shouldRecordImageResponse(c, info) {
+		t.Fatal("response with Server-Timing enabled was not recorded")
+	}
+
+	recorder := newImageResponseRecorder(c.Writer)
+	setImageServerTimingHeader(recorder, 12345*time.Microsecond, 1500*time.Millisecond, 600*time.Microsecond)
+	recorder.flushTo(c.Writer, []byte(`{"data":[]}`))
+
+	header := writer.Header().Get("Server-Timing")
+	match := serverTimingPattern.FindStringSubmatch(header)
+	if match == nil {
+		t.Fatalf("Server-Timing = %q, not in the expected format", header)
+	}
+	if match[1] != "12.3" || match[2] != "1500.0" || match[3] != "0.6" {
+		t.Fatalf("Server-Timing durations = %v, want 12.3, 1500.0, 0.6", match[1:])
+	}
+}
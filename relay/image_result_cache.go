@@ -0,0 +1,90 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageResultCacheHitKey 本次请求直接返回了缓存的结果，计费时按命中倍率折算
+const ImageResultCacheHitKey = "image_result_cache_hit"
+
+// imageResultCacheKey 由用户、渠道类型、API 类型、模型映射后的上游模型与完整请求计算缓存键，不可缓存时返回空。
+// 缓存按用户隔离，避免把一个用户的图片返回给另一个用户；缓存的是上游原始响应，
+// 需按渠道与 API 类型区分，否则不同渠道的响应会被错误的适配器转换；流式请求不缓存
+func imageResultCacheKey(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) string {
+	settings := model_setting.GetImageSettings()
+	if !settings.ResultCacheEnabled || settings.ResultCacheTTLSeconds <= 0 || info.IsStream || isStreamImageRequest(request) {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "user:%d\nmode:%d\nchannel_type:%d\napi_type:%d\nmodel:%s\n", info.UserId, info.RelayMode, info.ChannelType, info.ApiType, info.UpstreamModelName)
+	if info.RelayMode != relayconstant.RelayModeImagesGenerations {
+		if !settings.ResultCacheIncludeUploads {
+			return ""
+		}
+		if err := hashImageEditForm(c, h); err != nil {
+			logger.LogWarn(c, "build image result cache key failed: "+err.Error())
+			return ""
+		}
+	}
+	body, err := common.Marshal(request)
+	if err != nil {
+		return ""
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupImageResultCache 命中时记录到上下文，调用方跳过上游请求并使用返回的结果
+func lookupImageResultCache(c *gin.Context, key string) *service.ImageResultCacheEntry {
+	if key == "" {
+		return nil
+	}
+	entry := service.LoadImageResultCache(key)
+	if entry != nil {
+		c.Set(ImageResultCacheHitKey, true)
+		logger.LogInfo(c, fmt.Sprintf("image request served from result cache, key: %s", key[:16]))
+	}
+	return entry
+}
+
+// cachedImageHttpResponse 基于缓存结果构造独立的响应，交给适配器按正常流程处理
+func cachedImageHttpResponse(entry *service.ImageResultCacheEntry) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        entry.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+	}
+}
+
+// storeImageResultCache 保存上游成功的非流式响应，同时登记为相似请求的预览来源，读取后恢复响应体供后续处理使用
+func storeImageResultCache(c *gin.Context, key string, similarKey string, httpResp *http.Response) {
+	if key == "" || httpResp == nil || httpResp.StatusCode != http.StatusOK {
+		return
+	}
+	body, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	httpResp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		logger.LogWarn(c, "read image response for result cache failed: "+err.Error())
+		return
+	}
+	ttl := time.Duration(model_setting.GetImageSettings().ResultCacheTTLSeconds) * time.Second
+	service.StoreImageResultCache(key, similarKey, httpResp.Header, body, ttl)
+}
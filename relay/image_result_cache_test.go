@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func TestImageResultCacheKeySeparatesChannelTypes(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.ResultCacheEnabled = true
+		settings.ResultCacheTTLSeconds = 60
+		settings.ResultCachePreviewEnabled = true
+	})
+	request := &dto.ImageRequest{Model: "imagen-3", Prompt: "a red fox", N: 1}
+	keys := func(channelType int, apiType int) (string, string) {
+		info := newImageTestInfo(infoUserId(7), infoRelayMode(relayconstant.RelayModeImagesGenerations), infoUpstreamModel("imagen-3"))
+		info.ChannelType = channelType
+		info.ApiType = apiType
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		return imageResultCacheKey(c, info, request), imageSimilarResultKey(info, request)
+	}
+
+	// 缓存的是上游原始响应，同一上游模型名在不同渠道类型或 API 类型下不能共用缓存
+	openaiKey, openaiSimilar := keys(constant.ChannelTypeOpenAI, constant.APITypeOpenAI)
+	geminiKey, geminiSimilar := keys(constant.ChannelTypeGemini, constant.APITypeGemini)
+	proxyKey, proxySimilar := keys(constant.ChannelTypeOpenAI, constant.APITypeGemini)
+	if openaiKey == "" || openaiKey == geminiKey || openaiKey == proxyKey {
+		t.Fatalf("result cache keys not separated by channel: %q, %q, %q", openaiKey, geminiKey, proxyKey)
+	}
+	if openaiSimilar == "" || openaiSimilar == geminiSimilar || openaiSimilar == proxySimilar {
+		t.Fatalf("similar result keys not separated by channel: %q, %q, %q", openaiSimilar, geminiSimilar, proxySimilar)
+	}
+	if again, _ := keys(constant.ChannelTypeOpenAI, constant.APITypeOpenAI); again != openaiKey {
+		t.Fatalf("result cache key not stable: %q != %q", again, openaiKey)
+	}
+}
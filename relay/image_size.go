@@ -0,0 +1,215 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseImageSize 解析 "1024x1024" 形式的尺寸
+func parseImageSize(size string) (int, int, bool) {
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(size)), "x")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// parseAspectRatio 解析 "16:9" 形式的宽高比
+func parseAspectRatio(ratio string) (int, int, bool) {
+	w, h, ok := strings.Cut(strings.TrimSpace(ratio), ":")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// reducedAspectRatio 返回约分后的宽高比，例如 1792x1024 -> 7:4
+func reducedAspectRatio(width, height int) string {
+	d := gcd(width, height)
+	return fmt.Sprintf("%d:%d", width/d, height/d)
+}
+
+// requestedImageAspect 返回请求的尺寸与宽高比，未指定时返回空字符串
+func requestedImageAspect(request *dto.ImageRequest) (string, string) {
+	if width, height, ok := parseImageSize(request.Size); ok {
+		return fmt.Sprintf("%dx%d", width, height), reducedAspectRatio(width, height)
+	}
+	if raw, ok := request.Extra["aspect_ratio"]; ok {
+		var ratio string
+		if err := common.Unmarshal(raw, &ratio); err == nil {
+			if width, height, ok := parseAspectRatio(ratio); ok {
+				return "", reducedAspectRatio(width, height)
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkImageSizeAllowed 渠道配置了尺寸白名单时，拒绝不在白名单中的尺寸与宽高比。
+// 白名单项可以是具体尺寸（1024x1024）或宽高比（16:9），未指定尺寸的请求使用上游默认值，不做限制
+func checkImageSizeAllowed(info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	allowed := info.ChannelSetting.ImageAllowedSizes
+	if len(allowed) == 0 {
+		return nil
+	}
+	size, ratio := requestedImageAspect(request)
+	if size == "" && ratio == "" {
+		if strings.TrimSpace(request.Size) == "" || request.Size == "auto" {
+			return nil
+		}
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid size %q, allowed sizes: %s", request.Size, strings.Join(allowed, ", ")), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	for _, item := range allowed {
+		if width, height, ok := parseImageSize(item); ok {
+			if size != "" && size == fmt.Sprintf("%dx%d", width, height) {
+				return nil
+			}
+			continue
+		}
+		if width, height, ok := parseAspectRatio(item); ok && ratio == reducedAspectRatio(width, height) {
+			return nil
+		}
+	}
+	requested := request.Size
+	if requested == "" {
+		requested = ratio
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("size %q is not allowed on this channel, allowed sizes: %s", requested, strings.Join(allowed, ", ")), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// checkImageOutputSizeLimit 渠道或令牌配置为 reject 策略时，拒绝请求尺寸超过最大输出宽高的请求
+func checkImageOutputSizeLimit(info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	width, height, ok := parseImageSize(request.Size)
+	if !ok {
+		return nil
+	}
+	if exceedsImageOutputLimit(info.ChannelSetting.ImageMaxOutput, width, height) {
+		limit := info.ChannelSetting.ImageMaxOutput
+		return types.NewErrorWithStatusCode(fmt.Errorf("size %q exceeds the maximum output size %dx%d of this channel", request.Size, limit.MaxWidth, limit.MaxHeight), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if exceedsImageOutputLimit(info.TokenSetting.ImageMaxOutput, width, height) {
+		limit := info.TokenSetting.ImageMaxOutput
+		return types.NewErrorWithStatusCode(fmt.Errorf("size %q exceeds the maximum output size %dx%d allowed for this token", request.Size, limit.MaxWidth, limit.MaxHeight), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	return nil
+}
+
+// exceedsImageOutputLimit reject 策略下请求尺寸是否超过限制
+func exceedsImageOutputLimit(limit *dto.ImageMaxOutputSetting, width, height int) bool {
+	if limit == nil || limit.Policy == dto.ImageMaxOutputPolicyDownscale {
+		return false
+	}
+	return (limit.MaxWidth > 0 && width > limit.MaxWidth) || (limit.MaxHeight > 0 && height > limit.MaxHeight)
+}
+
+// shouldDownscaleImageOutput 渠道或令牌配置为 downscale 策略时，需要在生成后检查并缩小图片
+func shouldDownscaleImageOutput(info *relaycommon.RelayInfo) bool {
+	_, _, ok := imageDownscaleLimit(info, true)
+	return ok
+}
+
+// imageDownscaleLimit 合并渠道与令牌的 downscale 限制，取两者中更严格的宽高。
+// 令牌限制由管理员配置，无论策略都会缩小超限的输出（reject 策略下未指定尺寸的请求可能按上游默认尺寸返回更大的图片），
+// 客户端跳过 max_output 后处理时只忽略渠道限制
+func imageDownscaleLimit(info *relaycommon.RelayInfo, includeChannel bool) (maxWidth, maxHeight int, ok bool) {
+	if limit := info.TokenSetting.ImageMaxOutput; limit != nil {
+		maxWidth, maxHeight = limit.MaxWidth, limit.MaxHeight
+	}
+	if limit := info.ChannelSetting.ImageMaxOutput; includeChannel && limit != nil && limit.Policy == dto.ImageMaxOutputPolicyDownscale {
+		maxWidth = minPositive(maxWidth, limit.MaxWidth)
+		maxHeight = minPositive(maxHeight, limit.MaxHeight)
+	}
+	return maxWidth, maxHeight, maxWidth > 0 || maxHeight > 0
+}
+
+// minPositive 返回两个值中较小的正数，0 表示不限制
+func minPositive(a, b int) int {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// downscaleImageResponse 将响应中超过最大输出宽高的 base64 图片等比缩小，处理失败的图片保持原样
+func downscaleImageResponse(c *gin.Context, body []byte, maxWidth, maxHeight int) []byte {
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var items []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &items); err != nil {
+		return body
+	}
+	changed := false
+	for i, item := range items {
+		var b64 string
+		if err := common.Unmarshal(item["b64_json"], &b64); err != nil || b64 == "" {
+			continue
+		}
+		resized, ok, err := service.DownscaleBase64Image(b64, maxWidth, maxHeight)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("downscale image %d failed, return original: %s", i, err.Error()))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		raw, err := common.Marshal(resized)
+		if err != nil {
+			continue
+		}
+		item["b64_json"] = raw
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+	data, err := common.Marshal(items)
+	if err != nil {
+		return body
+	}
+	response["data"] = data
+	result, err := common.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
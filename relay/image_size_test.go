@@ -0,0 +1,174 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+func TestCheckImageOutputSizeLimitRejectsOverTokenLimit(t *testing.T) {
+	info := &relaycommon.RelayInfo{
+		TokenSetting: dto.TokenSetting{
+			ImageMaxOutput: &dto.ImageMaxOutputSetting{MaxWidth: 1024, MaxHeight: 1024},
+		},
+		ChannelMeta: &relaycommon.ChannelMeta{},
+	}
+
+	err := checkImageOutputSizeLimit(info, &dto.ImageRequest{Size: "1792x1024"})
+	if err == nil {
+		t.Fatal("size above the token limit was accepted")
+	}
+	if err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusBadRequest)
+	}
+	if err = checkImageOutputSizeLimit(info, &dto.ImageRequest{Size: "1024x1024"}); err != nil {
+		t.Fatalf("size within the token limit was rejected: %v", err)
+	}
+}
+
+// newPngImageResponse 返回包含一张指定尺寸 png 的上游响应
+func newPngImageResponse(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	body, err := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{{B64Json: base64.StdEncoding.EncodeToString(buf.Bytes())}}})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return body
+}
+
+// processImageOutputSize 经过响应后处理后返回第一张图片的宽高
+func processImageOutputSize(t *testing.T, info *relaycommon.RelayInfo, upstream []byte) (int, int) {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.Write(upstream)
+	body, newAPIError := processRecordedImageResponse(c, info, recorder)
+	if newAPIError != nil {
+		t.Fatalf("process response: %v", newAPIError)
+	}
+	var response dto.ImageResponse
+	if err := common.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(response.Data[0].B64Json)
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode output image: %v", err)
+	}
+	return config.Width, config.Height
+}
+
+func TestChannelImageMaxOutputPolicies(t *testing.T) {
+	for _, policy := range []string{"", dto.ImageMaxOutputPolicyReject, dto.ImageMaxOutputPolicyDownscale} {
+		t.Run("policy "+policy, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+			info.ChannelSetting.ImageMaxOutput = &dto.ImageMaxOutputSetting{MaxWidth: 1024, MaxHeight: 1024, Policy: policy}
+			downscale := policy == dto.ImageMaxOutputPolicyDownscale
+
+			err := checkImageOutputSizeLimit(info, &dto.ImageRequest{Size: "2048x1024"})
+			if downscale && err != nil {
+				t.Fatalf("downscale policy rejected an oversized request: %v", err)
+			}
+			if !downscale && (err == nil || err.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "1024x1024 of this channel")) {
+				t.Fatalf("reject policy accepted an oversized request: %v", err)
+			}
+			if err = checkImageOutputSizeLimit(info, &dto.ImageRequest{Size: "1024x1024"}); err != nil {
+				t.Fatalf("size within the limit was rejected: %v", err)
+			}
+
+			// 只有 downscale 策略在生成后缩小图片，等比缩放到限制以内
+			if shouldDownscaleImageOutput(info) != downscale {
+				t.Fatalf("downscale post-processing = %v, want %v", !downscale, downscale)
+			}
+			if !downscale {
+				return
+			}
+			if width, height := processImageOutputSize(t, info, newPngImageResponse(t, 2048, 1024)); width != 1024 || height != 512 {
+				t.Fatalf("output size = %dx%d, want 1024x512", width, height)
+			}
+			if width, height := processImageOutputSize(t, info, newPngImageResponse(t, 800, 600)); width != 800 || height != 600 {
+				t.Fatalf("image within the limit resized to %dx%d", width, height)
+			}
+		})
+	}
+}
+
+func TestImageDownscaleLimitKeepsTokenLimitWithoutChannel(t *testing.T) {
+	info := &relaycommon.RelayInfo{
+		TokenSetting: dto.TokenSetting{
+			ImageMaxOutput: &dto.ImageMaxOutputSetting{MaxWidth: 512, Policy: dto.ImageMaxOutputPolicyDownscale},
+		},
+		ChannelMeta: &relaycommon.ChannelMeta{},
+	}
+	info.ChannelSetting.ImageMaxOutput = &dto.ImageMaxOutputSetting{MaxWidth: 256, MaxHeight: 256, Policy: dto.ImageMaxOutputPolicyDownscale}
+
+	width, height, ok := imageDownscaleLimit(info, true)
+	if !ok || width != 256 || height != 256 {
+		t.Fatalf("combined limit = %dx%d (%v), want 256x256", width, height, ok)
+	}
+	// 跳过渠道 max_output 后处理时，令牌限制仍然生效
+	width, height, ok = imageDownscaleLimit(info, false)
+	if !ok || width != 512 || height != 0 {
+		t.Fatalf("token-only limit = %dx%d (%v), want 512x0", width, height, ok)
+	}
+}
+
+func TestImageDownscaleLimitCapsUnsizedRequestsUnderTokenRejectPolicy(t *testing.T) {
+	info := &relaycommon.RelayInfo{
+		TokenSetting: dto.TokenSetting{
+			ImageMaxOutput: &dto.ImageMaxOutputSetting{MaxWidth: 1024, MaxHeight: 1024, Policy: dto.ImageMaxOutputPolicyReject},
+		},
+		ChannelMeta: &relaycommon.ChannelMeta{},
+	}
+	// 未指定尺寸时无法预先拒绝，输出仍按令牌限制缩小
+	if err := checkImageOutputSizeLimit(info, &dto.ImageRequest{Size: "auto"}); err != nil {
+		t.Fatalf("unsized request was rejected: %v", err)
+	}
+	width, height, ok := imageDownscaleLimit(info, false)
+	if !ok || width != 1024 || height != 1024 {
+		t.Fatalf("token reject limit = %dx%d (%v), want 1024x1024", width, height, ok)
+	}
+}
+
+func TestCheckImageSizeAllowedEnforcesAspectRatioWhitelist(t *testing.T) {
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{
+		ChannelSetting: dto.ChannelSettings{ImageAllowedSizes: []string{"1:1", "16:9"}},
+	}}
+
+	for _, request := range []*dto.ImageRequest{
+		{Size: "1024x1024"},
+		{Size: "1920x1080"},
+		{Extra: map[string]json.RawMessage{"aspect_ratio": json.RawMessage(`"32:18"`)}},
+		{Size: "auto"},
+	} {
+		if err := checkImageSizeAllowed(info, request); err != nil {
+			t.Fatalf("allowed request %+v was rejected: %v", request, err)
+		}
+	}
+
+	for _, request := range []*dto.ImageRequest{
+		{Size: "1024x1792"},
+		{Extra: map[string]json.RawMessage{"aspect_ratio": json.RawMessage(`"4:3"`)}},
+	} {
+		err := checkImageSizeAllowed(info, request)
+		if err == nil {
+			t.Fatalf("disallowed request %+v was accepted", request)
+		}
+		if err.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "1:1, 16:9") {
+			t.Fatalf("rejection must be 400 with the allowed list, got %d: %v", err.StatusCode, err)
+		}
+	}
+}
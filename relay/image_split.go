@@ -0,0 +1,317 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getImageUpstreamBatchLimit 返回拆分时每次上游请求的张数，无需拆分时返回 0。
+// 流式与透传请求无法改写张数，不做拆分
+func getImageUpstreamBatchLimit(info *relaycommon.RelayInfo, request *dto.ImageRequest) int {
+	limit := model_setting.GetImageSettings().GetMaxUpstreamBatchSize(info.UpstreamModelName)
+	if limit <= 0 || request.N <= uint(limit) || info.IsStream {
+		return 0
+	}
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+		return 0
+	}
+	return limit
+}
+
+// doSplitImageUpstreamRequest 按上游限制拆分张数，依次构造各子请求体后并发发送
+func doSplitImageUpstreamRequest(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.ImageRequest, limit int) (any, error) {
+	total := int(request.N)
+	// 编辑接口按表单转发，构造子请求体期间改写表单中的张数，结束后恢复
+	if mf := c.Request.MultipartForm; mf != nil {
+		originalN, hadN := mf.Value["n"]
+		defer func() {
+			if hadN {
+				mf.Value["n"] = originalN
+			} else {
+				delete(mf.Value, "n")
+			}
+		}()
+	}
+	logger.LogInfo(c, fmt.Sprintf("image request for %d images exceeds upstream batch limit %d of model %s, splitting", total, limit, info.UpstreamModelName))
+
+	var requestBodies []io.Reader
+	for offset := 0; offset < total; offset += limit {
+		n := min(limit, total-offset)
+		subRequest := *request
+		subRequest.N = uint(n)
+		if mf := c.Request.MultipartForm; mf != nil {
+			mf.Value["n"] = []string{strconv.Itoa(n)}
+		}
+		bodies, newAPIError := buildImageRequestBodies(c, info, adaptor, &subRequest)
+		if newAPIError != nil {
+			return nil, newAPIError
+		}
+		requestBodies = append(requestBodies, bodies...)
+	}
+	return doImageUpstreamBatch(c, info, adaptor, requestBodies)
+}
+
+// imageBatchResult 单个子请求的结果，成功时保存响应体，上游返回错误状态时保留原始响应
+type imageBatchResult struct {
+	header http.Header
+	body   []byte
+	resp   *http.Response
+	err    error
+}
+
+// doImageUpstreamBatch 并发发送多个子请求（并发数受配置限制），按子请求顺序合并 data 与 usage，
+// created 等其他字段沿用第一个成功的子请求。部分子请求失败时按配置整体失败或返回成功的部分
+func doImageUpstreamBatch(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, requestBodies []io.Reader) (any, error) {
+	settings := model_setting.GetImageSettings()
+	results := make([]imageBatchResult, len(requestBodies))
+	sem := make(chan struct{}, max(settings.UpstreamSplitConcurrency, 1))
+	contexts := make([]*gin.Context, len(requestBodies))
+	var wg sync.WaitGroup
+	for i, requestBody := range requestBodies {
+		// 每个子请求使用独立的 gin.Context 与 RelayInfo，避免并发读写请求体、上下文键与渠道信息
+		subContext, subInfo := newImageBatchContext(c, info)
+		contexts[i] = subContext
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = doImageBatchItem(subContext, subInfo, adaptor, requestBody)
+		}()
+	}
+	wg.Wait()
+	for _, subContext := range contexts {
+		mergeImageBatchContext(c, subContext)
+	}
+
+	var (
+		merger  imageResponseMerger
+		header  http.Header
+		failure *imageBatchResult
+		failed  int
+	)
+	for i := range results {
+		result := &results[i]
+		if result.err != nil || result.resp != nil {
+			failed++
+			if failure == nil {
+				failure = result
+			} else if result.resp != nil {
+				_ = result.resp.Body.Close()
+			}
+			continue
+		}
+		if err := merger.add(result.body); err != nil {
+			result.err = err
+			failed++
+			if failure == nil {
+				failure = result
+			}
+			continue
+		}
+		if header == nil {
+			header = result.header
+		}
+	}
+	if failure != nil && (header == nil || settings.UpstreamSplitPartialPolicy != model_setting.ImageSplitPartialReturn) {
+		if failure.resp != nil {
+			return failure.resp, nil
+		}
+		return nil, failure.err
+	}
+	if failure != nil {
+		if failure.resp != nil {
+			_ = failure.resp.Body.Close()
+		}
+		warning := fmt.Sprintf("%d of %d upstream image requests failed, returning %d images", failed, len(results), len(merger.data))
+		logger.LogWarn(c, fmt.Sprintf("%s, channel #%d", warning, info.ChannelId))
+		setImageResponseMeta(c, "warning", warning)
+		c.Set("image_partial_count", len(merger.data))
+	}
+	body, err := merger.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return newMergedImageResponse(header, body), nil
+}
+
+// newImageBatchContext 为子请求复制 gin.Context 与 RelayInfo。复制的请求不带请求体，
+// 发送完成后关闭请求体不会影响原请求；渠道信息单独复制，适配器改写时互不影响
+func newImageBatchContext(c *gin.Context, info *relaycommon.RelayInfo) (*gin.Context, *relaycommon.RelayInfo) {
+	subContext := c.Copy()
+	subContext.Request = c.Request.Clone(c.Request.Context())
+	subContext.Request.Body = http.NoBody
+	subInfo := *info
+	if info.ChannelMeta != nil {
+		channelMeta := *info.ChannelMeta
+		subInfo.ChannelMeta = &channelMeta
+	}
+	return subContext, &subInfo
+}
+
+// mergeImageBatchContext 将子请求新增的上下文键合并回原请求，按子请求顺序先到者为准
+func mergeImageBatchContext(c *gin.Context, subContext *gin.Context) {
+	for key, value := range subContext.Keys {
+		if _, exists := c.Get(key); !exists {
+			c.Set(key, value)
+		}
+	}
+}
+
+func doImageBatchItem(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, requestBody io.Reader) imageBatchResult {
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return imageBatchResult{err: err}
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return imageBatchResult{err: fmt.Errorf("unexpected image response type %T", resp)}
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return imageBatchResult{resp: httpResp}
+	}
+	body, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	if err != nil {
+		return imageBatchResult{err: err}
+	}
+	return imageBatchResult{header: httpResp.Header.Clone(), body: body}
+}
+
+// imageResponseMerger 合并多次上游返回的图片响应，保留第一次响应的其他字段。
+// 调用方按子请求顺序依次 add，data 的顺序与子请求完成的先后无关
+type imageResponseMerger struct {
+	merged  map[string]json.RawMessage
+	data    []json.RawMessage
+	usage   map[string]any
+	indexed bool // 上游图片带有 index 字段，合并后按全局位置重新编号
+}
+
+func (m *imageResponseMerger) add(body []byte) error {
+	var chunk map[string]json.RawMessage
+	if err := common.Unmarshal(body, &chunk); err != nil {
+		return fmt.Errorf("invalid upstream image response: %w", err)
+	}
+	var items []json.RawMessage
+	if err := common.Unmarshal(chunk["data"], &items); err != nil {
+		return fmt.Errorf("invalid upstream image response data: %w", err)
+	}
+	if sorted, ok := sortImageItemsByIndex(items); ok {
+		items = sorted
+		m.indexed = true
+	}
+	m.data = append(m.data, items...)
+	if rawUsage, ok := chunk["usage"]; ok {
+		var chunkUsage map[string]any
+		if err := common.Unmarshal(rawUsage, &chunkUsage); err == nil {
+			m.usage = mergeImageUsage(m.usage, chunkUsage)
+		}
+	}
+	if m.merged == nil {
+		m.merged = chunk
+	}
+	return nil
+}
+
+func (m *imageResponseMerger) bytes() ([]byte, error) {
+	var err error
+	if m.indexed {
+		for i, item := range m.data {
+			var fields map[string]json.RawMessage
+			if common.Unmarshal(item, &fields) != nil {
+				continue
+			}
+			fields["index"] = json.RawMessage(strconv.Itoa(i))
+			if m.data[i], err = common.Marshal(fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.merged["data"], err = common.Marshal(m.data); err != nil {
+		return nil, err
+	}
+	if m.usage != nil {
+		if m.merged["usage"], err = common.Marshal(m.usage); err != nil {
+			return nil, err
+		}
+	}
+	return common.Marshal(m.merged)
+}
+
+// sortImageItemsByIndex 子请求内的图片全部带有 index 字段时按 index 稳定排序，否则保持上游顺序
+func sortImageItemsByIndex(items []json.RawMessage) ([]json.RawMessage, bool) {
+	if len(items) == 0 {
+		return items, false
+	}
+	indexes := make([]int, len(items))
+	for i, item := range items {
+		var fields struct {
+			Index *int `json:"index"`
+		}
+		if common.Unmarshal(item, &fields) != nil || fields.Index == nil {
+			return items, false
+		}
+		indexes[i] = *fields.Index
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return indexes[order[a]] < indexes[order[b]] })
+	sorted := make([]json.RawMessage, len(items))
+	for i, j := range order {
+		sorted[i] = items[j]
+	}
+	return sorted, true
+}
+
+func newMergedImageResponse(header http.Header, body []byte) *http.Response {
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+// mergeImageUsage 累加各次上游返回的 usage，数值字段求和，嵌套的明细字段逐项累加
+func mergeImageUsage(dst map[string]any, src map[string]any) map[string]any {
+	if dst == nil {
+		return src
+	}
+	for key, value := range src {
+		switch v := value.(type) {
+		case float64:
+			if existing, ok := dst[key].(float64); ok {
+				dst[key] = existing + v
+			} else {
+				dst[key] = v
+			}
+		case map[string]any:
+			existing, _ := dst[key].(map[string]any)
+			dst[key] = mergeImageUsage(existing, v)
+		default:
+			if _, ok := dst[key]; !ok {
+				dst[key] = v
+			}
+		}
+	}
+	return dst
+}
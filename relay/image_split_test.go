@@ -0,0 +1,151 @@
+package relay
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// splitTestAdaptor 模拟会读写 gin.Context 与 RelayInfo 的适配器，请求体为返回的图片 URL
+type splitTestAdaptor struct {
+	channel.Adaptor
+	mu       sync.Mutex
+	contexts map[*gin.Context]bool
+}
+
+func (a *splitTestAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	body, _ := io.ReadAll(requestBody)
+	url := string(body)
+	a.mu.Lock()
+	a.contexts[c] = true
+	a.mu.Unlock()
+	info.UpstreamModelName = "rewritten-" + url
+	c.Set("split_test_"+url, true)
+	_ = c.Request.Body.Close()
+	data, _ := common.Marshal(dto.ImageResponse{Created: 1, Data: []dto.ImageData{{Url: url}}})
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestImageUpstreamBatchIsolatesSubRequests(t *testing.T) {
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Request.Body = io.NopCloser(strings.NewReader("original"))
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "dall-e-2"}}
+	adaptor := &splitTestAdaptor{contexts: map[*gin.Context]bool{}}
+	urls := []string{"a", "b", "c", "d"}
+	var bodies []io.Reader
+	for _, url := range urls {
+		bodies = append(bodies, strings.NewReader(url))
+	}
+
+	resp, err := doImageUpstreamBatch(c, info, adaptor, bodies)
+	if err != nil {
+		t.Fatalf("batch failed: %v", err)
+	}
+	if len(adaptor.contexts) != len(urls) || adaptor.contexts[c] {
+		t.Fatalf("sub-requests must each get their own context, got %d distinct (shared: %v)", len(adaptor.contexts), adaptor.contexts[c])
+	}
+	if info.UpstreamModelName != "dall-e-2" {
+		t.Fatalf("sub-request mutated the caller's RelayInfo: %q", info.UpstreamModelName)
+	}
+	if body, _ := io.ReadAll(c.Request.Body); string(body) != "original" {
+		t.Fatalf("caller's request body was consumed: %q", body)
+	}
+	for _, url := range urls {
+		if !c.GetBool("split_test_" + url) {
+			t.Fatalf("context key from sub-request %q was not merged back", url)
+		}
+	}
+
+	var response dto.ImageResponse
+	body, _ := io.ReadAll(resp.(*http.Response).Body)
+	if err := common.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decode merged response: %v", err)
+	}
+	for i, data := range response.Data {
+		if data.Url != urls[i] {
+			t.Fatalf("image %d is %q, want %q", i, data.Url, urls[i])
+		}
+	}
+}
+
+// reverseOrderSplitAdaptor 子请求按相反顺序完成：每个子请求等后一个子请求返回后才返回，
+// 子请求内的图片也按 index 倒序返回
+type reverseOrderSplitAdaptor struct {
+	channel.Adaptor
+	done      map[string]chan struct{}
+	next      map[string]string
+	mu        sync.Mutex
+	completed []string
+}
+
+func (a *reverseOrderSplitAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	body, _ := io.ReadAll(requestBody)
+	batch := string(body)
+	if next, ok := a.next[batch]; ok {
+		<-a.done[next]
+	}
+	a.mu.Lock()
+	a.completed = append(a.completed, batch)
+	a.mu.Unlock()
+	close(a.done[batch])
+	data := `{"created":1,"data":[{"url":"` + batch + `1","index":1},{"url":"` + batch + `0","index":0}]}`
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(data))}, nil
+}
+
+func TestImageUpstreamBatchOrderIsDeterministic(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.UpstreamSplitConcurrency = 3
+	})
+	batches := []string{"a", "b", "c"}
+	for run := 0; run < 5; run++ {
+		adaptor := &reverseOrderSplitAdaptor{done: map[string]chan struct{}{}, next: map[string]string{}}
+		var bodies []io.Reader
+		for i, batch := range batches {
+			adaptor.done[batch] = make(chan struct{})
+			if i+1 < len(batches) {
+				adaptor.next[batch] = batches[i+1]
+			}
+			bodies = append(bodies, strings.NewReader(batch))
+		}
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		resp, err := doImageUpstreamBatch(c, &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}, adaptor, bodies)
+		if err != nil {
+			t.Fatalf("batch failed: %v", err)
+		}
+		if got := strings.Join(adaptor.completed, ","); got != "c,b,a" {
+			t.Fatalf("sub-requests completed in order %s, want c,b,a", got)
+		}
+
+		var response struct {
+			Data []struct {
+				Url   string `json:"url"`
+				Index int    `json:"index"`
+			} `json:"data"`
+		}
+		body, _ := io.ReadAll(resp.(*http.Response).Body)
+		if err := common.Unmarshal(body, &response); err != nil {
+			t.Fatalf("decode merged response: %v", err)
+		}
+		// 按子请求顺序、子请求内按 index 排列，并重新编号为全局位置
+		want := []string{"a0", "a1", "b0", "b1", "c0", "c1"}
+		if len(response.Data) != len(want) {
+			t.Fatalf("merged %d images, want %d: %s", len(response.Data), len(want), body)
+		}
+		for i, data := range response.Data {
+			if data.Url != want[i] || data.Index != i {
+				t.Fatalf("run %d: image %d is %q with index %d, want %q with index %d", run, i, data.Url, data.Index, want[i], i)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sensitiveMetadataHeaders 不允许写入存储元数据的请求头
+var sensitiveMetadataHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+	"X-Goog-Api-Key":      true,
+	"Mj-Api-Secret":       true,
+}
+
+// buildImageStorageMetadata 按配置的映射从请求中提取写入存储对象的元数据，令牌密钥等敏感字段不支持映射
+func buildImageStorageMetadata(c *gin.Context, info *relaycommon.RelayInfo) map[string]string {
+	mapping := model_setting.GetImageSettings().StorageMetadata
+	if len(mapping) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(mapping))
+	for key, source := range mapping {
+		var value string
+		switch source {
+		case "user_id":
+			value = strconv.Itoa(info.UserId)
+		case "username":
+			value = common.GetContextKeyString(c, constant.ContextKeyUserName)
+		case "token_id":
+			value = strconv.Itoa(info.TokenId)
+		case "token_name":
+			value = c.GetString("token_name")
+		case "group":
+			value = info.UsingGroup
+		case "channel_id":
+			value = strconv.Itoa(info.ChannelId)
+		case "model":
+			value = info.OriginModelName
+		case "request_id":
+			value = c.GetString(common.RequestIdKey)
+		default:
+			header, ok := strings.CutPrefix(source, "header:")
+			if !ok {
+				continue
+			}
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if sensitiveMetadataHeaders[header] {
+				continue
+			}
+			value = c.GetHeader(header)
+		}
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,37 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// bufferedReadCloser 预读响应体后仍由原响应体负责关闭
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// detectImageStreamResponse 判断上游是否以 SSE 流式返回图片。部分上游流式返回时未设置
+// text/event-stream，请求要求流式时检查响应体开头，识别后修正 Content-Type
+func detectImageStreamResponse(request *dto.ImageRequest, resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	if !isStreamImageRequest(request) || resp.Body == nil {
+		return false
+	}
+	reader := bufio.NewReader(resp.Body)
+	resp.Body = bufferedReadCloser{Reader: reader, Closer: resp.Body}
+	head, _ := reader.Peek(16)
+	head = bytes.TrimLeft(head, " \r\n")
+	if bytes.HasPrefix(head, []byte("event:")) || bytes.HasPrefix(head, []byte("data:")) {
+		resp.Header.Set("Content-Type", "text/event-stream")
+		return true
+	}
+	return false
+}
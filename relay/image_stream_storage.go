@@ -0,0 +1,238 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldStreamImageResponseToStorage 渠道开启流式转存且为非流式响应时，由 doImageResponseToStorage 代替适配器的 DoResponse
+func shouldStreamImageResponseToStorage(info *relaycommon.RelayInfo) bool {
+	return info.ChannelSetting.ImageStreamToStorage && !info.IsStream
+}
+
+// doImageResponseToStorage 将 OpenAI 格式的图片响应边读取边写入图片存储：b64_json 字段以流式 base64 解码后写入存储并替换为 url，
+// 其余字段原样保留，内存中只保留去掉图片数据后的响应体。
+// 该模式下不执行依赖完整图片数据的上游响应策略（NSFW 标记、提示词截断检测）
+func doImageResponseToStorage(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (any, *types.NewAPIError) {
+	defer service.CloseResponseBodyGracefully(resp)
+
+	rewriter := &imageStorageRewriter{
+		c:    c,
+		info: info,
+		in:   bufio.NewReaderSize(resp.Body, 32*1024),
+	}
+	body, err := rewriter.rewrite()
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	var usageResp dto.SimpleResponse
+	if err = common.Unmarshal(body, &usageResp); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if count, ok := service.CountImageResponseData(body); ok {
+		c.Set("image_returned_count", count)
+	}
+	if rewriter.stored > 0 {
+		logger.LogInfo(c, fmt.Sprintf("streamed %d images to storage", rewriter.stored))
+	}
+	service.IOCopyBytesGracefully(c, resp, body)
+
+	if usageResp.InputTokens > 0 {
+		usageResp.PromptTokens += usageResp.InputTokens
+	}
+	if usageResp.OutputTokens > 0 {
+		usageResp.CompletionTokens += usageResp.OutputTokens
+	}
+	if usageResp.InputTokensDetails != nil {
+		usageResp.PromptTokensDetails.ImageTokens += usageResp.InputTokensDetails.ImageTokens
+		usageResp.PromptTokensDetails.TextTokens += usageResp.InputTokensDetails.TextTokens
+	}
+	return &usageResp.Usage, nil
+}
+
+// imageStorageRewriter 逐字节扫描 JSON，只跟踪字符串与键，b64_json 的值直接交给存储读取
+type imageStorageRewriter struct {
+	c      *gin.Context
+	info   *relaycommon.RelayInfo
+	in     *bufio.Reader
+	out    bytes.Buffer
+	stored int
+}
+
+func (w *imageStorageRewriter) rewrite() ([]byte, error) {
+	var lastKey string
+	expectValue := false
+	for {
+		b, err := w.in.ReadByte()
+		if err == io.EOF {
+			return w.out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b != '"' {
+			switch {
+			case b == ':':
+				expectValue = true
+			case !isJSONSpace(b):
+				expectValue = false
+			}
+			w.out.WriteByte(b)
+			continue
+		}
+
+		if expectValue && lastKey == "b64_json" {
+			url, err := w.storeBase64String()
+			if err != nil {
+				return nil, err
+			}
+			quoted, _ := common.Marshal(url)
+			w.out.Write(quoted)
+			expectValue = false
+			continue
+		}
+
+		raw, err := w.readRawString()
+		if err != nil {
+			return nil, err
+		}
+		// 字符串后紧跟冒号时是对象的键
+		var spaces []byte
+		next, err := w.in.Peek(1)
+		for err == nil && isJSONSpace(next[0]) {
+			spaces = append(spaces, next[0])
+			_, _ = w.in.ReadByte()
+			next, err = w.in.Peek(1)
+		}
+		isKey := !expectValue && err == nil && next[0] == ':'
+		if isKey && raw == "b64_json" {
+			w.out.WriteString(`"url"`)
+		} else {
+			w.out.WriteByte('"')
+			w.out.WriteString(raw)
+			w.out.WriteByte('"')
+		}
+		w.out.Write(spaces)
+		if isKey {
+			lastKey = raw
+		}
+		expectValue = false
+	}
+}
+
+// readRawString 读取开引号之后到闭引号为止的原始内容，转义序列保持原样
+func (w *imageStorageRewriter) readRawString() (string, error) {
+	var sb bytes.Buffer
+	for {
+		b, err := w.in.ReadByte()
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		if b == '"' {
+			return sb.String(), nil
+		}
+		sb.WriteByte(b)
+		if b == '\\' {
+			esc, err := w.in.ReadByte()
+			if err != nil {
+				return "", unexpectedEOF(err)
+			}
+			sb.WriteByte(esc)
+		}
+	}
+}
+
+// storeBase64String 将 b64_json 字符串经流式 base64 解码写入存储并返回对外地址，支持 data URI 前缀
+func (w *imageStorageRewriter) storeBase64String() (string, error) {
+	value := &jsonStringReader{in: w.in}
+	defer func() {
+		_, _ = io.Copy(io.Discard, value)
+	}()
+	encoded := bufio.NewReaderSize(value, 64)
+	if prefix, _ := encoded.Peek(5); string(prefix) == "data:" {
+		if _, err := encoded.ReadString(','); err != nil {
+			return "", fmt.Errorf("invalid data uri in b64_json: %w", err)
+		}
+	}
+	decoded := bufio.NewReaderSize(base64.NewDecoder(base64.StdEncoding, encoded), 32*1024)
+	head, err := decoded.Peek(512)
+	if len(head) == 0 {
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("decode b64_json failed: %w", err)
+		}
+		return "", nil
+	}
+	id, err := service.SaveImageStream(w.c.Request.Context(), decoded, service.ImageContentType(w.c, head), getImageStorageTTL(w.c, w.info), buildImageStorageMetadata(w.c, w.info))
+	if err != nil {
+		return "", fmt.Errorf("stream image to storage failed: %w", err)
+	}
+	w.stored++
+	return service.GetStoredImageUrl(id), nil
+}
+
+// jsonStringReader 读取 JSON 字符串的内容直到闭引号，处理 base64 中可能出现的转义（如 "\/"、"\n"）
+type jsonStringReader struct {
+	in   *bufio.Reader
+	done bool
+}
+
+func (r *jsonStringReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && !r.done {
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+		if b == '"' {
+			r.done = true
+			break
+		}
+		if b == '\\' {
+			esc, err := r.in.ReadByte()
+			if err != nil {
+				return n, unexpectedEOF(err)
+			}
+			switch esc {
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			case 'u':
+				return n, errors.New("unexpected unicode escape in base64 image data")
+			default:
+				b = esc
+			}
+		}
+		p[n] = b
+		n++
+	}
+	if n == 0 && r.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
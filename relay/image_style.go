@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageStyleReferenceField = "style_reference"
+
+// applyImageStyleReference 校验请求中的风格参考图，并按渠道配置的字段名转发；
+// 渠道不支持时按 StyleReferenceUnsupportedPolicy 移除或拒绝
+func applyImageStyleReference(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	mf := c.Request.MultipartForm
+	var files []*multipart.FileHeader
+	var values []string
+	if mf != nil {
+		files = mf.File[imageStyleReferenceField]
+		values = mf.Value[imageStyleReferenceField]
+	}
+	var reference string
+	if len(request.StyleReference) > 0 {
+		if err := common.Unmarshal(request.StyleReference, &reference); err != nil {
+			return types.NewErrorWithStatusCode(fmt.Errorf("invalid style_reference field: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+	} else if len(values) > 0 {
+		reference = values[0]
+	}
+	if reference == "" && len(files) == 0 {
+		return nil
+	}
+
+	field := info.ChannelSetting.ImageStyleReferenceField
+	if field == "" {
+		if model_setting.GetImageSettings().StyleReferenceUnsupportedPolicy == model_setting.ImageStyleReferenceError {
+			return types.NewErrorWithStatusCode(errors.New("style_reference is not supported on this channel"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		logger.LogDebug(c, fmt.Sprintf("channel #%d does not support style_reference, stripped", info.ChannelId))
+		request.StyleReference = nil
+		if mf != nil {
+			delete(mf.File, imageStyleReferenceField)
+			delete(mf.Value, imageStyleReferenceField)
+		}
+		return nil
+	}
+
+	if err := validateImageStyleReference(reference, files); err != nil {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid style_reference: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	c.Set("image_style_reference", true)
+	if field == imageStyleReferenceField {
+		return nil
+	}
+	if len(request.StyleReference) > 0 {
+		if request.Extra == nil {
+			request.Extra = make(map[string]json.RawMessage)
+		}
+		request.Extra[field] = request.StyleReference
+		request.StyleReference = nil
+	}
+	if mf != nil {
+		if len(files) > 0 {
+			mf.File[field] = files
+			delete(mf.File, imageStyleReferenceField)
+		}
+		if len(values) > 0 {
+			mf.Value[field] = values
+			delete(mf.Value, imageStyleReferenceField)
+		}
+	}
+	return nil
+}
+
+// validateImageStyleReference 与其他输入图片一样校验格式、大小与尺寸
+func validateImageStyleReference(reference string, files []*multipart.FileHeader) error {
+	maxBytes := int64(constant.MaxFileDownloadMB) * 1024 * 1024
+	if len(files) > 1 {
+		return errors.New("only one style reference image is allowed")
+	}
+	var config image.Config
+	var err error
+	switch {
+	case len(files) == 1:
+		if maxBytes > 0 && files[0].Size > maxBytes {
+			return fmt.Errorf("image size exceeds %d MB", constant.MaxFileDownloadMB)
+		}
+		file, openErr := files[0].Open()
+		if openErr != nil {
+			return openErr
+		}
+		config, _, err = image.DecodeConfig(file)
+		_ = file.Close()
+	case strings.HasPrefix(reference, "http://") || strings.HasPrefix(reference, "https://"):
+		config, _, err = service.DecodeUrlImageData(reference)
+	default:
+		if maxBytes > 0 && int64(len(reference))*3/4 > maxBytes {
+			return fmt.Errorf("image size exceeds %d MB", constant.MaxFileDownloadMB)
+		}
+		config, _, _, err = service.DecodeBase64ImageData(reference)
+	}
+	if err != nil {
+		return err
+	}
+	maxDimension := model_setting.GetImageSettings().StyleReferenceMaxDimension
+	if maxDimension > 0 && (config.Width > maxDimension || config.Height > maxDimension) {
+		return fmt.Errorf("image dimensions %dx%d exceed %dx%d", config.Width, config.Height, maxDimension, maxDimension)
+	}
+	return nil
+}
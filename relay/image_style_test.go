@@ -0,0 +1,146 @@
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// buildStyleRequestBody 处理风格参考图后按 OpenAI 渠道构造上游请求体
+func buildStyleRequestBody(t *testing.T, info *relaycommon.RelayInfo, request *dto.ImageRequest) map[string]json.RawMessage {
+	t.Helper()
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err := applyImageStyleReference(c, info, request); err != nil {
+		t.Fatalf("apply style reference: %v", err)
+	}
+	bodies, err := buildImageRequestBodies(c, info, &openai.Adaptor{}, request)
+	if err != nil {
+		t.Fatalf("build request body: %v", err)
+	}
+	data, _ := io.ReadAll(bodies[0])
+	var body map[string]json.RawMessage
+	if err := common.Unmarshal(data, &body); err != nil {
+		t.Fatalf("decode request body %q: %v", data, err)
+	}
+	return body
+}
+
+func TestImageStyleReferenceForwardedUnderChannelField(t *testing.T) {
+	reference, _ := common.Marshal("data:image/png;base64," + encodeTestPNG(t, 8, 8))
+
+	// 渠道使用其他字段名时改名转发，原字段不再出现
+	body := buildStyleRequestBody(t, newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelId(2), infoChannelSetting(dto.ChannelSettings{ImageStyleReferenceField: "ip_adapter_image"})),
+		&dto.ImageRequest{Model: "flux-dev", Prompt: "a cat", StyleReference: reference})
+	if string(body["ip_adapter_image"]) != string(reference) {
+		t.Fatalf("ip_adapter_image = %s, want the style reference", body["ip_adapter_image"])
+	}
+	if _, ok := body[imageStyleReferenceField]; ok {
+		t.Fatal("style_reference forwarded under its original name")
+	}
+
+	// 字段名相同时原样转发
+	body = buildStyleRequestBody(t, newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelId(2), infoChannelSetting(dto.ChannelSettings{ImageStyleReferenceField: imageStyleReferenceField})),
+		&dto.ImageRequest{Model: "flux-dev", Prompt: "a cat", StyleReference: reference})
+	if string(body[imageStyleReferenceField]) != string(reference) {
+		t.Fatalf("style_reference = %s, want the style reference", body[imageStyleReferenceField])
+	}
+}
+
+func TestImageStyleReferenceFileForwardedInEditForm(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString(encodeTestPNG(t, 8, 8))
+	c := newImageEditFormContext(t, "image")
+	c.Request.MultipartForm.File[imageStyleReferenceField] = []*multipart.FileHeader{multipartFileHeader(t, imageStyleReferenceField, "style.png", png)}
+	info := newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesEdits), infoChannelId(2), infoChannelSetting(dto.ChannelSettings{ImageStyleReferenceField: "style_image"}))
+	request := &dto.ImageRequest{Model: "gpt-image-1", Prompt: "add a hat"}
+
+	if err := applyImageStyleReference(c, info, request); err != nil {
+		t.Fatalf("apply style reference: %v", err)
+	}
+	bodies, err := buildImageRequestBodies(c, info, &openai.Adaptor{}, request)
+	if err != nil {
+		t.Fatalf("build request body: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	reader := multipart.NewReader(bodies[0], params["boundary"])
+	forwarded := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			forwarded[part.FormName()] = part.FileName()
+		}
+	}
+	if forwarded["style_image"] != "style.png" {
+		t.Fatalf("style reference file not forwarded as style_image: %v", forwarded)
+	}
+	if _, ok := forwarded[imageStyleReferenceField]; ok {
+		t.Fatal("style_reference file forwarded under its original name")
+	}
+}
+
+// multipartFileHeader 构造只包含一个文件的表单并返回其文件头
+func multipartFileHeader(t *testing.T, field, filename string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body strings.Builder
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile(field, filename)
+	_, _ = part.Write(data)
+	_ = writer.Close()
+	form, err := multipart.NewReader(strings.NewReader(body.String()), writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("read form: %v", err)
+	}
+	return form.File[field][0]
+}
+
+func TestImageStyleReferenceOnUnsupportedChannel(t *testing.T) {
+	reference, _ := common.Marshal("data:image/png;base64," + encodeTestPNG(t, 8, 8))
+	info := newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelId(2), infoChannelSetting(dto.ChannelSettings{}))
+
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.StyleReferenceUnsupportedPolicy = model_setting.ImageStyleReferenceStrip
+	})
+	body := buildStyleRequestBody(t, info, &dto.ImageRequest{Model: "dall-e-3", Prompt: "a cat", StyleReference: reference})
+	if _, ok := body[imageStyleReferenceField]; ok {
+		t.Fatal("style_reference forwarded to a channel without support")
+	}
+
+	model_setting.GetImageSettings().StyleReferenceUnsupportedPolicy = model_setting.ImageStyleReferenceError
+	err := applyImageStyleReference(newImageTestContext(http.MethodPost, "/v1/images/generations"), info,
+		&dto.ImageRequest{Model: "dall-e-3", StyleReference: reference})
+	if err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unsupported style reference: %v, want 400", err)
+	}
+}
+
+func TestImageStyleReferenceValidation(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.StyleReferenceMaxDimension = 16
+	})
+	info := newImageTestInfo(infoRelayMode(relayconstant.RelayModeImagesGenerations), infoChannelId(2), infoChannelSetting(dto.ChannelSettings{ImageStyleReferenceField: "ip_adapter_image"}))
+	for _, reference := range []string{
+		"data:image/png;base64," + encodeTestPNG(t, 32, 8),
+		"not an image",
+	} {
+		raw, _ := common.Marshal(reference)
+		err := applyImageStyleReference(newImageTestContext(http.MethodPost, "/v1/images/generations"), info,
+			&dto.ImageRequest{Model: "flux-dev", StyleReference: raw})
+		if err == nil || err.StatusCode != http.StatusBadRequest || !strings.Contains(err.Error(), "invalid style_reference") {
+			t.Fatalf("invalid style reference accepted: %v", err)
+		}
+	}
+}
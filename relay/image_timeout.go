@@ -0,0 +1,43 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ImageRequestTimeoutKey 图片请求单独设置了超时，上游请求只受该超时限制，不再使用全局 HTTP 客户端超时
+const ImageRequestTimeoutKey = "image_request_timeout"
+
+// getImageRequestTimeout 渠道配置优先，其次按模型配置，均未配置时返回 0 使用全局超时
+func getImageRequestTimeout(info *relaycommon.RelayInfo) time.Duration {
+	seconds := info.ChannelSetting.ImageTimeoutSeconds
+	if seconds <= 0 {
+		seconds = model_setting.GetImageSettings().GetModelTimeoutSeconds(info.OriginModelName)
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// newImageTimeoutError 本地超时与上游返回的错误区分开，便于排查是超时配置过短还是上游故障。
+// 本地超时不重试其他渠道，否则实际等待时间会成倍超过配置的超时；只有上游返回的 504 才会重试
+func newImageTimeoutError(timeout time.Duration) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(fmt.Errorf("image request timed out after %s waiting for upstream (client-side timeout, not an upstream error)", timeout), types.ErrorCodeImageRequestTimeout, http.StatusGatewayTimeout, types.ErrOptionWithSkipRetry())
+}
+
+// ImageHttpClientProfileKey 图片请求使用的 HTTP 客户端配置名，由 DoRequest 据此选择独立连接池的客户端
+const ImageHttpClientProfileKey = "image_http_client_profile"
+
+// getImageHttpClientProfile 渠道配置优先，其次按模型配置，均未配置时返回空使用共享客户端
+func getImageHttpClientProfile(info *relaycommon.RelayInfo) string {
+	if name := info.ChannelSetting.ImageHttpClientProfile; name != "" {
+		return name
+	}
+	return model_setting.GetImageSettings().ModelHttpClientProfiles[info.OriginModelName]
+}
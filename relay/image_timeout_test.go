@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func TestImageTimeoutErrorIsNotRetried(t *testing.T) {
+	err := newImageTimeoutError(30 * time.Second)
+	if err.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusGatewayTimeout)
+	}
+	if err.GetErrorCode() != types.ErrorCodeImageRequestTimeout {
+		t.Fatalf("error code = %s, want %s", err.GetErrorCode(), types.ErrorCodeImageRequestTimeout)
+	}
+	if !types.IsSkipRetryError(err) {
+		t.Fatal("local image timeouts must not be retried on other channels")
+	}
+}
+
+// profileReadTimeout 按图片请求的客户端配置取得 HTTP 客户端，返回等待响应头的超时，未使用独立客户端时返回 false
+func profileReadTimeout(t *testing.T, info *relaycommon.RelayInfo) (time.Duration, bool) {
+	t.Helper()
+	name := getImageHttpClientProfile(info)
+	profile, ok := model_setting.GetImageSettings().GetHttpClientProfile(name)
+	if !ok {
+		return 0, false
+	}
+	client, err := service.GetProfileHttpClient(name, profile, "")
+	if err != nil {
+		t.Fatalf("profile %s client: %v", name, err)
+	}
+	return client.Transport.(*http.Transport).ResponseHeaderTimeout, true
+}
+
+func TestSlowProfileModelGetsLongerReadTimeout(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.HttpClientProfiles = map[string]model_setting.ImageHttpClientProfile{
+			"slow": {ConnectTimeoutSeconds: 10, ReadTimeoutSeconds: 600, MaxIdleConnsPerHost: 4},
+			"fast": {ConnectTimeoutSeconds: 5, ReadTimeoutSeconds: 30},
+		}
+		settings.ModelHttpClientProfiles = map[string]string{"slow-image-model": "slow", "gpt-image-1": model_setting.ImageHttpClientProfileDefault}
+	})
+	t.Cleanup(service.ResetProxyClientCache)
+
+	slow := &relaycommon.RelayInfo{OriginModelName: "slow-image-model", ChannelMeta: &relaycommon.ChannelMeta{}}
+	if timeout, ok := profileReadTimeout(t, slow); !ok || timeout != 600*time.Second {
+		t.Fatalf("slow model read timeout = %s (profile client %v), want 10m", timeout, ok)
+	}
+	// 渠道配置优先于按模型配置
+	slow.ChannelSetting.ImageHttpClientProfile = "fast"
+	if timeout, ok := profileReadTimeout(t, slow); !ok || timeout != 30*time.Second {
+		t.Fatalf("channel profile read timeout = %s (profile client %v), want 30s", timeout, ok)
+	}
+	// default 与未配置的模型使用共享客户端
+	for _, model := range []string{"gpt-image-1", "dall-e-3"} {
+		if timeout, ok := profileReadTimeout(t, &relaycommon.RelayInfo{OriginModelName: model, ChannelMeta: &relaycommon.ChannelMeta{}}); ok {
+			t.Fatalf("%s got a profile client with read timeout %s", model, timeout)
+		}
+	}
+
+	// 同一配置复用连接池，配置修改后按新配置重建
+	first, _ := service.GetProfileHttpClient("slow", model_setting.GetImageSettings().HttpClientProfiles["slow"], "")
+	again, _ := service.GetProfileHttpClient("slow", model_setting.GetImageSettings().HttpClientProfiles["slow"], "")
+	if first != again {
+		t.Fatal("profile client was not reused")
+	}
+	model_setting.GetImageSettings().HttpClientProfiles["slow"] = model_setting.ImageHttpClientProfile{ReadTimeoutSeconds: 900}
+	if timeout, _ := profileReadTimeout(t, &relaycommon.RelayInfo{OriginModelName: "slow-image-model", ChannelMeta: &relaycommon.ChannelMeta{}}); timeout != 900*time.Second {
+		t.Fatalf("read timeout after the profile changed = %s, want 15m", timeout)
+	}
+}
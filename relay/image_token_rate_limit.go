@@ -0,0 +1,35 @@
+package relay
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageTokenRateCheckedKey = "image_token_rate_checked"
+
+// checkTokenImageRate 按令牌的 images_per_minute 限制出图张数，与对话接口的请求频率限制相互独立。
+// 同一请求重试其他渠道时只计数一次，超限时返回 429 并通过 Retry-After 提示下一个窗口的开始时间
+func checkTokenImageRate(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	limit := info.TokenSetting.ImagesPerMinute
+	if limit <= 0 || c.GetBool(imageTokenRateCheckedKey) {
+		return nil
+	}
+	allowed, retryAfter := service.ReserveTokenImageRate(info.TokenId, limit, int(request.N))
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+		logger.LogWarn(c, fmt.Sprintf("token #%d exceeded image rate limit of %d images per minute", info.TokenId, limit))
+		return types.NewErrorWithStatusCode(fmt.Errorf("image rate limit reached: at most %d images per minute for this token", limit), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
+	c.Set(imageTokenRateCheckedKey, true)
+	return nil
+}
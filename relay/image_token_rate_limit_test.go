@@ -0,0 +1,58 @@
+package relay
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// waitForFreshRateWindow 避免测试跨越分钟窗口的边界
+func waitForFreshRateWindow() {
+	if remaining := 60 - time.Now().Unix()%60; remaining < 2 {
+		time.Sleep(time.Duration(remaining) * time.Second)
+	}
+}
+
+func TestCheckTokenImageRateEnforcesAdminLimit(t *testing.T) {
+	waitForFreshRateWindow()
+	info := &relaycommon.RelayInfo{TokenId: 91001, TokenSetting: dto.TokenSetting{ImagesPerMinute: 3}}
+
+	first := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err := checkTokenImageRate(first, info, &dto.ImageRequest{N: 2}); err != nil {
+		t.Fatalf("first request within the limit was rejected: %v", err)
+	}
+	// 同一请求重试时不重复计数
+	if err := checkTokenImageRate(first, info, &dto.ImageRequest{N: 2}); err != nil {
+		t.Fatalf("retry of an admitted request was counted again: %v", err)
+	}
+
+	second := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	err := checkTokenImageRate(second, info, &dto.ImageRequest{N: 2})
+	if err == nil {
+		t.Fatal("request exceeding images_per_minute was admitted")
+	}
+	if err.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", err.StatusCode, http.StatusTooManyRequests)
+	}
+	if second.Writer.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After header is missing")
+	}
+
+	third := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	if err = checkTokenImageRate(third, info, &dto.ImageRequest{N: 1}); err != nil {
+		t.Fatalf("request using the remaining capacity was rejected: %v", err)
+	}
+}
+
+func TestCheckTokenImageRateWithoutLimit(t *testing.T) {
+	info := &relaycommon.RelayInfo{TokenId: 91002}
+	for i := 0; i < 5; i++ {
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		if err := checkTokenImageRate(c, info, &dto.ImageRequest{N: 10}); err != nil {
+			t.Fatalf("token without images_per_minute was limited: %v", err)
+		}
+	}
+}
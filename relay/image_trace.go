@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"time"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageTraceparentKey 发往上游的 traceparent，由 DoRequest 写入上游请求头
+const ImageTraceparentKey = "image_traceparent"
+
+// imageTrace ImageHelper 的链路追踪，未注册追踪后端时为 nil，所有方法直接返回
+type imageTrace struct {
+	tracer service.ImageTracer
+	root   service.ImageSpan
+}
+
+// startImageTrace 创建本次图片请求的根 span，客户端传入 traceparent 时作为其子 span
+func startImageTrace(c *gin.Context, info *relaycommon.RelayInfo, start time.Time) *imageTrace {
+	tracer := service.GetImageTracer()
+	if tracer == nil {
+		return nil
+	}
+	parent, _ := service.ParseTraceparent(c.GetHeader("traceparent"), c.GetHeader("tracestate"))
+	root := tracer.StartSpan(parent, "image.relay", start)
+	root.SetAttributes(map[string]any{
+		"image.model":      info.OriginModelName,
+		"image.channel_id": info.ChannelId,
+		"image.relay_mode": info.RelayMode,
+	})
+	return &imageTrace{tracer: tracer, root: root}
+}
+
+// phase 记录已结束的阶段，耗时参数与 logImageHelperPhase 一致
+func (t *imageTrace) phase(name string, timeCost time.Duration, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.tracer.StartSpan(t.root.Context(), name, start).End(start.Add(timeCost), nil)
+}
+
+// startUpstream 开始上游请求阶段，并把该 span 作为上游请求的 traceparent
+func (t *imageTrace) startUpstream(c *gin.Context, info *relaycommon.RelayInfo, start time.Time) service.ImageSpan {
+	if t == nil {
+		return nil
+	}
+	span := t.tracer.StartSpan(t.root.Context(), "image.upstream", start)
+	span.SetAttributes(map[string]any{
+		"image.upstream_model": info.UpstreamModelName,
+		"image.channel_id":     info.ChannelId,
+	})
+	if spanContext := span.Context(); spanContext.IsValid() {
+		c.Set(ImageTraceparentKey, spanContext.Traceparent())
+	}
+	return span
+}
+
+func (t *imageTrace) setAttributes(attributes map[string]any) {
+	if t == nil {
+		return
+	}
+	t.root.SetAttributes(attributes)
+}
+
+// end 结束根 span，失败时记录错误
+func (t *imageTrace) end(newAPIError *types.NewAPIError) {
+	if t == nil {
+		return
+	}
+	var err error
+	if newAPIError != nil {
+		t.root.SetAttributes(map[string]any{"image.error_code": string(newAPIError.GetErrorCode())})
+		err = newAPIError
+	}
+	t.root.End(time.Now(), err)
+}
+
+// endImageSpan 结束可能为 nil 的 span
+func endImageSpan(span service.ImageSpan, end time.Time, err error) {
+	if span != nil {
+		span.End(end, err)
+	}
+}
@@ -0,0 +1,130 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageUpscaleWarningHeader = "X-New-Api-Upscale-Warning"
+
+// parseImageUpscaleFactor 解析并校验请求中的 upscale 参数，校验通过后记录放大倍数与单张价格。
+// 编辑接口的表单字段会被转发给上游，因此解析后从表单中移除；重试时沿用首次解析结果
+func parseImageUpscaleFactor(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ImageRequest) *types.NewAPIError {
+	if _, ok := c.Get("image_upscale_factor"); ok {
+		return nil
+	}
+	var rawFactor string
+	if raw, ok := request.Extra["upscale"]; ok {
+		rawFactor = strings.Trim(string(raw), "\"")
+	} else if mf := c.Request.MultipartForm; mf != nil && len(mf.Value["upscale"]) > 0 {
+		rawFactor = mf.Value["upscale"][0]
+		delete(mf.Value, "upscale")
+	}
+	if rawFactor == "" {
+		return nil
+	}
+	factor, err := strconv.Atoi(rawFactor)
+	if err != nil {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid upscale factor: %s", rawFactor), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if factor <= 1 {
+		return nil
+	}
+	settings := model_setting.GetImageSettings()
+	if !settings.IsImageUpscaleFactorSupported(factor) {
+		return types.NewErrorWithStatusCode(fmt.Errorf("upscale factor %d is not supported, supported factors: %v", factor, settings.UpscaleFactors), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	price, ok := settings.GetImageUpscalePrice(info.OriginModelName)
+	if !ok || settings.UpscaleBaseUrl == "" {
+		return types.NewErrorWithStatusCode(fmt.Errorf("upscale is not available for model %s", info.OriginModelName), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	c.Set("image_upscale_factor", factor)
+	c.Set("image_upscale_price", price*float64(factor))
+	return nil
+}
+
+// upscaleImageResponse 对响应中的 base64 图片逐张放大，任一图片放大失败时返回原始响应并通过响应头告知客户端
+func upscaleImageResponse(c *gin.Context, recorder *imageResponseRecorder, body []byte) []byte {
+	factor := c.GetInt("image_upscale_factor")
+	upscaled, count, size, err := upscaleImageResponseBody(c, body, factor)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("image upscale failed, return original images: %s", err.Error()))
+		recorder.header.Set(imageUpscaleWarningHeader, "upscale failed, original images returned")
+		return body
+	}
+	if count == 0 {
+		recorder.header.Set(imageUpscaleWarningHeader, "no inline images to upscale")
+		return body
+	}
+	c.Set("image_upscale_count", count)
+	c.Set("image_upscale_size", size)
+	return upscaled
+}
+
+// upscaleImageResponseBody 仅替换 data[].b64_json，保留响应中的其他字段
+func upscaleImageResponseBody(c *gin.Context, body []byte, factor int) ([]byte, int, string, error) {
+	var response map[string]json.RawMessage
+	if err := common.Unmarshal(body, &response); err != nil {
+		return nil, 0, "", err
+	}
+	var data []map[string]json.RawMessage
+	if err := common.Unmarshal(response["data"], &data); err != nil {
+		return nil, 0, "", err
+	}
+	settings := model_setting.GetImageSettings()
+	count := 0
+	size := ""
+	for i, item := range data {
+		var b64 string
+		if raw, ok := item["b64_json"]; !ok || common.Unmarshal(raw, &b64) != nil || b64 == "" {
+			continue
+		}
+		upscaled, err := service.UpscaleImage(c.Request.Context(), settings, b64, factor)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("image %d: %w", i, err)
+		}
+		if size == "" {
+			config, _, _, err := service.DecodeBase64ImageData(upscaled)
+			if err != nil {
+				return nil, 0, "", fmt.Errorf("image %d: invalid upscaled image: %w", i, err)
+			}
+			size = fmt.Sprintf("%dx%d", config.Width, config.Height)
+		}
+		raw, err := common.Marshal(upscaled)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		item["b64_json"] = raw
+		count++
+	}
+	if count == 0 {
+		return body, 0, "", nil
+	}
+	rawData, err := common.Marshal(data)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	response["data"] = rawData
+	upscaledBody, err := common.Marshal(response)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if len(upscaledBody) == 0 {
+		return nil, 0, "", errors.New("empty upscaled response")
+	}
+	return upscaledBody, count, size, nil
+}
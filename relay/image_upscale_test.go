@@ -0,0 +1,143 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func encodeTestPNG(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// newUpscaleServer 模拟放大接口，按请求的倍数返回放大后尺寸的图片；status 非 200 时直接返回错误
+func newUpscaleServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var request imageUpscaleTestRequest
+		if err := common.DecodeJson(r.Body, &request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		config, _, _, err := service.DecodeBase64ImageData(request.Image)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response, _ := common.Marshal(map[string]string{"image": encodeTestPNG(t, config.Width*request.Scale, config.Height*request.Scale)})
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+type imageUpscaleTestRequest struct {
+	Image string `json:"image"`
+	Scale int    `json:"scale"`
+}
+
+func withImageUpscale(t *testing.T, baseUrl string) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.UpscaleBaseUrl = baseUrl
+		settings.UpscaleFactors = []int{2, 4}
+		settings.UpscalePrices = map[string]float64{"dall-e-3": 0.01}
+	})
+}
+
+func newUpscaleTestRequest(factor string) *dto.ImageRequest {
+	return &dto.ImageRequest{Model: "dall-e-3", Extra: map[string]json.RawMessage{"upscale": json.RawMessage(factor)}}
+}
+
+func TestImageUpscaleReturnsUpscaledDimensionsAndBillsAddOn(t *testing.T) {
+	withImageUpscale(t, newUpscaleServer(t, http.StatusOK).URL)
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := &relaycommon.RelayInfo{OriginModelName: "dall-e-3", ChannelMeta: &relaycommon.ChannelMeta{}}
+	if err := parseImageUpscaleFactor(c, info, newUpscaleTestRequest("2")); err != nil {
+		t.Fatalf("parse upscale factor: %v", err)
+	}
+	if price := c.GetFloat64("image_upscale_price"); price != 0.02 {
+		t.Fatalf("upscale price = %v, want 0.01 × 2", price)
+	}
+
+	body, _ := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{
+		{B64Json: encodeTestPNG(t, 64, 32)},
+		{B64Json: encodeTestPNG(t, 64, 32)},
+	}})
+	recorder := newImageResponseRecorder(c.Writer)
+	upscaled := upscaleImageResponse(c, recorder, body)
+
+	var response dto.ImageResponse
+	if err := common.Unmarshal(upscaled, &response); err != nil {
+		t.Fatalf("decode upscaled response: %v", err)
+	}
+	for i, data := range response.Data {
+		config, _, _, err := service.DecodeBase64ImageData(data.B64Json)
+		if err != nil || config.Width != 128 || config.Height != 64 {
+			t.Fatalf("image %d is %dx%d (%v), want 128x64", i, config.Width, config.Height, err)
+		}
+	}
+	// 附加费用按放大张数计费，日志中的输出尺寸为放大后的尺寸
+	if count := c.GetInt("image_upscale_count"); count != 2 {
+		t.Fatalf("billed upscale count = %d, want 2", count)
+	}
+	if size := c.GetString("image_upscale_size"); size != "128x64" {
+		t.Fatalf("logged size = %q, want 128x64", size)
+	}
+	if warning := recorder.header.Get(imageUpscaleWarningHeader); warning != "" {
+		t.Fatalf("unexpected warning header: %s", warning)
+	}
+}
+
+func TestImageUpscaleFailureReturnsOriginal(t *testing.T) {
+	withImageUpscale(t, newUpscaleServer(t, http.StatusInternalServerError).URL)
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	c.Set("image_upscale_factor", 2)
+	body, _ := common.Marshal(dto.ImageResponse{Data: []dto.ImageData{{B64Json: encodeTestPNG(t, 64, 32)}}})
+	recorder := newImageResponseRecorder(c.Writer)
+
+	if result := upscaleImageResponse(c, recorder, body); !bytes.Equal(result, body) {
+		t.Fatal("failed upscale did not return the original images")
+	}
+	if recorder.header.Get(imageUpscaleWarningHeader) == "" {
+		t.Fatal("failed upscale did not set the warning header")
+	}
+	if c.GetInt("image_upscale_count") != 0 {
+		t.Fatal("failed upscale was billed")
+	}
+}
+
+func TestImageUpscaleRejectsUnsupportedFactor(t *testing.T) {
+	withImageUpscale(t, "http://127.0.0.1:1")
+	info := &relaycommon.RelayInfo{OriginModelName: "dall-e-3", ChannelMeta: &relaycommon.ChannelMeta{}}
+	for _, factor := range []string{"3", "x"} {
+		c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+		if err := parseImageUpscaleFactor(c, info, newUpscaleTestRequest(factor)); err == nil || err.StatusCode != http.StatusBadRequest {
+			t.Fatalf("factor %s was accepted: %v", factor, err)
+		}
+	}
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	other := &relaycommon.RelayInfo{OriginModelName: "gpt-image-1", ChannelMeta: &relaycommon.ChannelMeta{}}
+	if err := parseImageUpscaleFactor(c, other, newUpscaleTestRequest("2")); err == nil {
+		t.Fatal("upscale accepted for a model without an upscale price")
+	}
+}
@@ -0,0 +1,84 @@
+package relay
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+const imageUrlExpiresHeader = "X-New-Api-Image-Url-Expires"
+
+// parseImageUrlExpiry 解析图片 URL 的过期时间，无法确定时返回 false
+func parseImageUrlExpiry(rawUrl string, rules map[string]int, now time.Time) (time.Time, bool) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return time.Time{}, false
+	}
+	query := u.Query()
+	// Azure Blob SAS（OpenAI 返回的图片地址使用该格式）
+	if se := query.Get("se"); se != "" {
+		if t, err := time.Parse(time.RFC3339, se); err == nil {
+			return t, true
+		}
+	}
+	// AWS SigV4 预签名地址
+	if amzDate, amzExpires := query.Get("X-Amz-Date"), query.Get("X-Amz-Expires"); amzDate != "" && amzExpires != "" {
+		signedAt, err1 := time.Parse("20060102T150405Z", amzDate)
+		seconds, err2 := strconv.Atoi(amzExpires)
+		if err1 == nil && err2 == nil {
+			return signedAt.Add(time.Duration(seconds) * time.Second), true
+		}
+	}
+	// S3 V2 / CloudFront / 阿里云 OSS 等使用 Unix 时间戳
+	for _, key := range []string{"Expires", "x-oss-expires", "x-expires"} {
+		if value := query.Get(key); value != "" {
+			if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return time.Unix(ts, 0), true
+			}
+		}
+	}
+	host := strings.ToLower(u.Hostname())
+	for suffix, ttl := range rules {
+		if ttl > 0 && strings.HasSuffix(host, strings.ToLower(suffix)) {
+			return now.Add(time.Duration(ttl) * time.Second), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// shouldCheckImageUrlExpiry 开启过期提示时需要暂存响应以检查其中的 URL
+func shouldCheckImageUrlExpiry() bool {
+	return model_setting.GetImageSettings().UrlExpiryWarningEnabled
+}
+
+// setImageUrlExpiryHeader 响应中的图片 URL 剩余有效期较短时，在响应头中返回最早的过期时间
+func setImageUrlExpiryHeader(recorder *imageResponseRecorder, body []byte) {
+	settings := model_setting.GetImageSettings()
+	var response struct {
+		Data []struct {
+			Url string `json:"url"`
+		} `json:"data"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil {
+		return
+	}
+	now := time.Now()
+	var earliest time.Time
+	for _, item := range response.Data {
+		if item.Url == "" {
+			continue
+		}
+		expiresAt, ok := parseImageUrlExpiry(item.Url, settings.UrlExpiryRules, now)
+		if ok && (earliest.IsZero() || expiresAt.Before(earliest)) {
+			earliest = expiresAt
+		}
+	}
+	if earliest.IsZero() || earliest.Sub(now) > time.Duration(settings.UrlExpiryWarnSeconds)*time.Second {
+		return
+	}
+	recorder.header.Set(imageUrlExpiresHeader, earliest.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,113 @@
+package relay
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// processImageUrlResponse 处理返回给定图片 URL 的上游响应，返回写给客户端的响应头
+func processImageUrlResponse(t *testing.T, urls ...string) http.Header {
+	t.Helper()
+	response := dto.ImageResponse{Created: time.Now().Unix()}
+	for _, u := range urls {
+		response.Data = append(response.Data, dto.ImageData{Url: u})
+	}
+	upstream, err := common.Marshal(response)
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	c := newImageTestContext(http.MethodPost, "/v1/images/generations")
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	if !shouldRecordImageResponse(c, info) {
+		t.Fatal("url expiry check did not record the upstream response")
+	}
+	recorder := newImageResponseRecorder(c.Writer)
+	recorder.body.Write(upstream)
+	if _, err := processRecordedImageResponse(c, info, recorder); err != nil {
+		t.Fatalf("process response: %v", err)
+	}
+	return recorder.header
+}
+
+func TestImageUrlExpiryWarningForShortTTL(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.UrlExpiryWarningEnabled = true
+		settings.UrlExpiryWarnSeconds = 3600
+		settings.UrlExpiryRules = map[string]int{"cdn.example.com": 600}
+	})
+	now := time.Now().UTC().Truncate(time.Second)
+	sasExpiry := now.Add(30 * time.Minute)
+	amzDate := now.Add(-50 * time.Minute).Format("20060102T150405Z")
+
+	tests := []struct {
+		name string
+		urls []string
+		want time.Time
+	}{
+		{
+			name: "azure sas",
+			urls: []string{"https://oaidalleapiprodscus.blob.core.windows.net/private/img.png?se=" + sasExpiry.Format(time.RFC3339) + "&sig=x"},
+			want: sasExpiry,
+		},
+		{
+			// 多张图片时返回最早的过期时间
+			name: "earliest of several",
+			urls: []string{
+				"https://oaidalleapiprodscus.blob.core.windows.net/private/img.png?se=" + sasExpiry.Format(time.RFC3339),
+				"https://bucket.s3.amazonaws.com/img.png?X-Amz-Date=" + amzDate + "&X-Amz-Expires=3600",
+			},
+			want: now.Add(10 * time.Minute),
+		},
+		{
+			name: "unix expires",
+			urls: []string{"https://bucket.oss-cn-hangzhou.aliyuncs.com/img.png?Expires=" + strconv.FormatInt(now.Add(5*time.Minute).Unix(), 10)},
+			want: now.Add(5 * time.Minute),
+		},
+		{
+			name: "host rule",
+			urls: []string{"https://img.cdn.example.com/img.png"},
+			want: now.Add(10 * time.Minute),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := processImageUrlResponse(t, tt.urls...)
+			got, err := time.Parse(time.RFC3339, header.Get(imageUrlExpiresHeader))
+			if err != nil {
+				t.Fatalf("expiry header %q: %v", header.Get(imageUrlExpiresHeader), err)
+			}
+			// 按域名规则估算的过期时间以处理时刻为准，允许少量误差
+			if diff := got.Sub(tt.want); diff < -2*time.Second || diff > 2*time.Second {
+				t.Fatalf("expiry header = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageUrlExpiryWarningSkipped(t *testing.T) {
+	withImageSettings(t, func(settings *model_setting.ImageSettings) {
+		settings.UrlExpiryWarningEnabled = true
+		settings.UrlExpiryWarnSeconds = 3600
+	})
+	longLived := "https://bucket.s3.amazonaws.com/img.png?Expires=" + strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+
+	// 剩余有效期较长或无法判断过期时间时不提示
+	for _, u := range []string{longLived, "https://img.unknown.example.org/img.png"} {
+		if got := processImageUrlResponse(t, u).Get(imageUrlExpiresHeader); got != "" {
+			t.Fatalf("%s: unexpected expiry header %q", u, got)
+		}
+	}
+
+	// 关闭提示后不再为检查 URL 暂存响应
+	model_setting.GetImageSettings().UrlExpiryWarningEnabled = false
+	if shouldCheckImageUrlExpiry() {
+		t.Fatal("url expiry checked while the warning is disabled")
+	}
+}
@@ -0,0 +1,16 @@
+package relay
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+)
+
+func TestMain(m *testing.M) {
+	// 测试不连接 Redis，限流、缓存等使用进程内存储
+	common.RedisEnabled = false
+	service.InitHttpClient()
+	os.Exit(m.Run())
+}
@@ -14,6 +14,14 @@ func SetRelayRouter(router *gin.Engine) {
 	router.Use(middleware.CORS())
 	router.Use(middleware.DecompressRequestMiddleware())
 	router.Use(middleware.StatsMiddleware())
+	// 临时图片访问，无需鉴权
+	router.GET("/v1/images/files/:id", controller.GetStoredImage)
+	router.POST("/v1/images/generations/:id/cancel", middleware.TokenAuth(), controller.CancelImageGeneration)
+	router.POST("/v1/images/batches", middleware.TokenAuth(), controller.RelayImageBatch(router))
+	router.GET("/v1/images/jobs/:id", middleware.TokenAuth(), controller.GetImageJob)
+	router.GET("/v1/images/models", middleware.TokenAuth(), controller.ListImageModels)
+	router.GET("/v1/images/history", middleware.TokenAuth(), controller.GetImageHistory)
+	router.GET("/v1/images/queue", middleware.TokenAuth(), controller.GetImageQueueStatus)
 	// https://platform.openai.com/docs/api-reference/introduction
 	modelsRouter := router.Group("/v1/models")
 	modelsRouter.Use(middleware.TokenAuth())
@@ -98,12 +106,8 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/edits", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatOpenAIImage)
 		})
-		httpRouter.POST("/images/generations", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatOpenAIImage)
-		})
-		httpRouter.POST("/images/edits", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatOpenAIImage)
-		})
+		httpRouter.POST("/images/generations", controller.RelayImage(router))
+		httpRouter.POST("/images/edits", controller.RelayImage(router))
 
 		// embedding related routes
 		httpRouter.POST("/embeddings", func(c *gin.Context) {
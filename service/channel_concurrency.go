@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrChannelConcurrencyWaitTimeout = errors.New("timed out waiting for a free channel concurrency slot")
+
+// channelSemaphore 渠道并发名额，slots 的容量即最大并发数；waiting 为排队中的请求数，
+// durations 记录最近请求占用名额的时长，用于估算排队时间
+type channelSemaphore struct {
+	slots     chan struct{}
+	waiting   atomic.Int32
+	durations imageDurationWindow
+}
+
+// ChannelImageQueueStats 渠道并发名额的当前状态
+type ChannelImageQueueStats struct {
+	MaxConcurrent     int   `json:"max_concurrent"`
+	InFlight          int   `json:"in_flight"`
+	Queued            int   `json:"queued"`
+	AverageDurationMs int64 `json:"average_duration_ms"`
+	EstimatedWaitMs   int64 `json:"estimated_wait_ms"`
+}
+
+var (
+	channelSemaphores     = make(map[int]*channelSemaphore)
+	channelSemaphoresLock sync.Mutex
+)
+
+// getChannelSemaphore 返回渠道的并发名额，并发数配置变化时重新创建，进行中的请求仍归还到原有名额
+func getChannelSemaphore(channelId int, limit int) *channelSemaphore {
+	channelSemaphoresLock.Lock()
+	defer channelSemaphoresLock.Unlock()
+	sem, ok := channelSemaphores[channelId]
+	if !ok || cap(sem.slots) != limit {
+		sem = &channelSemaphore{slots: make(chan struct{}, limit)}
+		channelSemaphores[channelId] = sem
+	}
+	return sem
+}
+
+// AcquireChannelConcurrencySlot 获取渠道的并发名额，名额已满时最多排队 timeout，超时返回 ErrChannelConcurrencyWaitTimeout。
+// 返回的 release 可以重复调用，只会归还一次名额。限制仅在当前节点内生效
+func AcquireChannelConcurrencySlot(ctx context.Context, channelId int, limit int, timeout time.Duration) (release func(), err error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	sem := getChannelSemaphore(channelId, limit)
+	select {
+	case sem.slots <- struct{}{}:
+		return sem.releaseFunc(), nil
+	default:
+	}
+	sem.waiting.Add(1)
+	defer sem.waiting.Add(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sem.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrChannelConcurrencyWaitTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return sem.releaseFunc(), nil
+}
+
+func (s *channelSemaphore) releaseFunc() func() {
+	var once sync.Once
+	start := time.Now()
+	return func() {
+		once.Do(func() {
+			s.durations.observe(time.Since(start))
+			<-s.slots
+		})
+	}
+}
+
+// GetChannelImageQueueStats 返回渠道并发名额的进行中与排队请求数，以及按最近请求耗时估算的排队时间，
+// 渠道尚未使用过并发限制时返回零值
+func GetChannelImageQueueStats(channelId int) ChannelImageQueueStats {
+	channelSemaphoresLock.Lock()
+	sem, ok := channelSemaphores[channelId]
+	channelSemaphoresLock.Unlock()
+	if !ok {
+		return ChannelImageQueueStats{}
+	}
+	limit, inFlight, queued := cap(sem.slots), len(sem.slots), int(sem.waiting.Load())
+	average := sem.durations.average()
+	return ChannelImageQueueStats{
+		MaxConcurrent:     limit,
+		InFlight:          inFlight,
+		Queued:            queued,
+		AverageDurationMs: average.Milliseconds(),
+		EstimatedWaitMs:   estimateImageQueueWait(inFlight, queued, limit, average).Milliseconds(),
+	}
+}
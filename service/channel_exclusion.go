@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const excludedChannelsKey = "excluded_channels"
+
+// ParseExcludedChannels 解析图片请求头中客户端要排除的渠道 ID，结果保存在上下文中，
+// 首次选择与重试时都会跳过这些渠道。未开启该功能或非图片请求时忽略请求头
+func ParseExcludedChannels(c *gin.Context) error {
+	exclusionSetting := operation_setting.GetChannelExclusionSetting()
+	if !exclusionSetting.Enabled || exclusionSetting.Header == "" {
+		return nil
+	}
+	switch relayconstant.Path2RelayMode(c.Request.URL.Path) {
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+	default:
+		return nil
+	}
+	value := strings.TrimSpace(c.GetHeader(exclusionSetting.Header))
+	if value == "" {
+		return nil
+	}
+	var ids []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, err := strconv.Atoi(item)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("无效的排除渠道 ID: %s", item)
+		}
+		ids = append(ids, id)
+	}
+	if exclusionSetting.MaxExcludedChannels > 0 && len(ids) > exclusionSetting.MaxExcludedChannels {
+		return fmt.Errorf("最多只能排除 %d 个渠道", exclusionSetting.MaxExcludedChannels)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	excluded := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		excluded[id] = true
+	}
+	c.Set(excludedChannelsKey, excluded)
+	return nil
+}
+
+// GetExcludedChannels 返回客户端请求排除的渠道 ID
+func GetExcludedChannels(c *gin.Context) map[int]bool {
+	value, ok := c.Get(excludedChannelsKey)
+	if !ok {
+		return nil
+	}
+	excluded, _ := value.(map[int]bool)
+	return excluded
+}
+
+// ExcludeChannel 在本次请求后续的渠道选择中排除指定渠道，用于重试时切换到其他渠道
+func ExcludeChannel(c *gin.Context, channelId int) {
+	excluded := GetExcludedChannels(c)
+	if excluded == nil {
+		excluded = make(map[int]bool)
+		c.Set(excludedChannelsKey, excluded)
+	}
+	excluded[channelId] = true
+}
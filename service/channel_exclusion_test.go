@@ -0,0 +1,98 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func withChannelExclusion(t *testing.T) {
+	t.Helper()
+	setting := operation_setting.GetChannelExclusionSetting()
+	previous := *setting
+	setting.Enabled = true
+	setting.Header = "X-Exclude-Channels"
+	setting.MaxExcludedChannels = 3
+	t.Cleanup(func() { *setting = previous })
+}
+
+func newExclusionContext(t *testing.T, header string) *gin.Context {
+	t.Helper()
+	c := newChannelSelectContext(nil)
+	c.Request.Header.Set("X-Exclude-Channels", header)
+	if err := ParseExcludedChannels(c); err != nil {
+		t.Fatalf("parse excluded channels %q: %v", header, err)
+	}
+	return c
+}
+
+func TestExcludedChannelsAreSkipped(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{10, 5, 0}, []int{common.ChannelStatusEnabled, common.ChannelStatusEnabled, common.ChannelStatusEnabled})
+	withChannelExclusion(t)
+
+	for header, want := range map[string]int{
+		"":       1,
+		"1":      2,
+		" 1 , 2": 3,
+		"1,,2":   3,
+	} {
+		channel, _, err := CacheGetRandomSatisfiedChannel(newExclusionContext(t, header), "default", "gpt-image-1", 0)
+		if err != nil || channel == nil || channel.Id != want {
+			t.Fatalf("excluded %q: selected %+v (%v), want channel %d", header, channel, err, want)
+		}
+	}
+	// 重试降级到低优先级时同样跳过
+	channel, _, err := CacheGetRandomSatisfiedChannel(newExclusionContext(t, "3"), "default", "gpt-image-1", 1)
+	if err != nil || channel == nil || channel.Id != 2 {
+		t.Fatalf("retry selected %+v (%v), want channel 2", channel, err)
+	}
+	// 全部渠道都被排除时返回错误
+	if channel, _, err = CacheGetRandomSatisfiedChannel(newExclusionContext(t, "1,2,3"), "default", "gpt-image-1", 0); err == nil || !strings.Contains(err.Error(), "没有可用渠道") {
+		t.Fatalf("every channel excluded, selected %+v (%v)", channel, err)
+	}
+}
+
+func TestExcludedChannelsLimits(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{0}, []int{common.ChannelStatusEnabled})
+	withChannelExclusion(t)
+
+	// 超出数量限制或包含非数字 ID（渠道名称）时拒绝
+	for _, header := range []string{"1,2,3,4", "flaky-provider", "1,0"} {
+		c := newChannelSelectContext(nil)
+		c.Request.Header.Set("X-Exclude-Channels", header)
+		if err := ParseExcludedChannels(c); err == nil {
+			t.Fatalf("excluded channels %q accepted", header)
+		}
+	}
+
+	// 非图片请求忽略请求头
+	c := newChannelSelectContext(nil)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Request.Header.Set("X-Exclude-Channels", "1")
+	if err := ParseExcludedChannels(c); err != nil || len(GetExcludedChannels(c)) != 0 {
+		t.Fatalf("exclusion applied to a chat request: %v (%v)", GetExcludedChannels(c), err)
+	}
+
+	// 偏好渠道被排除时不作为首选
+	operation_setting.GetChannelExclusionSetting().Enabled = true
+	c = newExclusionContext(t, "1")
+	channel, _ := model.CacheGetChannel(1)
+	if imageChannelSatisfies(c, channel, "default", "gpt-image-1") {
+		t.Fatal("excluded channel satisfied the image selection")
+	}
+
+	// 未开启时忽略请求头
+	operation_setting.GetChannelExclusionSetting().Enabled = false
+	if excluded := GetExcludedChannels(newExclusionContext(t, "1")); len(excluded) != 0 {
+		t.Fatalf("exclusion applied while disabled: %v", excluded)
+	}
+}
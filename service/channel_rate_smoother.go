@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrChannelRateWaitTooLong = errors.New("upstream rate limit wait exceeded")
+
+// channelLeakyBucket 以固定间隔放行请求，next 为下一个可用的放行时间
+type channelLeakyBucket struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+var channelLeakyBuckets sync.Map // channelId -> *channelLeakyBucket
+
+// reserve 预约一个放行时间，需要等待的时间超过 maxWait 时不占用名额
+func (b *channelLeakyBucket) reserve(interval, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	if wait > maxWait {
+		return 0, false
+	}
+	b.next = b.next.Add(interval)
+	return wait, true
+}
+
+// WaitChannelRateSlot 按渠道配置的每秒请求数平滑发往上游的请求，排队时间超过 maxWait 时返回 ErrChannelRateWaitTooLong。
+// 限流状态仅在当前节点内生效
+func WaitChannelRateSlot(ctx context.Context, channelId int, requestsPerSecond float64, maxWait time.Duration) error {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	value, _ := channelLeakyBuckets.LoadOrStore(channelId, &channelLeakyBucket{})
+	bucket := value.(*channelLeakyBucket)
+	interval := time.Duration(float64(time.Second) / requestsPerSecond)
+	wait, ok := bucket.reserve(interval, maxWait)
+	if !ok {
+		return ErrChannelRateWaitTooLong
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
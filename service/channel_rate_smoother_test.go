@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetChannelRateSlots 清理测试渠道的限流状态
+func resetChannelRateSlots(t *testing.T, channelIds ...int) {
+	t.Helper()
+	for _, channelId := range channelIds {
+		channelLeakyBuckets.Delete(channelId)
+	}
+	t.Cleanup(func() {
+		for _, channelId := range channelIds {
+			channelLeakyBuckets.Delete(channelId)
+		}
+	})
+}
+
+func TestWaitChannelRateSlotSmoothsToConfiguredRate(t *testing.T) {
+	resetChannelRateSlots(t, 9101)
+	const requests = 5
+	const interval = 50 * time.Millisecond // 20 次/秒
+	start := time.Now()
+	released := make([]time.Duration, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := WaitChannelRateSlot(context.Background(), 9101, 20, time.Second); err != nil {
+				t.Errorf("request %d: %v", i, err)
+			}
+			released[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+
+	// 同时到达的请求按固定间隔依次放行
+	sort.Slice(released, func(i, j int) bool { return released[i] < released[j] })
+	for i := 1; i < requests; i++ {
+		if gap := released[i] - released[i-1]; gap < interval-10*time.Millisecond {
+			t.Fatalf("requests released %v apart, want about %v: %v", gap, interval, released)
+		}
+	}
+	if total := released[requests-1]; total < (requests-1)*interval-10*time.Millisecond || total > 2*time.Second {
+		t.Fatalf("last request released after %v, want about %v", total, (requests-1)*interval)
+	}
+}
+
+func TestWaitChannelRateSlotLimits(t *testing.T) {
+	resetChannelRateSlots(t, 9102, 9103, 9104)
+	// 未配置速率时不等待
+	if err := WaitChannelRateSlot(context.Background(), 9102, 0, 0); err != nil {
+		t.Fatalf("unlimited channel: %v", err)
+	}
+
+	// 排队超过 maxWait 时直接拒绝，且不占用后续请求的名额
+	if err := WaitChannelRateSlot(context.Background(), 9103, 5, 100*time.Millisecond); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if err := WaitChannelRateSlot(context.Background(), 9103, 5, 100*time.Millisecond); !errors.Is(err, ErrChannelRateWaitTooLong) {
+		t.Fatalf("request beyond max wait: %v, want ErrChannelRateWaitTooLong", err)
+	}
+	value, _ := channelLeakyBuckets.Load(9103)
+	if wait := time.Until(value.(*channelLeakyBucket).next); wait > 200*time.Millisecond {
+		t.Fatalf("rejected request reserved a slot, next release in %v", wait)
+	}
+
+	// 请求被取消时停止等待
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_ = WaitChannelRateSlot(ctx, 9104, 1, 2*time.Second)
+	if err := WaitChannelRateSlot(ctx, 9104, 1, 2*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled wait: %v, want context.DeadlineExceeded", err)
+	}
+}
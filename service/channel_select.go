@@ -2,16 +2,48 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/gin-gonic/gin"
 )
 
 func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName string, retry int) (*model.Channel, string, error) {
+	return cacheGetRandomSatisfiedChannel(c, group, modelName, retry, GetExcludedChannels(c))
+}
+
+// CacheGetRandomSatisfiedImageChannel 为图片请求选择渠道：在最高的可用优先级中按权重随机选择，
+// 熔断中的渠道与已失败的渠道不参与选择，整个优先级都不可用时自动落到下一优先级。
+// 重试时失败的渠道已被排除，因此始终从最高可用优先级开始，不按重试次数跳过优先级。
+// 所有渠道都熔断时忽略熔断状态选择，由 ImageHelper 返回熔断错误
+func CacheGetRandomSatisfiedImageChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, error) {
+	excluded := GetExcludedChannels(c)
+	blocked := GetBlockedImageChannels()
+	if len(blocked) > 0 {
+		merged := make(map[int]bool, len(excluded)+len(blocked))
+		for id := range excluded {
+			merged[id] = true
+		}
+		for id := range blocked {
+			merged[id] = true
+		}
+		channel, selectGroup, err := cacheGetRandomSatisfiedChannel(c, group, modelName, 0, merged)
+		if channel != nil {
+			return channel, selectGroup, nil
+		}
+		logger.LogDebug(c, fmt.Sprintf("all image channels of model %s are circuit-open or excluded: %v", modelName, err))
+	}
+	return cacheGetRandomSatisfiedChannel(c, group, modelName, 0, excluded)
+}
+
+func cacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName string, retry int, excluded map[int]bool) (*model.Channel, string, error) {
 	var channel *model.Channel
 	var err error
 	selectGroup := group
@@ -22,7 +54,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName stri
 		}
 		for _, autoGroup := range GetUserAutoGroup(userGroup) {
 			logger.LogDebug(c, "Auto selecting group:", autoGroup)
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, modelName, retry)
+			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, modelName, retry, excluded)
 			if channel == nil {
 				continue
 			} else {
@@ -33,10 +65,53 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, modelName stri
 			}
 		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(group, modelName, retry)
+		channel, err = model.GetRandomSatisfiedChannel(group, modelName, retry, excluded)
 		if err != nil {
 			return nil, group, err
 		}
 	}
+	if channel == nil && len(excluded) > 0 {
+		return nil, selectGroup, errors.New("排除指定渠道后没有可用渠道")
+	}
 	return channel, selectGroup, nil
 }
+
+// GetPreferredImageChannel 返回用户设置的图片偏好渠道，仅作为首选（重试时仍按常规策略选择）。
+// 渠道不存在、已禁用或不支持当前分组与模型时返回 nil
+func GetPreferredImageChannel(c *gin.Context, group string, modelName string) *model.Channel {
+	userSetting, ok := common.GetContextKeyType[dto.UserSetting](c, constant.ContextKeyUserSetting)
+	if !ok || userSetting.PreferredImageChannelId <= 0 {
+		return nil
+	}
+	channel, err := model.CacheGetChannel(userSetting.PreferredImageChannelId)
+	if err != nil || channel == nil || channel.Status != common.ChannelStatusEnabled {
+		logger.LogDebug(c, fmt.Sprintf("preferred image channel #%d is unavailable, ignored", userSetting.PreferredImageChannelId))
+		return nil
+	}
+	if !imageChannelSatisfies(c, channel, group, modelName) {
+		return nil
+	}
+	return channel
+}
+
+// imageChannelSatisfies 判断渠道是否支持当前分组与模型且未被请求排除，auto 分组时记录命中的分组
+func imageChannelSatisfies(c *gin.Context, channel *model.Channel, group string, modelName string) bool {
+	if GetExcludedChannels(c)[channel.Id] || GetBlockedImageChannels()[channel.Id] {
+		return false
+	}
+	models := channel.GetModels()
+	if !slices.Contains(models, modelName) && !slices.Contains(models, ratio_setting.FormatMatchingModelName(modelName)) {
+		return false
+	}
+	groups := channel.GetGroups()
+	if group == "auto" {
+		for _, autoGroup := range GetUserAutoGroup(common.GetContextKeyString(c, constant.ContextKeyUserGroup)) {
+			if slices.Contains(groups, autoGroup) {
+				c.Set("auto_group", autoGroup)
+				return true
+			}
+		}
+		return false
+	}
+	return slices.Contains(groups, group)
+}
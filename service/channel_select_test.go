@@ -0,0 +1,106 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupChannelTestDB 使用内存 SQLite 存放渠道与能力，insertTestChannels 写入后加载到渠道内存缓存
+func setupChannelTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err = db.AutoMigrate(&model.Channel{}, &model.Ability{}); err != nil {
+		t.Fatalf("migrate channels: %v", err)
+	}
+	previousDB, previousCache, previousSQLite := model.DB, common.MemoryCacheEnabled, common.UsingSQLite
+	model.DB, common.MemoryCacheEnabled, common.UsingSQLite = db, true, true
+	t.Cleanup(func() {
+		model.DB, common.MemoryCacheEnabled, common.UsingSQLite = previousDB, previousCache, previousSQLite
+	})
+}
+
+// insertTestChannels 写入支持 gpt-image-1 的渠道，priorities 与 statuses 按渠道 ID（从 1 开始）排列
+func insertTestChannels(t *testing.T, priorities []int64, statuses []int) {
+	t.Helper()
+	for i := range priorities {
+		weight := uint(0)
+		channel := &model.Channel{Id: i + 1, Name: "image-test", Key: "sk-test", Status: statuses[i], Models: "gpt-image-1",
+			Group: "default", Priority: &priorities[i], Weight: &weight}
+		if err := channel.Insert(); err != nil {
+			t.Fatalf("insert channel %d: %v", i+1, err)
+		}
+	}
+	model.InitChannelCache()
+}
+
+func newChannelSelectContext(userSetting *dto.UserSetting) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	if userSetting != nil {
+		common.SetContextKey(c, constant.ContextKeyUserSetting, *userSetting)
+	}
+	return c
+}
+
+func TestPreferredImageChannelIsTriedFirst(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{10, 0}, []int{common.ChannelStatusEnabled, common.ChannelStatusEnabled})
+
+	c := newChannelSelectContext(&dto.UserSetting{PreferredImageChannelId: 2})
+	if channel, _, _ := CacheGetRandomSatisfiedImageChannel(c, "default", "gpt-image-1"); channel == nil || channel.Id != 1 {
+		t.Fatalf("regular selection = %+v, want the higher priority channel 1", channel)
+	}
+	// 偏好渠道 2 优先级更低但仍作为首选
+	if channel := GetPreferredImageChannel(c, "default", "gpt-image-1"); channel == nil || channel.Id != 2 {
+		t.Fatalf("preferred channel = %+v, want channel 2", channel)
+	}
+	// 首选渠道失败后回落到常规选择
+	ExcludeChannel(c, 2)
+	if channel := GetPreferredImageChannel(c, "default", "gpt-image-1"); channel != nil {
+		t.Fatalf("failed preferred channel was chosen again: %d", channel.Id)
+	}
+	channel, _, err := CacheGetRandomSatisfiedImageChannel(c, "default", "gpt-image-1")
+	if err != nil || channel == nil || channel.Id != 1 {
+		t.Fatalf("fallback channel = %+v (%v), want channel 1", channel, err)
+	}
+}
+
+func TestPreferredImageChannelIgnoresUnusableChannel(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{0, 0}, []int{common.ChannelStatusEnabled, common.ChannelStatusManuallyDisabled})
+
+	cases := map[string]struct {
+		channelId int
+		group     string
+		model     string
+	}{
+		"disabled":      {channelId: 2, group: "default", model: "gpt-image-1"},
+		"missing":       {channelId: 99, group: "default", model: "gpt-image-1"},
+		"other model":   {channelId: 1, group: "default", model: "dall-e-3"},
+		"other group":   {channelId: 1, group: "vip", model: "gpt-image-1"},
+		"no preference": {channelId: 0, group: "default", model: "gpt-image-1"},
+	}
+	for name, tc := range cases {
+		c := newChannelSelectContext(&dto.UserSetting{PreferredImageChannelId: tc.channelId})
+		if channel := GetPreferredImageChannel(c, tc.group, tc.model); channel != nil {
+			t.Fatalf("%s: preferred channel %d was used", name, channel.Id)
+		}
+	}
+	if channel := GetPreferredImageChannel(newChannelSelectContext(nil), "default", "gpt-image-1"); channel != nil {
+		t.Fatalf("user without settings got preferred channel %d", channel.Id)
+	}
+}
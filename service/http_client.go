@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"golang.org/x/net/proxy"
@@ -19,8 +20,17 @@ var (
 	httpClient      *http.Client
 	proxyClientLock sync.Mutex
 	proxyClients    = make(map[string]*http.Client)
+
+	profileClientLock sync.Mutex
+	profileClients    = make(map[string]*profileHttpClient)
 )
 
+// profileHttpClient 按客户端配置创建的客户端，记录创建时的配置以便配置修改后重建
+type profileHttpClient struct {
+	profile model_setting.ImageHttpClientProfile
+	client  *http.Client
+}
+
 func checkRedirect(req *http.Request, via []*http.Request) error {
 	fetchSetting := system_setting.GetFetchSetting()
 	urlStr := req.URL.String()
@@ -60,6 +70,77 @@ func ResetProxyClientCache() {
 		}
 	}
 	proxyClients = make(map[string]*http.Client)
+
+	profileClientLock.Lock()
+	defer profileClientLock.Unlock()
+	for _, entry := range profileClients {
+		entry.client.CloseIdleConnections()
+	}
+	profileClients = make(map[string]*profileHttpClient)
+}
+
+// GetProfileHttpClient 返回按客户端配置创建的 HTTP 客户端，每个配置与代理的组合使用独立的连接池；
+// 配置修改后关闭旧连接池并按新配置重建。整体超时与共享客户端一致，仍为 RELAY_TIMEOUT
+func GetProfileHttpClient(name string, profile model_setting.ImageHttpClientProfile, proxyURL string) (*http.Client, error) {
+	key := name + "|" + proxyURL
+	profileClientLock.Lock()
+	defer profileClientLock.Unlock()
+	if entry, ok := profileClients[key]; ok {
+		if entry.profile == profile {
+			return entry.client, nil
+		}
+		entry.client.CloseIdleConnections()
+		delete(profileClients, key)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(profile.ConnectTimeoutSeconds) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: time.Duration(profile.ReadTimeoutSeconds) * time.Second,
+		MaxIdleConns:          profile.MaxIdleConns,
+		MaxIdleConnsPerHost:   profile.MaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(profile.IdleConnTimeoutSeconds) * time.Second,
+	}
+	if proxyURL != "" {
+		parsedURL, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		switch parsedURL.Scheme {
+		case "http", "https":
+			transport.Proxy = http.ProxyURL(parsedURL)
+		case "socks5", "socks5h":
+			var auth *proxy.Auth
+			if parsedURL.User != nil {
+				auth = &proxy.Auth{User: parsedURL.User.Username()}
+				auth.Password, _ = parsedURL.User.Password()
+			}
+			socksDialer, err := proxy.SOCKS5("tcp", parsedURL.Host, auth, dialer)
+			if err != nil {
+				return nil, err
+			}
+			transport.Proxy = nil
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return socksDialer.Dial(network, addr)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s, must be http, https, socks5 or socks5h", parsedURL.Scheme)
+		}
+	}
+	client := &http.Client{
+		Transport:     transport,
+		Timeout:       time.Duration(common.RelayTimeout) * time.Second,
+		CheckRedirect: checkRedirect,
+	}
+	profileClients[key] = &profileHttpClient{profile: profile, client: client}
+	return client, nil
 }
 
 // NewProxyHttpClient 创建支持代理的 HTTP 客户端
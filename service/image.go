@@ -2,7 +2,9 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
@@ -175,3 +177,59 @@ func getImageConfig(reader io.Reader) (image.Config, string, error) {
 	}
 	return config, format, nil
 }
+
+// DownloadImage 在 ctx 截止前下载图片，超过 maxBytes 时返回错误，maxBytes 为 0 时使用全局下载大小限制
+func DownloadImage(ctx context.Context, url string, maxBytes int64) ([]byte, error) {
+	type downloadResult struct {
+		data []byte
+		err  error
+	}
+	if maxBytes <= 0 {
+		maxBytes = int64(constant.MaxFileDownloadMB * 1024 * 1024)
+	}
+	// 下载请求不支持取消，缓冲通道保证超时后下载协程仍能退出
+	done := make(chan downloadResult, 1)
+	go func() {
+		resp, err := DoDownloadRequest(url, "image response format conversion")
+		if err != nil {
+			done <- downloadResult{err: fmt.Errorf("failed to download image: %w", err)}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			done <- downloadResult{err: fmt.Errorf("failed to download image: HTTP %d", resp.StatusCode)}
+			return
+		}
+		if resp.ContentLength > maxBytes {
+			done <- downloadResult{err: fmt.Errorf("image size %d exceeds maximum allowed size of %d bytes", resp.ContentLength, maxBytes)}
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+		if err != nil {
+			done <- downloadResult{err: fmt.Errorf("failed to read image data: %w", err)}
+			return
+		}
+		if int64(len(data)) > maxBytes {
+			done <- downloadResult{err: fmt.Errorf("image size exceeds maximum allowed size of %d bytes", maxBytes)}
+			return
+		}
+		done <- downloadResult{data: data}
+	}()
+	select {
+	case result := <-done:
+		return result.data, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CountImageResponseData 返回图片响应 data[] 中的图片数量，响应中没有 data 字段时 ok 为 false
+func CountImageResponseData(body []byte) (count int, ok bool) {
+	var response struct {
+		Data *[]json.RawMessage `json:"data"`
+	}
+	if err := common.Unmarshal(body, &response); err != nil || response.Data == nil {
+		return 0, false
+	}
+	return len(*response.Data), true
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const imageAffinityContextKey = "image_affinity_key"
+
+type imageAffinityBinding struct {
+	channelId int
+	expiresAt time.Time
+}
+
+// 未启用 Redis 时在进程内记录会话绑定，多节点部署时不共享
+var (
+	imageAffinityLock     sync.Mutex
+	imageAffinityBindings = make(map[string]imageAffinityBinding)
+)
+
+// imageAffinitySession 读取客户端的会话标识，请求头优先于 Cookie
+func imageAffinitySession(c *gin.Context, settings *model_setting.ImageSettings) string {
+	if settings.AffinityHeader != "" {
+		if session := strings.TrimSpace(c.GetHeader(settings.AffinityHeader)); session != "" {
+			return session
+		}
+	}
+	if settings.AffinityCookie != "" {
+		if session, err := c.Cookie(settings.AffinityCookie); err == nil {
+			return strings.TrimSpace(session)
+		}
+	}
+	return ""
+}
+
+// 会话标识由客户端提供，按用户隔离避免不同用户共用同一绑定
+func imageAffinityKey(userId int, session string, modelName string) string {
+	return fmt.Sprintf("image_affinity:%d:%s:%s", userId, modelName, session)
+}
+
+func loadImageAffinity(key string) (int, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return 0, false
+		}
+		channelId, err := strconv.Atoi(value)
+		return channelId, err == nil
+	}
+	imageAffinityLock.Lock()
+	defer imageAffinityLock.Unlock()
+	binding, ok := imageAffinityBindings[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(binding.expiresAt) {
+		delete(imageAffinityBindings, key)
+		return 0, false
+	}
+	return binding.channelId, true
+}
+
+func deleteImageAffinity(key string) {
+	if common.RedisEnabled {
+		_ = common.RedisDel(key)
+		return
+	}
+	imageAffinityLock.Lock()
+	defer imageAffinityLock.Unlock()
+	delete(imageAffinityBindings, key)
+}
+
+// GetImageAffinityChannel 返回当前会话绑定的图片渠道，仅作为首选（重试时仍按常规策略选择）。
+// 绑定的渠道已被自动禁用、删除或不再支持当前分组与模型时解除绑定并返回 nil
+func GetImageAffinityChannel(c *gin.Context, group string, modelName string) *model.Channel {
+	settings := model_setting.GetImageSettings()
+	if !settings.AffinityEnabled {
+		return nil
+	}
+	session := imageAffinitySession(c, settings)
+	if session == "" {
+		return nil
+	}
+	key := imageAffinityKey(common.GetContextKeyInt(c, constant.ContextKeyUserId), session, modelName)
+	c.Set(imageAffinityContextKey, key)
+
+	channelId, ok := loadImageAffinity(key)
+	if !ok {
+		return nil
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil || channel == nil || channel.Status != common.ChannelStatusEnabled || !imageChannelSatisfies(c, channel, group, modelName) {
+		logger.LogDebug(c, fmt.Sprintf("image affinity channel #%d is unavailable, binding released", channelId))
+		deleteImageAffinity(key)
+		return nil
+	}
+	return channel
+}
+
+// BindImageAffinity 请求成功后将会话绑定到处理该请求的渠道，并刷新绑定有效期
+func BindImageAffinity(c *gin.Context, channelId int) {
+	key := c.GetString(imageAffinityContextKey)
+	if key == "" {
+		return
+	}
+	ttl := time.Duration(model_setting.GetImageSettings().AffinityTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+	if common.RedisEnabled {
+		if err := common.RedisSet(key, strconv.Itoa(channelId), ttl); err != nil {
+			common.SysError("failed to save image affinity: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	imageAffinityLock.Lock()
+	defer imageAffinityLock.Unlock()
+	if len(imageAffinityBindings) >= 10000 {
+		for k, binding := range imageAffinityBindings {
+			if now.After(binding.expiresAt) {
+				delete(imageAffinityBindings, k)
+			}
+		}
+	}
+	imageAffinityBindings[key] = imageAffinityBinding{channelId: channelId, expiresAt: now.Add(ttl)}
+}
+
+// ReleaseImageAffinity 绑定的渠道请求失败时解除绑定，后续请求重新选择渠道
+func ReleaseImageAffinity(c *gin.Context, channelId int) {
+	key := c.GetString(imageAffinityContextKey)
+	if key == "" {
+		return
+	}
+	if bound, ok := loadImageAffinity(key); ok && bound == channelId {
+		deleteImageAffinity(key)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func withImageAffinity(t *testing.T) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.AffinityEnabled = true
+	settings.AffinityHeader = "X-Session-Id"
+	settings.AffinityCookie = "image_session"
+	settings.AffinityTTLSeconds = 1800
+	resetImageAffinityBindings := func() {
+		imageAffinityLock.Lock()
+		imageAffinityBindings = make(map[string]imageAffinityBinding)
+		imageAffinityLock.Unlock()
+	}
+	resetImageAffinityBindings()
+	t.Cleanup(func() {
+		*settings = previous
+		resetImageAffinityBindings()
+	})
+}
+
+func newImageAffinityContext(userId int, session string) *gin.Context {
+	c := newChannelSelectContext(nil)
+	common.SetContextKey(c, constant.ContextKeyUserId, userId)
+	if session != "" {
+		c.Request.Header.Set("X-Session-Id", session)
+	}
+	return c
+}
+
+func TestImageAffinityKeepsSessionOnChannel(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{10, 0}, []int{common.ChannelStatusEnabled, common.ChannelStatusEnabled})
+	withImageAffinity(t)
+
+	// 首次请求没有绑定，按常规策略选路后绑定到处理成功的渠道 2
+	c := newImageAffinityContext(7, "session-a")
+	if channel := GetImageAffinityChannel(c, "default", "gpt-image-1"); channel != nil {
+		t.Fatalf("new session bound to channel %d", channel.Id)
+	}
+	BindImageAffinity(c, 2)
+
+	// 同一会话的后续请求优先使用渠道 2，即使渠道 1 优先级更高
+	c = newImageAffinityContext(7, "session-a")
+	if channel := GetImageAffinityChannel(c, "default", "gpt-image-1"); channel == nil || channel.Id != 2 {
+		t.Fatalf("affinity channel = %+v, want channel 2", channel)
+	}
+	// 会话标识按用户隔离，也不影响其他会话与其他模型
+	for name, c := range map[string]*gin.Context{
+		"other user":    newImageAffinityContext(8, "session-a"),
+		"other session": newImageAffinityContext(7, "session-b"),
+		"no session":    newImageAffinityContext(7, ""),
+	} {
+		if channel := GetImageAffinityChannel(c, "default", "gpt-image-1"); channel != nil {
+			t.Fatalf("%s: bound to channel %d", name, channel.Id)
+		}
+	}
+	if channel := GetImageAffinityChannel(newImageAffinityContext(7, "session-a"), "default", "dall-e-3"); channel != nil {
+		t.Fatalf("other model bound to channel %d", channel.Id)
+	}
+
+	// 会话标识也可以来自 Cookie
+	c = newImageAffinityContext(7, "")
+	c.Request.Header.Set("Cookie", "image_session=session-a")
+	if channel := GetImageAffinityChannel(c, "default", "gpt-image-1"); channel == nil || channel.Id != 2 {
+		t.Fatalf("cookie session channel = %+v, want channel 2", channel)
+	}
+}
+
+func TestImageAffinityFailover(t *testing.T) {
+	setupChannelTestDB(t)
+	insertTestChannels(t, []int64{0, 0, 0}, []int{common.ChannelStatusEnabled, common.ChannelStatusEnabled, common.ChannelStatusAutoDisabled})
+	withImageAffinity(t)
+
+	c := newImageAffinityContext(7, "session-a")
+	GetImageAffinityChannel(c, "default", "gpt-image-1")
+	BindImageAffinity(c, 2)
+
+	// 其他渠道失败不影响绑定，绑定的渠道失败后解除绑定，由重试时的常规选路接管
+	c = newImageAffinityContext(7, "session-a")
+	GetImageAffinityChannel(c, "default", "gpt-image-1")
+	ReleaseImageAffinity(c, 1)
+	if channel := GetImageAffinityChannel(newImageAffinityContext(7, "session-a"), "default", "gpt-image-1"); channel == nil || channel.Id != 2 {
+		t.Fatalf("binding released by another channel's failure: %+v", channel)
+	}
+	ReleaseImageAffinity(c, 2)
+	c = newImageAffinityContext(7, "session-a")
+	if channel := GetImageAffinityChannel(c, "default", "gpt-image-1"); channel != nil {
+		t.Fatalf("failed channel %d still bound", channel.Id)
+	}
+	// 故障转移到渠道 1 成功后，会话绑定到新渠道
+	BindImageAffinity(c, 1)
+	if channel := GetImageAffinityChannel(newImageAffinityContext(7, "session-a"), "default", "gpt-image-1"); channel == nil || channel.Id != 1 {
+		t.Fatalf("affinity after failover = %+v, want channel 1", channel)
+	}
+
+	// 绑定的渠道被自动禁用时不再使用，并解除绑定
+	c = newImageAffinityContext(7, "session-b")
+	GetImageAffinityChannel(c, "default", "gpt-image-1")
+	BindImageAffinity(c, 3)
+	if channel := GetImageAffinityChannel(newImageAffinityContext(7, "session-b"), "default", "gpt-image-1"); channel != nil {
+		t.Fatalf("disabled channel %d was used", channel.Id)
+	}
+	if _, ok := loadImageAffinity(imageAffinityKey(7, "session-b", "gpt-image-1")); ok {
+		t.Fatal("binding to a disabled channel was kept")
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+
+	"github.com/QuantumNous/new-api/dto"
+
+	"golang.org/x/image/webp"
+)
+
+// webpFrame 动画 WebP 中的一帧（ANMF 块）
+type webpFrame struct {
+	x, y     int
+	dispose  bool // 显示后将该区域清空
+	noBlend  bool // 直接覆盖而不是按透明度混合
+	bitmap   []byte
+	hasAlpha bool
+}
+
+// StaticFrameBase64Image 动图（GIF、动画 WebP）按选取方式合成一帧并以 PNG 返回，
+// 静态图片原样返回且 converted 为 false
+func StaticFrameBase64Image(b64 string, selection string) (result string, converted bool, err error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", false, fmt.Errorf("decode base64 image failed: %w", err)
+	}
+	var frame image.Image
+	switch SniffImageFormat(data) {
+	case "gif":
+		frame, converted, err = gifStaticFrame(data, selection)
+	case "webp":
+		frame, converted, err = webpStaticFrame(data, selection)
+	}
+	if err != nil || !converted {
+		return b64, false, err
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, frame); err != nil {
+		return "", false, fmt.Errorf("encode static frame failed: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), true, nil
+}
+
+func staticFrameIndex(count int, selection string) int {
+	if selection == dto.ImageStaticFrameMiddle {
+		return count / 2
+	}
+	return 0
+}
+
+// gifStaticFrame 按 GIF 的处置方式依次合成到目标帧
+func gifStaticFrame(data []byte, selection string) (image.Image, bool, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("decode gif failed: %w", err)
+	}
+	if len(anim.Image) <= 1 {
+		return nil, false, nil
+	}
+	target := staticFrameIndex(len(anim.Image), selection)
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	for i := 0; i <= target; i++ {
+		frame := anim.Image[i]
+		var previous *image.RGBA
+		if i < target && i < len(anim.Disposal) && anim.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == target || i >= len(anim.Disposal) {
+			continue
+		}
+		switch anim.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return canvas, true, nil
+}
+
+// webpStaticFrame 解析动画 WebP 的 ANMF 块并按混合与处置方式依次合成到目标帧。
+// golang.org/x/image/webp 不支持动画，每一帧会被封装为独立的 WebP 后再解码
+func webpStaticFrame(data []byte, selection string) (image.Image, bool, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false, errors.New("invalid webp header")
+	}
+	var (
+		canvasWidth, canvasHeight int
+		animated                  bool
+		frames                    []webpFrame
+	)
+	for offset := 12; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		if size < 0 || start+size > len(data) {
+			return nil, false, errors.New("truncated webp chunk")
+		}
+		payload := data[start : start+size]
+		switch fourCC {
+		case "VP8X":
+			if len(payload) < 10 {
+				return nil, false, errors.New("invalid webp VP8X chunk")
+			}
+			animated = payload[0]&0x02 != 0
+			canvasWidth = int(readUint24(payload[4:7])) + 1
+			canvasHeight = int(readUint24(payload[7:10])) + 1
+		case "ANMF":
+			frame, err := parseWebpFrame(payload)
+			if err != nil {
+				return nil, false, err
+			}
+			frames = append(frames, frame)
+		}
+		offset = start + size + size%2
+	}
+	if !animated || len(frames) <= 1 {
+		return nil, false, nil
+	}
+
+	target := staticFrameIndex(len(frames), selection)
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
+	for i := 0; i <= target; i++ {
+		frame := frames[i]
+		img, err := webp.Decode(bytes.NewReader(wrapWebpFrame(frame)))
+		if err != nil {
+			return nil, false, fmt.Errorf("decode webp frame %d failed: %w", i, err)
+		}
+		rect := img.Bounds().Sub(img.Bounds().Min).Add(image.Pt(frame.x, frame.y))
+		op := draw.Over
+		if frame.noBlend {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+		if i < target && frame.dispose {
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return canvas, true, nil
+}
+
+func parseWebpFrame(payload []byte) (webpFrame, error) {
+	if len(payload) < 16 {
+		return webpFrame{}, errors.New("invalid webp ANMF chunk")
+	}
+	frame := webpFrame{
+		x:       int(readUint24(payload[0:3])) * 2,
+		y:       int(readUint24(payload[3:6])) * 2,
+		dispose: payload[15]&0x01 != 0,
+		noBlend: payload[15]&0x02 != 0,
+	}
+	// 帧数据由可选的 ALPH 块与 VP8/VP8L 块组成，原样保留用于重新封装
+	frameData := payload[16:]
+	for offset := 0; offset+8 <= len(frameData); {
+		fourCC := string(frameData[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(frameData[offset+4 : offset+8]))
+		end := offset + 8 + size + size%2
+		if end > len(frameData) {
+			end = len(frameData)
+		}
+		if fourCC == "ALPH" {
+			frame.hasAlpha = true
+		}
+		offset = end
+	}
+	frame.bitmap = frameData
+	return frame, nil
+}
+
+// wrapWebpFrame 将一帧的位图数据封装为独立的 WebP 文件，带透明通道时需要 VP8X 头
+func wrapWebpFrame(frame webpFrame) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if frame.hasAlpha {
+		width, height := webpBitmapSize(frame.bitmap)
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		putUint24(vp8x[4:7], uint32(max(width-1, 0)))
+		putUint24(vp8x[7:10], uint32(max(height-1, 0)))
+		body.WriteString("VP8X")
+		_ = binary.Write(&body, binary.LittleEndian, uint32(len(vp8x)))
+		body.Write(vp8x)
+	}
+	body.Write(frame.bitmap)
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	_ = binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// webpBitmapSize 从 VP8 / VP8L 块头读取帧尺寸
+func webpBitmapSize(frameData []byte) (int, int) {
+	for offset := 0; offset+8 <= len(frameData); {
+		fourCC := string(frameData[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(frameData[offset+4 : offset+8]))
+		payload := frameData[offset+8 : min(offset+8+size, len(frameData))]
+		switch {
+		case fourCC == "VP8 " && len(payload) >= 10:
+			return int(binary.LittleEndian.Uint16(payload[6:8]) & 0x3fff), int(binary.LittleEndian.Uint16(payload[8:10]) & 0x3fff)
+		case fourCC == "VP8L" && len(payload) >= 5:
+			bits := binary.LittleEndian.Uint32(payload[1:5])
+			return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1
+		}
+		offset += 8 + size + size%2
+	}
+	return 0, 0
+}
+
+func readUint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+var animationTestColors = []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+
+func appendWebpChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// newAnimatedWebp 生成每帧为纯色、覆盖整个画布的动画 WebP，帧颜色依次为 animationTestColors
+func newAnimatedWebp(t *testing.T, size int) []byte {
+	t.Helper()
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // 动画
+	putUint24(vp8x[4:7], uint32(size-1))
+	putUint24(vp8x[7:10], uint32(size-1))
+	appendWebpChunk(&body, "VP8X", vp8x)
+	appendWebpChunk(&body, "ANIM", make([]byte, 6))
+	for _, c := range animationTestColors {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		var still bytes.Buffer
+		if err := EncodeWebp(&still, img); err != nil {
+			t.Fatalf("encode frame: %v", err)
+		}
+		// 帧头：偏移、尺寸、时长与标志（不混合），随后是该帧的位图块
+		frame := make([]byte, 16)
+		putUint24(frame[6:9], uint32(size-1))
+		putUint24(frame[9:12], uint32(size-1))
+		putUint24(frame[12:15], 100)
+		frame[15] = 0x02
+		data := still.Bytes()
+		for offset := 12; offset+8 <= len(data); {
+			chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+			end := min(offset+8+chunkSize+chunkSize%2, len(data))
+			if fourCC := string(data[offset : offset+4]); fourCC != "VP8X" {
+				frame = append(frame, data[offset:end]...)
+			}
+			offset = end
+		}
+		appendWebpChunk(&body, "ANMF", frame)
+	}
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	_ = binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func TestStaticFrameFromAnimatedWebp(t *testing.T) {
+	animated := base64.StdEncoding.EncodeToString(newAnimatedWebp(t, 8))
+	for selection, want := range map[string]color.NRGBA{
+		dto.ImageStaticFrameFirst:  animationTestColors[0],
+		dto.ImageStaticFrameMiddle: animationTestColors[1],
+	} {
+		result, converted, err := StaticFrameBase64Image(animated, selection)
+		if err != nil || !converted {
+			t.Fatalf("%s: converted %v, err %v", selection, converted, err)
+		}
+		data, _ := base64.StdEncoding.DecodeString(result)
+		if format := SniffImageFormat(data); format != "png" {
+			t.Fatalf("%s: static frame format = %q, want png", selection, format)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode static frame: %v", selection, err)
+		}
+		if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 8 {
+			t.Fatalf("%s: static frame is %v, want the 8x8 canvas", selection, img.Bounds())
+		}
+		if got := color.NRGBAModel.Convert(img.At(4, 4)).(color.NRGBA); got != want {
+			t.Fatalf("%s: static frame color = %v, want %v", selection, got, want)
+		}
+	}
+}
+
+func TestStaticFrameKeepsStillImages(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	var webpBuf, pngBuf bytes.Buffer
+	if err := EncodeWebp(&webpBuf, img); err != nil {
+		t.Fatalf("encode webp: %v", err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	for _, data := range [][]byte{webpBuf.Bytes(), pngBuf.Bytes()} {
+		b64 := base64.StdEncoding.EncodeToString(data)
+		result, converted, err := StaticFrameBase64Image(b64, dto.ImageStaticFrameFirst)
+		if err != nil || converted || result != b64 {
+			t.Fatalf("still %s image changed: converted %v, err %v", SniffImageFormat(data), converted, err)
+		}
+	}
+}
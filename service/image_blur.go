@@ -0,0 +1,48 @@
+package service
+
+import (
+	"image"
+	"image/color"
+)
+
+// imageBlurBlocks 模糊后图片长边上的色块数量，数值越小越模糊
+const imageBlurBlocks = 24
+
+// BlurBase64Image 对 base64 图片做马赛克模糊，JPEG 保持原格式，其余格式统一输出 PNG，返回新的 base64 数据与格式
+func BlurBase64Image(b64 string) (string, string, error) {
+	img, format, err := decodeBase64Image(b64)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeBase64Image(pixelateImage(img, imageBlurBlocks), format)
+}
+
+// pixelateImage 将图片划分为色块并以块内平均色填充
+func pixelateImage(src image.Image, blocks int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	blockSize := max(width, height) / blocks
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	for by := 0; by < height; by += blockSize {
+		for bx := 0; bx < width; bx += blockSize {
+			maxX, maxY := min(bx+blockSize, width), min(by+blockSize, height)
+			var r, g, b, a, n uint64
+			for y := by; y < maxY; y++ {
+				for x := bx; x < maxX; x++ {
+					cr, cg, cb, ca := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			avg := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			for y := by; y < maxY; y++ {
+				for x := bx; x < maxX; x++ {
+					dst.Set(x, y, avg)
+				}
+			}
+		}
+	}
+	return dst
+}
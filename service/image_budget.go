@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// 未启用 Redis 时在进程内记录用户图片消费，重启或多节点部署时不共享
+var (
+	imageBudgetLock  sync.Mutex
+	imageBudgetSpent = make(map[string]int)
+	imageBudgetCycle string
+)
+
+// imageBudgetCycleWindow 返回当前预算周期的标识与结束时间
+func imageBudgetCycleWindow(cycle string, now time.Time) (string, time.Time) {
+	year, month, day := now.Date()
+	switch cycle {
+	case model_setting.ImageBudgetCycleDaily:
+		start := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+		return start.Format("20060102"), start.AddDate(0, 0, 1)
+	case model_setting.ImageBudgetCycleWeekly:
+		// 以周一为一周的开始
+		offset := (int(now.Weekday()) + 6) % 7
+		start := time.Date(year, month, day-offset, 0, 0, 0, 0, now.Location())
+		return "w" + start.Format("20060102"), start.AddDate(0, 0, 7)
+	default:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start.Format("200601"), start.AddDate(0, 1, 0)
+	}
+}
+
+func imageBudgetKey(cycleKey string, userId int) string {
+	return fmt.Sprintf("image_budget:%s:%d", cycleKey, userId)
+}
+
+// GetUserImageBudgetSpent 返回用户在当前周期内的图片消费额度
+func GetUserImageBudgetSpent(userId int) int {
+	cycleKey, _ := imageBudgetCycleWindow(model_setting.GetImageSettings().BudgetCycle, time.Now())
+	key := imageBudgetKey(cycleKey, userId)
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return 0
+		}
+		spent, _ := strconv.Atoi(value)
+		return spent
+	}
+	imageBudgetLock.Lock()
+	defer imageBudgetLock.Unlock()
+	return imageBudgetSpent[key]
+}
+
+// AddUserImageBudgetSpent 累加用户在当前周期内的图片消费额度
+func AddUserImageBudgetSpent(userId int, quota int) {
+	if quota <= 0 {
+		return
+	}
+	cycleKey, cycleEnd := imageBudgetCycleWindow(model_setting.GetImageSettings().BudgetCycle, time.Now())
+	key := imageBudgetKey(cycleKey, userId)
+	if common.RedisEnabled {
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		pipe.IncrBy(ctx, key, int64(quota))
+		pipe.ExpireAt(ctx, key, cycleEnd)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError("failed to record image budget spend: " + err.Error())
+		}
+		return
+	}
+	imageBudgetLock.Lock()
+	defer imageBudgetLock.Unlock()
+	if imageBudgetCycle != cycleKey {
+		// 进入新周期时丢弃上一周期的记录
+		imageBudgetSpent = make(map[string]int)
+		imageBudgetCycle = cycleKey
+	}
+	imageBudgetSpent[key] += quota
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrImageGenerationNotFound  = errors.New("image generation not found")
+	ErrImageGenerationCompleted = errors.New("image generation already completed")
+)
+
+// imageGeneration 进行中的图片生成请求，仅在当前节点内可取消
+type imageGeneration struct {
+	userId    int
+	cancel    context.CancelFunc
+	cancelled bool
+	completed bool
+}
+
+var (
+	imageGenerationsLock sync.Mutex
+	imageGenerations     = make(map[string]*imageGeneration)
+)
+
+// RegisterImageGeneration 登记进行中的图片生成请求，id 为请求 ID
+func RegisterImageGeneration(id string, userId int, cancel context.CancelFunc) {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	imageGenerations[id] = &imageGeneration{userId: userId, cancel: cancel}
+}
+
+// CompleteImageGeneration 上游已返回结果时标记完成，之后的取消请求将被拒绝；已被取消时返回 false
+func CompleteImageGeneration(id string) bool {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	generation, ok := imageGenerations[id]
+	if !ok {
+		return true
+	}
+	if generation.cancelled {
+		return false
+	}
+	generation.completed = true
+	return true
+}
+
+// IsImageGenerationCancelled 判断请求是否被客户端主动取消
+func IsImageGenerationCancelled(id string) bool {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	generation, ok := imageGenerations[id]
+	return ok && generation.cancelled
+}
+
+// UnregisterImageGeneration 请求结束后移除登记
+func UnregisterImageGeneration(id string) {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	delete(imageGenerations, id)
+}
+
+// AbortImageGeneration 客户端断开连接时取消请求（不校验用户），上游已返回结果时不做处理并返回 false
+func AbortImageGeneration(id string) bool {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	generation, ok := imageGenerations[id]
+	if !ok || generation.completed {
+		return false
+	}
+	if !generation.cancelled {
+		generation.cancelled = true
+		generation.cancel()
+	}
+	return true
+}
+
+// CancelImageGeneration 取消指定用户进行中的图片生成请求，上游已返回结果时返回 ErrImageGenerationCompleted
+func CancelImageGeneration(id string, userId int) error {
+	imageGenerationsLock.Lock()
+	defer imageGenerationsLock.Unlock()
+	generation, ok := imageGenerations[id]
+	if !ok || generation.userId != userId {
+		return ErrImageGenerationNotFound
+	}
+	if generation.completed {
+		return ErrImageGenerationCompleted
+	}
+	if !generation.cancelled {
+		generation.cancelled = true
+		generation.cancel()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// startImageGeneration 登记一次进行中的生成请求，模拟的上游请求阻塞到 release 关闭或被取消，
+// 结束后按请求处理流程返回是否被取消
+func startImageGeneration(t *testing.T, id string, userId int, release chan struct{}) <-chan bool {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	RegisterImageGeneration(id, userId, cancel)
+	t.Cleanup(func() {
+		cancel()
+		UnregisterImageGeneration(id)
+	})
+	cancelled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-release:
+			cancelled <- !CompleteImageGeneration(id)
+		case <-ctx.Done():
+			cancelled <- IsImageGenerationCancelled(id)
+		}
+	}()
+	return cancelled
+}
+
+func TestCancelInFlightImageGeneration(t *testing.T) {
+	release := make(chan struct{})
+	cancelled := startImageGeneration(t, "req-cancel", 7, release)
+
+	// 其他用户不能取消
+	if err := CancelImageGeneration("req-cancel", 8); !errors.Is(err, ErrImageGenerationNotFound) {
+		t.Fatalf("cancel by another user: %v, want ErrImageGenerationNotFound", err)
+	}
+	if err := CancelImageGeneration("req-cancel", 7); err != nil {
+		t.Fatalf("cancel in-flight generation: %v", err)
+	}
+	select {
+	case ok := <-cancelled:
+		if !ok {
+			t.Fatal("aborted generation was not reported as cancelled")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancel did not abort the upstream request")
+	}
+	// 重复取消保持幂等，取消后上游返回的结果不能再被标记完成
+	if err := CancelImageGeneration("req-cancel", 7); err != nil {
+		t.Fatalf("repeated cancel: %v", err)
+	}
+	if CompleteImageGeneration("req-cancel") {
+		t.Fatal("cancelled generation was completed")
+	}
+}
+
+func TestCancelCompletedImageGeneration(t *testing.T) {
+	release := make(chan struct{})
+	cancelled := startImageGeneration(t, "req-done", 7, release)
+	close(release)
+	if <-cancelled {
+		t.Fatal("generation completed without cancel was reported as cancelled")
+	}
+
+	if err := CancelImageGeneration("req-done", 7); !errors.Is(err, ErrImageGenerationCompleted) {
+		t.Fatalf("cancel after completion: %v, want ErrImageGenerationCompleted", err)
+	}
+	// 上游返回后客户端断开连接不当作取消
+	if AbortImageGeneration("req-done") || IsImageGenerationCancelled("req-done") {
+		t.Fatal("completed generation was aborted")
+	}
+
+	UnregisterImageGeneration("req-done")
+	if err := CancelImageGeneration("req-done", 7); !errors.Is(err, ErrImageGenerationNotFound) {
+		t.Fatalf("cancel after unregister: %v, want ErrImageGenerationNotFound", err)
+	}
+}
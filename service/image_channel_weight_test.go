@@ -0,0 +1,108 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// setTestChannelWeights 按渠道 ID（从 1 开始）设置权重并重新加载渠道缓存
+func setTestChannelWeights(t *testing.T, weights []uint) {
+	t.Helper()
+	for i, weight := range weights {
+		if err := model.DB.Model(&model.Channel{}).Where("id = ?", i+1).Update("weight", weight).Error; err != nil {
+			t.Fatalf("set weight of channel %d: %v", i+1, err)
+		}
+	}
+	model.InitChannelCache()
+}
+
+// withImageCircuitBreaker 开启熔断器，单次失败即熔断，结束后清空熔断状态
+func withImageCircuitBreaker(t *testing.T) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.CircuitBreakerEnabled = true
+	settings.CircuitBreakerFailureThreshold = 1
+	settings.CircuitBreakerOpenSeconds = 60
+	resetImageCircuitBreakers := func() {
+		imageCircuitBreakersLock.Lock()
+		imageCircuitBreakers = make(map[int]*imageCircuitBreaker)
+		imageCircuitBreakersLock.Unlock()
+	}
+	resetImageCircuitBreakers()
+	t.Cleanup(func() {
+		*settings = previous
+		resetImageCircuitBreakers()
+	})
+}
+
+// sampleImageChannels 多次选择渠道，返回各渠道被选中的比例
+func sampleImageChannels(t *testing.T, rounds int) map[int]float64 {
+	t.Helper()
+	counts := make(map[int]int)
+	for i := 0; i < rounds; i++ {
+		channel, _, err := CacheGetRandomSatisfiedImageChannel(newChannelSelectContext(nil), "default", "gpt-image-1")
+		if err != nil || channel == nil {
+			t.Fatalf("select image channel: %+v (%v)", channel, err)
+		}
+		counts[channel.Id]++
+	}
+	shares := make(map[int]float64, len(counts))
+	for id, count := range counts {
+		shares[id] = float64(count) / float64(rounds)
+	}
+	return shares
+}
+
+func TestImageChannelSelectionFollowsWeights(t *testing.T) {
+	setupChannelTestDB(t)
+	enabled := common.ChannelStatusEnabled
+	insertTestChannels(t, []int64{10, 10, 10, 0}, []int{enabled, enabled, enabled, enabled})
+	// 最高优先级内按 1:3:6 分配，低优先级渠道权重再大也不参与
+	setTestChannelWeights(t, []uint{10, 30, 60, 1000})
+	withImageCircuitBreaker(t)
+
+	shares := sampleImageChannels(t, 5000)
+	for id, want := range map[int]float64{1: 0.1, 2: 0.3, 3: 0.6, 4: 0} {
+		if math.Abs(shares[id]-want) > 0.04 {
+			t.Fatalf("channel %d selected %.3f of the time, want about %.2f (shares %v)", id, shares[id], want, shares)
+		}
+	}
+
+	// 熔断的渠道不参与选择，其余渠道按权重重新分配
+	RecordImageChannelResult(3, false)
+	shares = sampleImageChannels(t, 4000)
+	for id, want := range map[int]float64{1: 0.25, 2: 0.75, 3: 0, 4: 0} {
+		if math.Abs(shares[id]-want) > 0.04 {
+			t.Fatalf("with channel 3 open, channel %d selected %.3f of the time, want about %.2f (shares %v)", id, shares[id], want, shares)
+		}
+	}
+}
+
+func TestImageChannelSelectionFallsBackToNextPriority(t *testing.T) {
+	setupChannelTestDB(t)
+	enabled := common.ChannelStatusEnabled
+	insertTestChannels(t, []int64{10, 10, 0, 0}, []int{enabled, common.ChannelStatusManuallyDisabled, enabled, enabled})
+	setTestChannelWeights(t, []uint{50, 50, 20, 80})
+	withImageCircuitBreaker(t)
+
+	// 最高优先级中唯一启用的渠道熔断后，落到下一优先级并按权重选择
+	RecordImageChannelResult(1, false)
+	shares := sampleImageChannels(t, 4000)
+	for id, want := range map[int]float64{1: 0, 2: 0, 3: 0.2, 4: 0.8} {
+		if math.Abs(shares[id]-want) > 0.04 {
+			t.Fatalf("channel %d selected %.3f of the time, want about %.2f (shares %v)", id, shares[id], want, shares)
+		}
+	}
+
+	// 全部渠道熔断时忽略熔断状态，由调用方返回熔断错误
+	RecordImageChannelResult(3, false)
+	RecordImageChannelResult(4, false)
+	if channel, _, err := CacheGetRandomSatisfiedImageChannel(newChannelSelectContext(nil), "default", "gpt-image-1"); err != nil || channel == nil || channel.Id != 1 {
+		t.Fatalf("every channel open: selected %+v (%v), want the top priority channel 1", channel, err)
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// 图片渠道熔断器状态
+const (
+	ImageCircuitClosed   = "closed"
+	ImageCircuitOpen     = "open"
+	ImageCircuitHalfOpen = "half_open"
+)
+
+type imageCircuitBreaker struct {
+	state               string
+	consecutiveFailures int
+	lastFailureAt       time.Time
+	openUntil           time.Time
+	openDuration        time.Duration
+	probing             bool // 半开状态下已放行探测请求，结果返回前拒绝其他请求
+}
+
+// ImageCircuitBreakerState 渠道熔断器的当前状态，供管理接口与指标使用
+type ImageCircuitBreakerState struct {
+	ChannelId           int    `json:"channel_id"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenUntil           int64  `json:"open_until,omitempty"`
+	OpenSeconds         int    `json:"open_seconds,omitempty"` // 本次熔断时长，探测失败后翻倍
+}
+
+var (
+	imageCircuitBreakers     = make(map[int]*imageCircuitBreaker)
+	imageCircuitBreakersLock sync.Mutex
+)
+
+// AllowImageChannel 判断是否允许向渠道发送图片请求。熔断时长结束后转为半开并只放行一个探测请求，
+// 放行的请求必须通过 RecordImageChannelResult 或 ReleaseImageChannelProbe 结束
+func AllowImageChannel(channelId int) bool {
+	if !model_setting.GetImageSettings().CircuitBreakerEnabled {
+		return true
+	}
+	imageCircuitBreakersLock.Lock()
+	defer imageCircuitBreakersLock.Unlock()
+	breaker, ok := imageCircuitBreakers[channelId]
+	if !ok {
+		return true
+	}
+	switch breaker.state {
+	case ImageCircuitOpen:
+		if time.Now().Before(breaker.openUntil) {
+			return false
+		}
+		breaker.state = ImageCircuitHalfOpen
+		breaker.probing = true
+		common.SysLog(fmt.Sprintf("image circuit breaker of channel #%d is half-open, probing", channelId))
+		return true
+	case ImageCircuitHalfOpen:
+		if breaker.probing {
+			return false
+		}
+		breaker.probing = true
+		return true
+	}
+	return true
+}
+
+// GetBlockedImageChannels 返回当前不接受图片请求的渠道（熔断中，或半开且探测请求尚未返回），供渠道选择时排除。
+// 只读取状态，不会触发半开转换
+func GetBlockedImageChannels() map[int]bool {
+	if !model_setting.GetImageSettings().CircuitBreakerEnabled {
+		return nil
+	}
+	now := time.Now()
+	imageCircuitBreakersLock.Lock()
+	defer imageCircuitBreakersLock.Unlock()
+	var blocked map[int]bool
+	for channelId, breaker := range imageCircuitBreakers {
+		if (breaker.state == ImageCircuitOpen && now.Before(breaker.openUntil)) || (breaker.state == ImageCircuitHalfOpen && breaker.probing) {
+			if blocked == nil {
+				blocked = make(map[int]bool)
+			}
+			blocked[channelId] = true
+		}
+	}
+	return blocked
+}
+
+// RecordImageChannelResult 记录一次发往上游的结果：连续失败达到阈值时熔断；半开状态下探测成功则恢复，失败则加倍熔断时长
+func RecordImageChannelResult(channelId int, success bool) {
+	settings := model_setting.GetImageSettings()
+	if !settings.CircuitBreakerEnabled {
+		return
+	}
+	now := time.Now()
+	imageCircuitBreakersLock.Lock()
+	defer imageCircuitBreakersLock.Unlock()
+	breaker, ok := imageCircuitBreakers[channelId]
+	if success {
+		if !ok {
+			return
+		}
+		if breaker.state != ImageCircuitClosed {
+			common.SysLog(fmt.Sprintf("image circuit breaker of channel #%d is closed", channelId))
+		}
+		delete(imageCircuitBreakers, channelId)
+		return
+	}
+	if !ok {
+		breaker = &imageCircuitBreaker{state: ImageCircuitClosed}
+		imageCircuitBreakers[channelId] = breaker
+	}
+	baseOpen := time.Duration(max(settings.CircuitBreakerOpenSeconds, 1)) * time.Second
+	maxOpen := max(time.Duration(settings.CircuitBreakerMaxOpenSeconds)*time.Second, baseOpen)
+	switch breaker.state {
+	case ImageCircuitHalfOpen:
+		breaker.openDuration = min(breaker.openDuration*2, maxOpen)
+		breaker.consecutiveFailures++
+		breaker.lastFailureAt = now
+		breaker.openUntil = now.Add(breaker.openDuration)
+		breaker.state = ImageCircuitOpen
+		breaker.probing = false
+		common.SysLog(fmt.Sprintf("image circuit breaker of channel #%d probe failed, reopened for %s", channelId, breaker.openDuration))
+	case ImageCircuitClosed:
+		window := time.Duration(settings.CircuitBreakerWindowSeconds) * time.Second
+		if window > 0 && !breaker.lastFailureAt.IsZero() && now.Sub(breaker.lastFailureAt) > window {
+			breaker.consecutiveFailures = 0
+		}
+		breaker.consecutiveFailures++
+		breaker.lastFailureAt = now
+		if breaker.consecutiveFailures >= max(settings.CircuitBreakerFailureThreshold, 1) {
+			breaker.openDuration = baseOpen
+			breaker.openUntil = now.Add(baseOpen)
+			breaker.state = ImageCircuitOpen
+			common.SysLog(fmt.Sprintf("image circuit breaker of channel #%d opened after %d consecutive failures", channelId, breaker.consecutiveFailures))
+		}
+	}
+}
+
+// ReleaseImageChannelProbe 放行的请求没有发往上游（参数错误、命中缓存等）时释放探测机会，不改变熔断状态
+func ReleaseImageChannelProbe(channelId int) {
+	imageCircuitBreakersLock.Lock()
+	defer imageCircuitBreakersLock.Unlock()
+	if breaker, ok := imageCircuitBreakers[channelId]; ok && breaker.state == ImageCircuitHalfOpen {
+		breaker.probing = false
+	}
+}
+
+// GetImageCircuitBreakerStates 返回有失败记录的渠道熔断状态，未列出的渠道均为 closed
+func GetImageCircuitBreakerStates() []ImageCircuitBreakerState {
+	imageCircuitBreakersLock.Lock()
+	states := make([]ImageCircuitBreakerState, 0, len(imageCircuitBreakers))
+	for channelId, breaker := range imageCircuitBreakers {
+		state := ImageCircuitBreakerState{
+			ChannelId:           channelId,
+			State:               breaker.state,
+			ConsecutiveFailures: breaker.consecutiveFailures,
+		}
+		if breaker.state != ImageCircuitClosed {
+			state.OpenUntil = breaker.openUntil.Unix()
+			state.OpenSeconds = int(breaker.openDuration.Seconds())
+		}
+		states = append(states, state)
+	}
+	imageCircuitBreakersLock.Unlock()
+	sort.Slice(states, func(i, j int) bool { return states[i].ChannelId < states[j].ChannelId })
+	return states
+}
+
+// WriteImageCircuitBreakerMetrics 以 Prometheus 文本格式输出熔断中的渠道，0 closed / 1 half_open / 2 open
+func WriteImageCircuitBreakerMetrics(w io.Writer) {
+	const name = "new_api_image_circuit_breaker_state"
+	fmt.Fprintf(w, "# HELP %s Image channel circuit breaker state (0 closed, 1 half_open, 2 open).\n", name)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	for _, state := range GetImageCircuitBreakerStates() {
+		value := 0
+		switch state.State {
+		case ImageCircuitHalfOpen:
+			value = 1
+		case ImageCircuitOpen:
+			value = 2
+		}
+		fmt.Fprintf(w, "%s{channel=\"%d\"} %d\n", name, state.ChannelId, value)
+	}
+}
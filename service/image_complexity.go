@@ -0,0 +1,26 @@
+package service
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ScoreImagePromptComplexity 估算提示词复杂度（0-100）：长度最多贡献 60 分，每命中一个关键词贡献 10 分，最多 40 分
+func ScoreImagePromptComplexity(prompt string, keywords []string, fullLength int) float64 {
+	if fullLength <= 0 {
+		fullLength = 400
+	}
+	lengthScore := float64(utf8.RuneCountInString(strings.TrimSpace(prompt))) / float64(fullLength)
+	if lengthScore > 1 {
+		lengthScore = 1
+	}
+	lower := strings.ToLower(prompt)
+	hits := 0
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" && strings.Contains(lower, keyword) {
+			hits++
+		}
+	}
+	return lengthScore*60 + float64(min(hits, 4))*10
+}
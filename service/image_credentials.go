@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ExtractContentCredentials 提取图片中的内容凭证（C2PA 清单）：PNG 的 caBX 块与 JPEG 的 APP11（JUMBF）段，
+// 返回图片格式与原样保留的块数据（包含长度与校验等头部）
+func ExtractContentCredentials(data []byte) (format string, segments [][]byte) {
+	switch SniffImageFormat(data) {
+	case "png":
+		for offset := len(pngSignature); offset+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+			end := offset + 12 + length
+			if length < 0 || end > len(data) {
+				break
+			}
+			if string(data[offset+4:offset+8]) == "caBX" {
+				segments = append(segments, data[offset:end])
+			}
+			offset = end
+		}
+		return "png", segments
+	case "jpeg":
+		for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+			marker := data[offset+1]
+			// SOS 之后是图像数据，元数据段都在此之前
+			if marker == 0xDA {
+				break
+			}
+			end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+			if end > len(data) {
+				break
+			}
+			if marker == 0xEB {
+				segments = append(segments, data[offset:end])
+			}
+			offset = end
+		}
+		return "jpeg", segments
+	}
+	return "", nil
+}
+
+// InjectContentCredentials 将提取的内容凭证写回同格式的图片：PNG 插入到 IHDR 之后，JPEG 插入到 SOI 之后。
+// 图片内容变化后清单中的哈希绑定不再匹配，但凭证本身得以保留供下游校验
+func InjectContentCredentials(data []byte, format string, segments [][]byte) ([]byte, error) {
+	if len(segments) == 0 {
+		return data, nil
+	}
+	if SniffImageFormat(data) != format {
+		return nil, errors.New("content credentials can only be preserved within the same image format")
+	}
+	var insertAt int
+	switch format {
+	case "png":
+		// 签名 8 字节 + IHDR 块 25 字节
+		insertAt = len(pngSignature) + 25
+	case "jpeg":
+		insertAt = 2
+	default:
+		return nil, errors.New("content credentials are not supported for this image format")
+	}
+	if len(data) < insertAt {
+		return nil, errors.New("image is too short")
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	buf.Write(data[:insertAt])
+	for _, segment := range segments {
+		buf.Write(segment)
+	}
+	buf.Write(data[insertAt:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+)
+
+// ImageContentTypeUnknown 无法识别图片格式时使用的 Content-Type
+const ImageContentTypeUnknown = "application/octet-stream"
+
+// SniffImageFormat 根据文件头识别图片格式，返回 png/jpeg/webp/gif/avif，无法识别时返回空字符串
+func SniffImageFormat(data []byte) string {
+	// http.DetectContentType 不识别 AVIF，按 ftyp 盒的主品牌判断
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return "avif"
+	}
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpeg"
+	case "image/webp":
+		return "webp"
+	case "image/gif":
+		return "gif"
+	default:
+		return ""
+	}
+}
+
+// ImageContentType 根据文件头返回图片的 Content-Type（image/png、image/jpeg、image/webp、image/gif、image/avif），
+// 写入存储与直接返回图片字节时使用，避免浏览器无法预览。无法识别时返回 application/octet-stream 并记录日志
+func ImageContentType(ctx context.Context, data []byte) string {
+	if format := SniffImageFormat(data); format != "" {
+		return "image/" + format
+	}
+	head := data
+	if len(head) > 8 {
+		head = head[:8]
+	}
+	logger.LogWarn(ctx, fmt.Sprintf("unable to detect image content type from leading bytes %x (%d bytes), using %s", head, len(data), ImageContentTypeUnknown))
+	return ImageContentTypeUnknown
+}
+
+// SniffBase64ImageFormat 只解码 base64 数据开头的少量字节来识别图片格式，避免解码整张图片
+func SniffBase64ImageFormat(b64 string) string {
+	if strings.HasPrefix(b64, "data:") {
+		if idx := strings.Index(b64, ","); idx != -1 {
+			b64 = b64[idx+1:]
+		}
+	}
+	if len(b64) > 64 {
+		b64 = b64[:64]
+	}
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return ""
+	}
+	return SniffImageFormat(data)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+type imageGenerationParamsEntry struct {
+	params    map[string]string
+	expiresAt time.Time
+}
+
+// 未启用 Redis 时在进程内保存生成参数，重启或多节点部署时不共享
+var (
+	imageGenerationParamsLock sync.Mutex
+	imageGenerationParams     = make(map[string]imageGenerationParamsEntry)
+)
+
+// 按用户隔离，避免通过他人的生成 ID 读取参数
+func imageGenerationParamsKey(userId int, generationId string) string {
+	return fmt.Sprintf("image_generation_params:%d:%s", userId, generationId)
+}
+
+// SaveImageGenerationParams 保存一次生成使用的参数，键为参数名，值为 JSON 编码后的参数值
+func SaveImageGenerationParams(userId int, generationId string, params map[string]string, ttl time.Duration) {
+	key := imageGenerationParamsKey(userId, generationId)
+	if common.RedisEnabled {
+		raw, err := common.Marshal(params)
+		if err == nil {
+			err = common.RedisSet(key, string(raw), ttl)
+		}
+		if err != nil {
+			common.SysError("failed to save image generation params: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	imageGenerationParamsLock.Lock()
+	defer imageGenerationParamsLock.Unlock()
+	if len(imageGenerationParams) >= 10000 {
+		for k, entry := range imageGenerationParams {
+			if now.After(entry.expiresAt) {
+				delete(imageGenerationParams, k)
+			}
+		}
+	}
+	imageGenerationParams[key] = imageGenerationParamsEntry{params: params, expiresAt: now.Add(ttl)}
+}
+
+// LoadImageGenerationParams 读取之前保存的生成参数，不存在或已过期时返回 false
+func LoadImageGenerationParams(userId int, generationId string) (map[string]string, bool) {
+	key := imageGenerationParamsKey(userId, generationId)
+	if common.RedisEnabled {
+		raw, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		var params map[string]string
+		if err = common.UnmarshalJsonStr(raw, &params); err != nil {
+			return nil, false
+		}
+		return params, true
+	}
+	imageGenerationParamsLock.Lock()
+	defer imageGenerationParamsLock.Unlock()
+	entry, ok := imageGenerationParams[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(imageGenerationParams, key)
+		return nil, false
+	}
+	return entry.params, true
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+var (
+	ErrGlobalImageQueueFull   = errors.New("too many concurrent image requests, please retry later")
+	ErrGlobalImageWaitTimeout = errors.New("timed out waiting for a free image request slot")
+)
+
+// globalImageLimiter 当前节点同时进行的图片请求数。名额已满时按先来后到排队，
+// 归还名额时直接交给队首的等待者，inFlight 不变。durations 记录最近请求占用名额的时长，用于估算排队时间
+type globalImageLimiter struct {
+	lock      sync.Mutex
+	inFlight  int
+	waiters   []chan struct{}
+	durations imageDurationWindow
+}
+
+var imageGlobalLimiter globalImageLimiter
+
+// GlobalImageLimiterStats 全局图片并发的当前状态，供管理接口与指标使用
+type GlobalImageLimiterStats struct {
+	MaxConcurrent     int   `json:"max_concurrent"` // 0 表示不限制
+	QueueSize         int   `json:"queue_size"`
+	InFlight          int   `json:"in_flight"`
+	Queued            int   `json:"queued"`
+	AverageDurationMs int64 `json:"average_duration_ms"` // 最近请求占用名额的平均时长
+	EstimatedWaitMs   int64 `json:"estimated_wait_ms"`   // 新请求预计的排队时间
+}
+
+// AcquireGlobalImageSlot 获取全局图片并发名额，未配置 global_max_concurrent_images 时不限制。
+// 名额已满时最多排队 global_image_queue_size 个请求，每个最多等待 global_image_queue_wait_seconds 秒；
+// 队列已满返回 ErrGlobalImageQueueFull，等待超时返回 ErrGlobalImageWaitTimeout。返回的 release 可以重复调用，只会归还一次名额
+func AcquireGlobalImageSlot(ctx context.Context) (release func(), err error) {
+	settings := model_setting.GetImageSettings()
+	limit := settings.GlobalMaxConcurrentImages
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	limiter := &imageGlobalLimiter
+	limiter.lock.Lock()
+	if limiter.inFlight < limit {
+		limiter.inFlight++
+		limiter.lock.Unlock()
+		return limiter.releaseFunc(), nil
+	}
+	if len(limiter.waiters) >= settings.GlobalImageQueueSize {
+		limiter.lock.Unlock()
+		return nil, ErrGlobalImageQueueFull
+	}
+	ready := make(chan struct{})
+	limiter.waiters = append(limiter.waiters, ready)
+	limiter.lock.Unlock()
+
+	wait := time.Duration(settings.GlobalImageQueueWaitSeconds) * time.Second
+	if wait <= 0 {
+		wait = 30 * time.Second
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return limiter.releaseFunc(), nil
+	case <-timer.C:
+		err = ErrGlobalImageWaitTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if !limiter.cancelWait(ready) {
+		// 放弃等待的同时已被分配名额，归还给下一个等待者
+		limiter.release()
+	}
+	return nil, err
+}
+
+func (l *globalImageLimiter) releaseFunc() func() {
+	var once sync.Once
+	start := time.Now()
+	return func() {
+		once.Do(func() {
+			l.durations.observe(time.Since(start))
+			l.release()
+		})
+	}
+}
+
+// release 归还名额：有等待者且未超过当前上限（上限可能被调低）时交给队首，否则减少 inFlight
+func (l *globalImageLimiter) release() {
+	limit := model_setting.GetImageSettings().GlobalMaxConcurrentImages
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.waiters) > 0 && (limit <= 0 || l.inFlight <= limit) {
+		ready := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		close(ready)
+		return
+	}
+	l.inFlight--
+}
+
+// cancelWait 将等待者移出队列，已经被分配名额时返回 false
+func (l *globalImageLimiter) cancelWait(ready chan struct{}) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, waiter := range l.waiters {
+		if waiter == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GetGlobalImageLimiterStats 返回全局图片并发的进行中与排队请求数，以及按最近请求耗时估算的排队时间
+func GetGlobalImageLimiterStats() GlobalImageLimiterStats {
+	settings := model_setting.GetImageSettings()
+	average := imageGlobalLimiter.durations.average()
+	imageGlobalLimiter.lock.Lock()
+	defer imageGlobalLimiter.lock.Unlock()
+	inFlight, queued := imageGlobalLimiter.inFlight, len(imageGlobalLimiter.waiters)
+	return GlobalImageLimiterStats{
+		MaxConcurrent:     settings.GlobalMaxConcurrentImages,
+		QueueSize:         settings.GlobalImageQueueSize,
+		InFlight:          inFlight,
+		Queued:            queued,
+		AverageDurationMs: average.Milliseconds(),
+		EstimatedWaitMs:   estimateImageQueueWait(inFlight, queued, settings.GlobalMaxConcurrentImages, average).Milliseconds(),
+	}
+}
+
+// WriteGlobalImageLimiterMetrics 以 Prometheus 文本格式输出全局图片并发的进行中与排队请求数及预计排队时间
+func WriteGlobalImageLimiterMetrics(w io.Writer) {
+	stats := GetGlobalImageLimiterStats()
+	for _, gauge := range []struct {
+		name  string
+		help  string
+		value int64
+	}{
+		{"new_api_image_global_in_flight", "Image requests currently holding a global concurrency slot.", int64(stats.InFlight)},
+		{"new_api_image_global_queued", "Image requests waiting for a global concurrency slot.", int64(stats.Queued)},
+		{"new_api_image_global_max_concurrent", "Configured global image concurrency limit (0 means unlimited).", int64(stats.MaxConcurrent)},
+		{"new_api_image_global_estimated_wait_ms", "Estimated queue wait in milliseconds for a new image request.", stats.EstimatedWaitMs},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", gauge.name, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.name)
+		fmt.Fprintf(w, "%s %d\n", gauge.name, gauge.value)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// RecordImageHistory 异步写入用户的图片历史，不阻塞响应。写入失败时按 1s、2s、4s… 退避（单次最长 30s），最多重试 HistoryWriteRetries 次
+func RecordImageHistory(history *model.ImageHistory) {
+	retries := max(model_setting.GetImageSettings().HistoryWriteRetries, 0)
+	gopool.Go(func() {
+		var err error
+		for attempt := 0; attempt <= retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(min(time.Duration(1<<min(attempt-1, 5))*time.Second, 30*time.Second))
+			}
+			if err = model.InsertImageHistory(history); err == nil {
+				return
+			}
+		}
+		common.SysError(fmt.Sprintf("failed to record image history of user #%d after %d attempts: %s", history.UserId, retries+1, err.Error()))
+	})
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrImageIdempotencyKeyMismatch = errors.New("idempotency key was already used with a different request")
+
+// ImageIdempotentResponse 保存的成功响应，重复请求直接重放
+type ImageIdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// imageIdempotencyEntry 进行中的请求 done 尚未关闭，完成后 response 为空表示失败，等待者重新竞争执行
+type imageIdempotencyEntry struct {
+	fingerprint string
+	done        chan struct{}
+	response    *ImageIdempotentResponse
+	expiresAt   time.Time
+}
+
+var (
+	imageIdempotencyEntries = make(map[string]*imageIdempotencyEntry)
+	imageIdempotencyLock    sync.Mutex
+)
+
+// ImageIdempotencyLease 首个请求持有的执行权，必须调用 Complete 结束
+type ImageIdempotencyLease struct {
+	key   string
+	entry *imageIdempotencyEntry
+}
+
+// AcquireImageIdempotency 查找幂等键：已有成功结果时返回结果；有相同键的请求在执行时等待其完成；
+// 否则返回执行权。fingerprint 为请求内容的摘要，相同键对应不同请求时返回 ErrImageIdempotencyKeyMismatch
+func AcquireImageIdempotency(ctx context.Context, key string, fingerprint string) (*ImageIdempotentResponse, *ImageIdempotencyLease, error) {
+	for {
+		now := time.Now()
+		imageIdempotencyLock.Lock()
+		entry, ok := imageIdempotencyEntries[key]
+		if ok && entry.response != nil && now.After(entry.expiresAt) {
+			delete(imageIdempotencyEntries, key)
+			ok = false
+		}
+		if !ok {
+			sweepImageIdempotencyLocked(now)
+			entry = &imageIdempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+			imageIdempotencyEntries[key] = entry
+			imageIdempotencyLock.Unlock()
+			return nil, &ImageIdempotencyLease{key: key, entry: entry}, nil
+		}
+		imageIdempotencyLock.Unlock()
+		if entry.fingerprint != fingerprint {
+			return nil, nil, ErrImageIdempotencyKeyMismatch
+		}
+		select {
+		case <-entry.done:
+			if entry.response != nil {
+				return entry.response, nil, nil
+			}
+			// 首个请求失败，重新竞争执行
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// Complete 保存成功响应并唤醒等待者，response 为空时释放幂等键，之后的请求重新执行
+func (l *ImageIdempotencyLease) Complete(response *ImageIdempotentResponse, ttl time.Duration) {
+	imageIdempotencyLock.Lock()
+	if response != nil && ttl > 0 {
+		l.entry.response = response
+		l.entry.expiresAt = time.Now().Add(ttl)
+	} else if imageIdempotencyEntries[l.key] == l.entry {
+		delete(imageIdempotencyEntries, l.key)
+	}
+	imageIdempotencyLock.Unlock()
+	close(l.entry.done)
+}
+
+// sweepImageIdempotencyLocked 清理过期的结果，调用方需持有 imageIdempotencyLock
+func sweepImageIdempotencyLocked(now time.Time) {
+	for key, entry := range imageIdempotencyEntries {
+		if entry.response != nil && now.After(entry.expiresAt) {
+			delete(imageIdempotencyEntries, key)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/webp"
+)
+
+// imageHeaderReadLimit 解析尺寸时最多读取的字节数，JPEG 的 EXIF 等元数据位于 SOF 之前，需要预留足够空间
+const imageHeaderReadLimit = 256 << 10
+
+var ErrUnsupportedImageHeader = errors.New("unsupported image format")
+
+// DecodeImageHeader 只读取文件头部解析图片宽高，支持 png/jpeg/webp，不解码像素数据
+func DecodeImageHeader(r io.Reader) (int, int, error) {
+	reader := bufio.NewReader(io.LimitReader(r, imageHeaderReadLimit))
+	head, _ := reader.Peek(512)
+	var (
+		config image.Config
+		err    error
+	)
+	switch SniffImageFormat(head) {
+	case "png":
+		config, err = png.DecodeConfig(reader)
+	case "jpeg":
+		config, err = jpeg.DecodeConfig(reader)
+	case "webp":
+		config, err = webp.DecodeConfig(reader)
+	default:
+		return 0, 0, ErrUnsupportedImageHeader
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// EstimateImageInputTokens 估算参考图的输入 token，按 gpt-image 系列（与 gpt-4o 相同）的分块方式计算
+func EstimateImageInputTokens(width int, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	return tileImageTokens(width, height, 85, 170)
+}
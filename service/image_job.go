@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+const (
+	ImageJobStatusQueued    = "queued"
+	ImageJobStatusRunning   = "running"
+	ImageJobStatusSucceeded = "succeeded"
+	ImageJobStatusFailed    = "failed"
+)
+
+var (
+	ErrImageJobNotFound  = errors.New("image job not found")
+	ErrImageJobQueueFull = errors.New("too many pending image jobs, please retry later")
+)
+
+// ImageJob 异步图片任务，仅保存在当前节点内存中
+type ImageJob struct {
+	Id     string
+	UserId int
+
+	lock        sync.Mutex
+	status      string
+	statusCode  int
+	contentType string
+	body        []byte
+	createdAt   time.Time
+	completedAt time.Time
+
+	// estimatedQuota 被接受时按计费档位折算的预估额度，finalQuota 为结算后的实际扣费，billed 表示已结算
+	estimatedQuota int
+	finalQuota     int
+	billed         bool
+
+	acceptOnce sync.Once
+	accepted   chan struct{}
+	done       chan struct{}
+}
+
+// ImageJobSnapshot 查询任务时返回的状态，任务完成后才有响应内容
+type ImageJobSnapshot struct {
+	Id          string
+	Status      string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	// EstimatedQuota 任务被接受时的预估额度，FinalQuota 在任务完成后才有值，失败且未结算的任务为 0（预扣额度已退还）
+	EstimatedQuota int
+	FinalQuota     *int
+}
+
+var (
+	imageJobs     = make(map[string]*ImageJob)
+	imageJobsLock sync.Mutex
+
+	imageJobWorkers     chan struct{}
+	imageJobWorkersLock sync.Mutex
+)
+
+type imageJobContextKey struct{}
+
+// WithImageJob 将任务绑定到后台请求的上下文，ImageHelper 据此识别异步任务
+func WithImageJob(ctx context.Context, job *ImageJob) context.Context {
+	return context.WithValue(ctx, imageJobContextKey{}, job)
+}
+
+// ImageJobFromContext 返回上下文绑定的异步任务，普通请求返回 nil
+func ImageJobFromContext(ctx context.Context) *ImageJob {
+	job, _ := ctx.Value(imageJobContextKey{}).(*ImageJob)
+	return job
+}
+
+// NewImageJob 登记一个新任务，未完成的任务数达到上限时返回 ErrImageJobQueueFull
+func NewImageJob(userId int) (*ImageJob, error) {
+	settings := model_setting.GetImageSettings()
+	now := time.Now()
+	imageJobsLock.Lock()
+	defer imageJobsLock.Unlock()
+	sweepImageJobsLocked(now)
+	if settings.AsyncJobMaxPending > 0 {
+		pending := 0
+		for _, job := range imageJobs {
+			if !job.completed() {
+				pending++
+			}
+		}
+		if pending >= settings.AsyncJobMaxPending {
+			return nil, ErrImageJobQueueFull
+		}
+	}
+	job := &ImageJob{
+		Id:        "imgjob_" + common.GetUUID(),
+		UserId:    userId,
+		status:    ImageJobStatusQueued,
+		createdAt: now,
+		accepted:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	imageJobs[job.Id] = job
+	return job, nil
+}
+
+// sweepImageJobsLocked 清理超过保留时间的已完成任务，调用方需持有 imageJobsLock
+func sweepImageJobsLocked(now time.Time) {
+	ttl := time.Duration(model_setting.GetImageSettings().AsyncJobResultTTLSeconds) * time.Second
+	for id, job := range imageJobs {
+		job.lock.Lock()
+		completedAt := job.completedAt
+		job.lock.Unlock()
+		if !completedAt.IsZero() && now.Sub(completedAt) > ttl {
+			delete(imageJobs, id)
+		}
+	}
+}
+
+// Accept 任务通过校验并完成预扣费后调用，通知提交方返回 job_id；重试时重复调用无影响
+func (j *ImageJob) Accept() {
+	j.acceptOnce.Do(func() { close(j.accepted) })
+}
+
+// Accepted 任务被接受时关闭
+func (j *ImageJob) Accepted() <-chan struct{} {
+	return j.accepted
+}
+
+// Done 任务完成（包括被接受前就失败）时关闭
+func (j *ImageJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// SetEstimatedQuota 记录任务被接受时的预估额度，需在 Accept 之前调用，提交响应才能返回预估费用
+func (j *ImageJob) SetEstimatedQuota(quota int) {
+	j.lock.Lock()
+	j.estimatedQuota = quota
+	j.lock.Unlock()
+}
+
+// SetFinalQuota 记录结算后的实际扣费，重试时以最后一次结算为准
+func (j *ImageJob) SetFinalQuota(quota int) {
+	j.lock.Lock()
+	j.finalQuota = quota
+	j.billed = true
+	j.lock.Unlock()
+}
+
+// Complete 记录后台请求的最终响应
+func (j *ImageJob) Complete(statusCode int, contentType string, body []byte) {
+	j.lock.Lock()
+	j.statusCode = statusCode
+	j.contentType = contentType
+	j.body = body
+	j.status = ImageJobStatusFailed
+	if statusCode == http.StatusOK {
+		j.status = ImageJobStatusSucceeded
+	}
+	j.completedAt = time.Now()
+	j.lock.Unlock()
+	close(j.done)
+}
+
+func (j *ImageJob) completed() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return !j.completedAt.IsZero()
+}
+
+func (j *ImageJob) setStatus(status string) {
+	j.lock.Lock()
+	if j.completedAt.IsZero() {
+		j.status = status
+	}
+	j.lock.Unlock()
+}
+
+// AcquireImageJobWorker 等待后台工作槽位，槽位数由 AsyncJobWorkers 控制，调整配置后对新申请的槽位生效
+func AcquireImageJobWorker(ctx context.Context, job *ImageJob) (func(), error) {
+	workers := max(model_setting.GetImageSettings().AsyncJobWorkers, 1)
+	imageJobWorkersLock.Lock()
+	if imageJobWorkers == nil || cap(imageJobWorkers) != workers {
+		imageJobWorkers = make(chan struct{}, workers)
+	}
+	slots := imageJobWorkers
+	imageJobWorkersLock.Unlock()
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	job.setStatus(ImageJobStatusRunning)
+	return func() { <-slots }, nil
+}
+
+// RemoveImageJob 任务被接受前就失败时直接移除，错误已同步返回给提交方
+func RemoveImageJob(id string) {
+	imageJobsLock.Lock()
+	defer imageJobsLock.Unlock()
+	delete(imageJobs, id)
+}
+
+// GetImageJob 查询指定用户的任务
+func GetImageJob(id string, userId int) (*ImageJobSnapshot, error) {
+	imageJobsLock.Lock()
+	sweepImageJobsLocked(time.Now())
+	job, ok := imageJobs[id]
+	imageJobsLock.Unlock()
+	if !ok || job.UserId != userId {
+		return nil, ErrImageJobNotFound
+	}
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	snapshot := &ImageJobSnapshot{
+		Id:             job.Id,
+		Status:         job.status,
+		StatusCode:     job.statusCode,
+		ContentType:    job.contentType,
+		Body:           job.body,
+		CreatedAt:      job.createdAt,
+		CompletedAt:    job.completedAt,
+		EstimatedQuota: job.estimatedQuota,
+	}
+	if !job.completedAt.IsZero() {
+		finalQuota := 0
+		if job.billed {
+			finalQuota = job.finalQuota
+		}
+		snapshot.FinalQuota = &finalQuota
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 图片请求各阶段耗时的直方图分桶上限（秒）
+var imagePhaseBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+type imagePhaseKey struct {
+	model     string
+	channelId int
+	phase     string
+}
+
+// imagePhaseHistogram 每个分桶单独计数，输出时再累加为 Prometheus 要求的累计值
+type imagePhaseHistogram struct {
+	buckets  []atomic.Uint64 // 最后一个为 +Inf
+	count    atomic.Uint64
+	sumNanos atomic.Int64
+}
+
+var (
+	imagePhaseHistograms     = make(map[imagePhaseKey]*imagePhaseHistogram)
+	imagePhaseHistogramsLock sync.RWMutex
+)
+
+// ObserveImagePhase 记录一次图片请求阶段耗时，标签组合已存在时不产生内存分配
+func ObserveImagePhase(model string, channelId int, phase string, d time.Duration) {
+	key := imagePhaseKey{model: model, channelId: channelId, phase: phase}
+	imagePhaseHistogramsLock.RLock()
+	histogram, ok := imagePhaseHistograms[key]
+	imagePhaseHistogramsLock.RUnlock()
+	if !ok {
+		imagePhaseHistogramsLock.Lock()
+		if histogram, ok = imagePhaseHistograms[key]; !ok {
+			histogram = &imagePhaseHistogram{buckets: make([]atomic.Uint64, len(imagePhaseBuckets)+1)}
+			imagePhaseHistograms[key] = histogram
+		}
+		imagePhaseHistogramsLock.Unlock()
+	}
+	seconds := d.Seconds()
+	idx := sort.SearchFloat64s(imagePhaseBuckets, seconds)
+	histogram.buckets[idx].Add(1)
+	histogram.count.Add(1)
+	histogram.sumNanos.Add(int64(d))
+}
+
+var imageMetricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteImagePhaseMetrics 以 Prometheus 文本格式输出各阶段耗时直方图
+func WriteImagePhaseMetrics(w io.Writer) {
+	imagePhaseHistogramsLock.RLock()
+	keys := make([]imagePhaseKey, 0, len(imagePhaseHistograms))
+	for key := range imagePhaseHistograms {
+		keys = append(keys, key)
+	}
+	imagePhaseHistogramsLock.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].model != keys[j].model {
+			return keys[i].model < keys[j].model
+		}
+		if keys[i].channelId != keys[j].channelId {
+			return keys[i].channelId < keys[j].channelId
+		}
+		return keys[i].phase < keys[j].phase
+	})
+
+	const name = "new_api_image_phase_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of image relay phases (deepcopy, upstream, response), including failed requests.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range keys {
+		imagePhaseHistogramsLock.RLock()
+		histogram := imagePhaseHistograms[key]
+		imagePhaseHistogramsLock.RUnlock()
+		labels := fmt.Sprintf(`model="%s",channel="%d",phase="%s"`, imageMetricLabelEscaper.Replace(key.model), key.channelId, key.phase)
+		var cumulative uint64
+		for i := range histogram.buckets {
+			cumulative += histogram.buckets[i].Load()
+			le := "+Inf"
+			if i < len(imagePhaseBuckets) {
+				le = strconv.FormatFloat(imagePhaseBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(time.Duration(histogram.sumNanos.Load()).Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, histogram.count.Load())
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// imageModelStatsBucket 一分钟内的生成结果计数
+type imageModelStatsBucket struct {
+	minute  int64
+	success int
+	failure int
+}
+
+var (
+	imageModelStats     = make(map[string][]imageModelStatsBucket)
+	imageModelStatsLock sync.Mutex
+)
+
+// ImageModelStats 模型在滚动窗口内的生成结果统计（跨渠道汇总）
+type ImageModelStats struct {
+	Model       string  `json:"model"`
+	Success     int     `json:"success"`
+	Failure     int     `json:"failure"`
+	SuccessRate float64 `json:"success_rate"`
+}
+
+func imageModelStatsWindow() time.Duration {
+	window := time.Duration(model_setting.GetImageSettings().ModelStatsWindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Hour
+	}
+	return window
+}
+
+// pruneImageModelStats 丢弃窗口之外的分钟桶，调用方需持有锁
+func pruneImageModelStats(buckets []imageModelStatsBucket, now time.Time) []imageModelStatsBucket {
+	cutoff := now.Add(-imageModelStatsWindow()).Unix() / 60
+	idx := 0
+	for idx < len(buckets) && buckets[idx].minute <= cutoff {
+		idx++
+	}
+	return buckets[idx:]
+}
+
+// RecordImageModelOutcome 按模型记录一次图片生成的结果，未开启统计时忽略
+func RecordImageModelOutcome(modelName string, success bool) {
+	if !model_setting.GetImageSettings().ModelStatsEnabled || modelName == "" {
+		return
+	}
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	imageModelStatsLock.Lock()
+	defer imageModelStatsLock.Unlock()
+
+	buckets := pruneImageModelStats(imageModelStats[modelName], now)
+	if len(buckets) == 0 || buckets[len(buckets)-1].minute != minute {
+		buckets = append(buckets, imageModelStatsBucket{minute: minute})
+	}
+	if success {
+		buckets[len(buckets)-1].success++
+	} else {
+		buckets[len(buckets)-1].failure++
+	}
+	imageModelStats[modelName] = buckets
+}
+
+// GetImageModelStats 返回滚动窗口内各模型的成功与失败次数，按模型名排序
+func GetImageModelStats() []ImageModelStats {
+	now := time.Now()
+
+	imageModelStatsLock.Lock()
+	defer imageModelStatsLock.Unlock()
+
+	stats := make([]ImageModelStats, 0, len(imageModelStats))
+	for modelName, buckets := range imageModelStats {
+		buckets = pruneImageModelStats(buckets, now)
+		if len(buckets) == 0 {
+			delete(imageModelStats, modelName)
+			continue
+		}
+		imageModelStats[modelName] = buckets
+		item := ImageModelStats{Model: modelName}
+		for _, bucket := range buckets {
+			item.Success += bucket.success
+			item.Failure += bucket.failure
+		}
+		if total := item.Success + item.Failure; total > 0 {
+			item.SuccessRate = float64(item.Success) / float64(total)
+		}
+		stats = append(stats, item)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Model < stats[j].Model })
+	return stats
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func withImageModelStats(t *testing.T) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.ModelStatsEnabled = true
+	settings.ModelStatsWindowSeconds = 3600
+	resetImageModelStats := func() {
+		imageModelStatsLock.Lock()
+		imageModelStats = make(map[string][]imageModelStatsBucket)
+		imageModelStatsLock.Unlock()
+	}
+	resetImageModelStats()
+	t.Cleanup(func() {
+		*settings = previous
+		resetImageModelStats()
+	})
+}
+
+func TestImageModelOutcomeCounters(t *testing.T) {
+	withImageModelStats(t)
+	for _, success := range []bool{true, true, false, true} {
+		RecordImageModelOutcome("gpt-image-1", success)
+	}
+	RecordImageModelOutcome("dall-e-3", false)
+	RecordImageModelOutcome("", true)
+
+	// 窗口之外的记录不计入
+	imageModelStatsLock.Lock()
+	stale := imageModelStatsBucket{minute: time.Now().Add(-2*time.Hour).Unix() / 60, success: 10, failure: 10}
+	imageModelStats["gpt-image-1"] = append([]imageModelStatsBucket{stale}, imageModelStats["gpt-image-1"]...)
+	imageModelStatsLock.Unlock()
+
+	want := []ImageModelStats{
+		{Model: "dall-e-3", Failure: 1, SuccessRate: 0},
+		{Model: "gpt-image-1", Success: 3, Failure: 1, SuccessRate: 0.75},
+	}
+	stats := GetImageModelStats()
+	if len(stats) != len(want) {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Fatalf("stats[%d] = %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+
+	// 未开启统计时不记录
+	model_setting.GetImageSettings().ModelStatsEnabled = false
+	RecordImageModelOutcome("gpt-image-1", false)
+	RecordImageModelOutcome("flux-dev", true)
+	if stats = GetImageModelStats(); len(stats) != 2 || stats[1].Failure != 1 {
+		t.Fatalf("outcomes recorded while stats are disabled: %+v", stats)
+	}
+}
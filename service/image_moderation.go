@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// ImageModerationResult 审核接口对单张图片的判定
+type ImageModerationResult struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// ModerateImage 将一张图片提交到审核接口，imageType 为 b64_json 或 url。超时由调用方通过 ctx 控制
+func ModerateImage(ctx context.Context, setting *dto.ImageModerationSetting, model string, imageType string, image string) (*ImageModerationResult, error) {
+	if setting == nil || setting.Endpoint == "" {
+		return nil, errors.New("image moderation endpoint is not configured")
+	}
+	body, err := common.Marshal(map[string]string{
+		"model": model,
+		"type":  imageType,
+		"image": image,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, setting.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if setting.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+setting.ApiKey)
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer CloseResponseBodyGracefully(resp)
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read moderation response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation endpoint returned status %d", resp.StatusCode)
+	}
+	var result ImageModerationResult
+	if err = common.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("parse moderation response failed: %w", err)
+	}
+	return &result, nil
+}
+
+// FormatImageModerationCategories 命中分类的展示文本，未返回分类时为 flagged
+func FormatImageModerationCategories(result *ImageModerationResult) string {
+	if result == nil || len(result.Categories) == 0 {
+		return "flagged"
+	}
+	return strings.Join(result.Categories, ", ")
+}
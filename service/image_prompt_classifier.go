@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+const defaultImagePromptClassifierTimeout = 5 * time.Second
+
+// ClassifyImagePrompt 调用配置的分类模型对图片提示词进行分类，返回小写的分类名。
+// 模型输出不是候选分类之一时返回空字符串，空分类不会被视为允许的分类
+func ClassifyImagePrompt(ctx context.Context, setting *dto.ImagePromptClassifierSetting, prompt string) (string, error) {
+	if setting == nil || setting.BaseUrl == "" {
+		return "", errors.New("image prompt classifier is not configured")
+	}
+	categories := setting.Categories
+	if len(categories) == 0 {
+		categories = append(append([]string{}, setting.AllowedCategories...), "other")
+	}
+
+	timeout := defaultImagePromptClassifierTimeout
+	if setting.TimeoutSeconds > 0 {
+		timeout = time.Duration(setting.TimeoutSeconds) * time.Second
+	}
+	systemPrompt := "Classify the image generation prompt into exactly one of the following categories: " +
+		strings.Join(categories, ", ") + ". Reply with the category name only."
+	output, err := requestImagePromptCompletion(ctx, setting.BaseUrl, setting.ApiKey, setting.Model, systemPrompt, prompt, timeout)
+	if err != nil {
+		return "", err
+	}
+	return normalizeImagePromptCategory(output, categories), nil
+}
+
+// normalizeImagePromptCategory 去掉首尾空白与引号、句号后，要求模型输出与候选分类完全一致（不区分大小写）。
+// "not product"、"people, product" 等输出不做部分匹配，返回空字符串
+func normalizeImagePromptCategory(output string, categories []string) string {
+	output = strings.ToLower(strings.TrimSpace(strings.Trim(strings.TrimSpace(output), ".\"'`")))
+	if output == "" {
+		return ""
+	}
+	for _, category := range categories {
+		if output == strings.ToLower(strings.TrimSpace(category)) {
+			return output
+		}
+	}
+	return ""
+}
+
+// IsImagePromptCategoryAllowed 判断分类是否在允许列表中，无法识别的空分类总是不允许
+func IsImagePromptCategoryAllowed(setting *dto.ImagePromptClassifierSetting, category string) bool {
+	if category == "" {
+		return false
+	}
+	for _, allowed := range setting.AllowedCategories {
+		if strings.EqualFold(strings.TrimSpace(allowed), category) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// newClassifierServer 模拟分类模型，按提示词返回固定的输出
+func newClassifierServer(t *testing.T, outputs map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := common.DecodeJson(r.Body, &request); err != nil || len(request.Messages) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		output := outputs[request.Messages[1].Content]
+		response, _ := common.Marshal(map[string]any{
+			"choices": []map[string]any{{"message": map[string]string{"content": output}}},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClassifyImagePromptAllowedAndDisallowed(t *testing.T) {
+	server := newClassifierServer(t, map[string]string{
+		"a red sneaker on a white background": "Product.",
+		"portrait of a smiling woman":         "people",
+	})
+	setting := &dto.ImagePromptClassifierSetting{
+		Enabled:           true,
+		BaseUrl:           server.URL,
+		Model:             "classifier",
+		Categories:        []string{"product", "people", "other"},
+		AllowedCategories: []string{"product"},
+	}
+
+	category, err := ClassifyImagePrompt(context.Background(), setting, "a red sneaker on a white background")
+	if err != nil {
+		t.Fatalf("classify allowed prompt: %v", err)
+	}
+	if category != "product" || !IsImagePromptCategoryAllowed(setting, category) {
+		t.Fatalf("category %q should be allowed", category)
+	}
+
+	category, err = ClassifyImagePrompt(context.Background(), setting, "portrait of a smiling woman")
+	if err != nil {
+		t.Fatalf("classify disallowed prompt: %v", err)
+	}
+	if category != "people" || IsImagePromptCategoryAllowed(setting, category) {
+		t.Fatalf("category %q should be disallowed", category)
+	}
+}
+
+func TestNormalizeImagePromptCategoryRequiresExactMatch(t *testing.T) {
+	categories := []string{"product", "people", "other"}
+	cases := map[string]string{
+		"product":          "product",
+		"  PRODUCT.\n":     "product",
+		"\"people\"":       "people",
+		"`other`":          "other",
+		"not product":      "",
+		"people, product":  "",
+		"product photo":    "",
+		"this is a people": "",
+		"":                 "",
+	}
+	for output, want := range cases {
+		if got := normalizeImagePromptCategory(output, categories); got != want {
+			t.Errorf("normalizeImagePromptCategory(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
+
+func TestUnrecognizedCategoryIsNeverAllowed(t *testing.T) {
+	setting := &dto.ImagePromptClassifierSetting{AllowedCategories: []string{"product", ""}}
+	if IsImagePromptCategoryAllowed(setting, "") {
+		t.Fatal("empty category must not be allowed")
+	}
+	if !IsImagePromptCategoryAllowed(setting, "product") {
+		t.Fatal("product should be allowed")
+	}
+}
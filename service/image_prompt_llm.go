@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+type imagePromptCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// requestImagePromptCompletion 调用 OpenAI 兼容的 chat/completions 接口处理图片提示词（分类、摘要等），超时后立即返回
+func requestImagePromptCompletion(ctx context.Context, baseUrl string, apiKey string, model string, systemPrompt string, prompt string, timeout time.Duration) (string, error) {
+	if baseUrl == "" {
+		return "", errors.New("completion endpoint is not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload := map[string]any{
+		"model":       model,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{
+				"role":    "system",
+				"content": systemPrompt,
+			},
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+	body, err := common.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseUrl, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("completion request failed: %w", err)
+	}
+	defer CloseResponseBodyGracefully(resp)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read completion response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("completion endpoint returned status %d", resp.StatusCode)
+	}
+	var completionResponse imagePromptCompletionResponse
+	if err = common.Unmarshal(respBody, &completionResponse); err != nil {
+		return "", fmt.Errorf("parse completion response failed: %w", err)
+	}
+	if len(completionResponse.Choices) == 0 {
+		return "", errors.New("completion endpoint returned no choices")
+	}
+	return completionResponse.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// SummarizeImagePrompt 使用配置的模型将超长提示词压缩到目标长度以内，尽量保留主体、风格、构图等关键信息
+func SummarizeImagePrompt(ctx context.Context, settings *model_setting.ImageSettings, prompt string) (string, error) {
+	targetLength := settings.PromptSummarizeTargetLength
+	if targetLength <= 0 || targetLength > settings.PromptSummarizeMaxLength {
+		targetLength = settings.PromptSummarizeMaxLength
+	}
+	timeout := time.Duration(settings.PromptSummarizeTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	systemPrompt := fmt.Sprintf("Rewrite the following image generation prompt so that it is at most %d characters long. "+
+		"Preserve the subjects, style, composition, colors, text to render and any other key visual details. "+
+		"Reply with the rewritten prompt only.", targetLength)
+	summary, err := requestImagePromptCompletion(ctx, settings.PromptSummarizeBaseUrl, settings.PromptSummarizeApiKey, settings.PromptSummarizeModel, systemPrompt, prompt, timeout)
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", errors.New("summarized prompt is empty")
+	}
+	if utf8.RuneCountInString(summary) > settings.PromptSummarizeMaxLength {
+		return "", fmt.Errorf("summarized prompt still exceeds %d characters", settings.PromptSummarizeMaxLength)
+	}
+	return summary, nil
+}
+
+// TruncatePromptRunes 按字符（而非字节）截断提示词
+func TruncatePromptRunes(prompt string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(prompt) <= maxLength {
+		return prompt
+	}
+	return string([]rune(prompt)[:maxLength])
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// imageDurationWindowSize 估算排队时间时参考的最近请求数
+const imageDurationWindowSize = 32
+
+// imageDurationWindow 最近若干次图片请求占用并发名额的时长，按滚动平均估算排队等待时间
+type imageDurationWindow struct {
+	lock    sync.Mutex
+	samples [imageDurationWindowSize]time.Duration
+	next    int
+	count   int
+}
+
+func (w *imageDurationWindow) observe(duration time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.samples[w.next] = duration
+	w.next = (w.next + 1) % imageDurationWindowSize
+	if w.count < imageDurationWindowSize {
+		w.count++
+	}
+}
+
+func (w *imageDurationWindow) reset() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.next, w.count = 0, 0
+}
+
+// average 返回窗口内的平均时长，尚无记录时返回 0
+func (w *imageDurationWindow) average() time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.count == 0 {
+		return 0
+	}
+	var total time.Duration
+	for i := 0; i < w.count; i++ {
+		total += w.samples[i]
+	}
+	return total / time.Duration(w.count)
+}
+
+// estimateImageQueueWait 估算新请求的排队时间：名额未满时为 0，否则前方 queued 个请求与自身
+// 依次等待 limit 个名额释放，每个名额平均占用 average。尚无耗时记录时返回 0
+func estimateImageQueueWait(inFlight int, queued int, limit int, average time.Duration) time.Duration {
+	if limit <= 0 || average <= 0 || inFlight < limit {
+		return 0
+	}
+	return time.Duration(queued+1) * average / time.Duration(limit)
+}
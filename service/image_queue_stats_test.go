@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// withGlobalImageLimit 设置全局图片并发上限并清空限流器状态，测试结束后还原
+func withGlobalImageLimit(t *testing.T, limit int) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.GlobalMaxConcurrentImages = limit
+	settings.GlobalImageQueueSize = 10
+	settings.GlobalImageQueueWaitSeconds = 5
+	resetGlobalImageLimiter()
+	t.Cleanup(func() {
+		*settings = previous
+		resetGlobalImageLimiter()
+	})
+}
+
+func resetGlobalImageLimiter() {
+	imageGlobalLimiter.lock.Lock()
+	imageGlobalLimiter.inFlight = 0
+	imageGlobalLimiter.waiters = nil
+	imageGlobalLimiter.lock.Unlock()
+	imageGlobalLimiter.durations.reset()
+}
+
+func waitForGlobalImageQueue(t *testing.T, want int) GlobalImageLimiterStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats := GetGlobalImageLimiterStats(); stats.Queued == want {
+			return stats
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d queued image requests", want)
+	return GlobalImageLimiterStats{}
+}
+
+func TestGlobalImageQueueEstimatesWaitFromRecentDurations(t *testing.T) {
+	withGlobalImageLimit(t, 1)
+
+	// 名额空闲且无历史耗时时不需要等待
+	if stats := GetGlobalImageLimiterStats(); stats.EstimatedWaitMs != 0 || stats.Queued != 0 {
+		t.Fatalf("idle limiter reported a wait: %+v", stats)
+	}
+
+	// 实际占用名额的时长计入滚动平均
+	release, err := AcquireGlobalImageSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquire slot: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	release()
+	if average := GetGlobalImageLimiterStats().AverageDurationMs; average < 50 || average > 500 {
+		t.Fatalf("average duration = %dms, want about 50ms", average)
+	}
+
+	imageGlobalLimiter.durations.reset()
+	for i := 0; i < 4; i++ {
+		imageGlobalLimiter.durations.observe(200 * time.Millisecond)
+	}
+	release, err = AcquireGlobalImageSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquire slot: %v", err)
+	}
+	if stats := GetGlobalImageLimiterStats(); stats.EstimatedWaitMs != 200 {
+		t.Fatalf("full limiter without queue estimated %dms, want 200ms", stats.EstimatedWaitMs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			if waiterRelease, err := AcquireGlobalImageSlot(ctx); err == nil {
+				waiterRelease()
+			}
+			done <- struct{}{}
+		}()
+	}
+	stats := waitForGlobalImageQueue(t, 2)
+	// 前方两个排队请求与自身各等待一个平均耗时
+	if stats.EstimatedWaitMs != 600 || stats.AverageDurationMs != 200 {
+		t.Fatalf("queued estimate = %+v, want 600ms wait from a 200ms average", stats)
+	}
+
+	release()
+	<-done
+	<-done
+	if stats = GetGlobalImageLimiterStats(); stats.Queued != 0 || stats.InFlight != 0 || stats.EstimatedWaitMs != 0 {
+		t.Fatalf("limiter not drained: %+v", stats)
+	}
+}
+
+func TestChannelImageQueueEstimatesWait(t *testing.T) {
+	const channelId = 90001
+	t.Cleanup(func() {
+		channelSemaphoresLock.Lock()
+		delete(channelSemaphores, channelId)
+		channelSemaphoresLock.Unlock()
+	})
+	release, err := AcquireChannelConcurrencySlot(context.Background(), channelId, 2, time.Second)
+	if err != nil {
+		t.Fatalf("acquire channel slot: %v", err)
+	}
+	defer release()
+	sem := getChannelSemaphore(channelId, 2)
+	for i := 0; i < 3; i++ {
+		sem.durations.observe(300 * time.Millisecond)
+	}
+	if stats := GetChannelImageQueueStats(channelId); stats.EstimatedWaitMs != 0 || stats.InFlight != 1 {
+		t.Fatalf("channel with a free slot reported a wait: %+v", stats)
+	}
+
+	second, err := AcquireChannelConcurrencySlot(context.Background(), channelId, 2, time.Second)
+	if err != nil {
+		t.Fatalf("acquire channel slot: %v", err)
+	}
+	defer second()
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		if waiterRelease, err := AcquireChannelConcurrencySlot(context.Background(), channelId, 2, time.Second); err == nil {
+			waiterRelease()
+		}
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for GetChannelImageQueueStats(channelId).Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the queued channel request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 一个排队请求与自身分摊到两个名额上：(1+1)*300ms/2
+	if stats := GetChannelImageQueueStats(channelId); stats.EstimatedWaitMs != 300 || stats.MaxConcurrent != 2 {
+		t.Fatalf("channel estimate = %+v, want 300ms", stats)
+	}
+	second()
+	<-waiting
+}
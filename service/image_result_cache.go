@@ -0,0 +1,173 @@
+package service
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// imageResultCacheSweepInterval 后台清理过期结果的间隔，读取时也会跳过已过期的结果
+const imageResultCacheSweepInterval = time.Minute
+
+// ImageResultCacheEntry 缓存的上游成功响应
+type ImageResultCacheEntry struct {
+	Header    http.Header
+	Body      []byte
+	key       string
+	expiresAt time.Time
+}
+
+var (
+	imageResultCache     = make(map[string]*list.Element)
+	imageResultCacheLock sync.Mutex
+	// imageResultCacheLRU 按最近使用排序，队首为最近使用，超出条数或总大小时从队尾淘汰
+	imageResultCacheLRU   = list.New()
+	imageResultCacheBytes int64
+	imageResultSweepOnce  sync.Once
+	// imageResultSimilar 相似请求键到最近一次缓存键的索引，仅用于返回预览
+	imageResultSimilar = make(map[string]string)
+
+	imageResultCacheHits   atomic.Int64
+	imageResultCacheMisses atomic.Int64
+)
+
+// ImageResultCacheStats 结果缓存的命中统计，从进程启动开始累计
+type ImageResultCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// LoadImageResultCache 查找未过期的缓存结果，同时记录一次命中或未命中
+func LoadImageResultCache(key string) *ImageResultCacheEntry {
+	imageResultCacheLock.Lock()
+	entry := getImageResultCacheLocked(key, time.Now())
+	imageResultCacheLock.Unlock()
+	if entry == nil {
+		imageResultCacheMisses.Add(1)
+		return nil
+	}
+	imageResultCacheHits.Add(1)
+	return entry
+}
+
+// StoreImageResultCache 保存上游成功响应，similarKey 非空时同时登记为该类相似请求的最新结果。
+// 超过 ResultCacheMaxEntryBytes 的响应不缓存；超出条数或总大小上限时淘汰最久未使用的结果
+func StoreImageResultCache(key string, similarKey string, header http.Header, body []byte, ttl time.Duration) {
+	settings := model_setting.GetImageSettings()
+	if settings.ResultCacheMaxEntryBytes > 0 && len(body) > settings.ResultCacheMaxEntryBytes {
+		return
+	}
+	imageResultSweepOnce.Do(func() {
+		gopool.Go(sweepImageResultCacheLoop)
+	})
+	entry := &ImageResultCacheEntry{
+		Header:    header.Clone(),
+		Body:      body,
+		key:       key,
+		expiresAt: time.Now().Add(ttl),
+	}
+	imageResultCacheLock.Lock()
+	defer imageResultCacheLock.Unlock()
+	if element, ok := imageResultCache[key]; ok {
+		removeImageResultCacheLocked(element)
+	}
+	imageResultCache[key] = imageResultCacheLRU.PushFront(entry)
+	imageResultCacheBytes += int64(len(body))
+	for imageResultCacheOverLimit(settings) {
+		removeImageResultCacheLocked(imageResultCacheLRU.Back())
+	}
+	if similarKey != "" {
+		imageResultSimilar[similarKey] = key
+	}
+}
+
+// LoadSimilarImageResult 查找相似请求最近一次未过期的缓存结果，不计入命中统计
+func LoadSimilarImageResult(similarKey string) *ImageResultCacheEntry {
+	imageResultCacheLock.Lock()
+	defer imageResultCacheLock.Unlock()
+	key, ok := imageResultSimilar[similarKey]
+	if !ok {
+		return nil
+	}
+	entry := getImageResultCacheLocked(key, time.Now())
+	if entry == nil {
+		delete(imageResultSimilar, similarKey)
+	}
+	return entry
+}
+
+// GetImageResultCacheStats 返回结果缓存的命中与未命中次数
+func GetImageResultCacheStats() ImageResultCacheStats {
+	imageResultCacheLock.Lock()
+	entries := len(imageResultCache)
+	bytes := imageResultCacheBytes
+	imageResultCacheLock.Unlock()
+	return ImageResultCacheStats{
+		Hits:    imageResultCacheHits.Load(),
+		Misses:  imageResultCacheMisses.Load(),
+		Entries: entries,
+		Bytes:   bytes,
+	}
+}
+
+// getImageResultCacheLocked 返回未过期的结果并标记为最近使用，已过期的结果直接删除。调用方需持有 imageResultCacheLock
+func getImageResultCacheLocked(key string, now time.Time) *ImageResultCacheEntry {
+	element, ok := imageResultCache[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*ImageResultCacheEntry)
+	if now.After(entry.expiresAt) {
+		removeImageResultCacheLocked(element)
+		return nil
+	}
+	imageResultCacheLRU.MoveToFront(element)
+	return entry
+}
+
+func removeImageResultCacheLocked(element *list.Element) {
+	entry := imageResultCacheLRU.Remove(element).(*ImageResultCacheEntry)
+	delete(imageResultCache, entry.key)
+	imageResultCacheBytes -= int64(len(entry.Body))
+}
+
+func imageResultCacheOverLimit(settings *model_setting.ImageSettings) bool {
+	if settings.ResultCacheMaxEntries > 0 && imageResultCacheLRU.Len() > settings.ResultCacheMaxEntries {
+		return true
+	}
+	return settings.ResultCacheMaxBytes > 0 && imageResultCacheBytes > settings.ResultCacheMaxBytes
+}
+
+// sweepImageResultCacheLoop 定期删除过期的结果与失效的相似请求索引
+func sweepImageResultCacheLoop() {
+	ticker := time.NewTicker(imageResultCacheSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepImageResultCache(time.Now())
+	}
+}
+
+func sweepImageResultCache(now time.Time) {
+	imageResultCacheLock.Lock()
+	defer imageResultCacheLock.Unlock()
+	for element := imageResultCacheLRU.Back(); element != nil; {
+		previous := element.Prev()
+		if now.After(element.Value.(*ImageResultCacheEntry).expiresAt) {
+			removeImageResultCacheLocked(element)
+		}
+		element = previous
+	}
+	for similarKey, key := range imageResultSimilar {
+		if _, ok := imageResultCache[key]; !ok {
+			delete(imageResultSimilar, similarKey)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"container/list"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// withImageResultCacheLimits 设置结果缓存上限并清空缓存，测试结束后还原
+func withImageResultCacheLimits(t *testing.T, maxEntries int, maxBytes int64, maxEntryBytes int) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.ResultCacheMaxEntries = maxEntries
+	settings.ResultCacheMaxBytes = maxBytes
+	settings.ResultCacheMaxEntryBytes = maxEntryBytes
+	resetImageResultCache()
+	t.Cleanup(func() {
+		*settings = previous
+		resetImageResultCache()
+	})
+}
+
+func resetImageResultCache() {
+	imageResultCacheLock.Lock()
+	imageResultCache = make(map[string]*list.Element)
+	imageResultCacheLRU = list.New()
+	imageResultCacheBytes = 0
+	imageResultSimilar = make(map[string]string)
+	imageResultCacheLock.Unlock()
+}
+
+func storeTestImageResult(key string, size int, ttl time.Duration) {
+	StoreImageResultCache(key, "", http.Header{}, make([]byte, size), ttl)
+}
+
+func TestImageResultCacheEvictsLeastRecentlyUsedEntry(t *testing.T) {
+	withImageResultCacheLimits(t, 2, 0, 0)
+	storeTestImageResult("a", 10, time.Minute)
+	storeTestImageResult("b", 10, time.Minute)
+	if LoadImageResultCache("a") == nil {
+		t.Fatal("entry a is missing")
+	}
+	storeTestImageResult("c", 10, time.Minute)
+
+	if LoadImageResultCache("b") != nil {
+		t.Fatal("least recently used entry b was not evicted")
+	}
+	if LoadImageResultCache("a") == nil || LoadImageResultCache("c") == nil {
+		t.Fatal("recently used entries were evicted")
+	}
+	if stats := GetImageResultCacheStats(); stats.Entries != 2 || stats.Bytes != 20 {
+		t.Fatalf("stats = %+v, want 2 entries and 20 bytes", stats)
+	}
+}
+
+func TestImageResultCacheEnforcesByteLimits(t *testing.T) {
+	withImageResultCacheLimits(t, 0, 100, 60)
+	storeTestImageResult("a", 40, time.Minute)
+	storeTestImageResult("b", 40, time.Minute)
+	storeTestImageResult("c", 40, time.Minute)
+	if LoadImageResultCache("a") != nil {
+		t.Fatal("oldest entry was kept beyond the total byte limit")
+	}
+	if stats := GetImageResultCacheStats(); stats.Bytes != 80 {
+		t.Fatalf("cached bytes = %d, want 80", stats.Bytes)
+	}
+
+	storeTestImageResult("large", 61, time.Minute)
+	if LoadImageResultCache("large") != nil {
+		t.Fatal("body above the per-entry limit was cached")
+	}
+	if LoadImageResultCache("b") == nil || LoadImageResultCache("c") == nil {
+		t.Fatal("skipping an oversized body evicted other entries")
+	}
+}
+
+func TestSweepImageResultCacheRemovesExpiredEntries(t *testing.T) {
+	withImageResultCacheLimits(t, 0, 0, 0)
+	StoreImageResultCache("old", "similar-old", http.Header{}, make([]byte, 10), time.Second)
+	storeTestImageResult("fresh", 10, time.Hour)
+
+	sweepImageResultCache(time.Now().Add(time.Minute))
+	if stats := GetImageResultCacheStats(); stats.Entries != 1 || stats.Bytes != 10 {
+		t.Fatalf("stats after sweep = %+v, want only the fresh entry", stats)
+	}
+	imageResultCacheLock.Lock()
+	_, similarKept := imageResultSimilar["similar-old"]
+	imageResultCacheLock.Unlock()
+	if similarKept {
+		t.Fatal("similar index of an expired entry was kept")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// ImageRetryDelay 返回第 attempt 次重试（从 1 开始）前的等待时间，未配置退避时返回 0
+func ImageRetryDelay(setting *dto.ImageRetryBackoffSetting, attempt int) time.Duration {
+	if setting == nil || setting.BaseDelayMs <= 0 || attempt <= 0 {
+		return 0
+	}
+	maxDelay := time.Duration(setting.MaxDelayMs) * time.Millisecond
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	multiplier := setting.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := time.Duration(setting.BaseDelayMs) * time.Millisecond
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	delay = min(delay, maxDelay)
+	switch setting.Jitter {
+	case dto.ImageRetryJitterNone:
+		return delay
+	case dto.ImageRetryJitterEqual:
+		half := delay / 2
+		return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+	default:
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+}
+
+// WaitImageRetryDelay 按退避配置等待，上游要求的等待时间 minDelay 更长时以其为准，客户端断开时提前返回 false
+func WaitImageRetryDelay(ctx context.Context, setting *dto.ImageRetryBackoffSetting, attempt int, minDelay time.Duration) bool {
+	delay := max(ImageRetryDelay(setting, attempt), minDelay)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ParseRetryAfter 解析 Retry-After 响应头，支持秒数与 HTTP 日期两种格式，无法解析或已过期时返回 0
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestImageRetryDelayWithinJitterRange(t *testing.T) {
+	// 基础 100ms、倍数 2、上限 1s：第 3 次重试退避 400ms，第 5 次重试被上限截断为 1s
+	backoff := func(jitter string) *dto.ImageRetryBackoffSetting {
+		return &dto.ImageRetryBackoffSetting{BaseDelayMs: 100, MaxDelayMs: 1000, Multiplier: 2, Jitter: jitter}
+	}
+	tests := []struct {
+		jitter   string
+		attempt  int
+		min, max time.Duration
+	}{
+		{jitter: dto.ImageRetryJitterNone, attempt: 1, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{jitter: dto.ImageRetryJitterNone, attempt: 3, min: 400 * time.Millisecond, max: 400 * time.Millisecond},
+		{jitter: dto.ImageRetryJitterNone, attempt: 5, min: time.Second, max: time.Second},
+		{jitter: dto.ImageRetryJitterFull, attempt: 3, min: 0, max: 400 * time.Millisecond},
+		{jitter: "", attempt: 3, min: 0, max: 400 * time.Millisecond},
+		{jitter: dto.ImageRetryJitterEqual, attempt: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{jitter: dto.ImageRetryJitterEqual, attempt: 5, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		setting := backoff(tt.jitter)
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			delay := ImageRetryDelay(setting, tt.attempt)
+			if delay < tt.min || delay > tt.max {
+				t.Fatalf("jitter %q attempt %d: delay %v outside [%v, %v]", tt.jitter, tt.attempt, delay, tt.min, tt.max)
+			}
+			seen[delay] = true
+		}
+		// 抖动后的等待时间应当分散，避免各客户端同时重试
+		if tt.min != tt.max && len(seen) < 10 {
+			t.Fatalf("jitter %q attempt %d produced only %d distinct delays", tt.jitter, tt.attempt, len(seen))
+		}
+	}
+
+	for _, setting := range []*dto.ImageRetryBackoffSetting{nil, {BaseDelayMs: 0, Jitter: dto.ImageRetryJitterNone}} {
+		if delay := ImageRetryDelay(setting, 2); delay != 0 {
+			t.Fatalf("backoff %+v: delay %v, want 0", setting, delay)
+		}
+	}
+}
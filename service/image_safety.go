@@ -0,0 +1,32 @@
+package service
+
+import (
+	"sort"
+	"strings"
+)
+
+// CheckImageSafetyThresholds 检查图片安全评分是否超过阈值，返回首个超限的分类、评分与阈值。
+// 阈值的键可以是带命名空间的分类（如 gemini.violence），也可以只写分类名，对所有上游生效
+func CheckImageSafetyThresholds(ratings map[string]float64, thresholds map[string]float64) (string, float64, float64, bool) {
+	if len(ratings) == 0 || len(thresholds) == 0 {
+		return "", 0, 0, false
+	}
+	categories := make([]string, 0, len(ratings))
+	for category := range ratings {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		score := ratings[category]
+		threshold, ok := thresholds[category]
+		if !ok {
+			if idx := strings.Index(category, "."); idx != -1 {
+				threshold, ok = thresholds[category[idx+1:]]
+			}
+		}
+		if ok && score > threshold {
+			return category, score, threshold, true
+		}
+	}
+	return "", 0, 0, false
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+type imageSlaSample struct {
+	at      time.Time
+	latency time.Duration
+	success bool
+}
+
+type imageSlaWindow struct {
+	samples     []imageSlaSample
+	lastAlertAt time.Time
+}
+
+var (
+	imageSlaWindows = make(map[int]*imageSlaWindow)
+	imageSlaLock    sync.Mutex
+)
+
+// ImageSlaBreach SLA 违反详情
+type ImageSlaBreach struct {
+	ChannelId  int
+	Requests   int
+	P95Latency time.Duration
+	ErrorRate  float64
+	Rule       model_setting.ImageSlaRule
+}
+
+// RecordImageSlaSample 记录一次图片请求的耗时与结果，按渠道滚动窗口评估 SLA，违反时异步发送告警
+func RecordImageSlaSample(channelId int, latency time.Duration, success bool) {
+	settings := model_setting.GetImageSettings()
+	rule, ok := settings.GetImageSlaRule(channelId)
+	if !ok {
+		return
+	}
+	breach := addImageSlaSample(channelId, rule, imageSlaSample{at: time.Now(), latency: latency, success: success},
+		time.Duration(settings.SlaAlertCooldownSeconds)*time.Second)
+	if breach == nil || settings.SlaAlertWebhookUrl == "" {
+		return
+	}
+	webhookUrl, secret := settings.SlaAlertWebhookUrl, settings.SlaAlertWebhookSecret
+	gopool.Go(func() {
+		content := fmt.Sprintf("渠道 #%d 图片生成 SLA 告警：窗口内 %d 次请求，P95 耗时 %dms（阈值 %dms），错误率 %.2f%%（阈值 %.2f%%）",
+			breach.ChannelId, breach.Requests, breach.P95Latency.Milliseconds(), breach.Rule.P95LatencyMs,
+			breach.ErrorRate*100, breach.Rule.MaxErrorRate*100)
+		notify := dto.NewNotify(dto.NotifyTypeChannelSla, "图片渠道 SLA 告警", content, nil)
+		if err := SendWebhookNotify(webhookUrl, secret, notify); err != nil {
+			common.SysError(fmt.Sprintf("failed to send image sla alert for channel #%d: %s", breach.ChannelId, err.Error()))
+		}
+	})
+}
+
+func addImageSlaSample(channelId int, rule model_setting.ImageSlaRule, sample imageSlaSample, cooldown time.Duration) *ImageSlaBreach {
+	windowSize := time.Duration(rule.WindowSeconds) * time.Second
+	if windowSize <= 0 {
+		windowSize = 5 * time.Minute
+	}
+	minRequests := rule.MinRequests
+	if minRequests <= 0 {
+		minRequests = 20
+	}
+
+	imageSlaLock.Lock()
+	defer imageSlaLock.Unlock()
+
+	window, ok := imageSlaWindows[channelId]
+	if !ok {
+		window = &imageSlaWindow{}
+		imageSlaWindows[channelId] = window
+	}
+	window.samples = append(window.samples, sample)
+	cutoff := sample.at.Add(-windowSize)
+	idx := 0
+	for idx < len(window.samples) && window.samples[idx].at.Before(cutoff) {
+		idx++
+	}
+	window.samples = window.samples[idx:]
+
+	if len(window.samples) < minRequests {
+		return nil
+	}
+	if !window.lastAlertAt.IsZero() && sample.at.Sub(window.lastAlertAt) < cooldown {
+		return nil
+	}
+
+	latencies := make([]time.Duration, 0, len(window.samples))
+	failures := 0
+	for _, s := range window.samples {
+		latencies = append(latencies, s.latency)
+		if !s.success {
+			failures++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p95 := latencies[(len(latencies)*95+99)/100-1]
+	errorRate := float64(failures) / float64(len(window.samples))
+
+	latencyBreached := rule.P95LatencyMs > 0 && p95.Milliseconds() > rule.P95LatencyMs
+	errorBreached := rule.MaxErrorRate > 0 && errorRate > rule.MaxErrorRate
+	if !latencyBreached && !errorBreached {
+		return nil
+	}
+	window.lastAlertAt = sample.at
+	return &ImageSlaBreach{
+		ChannelId:  channelId,
+		Requests:   len(window.samples),
+		P95Latency: p95,
+		ErrorRate:  errorRate,
+		Rule:       rule,
+	}
+}
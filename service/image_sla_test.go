@@ -0,0 +1,100 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+// withImageSlaAlert 为渠道配置 SLA 规则与告警地址并清空滚动窗口，告警地址为本地模拟服务，测试期间关闭 SSRF 防护
+func withImageSlaAlert(t *testing.T, channelId string, rule model_setting.ImageSlaRule, webhookUrl string) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.SlaRules = map[string]model_setting.ImageSlaRule{channelId: rule}
+	settings.SlaAlertWebhookUrl = webhookUrl
+	settings.SlaAlertCooldownSeconds = 600
+	fetchSetting := system_setting.GetFetchSetting()
+	previousSSRF := fetchSetting.EnableSSRFProtection
+	fetchSetting.EnableSSRFProtection = false
+	resetImageSlaWindows()
+	t.Cleanup(func() {
+		*settings = previous
+		fetchSetting.EnableSSRFProtection = previousSSRF
+		resetImageSlaWindows()
+	})
+}
+
+func resetImageSlaWindows() {
+	imageSlaLock.Lock()
+	imageSlaWindows = make(map[int]*imageSlaWindow)
+	imageSlaLock.Unlock()
+}
+
+func TestImageSlaBreachSendsAlert(t *testing.T) {
+	alerts := make(chan WebhookPayload, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload WebhookPayload
+		if err := common.Unmarshal(body, &payload); err != nil {
+			t.Errorf("decode alert %q: %v", body, err)
+		}
+		alerts <- payload
+	}))
+	defer server.Close()
+	withImageSlaAlert(t, "9", model_setting.ImageSlaRule{P95LatencyMs: 1000, WindowSeconds: 60, MinRequests: 5}, server.URL)
+
+	// 请求数未达到评估门槛时不告警
+	for i := 0; i < 4; i++ {
+		RecordImageSlaSample(9, 3*time.Second, true)
+	}
+	select {
+	case payload := <-alerts:
+		t.Fatalf("alert sent before reaching min requests: %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	RecordImageSlaSample(9, 3*time.Second, true)
+	select {
+	case payload := <-alerts:
+		if payload.Type != dto.NotifyTypeChannelSla || !strings.Contains(payload.Content, "渠道 #9") || !strings.Contains(payload.Content, "P95 耗时 3000ms") {
+			t.Fatalf("unexpected alert: %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sla breach did not send an alert")
+	}
+
+	// 冷却期内持续违反不重复告警，未配置规则的渠道不评估
+	RecordImageSlaSample(9, 3*time.Second, false)
+	for i := 0; i < 5; i++ {
+		RecordImageSlaSample(10, 3*time.Second, false)
+	}
+	select {
+	case payload := <-alerts:
+		t.Fatalf("alert repeated within cooldown: %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestImageSlaErrorRateBreach(t *testing.T) {
+	rule := model_setting.ImageSlaRule{MaxErrorRate: 0.2, MinRequests: 10}
+	start := time.Now()
+	resetImageSlaWindows()
+	t.Cleanup(resetImageSlaWindows)
+	var breach *ImageSlaBreach
+	for i := 0; i < 10; i++ {
+		breach = addImageSlaSample(11, rule, imageSlaSample{at: start.Add(time.Duration(i) * time.Second), latency: time.Second, success: i%4 != 0}, time.Minute)
+	}
+	// 10 次中失败 3 次，错误率 30% 超过阈值 20%
+	if breach == nil || breach.Requests != 10 || breach.ErrorRate != 0.3 {
+		t.Fatalf("unexpected breach: %+v", breach)
+	}
+}
@@ -0,0 +1,482 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+// ImageStoragePath 临时图片对外访问路径前缀
+const ImageStoragePath = "/v1/images/files/"
+
+var ErrStoredImageNotFound = errors.New("stored image not found or expired")
+
+// StoredImage 临时存储的图片
+type StoredImage struct {
+	Data        []byte
+	ContentType string
+	ExpiresAt   time.Time
+	Metadata    map[string]string
+	ETag        string // 内容的 sha256，用于客户端与 CDN 条件请求
+}
+
+// ImageStorage 图片临时存储，用于向上游或客户端提供短期有效的图片地址
+type ImageStorage interface {
+	Save(data []byte, contentType string, ttl time.Duration, metadata map[string]string) (string, error)
+	Load(id string) (*StoredImage, error)
+	Delete(id string) error
+}
+
+// ImageStreamStorage 支持流式写入的图片存储，写入过程中不在内存中保留完整图片
+type ImageStreamStorage interface {
+	SaveStream(r io.Reader, contentType string, ttl time.Duration, metadata map[string]string) (string, error)
+}
+
+type storedImageMeta struct {
+	ContentType string            `json:"content_type"`
+	ExpiresAt   int64             `json:"expires_at"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Object      string            `json:"object,omitempty"` // 去重存储时指向的内容哈希
+}
+
+// localImageStorage 本地磁盘存储，多节点部署时需要共享存储目录。
+// 开启去重后图片内容按 sha256 保存在 objects 目录下，记录文件通过 object 字段引用，
+// 引用计数保存在 <hash>.refs 中；引用计数只在当前进程内加锁，多节点共享目录时不保证去重的原子性
+type localImageStorage struct {
+	dir      string
+	refsLock sync.Mutex
+}
+
+var (
+	imageStorage     ImageStorage
+	imageStorageOnce sync.Once
+)
+
+// GetImageStorage 返回全局图片存储实例，首次调用时初始化并启动过期清理
+func GetImageStorage() ImageStorage {
+	imageStorageOnce.Do(func() {
+		dir := model_setting.GetImageSettings().StorageDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "new-api-images")
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+			common.SysError("failed to create image storage dir: " + err.Error())
+		}
+		storage := &localImageStorage{dir: dir}
+		go storage.cleanupLoop(time.Minute)
+		imageStorage = storage
+	})
+	return imageStorage
+}
+
+// SaveImageWithRetry 带超时与重试地写入图片存储，避免存储后端缓慢时阻塞整个请求
+func SaveImageWithRetry(ctx context.Context, data []byte, contentType string, ttl time.Duration, metadata map[string]string) (string, error) {
+	settings := model_setting.GetImageSettings()
+	timeout := time.Duration(settings.StorageWriteTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if contentType == "" {
+		contentType = ImageContentType(ctx, data)
+	}
+	storage := GetImageStorage()
+	var lastErr error
+	for attempt := 0; attempt <= settings.StorageWriteRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+			}
+		}
+		write := startImageStorageWrite(storage, data, contentType, ttl, metadata)
+		select {
+		case result := <-write.done:
+			if result.err == nil {
+				return result.id, nil
+			}
+			lastErr = result.err
+		case <-time.After(timeout):
+			lastErr = fmt.Errorf("storage write timed out after %s", timeout)
+			write.abandon()
+		case <-ctx.Done():
+			write.abandon()
+			return "", ctx.Err()
+		}
+		common.SysLog(fmt.Sprintf("image storage write failed (attempt %d): %s", attempt+1, lastErr.Error()))
+	}
+	return "", lastErr
+}
+
+type imageStorageWriteResult struct {
+	id  string
+	err error
+}
+
+// imageStorageWrite 后台进行的一次存储写入。超时或请求取消后调用方放弃等待，
+// 写入协程仍会执行完，此时由它删除写入成功的图片，避免留下无人引用的对象与多余的去重引用计数
+type imageStorageWrite struct {
+	storage   ImageStorage
+	done      chan imageStorageWriteResult
+	mu        sync.Mutex
+	abandoned bool
+}
+
+func startImageStorageWrite(storage ImageStorage, data []byte, contentType string, ttl time.Duration, metadata map[string]string) *imageStorageWrite {
+	write := &imageStorageWrite{storage: storage, done: make(chan imageStorageWriteResult, 1)}
+	go func() {
+		id, err := storage.Save(data, contentType, ttl, metadata)
+		write.mu.Lock()
+		defer write.mu.Unlock()
+		if write.abandoned {
+			write.discard(imageStorageWriteResult{id: id, err: err})
+			return
+		}
+		write.done <- imageStorageWriteResult{id: id, err: err}
+	}()
+	return write
+}
+
+// abandon 放弃等待写入结果。写入恰好在放弃前完成时结果已在通道中，同样删除
+func (w *imageStorageWrite) abandon() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.abandoned = true
+	select {
+	case result := <-w.done:
+		w.discard(result)
+	default:
+	}
+}
+
+func (w *imageStorageWrite) discard(result imageStorageWriteResult) {
+	if result.err != nil {
+		return
+	}
+	if err := w.storage.Delete(result.id); err != nil {
+		common.SysLog(fmt.Sprintf("failed to delete abandoned stored image %s: %s", result.id, err.Error()))
+	}
+}
+
+// SaveImageStream 流式写入图片存储，读取的数据无法重放，因此不做重试。
+// 存储不支持流式写入时退化为读取全部内容后写入
+func SaveImageStream(ctx context.Context, r io.Reader, contentType string, ttl time.Duration, metadata map[string]string) (string, error) {
+	storage := GetImageStorage()
+	streamStorage, ok := storage.(ImageStreamStorage)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return SaveImageWithRetry(ctx, data, contentType, ttl, metadata)
+	}
+	return streamStorage.SaveStream(&contextReader{ctx: ctx, r: r}, contentType, ttl, metadata)
+}
+
+// contextReader 上下文取消后停止读取，避免客户端断开后继续写入存储
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// GetStoredImageUrl 返回临时图片的对外访问地址
+func GetStoredImageUrl(id string) string {
+	return strings.TrimSuffix(system_setting.ServerAddress, "/") + ImageStoragePath + id
+}
+
+func newStoredImageId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// isValidStoredImageId 防止路径穿越，只接受生成的十六进制 id
+func isValidStoredImageId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (s *localImageStorage) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *localImageStorage) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *localImageStorage) objectPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash)
+}
+
+func (s *localImageStorage) refsPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash+".refs")
+}
+
+func (s *localImageStorage) readRefs(hash string) int {
+	raw, err := os.ReadFile(s.refsPath(hash))
+	if err != nil {
+		return 0
+	}
+	refs, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+	return refs
+}
+
+// retainObject 保存图片内容（已存在时直接复用）并增加引用计数
+func (s *localImageStorage) retainObject(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	s.refsLock.Lock()
+	defer s.refsLock.Unlock()
+	refs := s.readRefs(hash)
+	if _, err := os.Stat(s.objectPath(hash)); err != nil {
+		if err = os.WriteFile(s.objectPath(hash), data, 0o644); err != nil {
+			return "", fmt.Errorf("write stored image failed: %w", err)
+		}
+		refs = 0
+	}
+	if err := os.WriteFile(s.refsPath(hash), []byte(strconv.Itoa(refs+1)), 0o644); err != nil {
+		if refs == 0 {
+			_ = os.Remove(s.objectPath(hash))
+		}
+		return "", fmt.Errorf("write stored image refs failed: %w", err)
+	}
+	return hash, nil
+}
+
+// retainObjectFile 与 retainObject 相同，内容来自已写入磁盘的临时文件，内容已存在时删除临时文件
+func (s *localImageStorage) retainObjectFile(path string, hash string) error {
+	s.refsLock.Lock()
+	defer s.refsLock.Unlock()
+	refs := s.readRefs(hash)
+	if _, err := os.Stat(s.objectPath(hash)); err != nil {
+		if err = os.Rename(path, s.objectPath(hash)); err != nil {
+			return fmt.Errorf("write stored image failed: %w", err)
+		}
+		refs = 0
+	} else {
+		_ = os.Remove(path)
+	}
+	if err := os.WriteFile(s.refsPath(hash), []byte(strconv.Itoa(refs+1)), 0o644); err != nil {
+		if refs == 0 {
+			_ = os.Remove(s.objectPath(hash))
+		}
+		return fmt.Errorf("write stored image refs failed: %w", err)
+	}
+	return nil
+}
+
+// releaseObject 减少引用计数，没有记录引用时删除图片内容
+func (s *localImageStorage) releaseObject(hash string) error {
+	s.refsLock.Lock()
+	defer s.refsLock.Unlock()
+	refs := s.readRefs(hash) - 1
+	if refs > 0 {
+		return os.WriteFile(s.refsPath(hash), []byte(strconv.Itoa(refs)), 0o644)
+	}
+	_ = os.Remove(s.refsPath(hash))
+	if err := os.Remove(s.objectPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localImageStorage) Save(data []byte, contentType string, ttl time.Duration, metadata map[string]string) (string, error) {
+	id, err := newStoredImageId()
+	if err != nil {
+		return "", err
+	}
+	record := storedImageMeta{
+		ContentType: contentType,
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+		Metadata:    metadata,
+	}
+	if model_setting.GetImageSettings().StorageDedupEnabled {
+		if record.Object, err = s.retainObject(data); err != nil {
+			return "", err
+		}
+	} else if err = os.WriteFile(s.dataPath(id), data, 0o644); err != nil {
+		return "", fmt.Errorf("write stored image failed: %w", err)
+	}
+	if err = s.writeMeta(id, record); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// SaveStream 先写入临时文件并同时计算哈希，完成后再移动到最终位置，避免读到写了一半的图片
+func (s *localImageStorage) SaveStream(r io.Reader, contentType string, ttl time.Duration, metadata map[string]string) (string, error) {
+	id, err := newStoredImageId()
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return "", fmt.Errorf("create stored image failed: %w", err)
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("write stored image failed: %w", err)
+	}
+	record := storedImageMeta{
+		ContentType: contentType,
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+		Metadata:    metadata,
+	}
+	if model_setting.GetImageSettings().StorageDedupEnabled {
+		hash := hex.EncodeToString(hasher.Sum(nil))
+		if err = s.retainObjectFile(tmp.Name(), hash); err != nil {
+			_ = os.Remove(tmp.Name())
+			return "", err
+		}
+		record.Object = hash
+	} else if err = os.Rename(tmp.Name(), s.dataPath(id)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("write stored image failed: %w", err)
+	}
+	if err = s.writeMeta(id, record); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// writeMeta 写入图片记录，失败时回滚已写入的图片内容
+func (s *localImageStorage) writeMeta(id string, record storedImageMeta) error {
+	meta, err := common.Marshal(record)
+	if err == nil {
+		err = os.WriteFile(s.metaPath(id), meta, 0o644)
+	}
+	if err != nil {
+		if record.Object != "" {
+			_ = s.releaseObject(record.Object)
+		} else {
+			_ = os.Remove(s.dataPath(id))
+		}
+		return fmt.Errorf("write stored image meta failed: %w", err)
+	}
+	return nil
+}
+
+func (s *localImageStorage) loadMeta(id string) (*storedImageMeta, error) {
+	raw, err := os.ReadFile(s.metaPath(id))
+	if err != nil {
+		return nil, ErrStoredImageNotFound
+	}
+	var meta storedImageMeta
+	if err = common.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (s *localImageStorage) Load(id string) (*StoredImage, error) {
+	if !isValidStoredImageId(id) {
+		return nil, ErrStoredImageNotFound
+	}
+	meta, err := s.loadMeta(id)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Unix(meta.ExpiresAt, 0)
+	if time.Now().After(expiresAt) {
+		_ = s.Delete(id)
+		return nil, ErrStoredImageNotFound
+	}
+	dataPath := s.dataPath(id)
+	if meta.Object != "" {
+		dataPath = s.objectPath(meta.Object)
+	}
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, ErrStoredImageNotFound
+	}
+	// 去重存储时对象名即内容哈希，无需重新计算
+	hash := meta.Object
+	if hash == "" {
+		sum := sha256.Sum256(data)
+		hash = hex.EncodeToString(sum[:])
+	}
+	return &StoredImage{
+		Data:        data,
+		ContentType: meta.ContentType,
+		ExpiresAt:   expiresAt,
+		Metadata:    meta.Metadata,
+		ETag:        `"` + hash + `"`,
+	}, nil
+}
+
+func (s *localImageStorage) Delete(id string) error {
+	if !isValidStoredImageId(id) {
+		return ErrStoredImageNotFound
+	}
+	meta, _ := s.loadMeta(id)
+	if err := os.Remove(s.metaPath(id)); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// 记录已被并发删除，引用已经释放过
+		if meta != nil && meta.Object != "" {
+			return nil
+		}
+	} else if meta != nil && meta.Object != "" {
+		// 仅在删除记录成功后释放引用，避免重复释放
+		return s.releaseObject(meta.Object)
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// cleanupLoop 定期删除已过期的图片
+func (s *localImageStorage) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			continue
+		}
+		now := time.Now().Unix()
+		for _, entry := range entries {
+			id, ok := strings.CutSuffix(entry.Name(), ".json")
+			if !ok || !isValidStoredImageId(id) {
+				continue
+			}
+			meta, err := s.loadMeta(id)
+			if err != nil || meta.ExpiresAt < now {
+				_ = s.Delete(id)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+// withImageStorage 替换全局图片存储，测试结束后还原
+func withImageStorage(t *testing.T, storage ImageStorage) {
+	t.Helper()
+	previous := GetImageStorage()
+	imageStorage = storage
+	t.Cleanup(func() { imageStorage = previous })
+}
+
+// withStorageWriteSettings 设置存储写入的超时与重试次数，测试结束后还原
+func withStorageWriteSettings(t *testing.T, timeoutSeconds int, retries int) {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := *settings
+	settings.StorageWriteTimeoutSeconds = timeoutSeconds
+	settings.StorageWriteRetries = retries
+	t.Cleanup(func() { *settings = previous })
+}
+
+// stubImageStorage 模拟缓慢或失败的存储后端：前 failures 次写入返回错误，delay 非 0 时每次写入先等待
+type stubImageStorage struct {
+	ImageStorage
+	calls    atomic.Int32
+	failures int32
+	delay    time.Duration
+	deleted  atomic.Int32
+}
+
+func (s *stubImageStorage) Save(data []byte, contentType string, ttl time.Duration, metadata map[string]string) (string, error) {
+	call := s.calls.Add(1)
+	time.Sleep(s.delay)
+	if call <= s.failures {
+		return "", errors.New("storage backend unavailable")
+	}
+	return "stored-id", nil
+}
+
+func (s *stubImageStorage) Delete(id string) error {
+	s.deleted.Add(1)
+	return nil
+}
+
+func TestSaveImageWithRetryRecoversFromFailures(t *testing.T) {
+	withStorageWriteSettings(t, 1, 2)
+	storage := &stubImageStorage{failures: 2}
+	withImageStorage(t, storage)
+
+	id, err := SaveImageWithRetry(context.Background(), []byte("image"), "image/png", time.Minute, nil)
+	if err != nil || id != "stored-id" {
+		t.Fatalf("save = %q, %v, want stored-id after retries", id, err)
+	}
+	if calls := storage.calls.Load(); calls != 3 {
+		t.Fatalf("storage called %d times, want 3", calls)
+	}
+}
+
+func TestSaveImageWithRetryReturnsLastError(t *testing.T) {
+	withStorageWriteSettings(t, 1, 1)
+	storage := &stubImageStorage{failures: 10}
+	withImageStorage(t, storage)
+
+	_, err := SaveImageWithRetry(context.Background(), []byte("image"), "image/png", time.Minute, nil)
+	if err == nil || err.Error() != "storage backend unavailable" {
+		t.Fatalf("err = %v, want the backend error", err)
+	}
+	if calls := storage.calls.Load(); calls != 2 {
+		t.Fatalf("storage called %d times, want 2", calls)
+	}
+}
+
+func TestSaveImageWithRetryTimesOutSlowStorage(t *testing.T) {
+	withStorageWriteSettings(t, 1, 1)
+	storage := &stubImageStorage{delay: 3 * time.Second}
+	withImageStorage(t, storage)
+
+	start := time.Now()
+	_, err := SaveImageWithRetry(context.Background(), []byte("image"), "image/png", time.Minute, nil)
+	elapsed := time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+		t.Fatalf("err = %v, want a storage timeout", err)
+	}
+	if calls := storage.calls.Load(); calls != 2 {
+		t.Fatalf("storage called %d times, want 2", calls)
+	}
+	// 两次 1 秒超时加一次 200ms 退避，不等待缓慢的写入完成
+	if elapsed < 2*time.Second || elapsed > 3*time.Second {
+		t.Fatalf("save took %s, want about 2.2s", elapsed)
+	}
+}
+
+func TestSaveImageWithRetryDeletesAbandonedWrites(t *testing.T) {
+	withStorageWriteSettings(t, 1, 0)
+	storage := &stubImageStorage{delay: 1500 * time.Millisecond}
+	withImageStorage(t, storage)
+
+	// 超时后放弃的写入最终成功，写入协程删除该图片
+	if _, err := SaveImageWithRetry(context.Background(), []byte("image"), "image/png", time.Minute, nil); err == nil {
+		t.Fatal("slow storage write did not time out")
+	}
+	// 请求取消同样删除
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := SaveImageWithRetry(ctx, []byte("image"), "image/png", time.Minute, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context deadline", err)
+	}
+	time.Sleep(2 * time.Second)
+	if calls, deleted := storage.calls.Load(), storage.deleted.Load(); calls != 2 || deleted != 2 {
+		t.Fatalf("storage called %d times and deleted %d images, want both abandoned writes deleted", calls, deleted)
+	}
+}
+
+// newTestLocalImageStorage 在临时目录创建本地图片存储，并设置是否开启去重
+func newTestLocalImageStorage(t *testing.T, dedup bool) *localImageStorage {
+	t.Helper()
+	settings := model_setting.GetImageSettings()
+	previous := settings.StorageDedupEnabled
+	settings.StorageDedupEnabled = dedup
+	t.Cleanup(func() { settings.StorageDedupEnabled = previous })
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+		t.Fatalf("create storage dir: %v", err)
+	}
+	return &localImageStorage{dir: dir}
+}
+
+// countStoredObjects 返回去重目录中保存的图片内容数量，不含引用计数文件
+func countStoredObjects(t *testing.T, storage *localImageStorage) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(storage.dir, "objects"))
+	if err != nil {
+		t.Fatalf("read objects dir: %v", err)
+	}
+	count := 0
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".refs") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestDedupStoresIdenticalImagesOnce(t *testing.T) {
+	storage := newTestLocalImageStorage(t, true)
+	data := []byte("identical image bytes")
+
+	first, err := storage.Save(data, "image/png", time.Hour, nil)
+	if err != nil {
+		t.Fatalf("save first image: %v", err)
+	}
+	second, err := storage.SaveStream(bytes.NewReader(data), "image/png", time.Hour, map[string]string{"user_id": "7"})
+	if err != nil {
+		t.Fatalf("save second image: %v", err)
+	}
+	if _, err = storage.Save([]byte("another image"), "image/png", time.Hour, nil); err != nil {
+		t.Fatalf("save different image: %v", err)
+	}
+	if first == second {
+		t.Fatal("identical images share the same id")
+	}
+	if count := countStoredObjects(t, storage); count != 2 {
+		t.Fatalf("stored %d objects, want 2", count)
+	}
+
+	// 两个记录读取到相同内容，元数据各自独立
+	a, err := storage.Load(first)
+	if err != nil {
+		t.Fatalf("load first image: %v", err)
+	}
+	b, err := storage.Load(second)
+	if err != nil {
+		t.Fatalf("load second image: %v", err)
+	}
+	if !bytes.Equal(a.Data, data) || !bytes.Equal(b.Data, data) || a.ETag != b.ETag {
+		t.Fatal("deduplicated records do not return the same image")
+	}
+	if a.Metadata != nil || b.Metadata["user_id"] != "7" {
+		t.Fatalf("metadata mixed between records: %v, %v", a.Metadata, b.Metadata)
+	}
+}
+
+func TestDedupReleasesObjectWithLastReference(t *testing.T) {
+	storage := newTestLocalImageStorage(t, true)
+	data := []byte("shared image bytes")
+	first, _ := storage.Save(data, "image/png", time.Hour, nil)
+	second, _ := storage.Save(data, "image/png", time.Hour, nil)
+	record, err := storage.Load(first)
+	if err != nil {
+		t.Fatalf("load image: %v", err)
+	}
+	hash := strings.Trim(record.ETag, `"`)
+	if refs := storage.readRefs(hash); refs != 2 {
+		t.Fatalf("refs = %d, want 2", refs)
+	}
+
+	// 删除一个记录后内容仍被另一个记录引用，重复删除不会多释放引用
+	if err = storage.Delete(first); err != nil {
+		t.Fatalf("delete first image: %v", err)
+	}
+	_ = storage.Delete(first)
+	if refs := storage.readRefs(hash); refs != 1 {
+		t.Fatalf("refs after deleting one record = %d, want 1", refs)
+	}
+	if _, err = storage.Load(second); err != nil {
+		t.Fatalf("remaining record lost its image: %v", err)
+	}
+
+	if err = storage.Delete(second); err != nil {
+		t.Fatalf("delete second image: %v", err)
+	}
+	if _, err = os.Stat(storage.objectPath(hash)); !os.IsNotExist(err) {
+		t.Fatalf("object kept after the last reference was released: %v", err)
+	}
+	if _, err = os.Stat(storage.refsPath(hash)); !os.IsNotExist(err) {
+		t.Fatalf("refs file kept after the last reference was released: %v", err)
+	}
+}
+
+func TestStorageWithoutDedupKeepsSeparateCopies(t *testing.T) {
+	storage := newTestLocalImageStorage(t, false)
+	data := []byte("identical image bytes")
+	first, _ := storage.Save(data, "image/png", time.Hour, nil)
+	second, _ := storage.Save(data, "image/png", time.Hour, nil)
+	if countStoredObjects(t, storage) != 0 {
+		t.Fatal("images stored as shared objects with dedup disabled")
+	}
+	for _, id := range []string{first, second} {
+		if _, err := os.Stat(storage.dataPath(id)); err != nil {
+			t.Fatalf("image %s not stored separately: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 未启用 Redis 时在进程内按分钟窗口记录令牌的出图张数，多节点部署时不共享
+var (
+	imageTokenRateLock   sync.Mutex
+	imageTokenRateCounts = make(map[int]int)
+	imageTokenRateWindow int64
+)
+
+const imageTokenRateWindowSeconds = 60
+
+// ReserveTokenImageRate 在当前分钟窗口内为令牌预留 n 张图片的额度。超出 limit 时不计数，
+// 返回 false 与距离窗口结束的时间；limit 不大于 0 表示不限制
+func ReserveTokenImageRate(tokenId int, limit int, n int) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	n = max(n, 1)
+	now := time.Now()
+	window := now.Unix() / imageTokenRateWindowSeconds
+	retryAfter := time.Unix((window+1)*imageTokenRateWindowSeconds, 0).Sub(now)
+	if n > limit {
+		return false, retryAfter
+	}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := fmt.Sprintf("image_token_rate:%d:%d", tokenId, window)
+		count, err := common.RDB.IncrBy(ctx, key, int64(n)).Result()
+		if err != nil {
+			// 限流存储不可用时放行，避免影响正常请求
+			common.SysError("failed to check token image rate limit: " + err.Error())
+			return true, 0
+		}
+		if count == int64(n) {
+			common.RDB.Expire(ctx, key, 2*imageTokenRateWindowSeconds*time.Second)
+		}
+		if count > int64(limit) {
+			common.RDB.DecrBy(ctx, key, int64(n))
+			return false, retryAfter
+		}
+		return true, 0
+	}
+	imageTokenRateLock.Lock()
+	defer imageTokenRateLock.Unlock()
+	if imageTokenRateWindow != window {
+		// 进入新窗口时丢弃上一窗口的记录
+		imageTokenRateCounts = make(map[int]int)
+		imageTokenRateWindow = window
+	}
+	if imageTokenRateCounts[tokenId]+n > limit {
+		return false, retryAfter
+	}
+	imageTokenRateCounts[tokenId] += n
+	return true, 0
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ImageTraceContext W3C trace context（traceparent），TraceId 为空表示没有上级链路
+type ImageTraceContext struct {
+	TraceId    [16]byte
+	SpanId     [8]byte
+	TraceFlags byte
+	TraceState string
+}
+
+// IsValid trace id 与 span id 均不为全零
+func (t ImageTraceContext) IsValid() bool {
+	return t.TraceId != [16]byte{} && t.SpanId != [8]byte{}
+}
+
+// Traceparent 按 W3C 格式输出，用于向上游传递链路
+func (t ImageTraceContext) Traceparent() string {
+	return "00-" + hex.EncodeToString(t.TraceId[:]) + "-" + hex.EncodeToString(t.SpanId[:]) + "-" + hex.EncodeToString([]byte{t.TraceFlags})
+}
+
+// ParseTraceparent 解析客户端传入的 traceparent，格式不正确时返回 false
+func ParseTraceparent(traceparent string, tracestate string) (ImageTraceContext, bool) {
+	var t ImageTraceContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return t, false
+	}
+	// 版本 00 只允许四段，更高版本允许追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return t, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(t.TraceId[:], []byte(parts[1])); err != nil {
+		return t, false
+	}
+	if _, err := hex.Decode(t.SpanId[:], []byte(parts[2])); err != nil {
+		return t, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return t, false
+	}
+	t.TraceFlags = flags[0]
+	t.TraceState = tracestate
+	return t, t.IsValid()
+}
+
+// ImageTracer 图片请求的链路追踪后端，OpenTelemetry 等实现通过 SetImageTracer 注册。
+// parent 无效时由实现创建新的链路
+type ImageTracer interface {
+	StartSpan(parent ImageTraceContext, name string, start time.Time) ImageSpan
+}
+
+// ImageSpan 单个阶段的 span
+type ImageSpan interface {
+	Context() ImageTraceContext
+	SetAttributes(attributes map[string]any)
+	End(end time.Time, err error)
+}
+
+type imageTracerHolder struct {
+	tracer ImageTracer
+}
+
+var imageTracer atomic.Pointer[imageTracerHolder]
+
+// SetImageTracer 注册链路追踪后端，传入 nil 关闭追踪
+func SetImageTracer(tracer ImageTracer) {
+	if tracer == nil {
+		imageTracer.Store(nil)
+		return
+	}
+	imageTracer.Store(&imageTracerHolder{tracer: tracer})
+}
+
+// GetImageTracer 返回已注册的链路追踪后端，未注册时返回 nil，调用方据此跳过全部追踪逻辑
+func GetImageTracer() ImageTracer {
+	holder := imageTracer.Load()
+	if holder == nil {
+		return nil
+	}
+	return holder.tracer
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// imageEncoders 服务端可用的图片编码器，未列出的格式（如 GIF）无法在服务端转换；WebP 使用无损编码
+var imageEncoders = map[string]func(io.Writer, image.Image) error{
+	"png": png.Encode,
+	"jpeg": func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	},
+	"webp": EncodeWebp,
+}
+
+// CanEncodeImageFormat 服务端是否能够输出指定格式
+func CanEncodeImageFormat(format string) bool {
+	_, ok := imageEncoders[format]
+	return ok
+}
+
+// ConvertBase64ImageFormat 将 base64 图片重新编码为指定格式
+func ConvertBase64ImageFormat(b64 string, format string) (string, error) {
+	encode, ok := imageEncoders[format]
+	if !ok {
+		return "", fmt.Errorf("no encoder available for image format %q", format)
+	}
+	img, _, err := decodeBase64Image(b64)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = encode(&buf, img); err != nil {
+		return "", fmt.Errorf("encode %s image failed: %w", format, err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// TranscodeImageToPNG 将 JPEG、WebP、GIF 输入图片转为 PNG，透明通道原样保留（用于蒙版）。
+// 服务端没有 AVIF 解码器，AVIF 图片返回错误
+func TranscodeImageToPNG(data []byte) ([]byte, error) {
+	format := SniffImageFormat(data)
+	var img image.Image
+	var err error
+	switch format {
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "webp":
+		img, err = webp.Decode(bytes.NewReader(data))
+	case "gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	case "avif":
+		return nil, errors.New("avif images cannot be decoded on this server, please upload png")
+	default:
+		return nil, fmt.Errorf("unsupported image format: %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s image failed: %w", format, err)
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode png image failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBase64Image 解码 base64 图片，支持 png/jpeg/webp
+func decodeBase64Image(b64 string) (image.Image, string, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode base64 image failed: %w", err)
+	}
+	format := SniffImageFormat(data)
+	var img image.Image
+	switch format {
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "webp":
+		img, err = webp.Decode(bytes.NewReader(data))
+	default:
+		return nil, "", fmt.Errorf("unsupported image format: %q", format)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s image failed: %w", format, err)
+	}
+	return img, format, nil
+}
+
+// encodeBase64Image 编码处理后的图片，JPEG 与 WebP 保持原格式（WebP 以无损格式重新编码），其余格式统一输出 PNG
+func encodeBase64Image(img image.Image, format string) (string, string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	case "webp":
+		err = EncodeWebp(&buf, img)
+	default:
+		format = "png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("encode %s image failed: %w", format, err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), format, nil
+}
+
+// DownscaleBase64Image 等比缩小超过最大宽高的图片（0 表示不限制），未超限时原样返回且 resized 为 false
+func DownscaleBase64Image(b64 string, maxWidth, maxHeight int) (result string, resized bool, err error) {
+	img, format, err := decodeBase64Image(b64)
+	if err != nil {
+		return "", false, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = min(scale, float64(maxWidth)/float64(width))
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+	if scale >= 1 {
+		return b64, false, nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	result, _, err = encodeBase64Image(dst, format)
+	if err != nil {
+		return "", false, err
+	}
+	return result, true, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+type imageUpscaleRequest struct {
+	Model string `json:"model,omitempty"`
+	Image string `json:"image"`
+	Scale int    `json:"scale"`
+}
+
+type imageUpscaleResponse struct {
+	Image string `json:"image"`
+}
+
+// UpscaleImage 调用配置的放大接口放大 base64 图片，返回放大后的 base64 数据
+func UpscaleImage(ctx context.Context, settings *model_setting.ImageSettings, b64 string, factor int) (string, error) {
+	if settings.UpscaleBaseUrl == "" {
+		return "", errors.New("image upscale endpoint is not configured")
+	}
+	timeout := time.Duration(settings.UpscaleTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := common.Marshal(imageUpscaleRequest{
+		Model: settings.UpscaleModel,
+		Image: b64,
+		Scale: factor,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.UpscaleBaseUrl, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if settings.UpscaleApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.UpscaleApiKey)
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("upscale request failed: %w", err)
+	}
+	defer CloseResponseBodyGracefully(resp)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read upscale response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upscale endpoint returned status %d", resp.StatusCode)
+	}
+	var upscaleResponse imageUpscaleResponse
+	if err = common.Unmarshal(respBody, &upscaleResponse); err != nil {
+		return "", fmt.Errorf("parse upscale response failed: %w", err)
+	}
+	if upscaleResponse.Image == "" {
+		return "", errors.New("upscale endpoint returned empty image")
+	}
+	return upscaleResponse.Image, nil
+}
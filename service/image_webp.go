@@ -0,0 +1,21 @@
+package service
+
+import (
+	"image"
+	"io"
+
+	webpenc "github.com/gen2brain/webp"
+)
+
+// EncodeWebp 以无损格式编码 WebP，透明区域的颜色原样保留。golang.org/x/image 只提供解码器，编码使用 libwebp
+func EncodeWebp(w io.Writer, img image.Image) error {
+	return EncodeWebpQuality(w, img, 100)
+}
+
+// EncodeWebpQuality 按质量（1-100）编码 WebP：100 为无损，低于 100 时使用 libwebp 的有损编码
+func EncodeWebpQuality(w io.Writer, img image.Image, quality int) error {
+	if quality >= 100 {
+		return webpenc.Encode(w, img, webpenc.Options{Lossless: true, Exact: true})
+	}
+	return webpenc.Encode(w, img, webpenc.Options{Quality: max(quality, 1)})
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// 自动调整 WebP 质量的默认范围与固定质量
+const (
+	defaultWebpMinQuality = 60
+	defaultWebpMaxQuality = 100
+	defaultWebpQuality    = 100
+	// webpDetailSampleSize 分析细节时每个方向最多采样的像素数
+	webpDetailSampleSize = 512
+)
+
+// ImageDetailScore 估计图片细节丰富程度（0-1）：对采样后的亮度求水平与垂直梯度，
+// 以梯度直方图的香农熵（最大 8 位）归一化。平滑渐变与纯色接近 0，噪点与复杂纹理接近 1
+func ImageDetailScore(img image.Image) (float64, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 2 || height < 2 {
+		return 0, errors.New("image too small to analyze")
+	}
+	stepX := max(1, width/webpDetailSampleSize)
+	stepY := max(1, height/webpDetailSampleSize)
+	luma := func(x, y int) int {
+		return int(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+	}
+	var histogram [256]int
+	total := 0
+	for y := 0; y+stepY < height; y += stepY {
+		for x := 0; x+stepX < width; x += stepX {
+			center := luma(x, y)
+			gradient := absDiff(luma(x+stepX, y), center) + absDiff(luma(x, y+stepY), center)
+			histogram[min(gradient, 255)]++
+			total++
+		}
+	}
+	if total == 0 {
+		return 0, errors.New("image too small to analyze")
+	}
+	entropy := 0.0
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return math.Min(entropy/8, 1), nil
+}
+
+func absDiff(a, b int) int {
+	if a < b {
+		return b - a
+	}
+	return a - b
+}
+
+// SelectWebpQuality 按渠道设置确定 WebP 编码质量。开启自动调整时细节越丰富质量越低（压缩痕迹不明显），
+// 平滑图片使用较高质量避免色带；分析失败时返回固定质量与错误
+func SelectWebpQuality(img image.Image, setting *dto.ImageWebpQualitySetting) (int, error) {
+	if setting == nil {
+		return defaultWebpQuality, nil
+	}
+	fixed := clampWebpQuality(setting.Quality, defaultWebpQuality)
+	if !setting.Auto {
+		return fixed, nil
+	}
+	minQuality := clampWebpQuality(setting.MinQuality, defaultWebpMinQuality)
+	maxQuality := clampWebpQuality(setting.MaxQuality, defaultWebpMaxQuality)
+	if minQuality > maxQuality {
+		minQuality, maxQuality = maxQuality, minQuality
+	}
+	score, err := ImageDetailScore(img)
+	if err != nil {
+		return fixed, err
+	}
+	return maxQuality - int(math.Round(score*float64(maxQuality-minQuality))), nil
+}
+
+func clampWebpQuality(quality int, fallback int) int {
+	if quality <= 0 {
+		return fallback
+	}
+	return min(quality, 100)
+}
+
+// ConvertBase64ImageToWebp 按渠道质量设置将 base64 图片编码为 WebP，返回实际使用的质量。
+// 质量低于 100 时为有损编码；按内容分析失败时使用固定质量
+func ConvertBase64ImageToWebp(b64 string, setting *dto.ImageWebpQualitySetting) (string, int, error) {
+	img, _, err := decodeBase64Image(b64)
+	if err != nil {
+		return "", 0, err
+	}
+	quality, err := SelectWebpQuality(img, setting)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("analyze image for webp quality failed, using fixed quality %d: %s", quality, err.Error()))
+	}
+	var buf bytes.Buffer
+	if err = EncodeWebpQuality(&buf, img, quality); err != nil {
+		return "", 0, fmt.Errorf("encode webp image failed: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), quality, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestSelectWebpQualityVariesWithContentDetail(t *testing.T) {
+	setting := &dto.ImageWebpQualitySetting{Auto: true, MinQuality: 50, MaxQuality: 95}
+	smooth, err := SelectWebpQuality(newWebpTestImage(256, 256, false), setting)
+	if err != nil {
+		t.Fatalf("analyze smooth image: %v", err)
+	}
+	noisy, err := SelectWebpQuality(newWebpTestImage(256, 256, true), setting)
+	if err != nil {
+		t.Fatalf("analyze noisy image: %v", err)
+	}
+	if smooth <= noisy {
+		t.Fatalf("smooth image quality %d should be higher than noisy image quality %d", smooth, noisy)
+	}
+	for _, quality := range []int{smooth, noisy} {
+		if quality < setting.MinQuality || quality > setting.MaxQuality {
+			t.Fatalf("quality %d outside configured range [%d, %d]", quality, setting.MinQuality, setting.MaxQuality)
+		}
+	}
+}
+
+func TestSelectWebpQualityFallsBackWhenAnalysisFails(t *testing.T) {
+	setting := &dto.ImageWebpQualitySetting{Auto: true, MinQuality: 50, MaxQuality: 95, Quality: 80}
+	quality, err := SelectWebpQuality(image.NewNRGBA(image.Rect(0, 0, 1, 1)), setting)
+	if err == nil {
+		t.Fatal("expected analysis of a 1x1 image to fail")
+	}
+	if quality != 80 {
+		t.Fatalf("fallback quality = %d, want 80", quality)
+	}
+}
+
+func TestSelectWebpQualityUsesFixedQualityWithoutAuto(t *testing.T) {
+	quality, err := SelectWebpQuality(newWebpTestImage(64, 64, true), &dto.ImageWebpQualitySetting{Quality: 70})
+	if err != nil || quality != 70 {
+		t.Fatalf("quality = %d, err = %v, want 70", quality, err)
+	}
+	if quality, _ = SelectWebpQuality(newWebpTestImage(64, 64, true), nil); quality != 100 {
+		t.Fatalf("quality without setting = %d, want lossless 100", quality)
+	}
+}
+
+// encodedWebpSize 将图片按渠道质量设置转换为 WebP，返回编码后的字节数
+func encodedWebpSize(t *testing.T, img image.Image, setting *dto.ImageWebpQualitySetting) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := ConvertBase64ImageToWebp(base64.StdEncoding.EncodeToString(buf.Bytes()), setting)
+	if err != nil {
+		t.Fatalf("convert to webp: %v", err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(result)
+	return len(data)
+}
+
+func TestAutoWebpQualityFollowsContentInOutputSize(t *testing.T) {
+	auto := &dto.ImageWebpQualitySetting{Auto: true, MinQuality: 30, MaxQuality: 90}
+	atMin := &dto.ImageWebpQualitySetting{Quality: 30}
+	atMax := &dto.ImageWebpQualitySetting{Quality: 90}
+	smooth := newWebpTestImage(256, 256, false)
+	noisy := newWebpTestImage(256, 256, true)
+	for i := 3; i < len(noisy.Pix); i += 4 {
+		noisy.Pix[i] = 0xff
+	}
+
+	// 细节丰富的图片使用接近最低的质量，体积明显小于最高质量
+	if autoSize, maxSize := encodedWebpSize(t, noisy, auto), encodedWebpSize(t, noisy, atMax); float64(autoSize) > 0.75*float64(maxSize) {
+		t.Fatalf("noisy image: auto quality produced %d bytes, want well below the %d bytes at max quality", autoSize, maxSize)
+	}
+	// 平滑图片使用较高质量，保留的信息明显多于最低质量
+	if autoSize, minSize := encodedWebpSize(t, smooth, auto), encodedWebpSize(t, smooth, atMin); float64(autoSize) < 1.3*float64(minSize) {
+		t.Fatalf("smooth image: auto quality produced %d bytes, want well above the %d bytes at min quality", autoSize, minSize)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func newWebpTestImage(width, height int, noisy bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) % 256), A: 255}
+			if noisy {
+				c = color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: uint8(rng.Intn(256))}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeWebpRoundTrip(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {1, 7}, {7, 1}, {17, 33}, {64, 64}, {300, 200}}
+	for _, size := range sizes {
+		for _, noisy := range []bool{false, true} {
+			src := newWebpTestImage(size[0], size[1], noisy)
+			var buf bytes.Buffer
+			if err := EncodeWebp(&buf, src); err != nil {
+				t.Fatalf("encode %v: %v", size, err)
+			}
+			if SniffImageFormat(buf.Bytes()) != "webp" {
+				t.Fatalf("encoded %v is not recognised as webp", size)
+			}
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("decode %v noisy=%v: %v", size, noisy, err)
+			}
+			got, ok := decoded.(*image.NRGBA)
+			if !ok {
+				t.Fatalf("decoded %T, want *image.NRGBA", decoded)
+			}
+			if !bytes.Equal(got.Pix, src.Pix) {
+				t.Fatalf("lossless round trip of %v noisy=%v changed pixels", size, noisy)
+			}
+		}
+	}
+}
+
+func TestEncodeWebpSmallerThanPngForSmoothImages(t *testing.T) {
+	src := newWebpTestImage(256, 256, false)
+	var webpBuf, pngBuf bytes.Buffer
+	if err := EncodeWebp(&webpBuf, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngBuf, src); err != nil {
+		t.Fatal(err)
+	}
+	if webpBuf.Len() >= pngBuf.Len() {
+		t.Fatalf("webp %d bytes is not smaller than png %d bytes", webpBuf.Len(), pngBuf.Len())
+	}
+}
+
+func TestEncodeWebpLossyRoundTrip(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {7, 3}, {17, 33}, {300, 200}}
+	for _, size := range sizes {
+		src := newWebpTestImage(size[0], size[1], false)
+		// 左半部分半透明，验证有损编码保留透明通道
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]/2; x++ {
+				src.Pix[src.PixOffset(x, y)+3] = 128
+			}
+		}
+		var buf bytes.Buffer
+		if err := EncodeWebpQuality(&buf, src, 80); err != nil {
+			t.Fatalf("encode %v: %v", size, err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("decode %v: %v", size, err)
+		}
+		if decoded.Bounds() != src.Bounds() {
+			t.Fatalf("decoded bounds %v, want %v", decoded.Bounds(), src.Bounds())
+		}
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				want := src.NRGBAAt(x, y).A
+				got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA).A
+				if diff := int(got) - int(want); diff < -2 || diff > 2 {
+					t.Fatalf("alpha at (%d,%d) of %v = %d, want %d", x, y, size, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebpQualityTradesFidelityForSize(t *testing.T) {
+	src := newWebpTestImage(128, 128, false)
+	var lossless, lossy bytes.Buffer
+	if err := EncodeWebpQuality(&lossless, src, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeWebpQuality(&lossy, src, 60); err != nil {
+		t.Fatal(err)
+	}
+	if lossy.Len() >= lossless.Len() {
+		t.Fatalf("quality 60 (%d bytes) is not smaller than lossless (%d bytes)", lossy.Len(), lossless.Len())
+	}
+	decoded, err := webp.Decode(bytes.NewReader(lossy.Bytes()))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	total := 0
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			r, g, b, _ := decoded.At(x, y).RGBA()
+			want := src.NRGBAAt(x, y)
+			total += absDiff(int(r>>8), int(want.R)) + absDiff(int(g>>8), int(want.G)) + absDiff(int(b>>8), int(want.B))
+		}
+	}
+	if mean := float64(total) / (128 * 128 * 3); mean > 10 {
+		t.Fatalf("mean channel error %.2f at quality 60, want at most 10", mean)
+	}
+}
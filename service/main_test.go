@@ -0,0 +1,15 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestMain(m *testing.M) {
+	// 测试不连接 Redis，限流、缓存等使用进程内存储
+	common.RedisEnabled = false
+	InitHttpClient()
+	os.Exit(m.Run())
+}
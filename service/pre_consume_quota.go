@@ -77,3 +77,63 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	relayInfo.FinalPreConsumedQuota = preConsumedQuota
 	return nil
 }
+
+// HoldAdditionalQuota 在已预扣额度之外追加预扣，不受信任额度影响，余额不足时拒绝。
+// 追加部分计入 FinalPreConsumedQuota，由结算时多退少补，请求失败时随预扣额度一起返还
+func HoldAdditionalQuota(c *gin.Context, quota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if quota <= 0 {
+		return nil
+	}
+	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if userQuota < quota {
+		return types.NewErrorWithStatusCode(fmt.Errorf("预扣费额度失败, 用户剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(userQuota), logger.FormatQuota(quota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if err = PreConsumeTokenQuota(relayInfo, quota); err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if err = model.DecreaseUserQuota(relayInfo.UserId, quota); err != nil {
+		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	relayInfo.FinalPreConsumedQuota += quota
+	logger.LogInfo(c, fmt.Sprintf("用户 %d 追加预扣费 %s, 累计预扣费 %s", relayInfo.UserId, logger.FormatQuota(quota), logger.FormatQuota(relayInfo.FinalPreConsumedQuota)))
+	return nil
+}
+
+// CheckStreamMinimumQuota 流式请求开始转发后无法再以普通 JSON 返回额度错误，发往上游前按结算使用的价格
+// 确认用户与令牌至少能支付最低费用：按次计费为单次价格，按量计费为输入 token 的费用。
+// 已预扣的额度计入，信任额度免预扣的用户同样需要满足
+func CheckStreamMinimumQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	priceData := relayInfo.PriceData
+	if priceData.FreeModel {
+		return nil
+	}
+	groupRatio := priceData.GroupRatioInfo.GroupRatio
+	var minimum int
+	if priceData.UsePrice {
+		minimum = int(priceData.ModelPrice * common.QuotaPerUnit * groupRatio)
+	} else {
+		ratio := priceData.ModelRatio * groupRatio
+		minimum = int(float64(relayInfo.PromptTokens) * ratio)
+		if minimum == 0 && ratio > 0 {
+			minimum = 1
+		}
+	}
+	need := minimum - relayInfo.FinalPreConsumedQuota
+	if need <= 0 {
+		return nil
+	}
+	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if userQuota < need {
+		return types.NewErrorWithStatusCode(fmt.Errorf("用户额度不足以支付流式请求的最低费用, 剩余额度: %s, 最低费用: %s", logger.FormatQuota(userQuota), logger.FormatQuota(need)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if !relayInfo.TokenUnlimited && c.GetInt("token_quota") < minimum {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌额度不足以支付流式请求的最低费用, 剩余额度: %s, 最低费用: %s", logger.FormatQuota(c.GetInt("token_quota")), logger.FormatQuota(minimum)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 短于该长度的提示词不做文本替换，避免把常见短词误替换
+const promptRedactMinLength = 4
+
+// RedactedPromptMarker 提示词的脱敏标记，保留字符数与哈希前缀，便于对照排查
+func RedactedPromptMarker(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return fmt.Sprintf("[redacted prompt len=%d sha256=%s]", utf8.RuneCountInString(prompt), hex.EncodeToString(sum[:])[:12])
+}
+
+// RedactPromptText 将文本中出现的提示词（包括 JSON 转义后的形式）替换为脱敏标记，
+// 用于上游错误信息、multipart 请求体等无法按字段处理的内容
+func RedactPromptText(text string, prompts ...string) string {
+	for _, prompt := range prompts {
+		if utf8.RuneCountInString(prompt) < promptRedactMinLength {
+			continue
+		}
+		marker := RedactedPromptMarker(prompt)
+		text = strings.ReplaceAll(text, prompt, marker)
+		if escaped, err := common.Marshal(prompt); err == nil && len(escaped) > 2 {
+			text = strings.ReplaceAll(text, string(escaped[1:len(escaped)-1]), marker)
+		}
+	}
+	return text
+}
+
+// RedactPromptJSON 将 JSON 中键名包含 prompt 的字符串字段（prompt、negative_prompt、revised_prompt 等）替换为脱敏标记，
+// 再按文本替换已知的提示词；不是 JSON 时只做文本替换
+func RedactPromptJSON(data []byte, prompts ...string) []byte {
+	var value any
+	if err := common.Unmarshal(data, &value); err == nil {
+		if redacted, err := common.Marshal(redactPromptValue(value, false)); err == nil {
+			data = redacted
+		}
+	}
+	return []byte(RedactPromptText(string(data), prompts...))
+}
+
+func redactPromptValue(value any, promptField bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = redactPromptValue(item, promptField || strings.Contains(strings.ToLower(key), "prompt"))
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = redactPromptValue(item, promptField)
+		}
+		return v
+	case string:
+		if promptField {
+			return RedactedPromptMarker(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -225,6 +225,11 @@ func getImageToken(fileMeta *types.FileMeta, model string, stream bool) (int, er
 	}
 
 	// Tile-based calculation for 4o/4.1/4.5/o1/o3/etc.
+	return tileImageTokens(width, height, baseTokens, tileTokens), nil
+}
+
+// tileImageTokens 按 512px 分块计算图片 token：先缩放到 2048x2048 以内，再将短边缩放到 768
+func tileImageTokens(width int, height int, baseTokens int, tileTokens int) int {
 	// Step 1: fit within 2048x2048 square
 	maxSide := math.Max(float64(width), float64(height))
 	fitScale := 1.0
@@ -237,7 +242,7 @@ func getImageToken(fileMeta *types.FileMeta, model string, stream bool) (int, er
 	// Step 2: scale so that shortest side is exactly 768
 	minSide := math.Min(float64(fitW), float64(fitH))
 	if minSide == 0 {
-		return baseTokens, nil
+		return baseTokens
 	}
 	shortScale := 768.0 / minSide
 	finalW := int(math.Round(float64(fitW) * shortScale))
@@ -252,7 +257,7 @@ func getImageToken(fileMeta *types.FileMeta, model string, stream bool) (int, er
 		log.Printf("scaled to: %dx%d, tiles: %d", finalW, finalH, tiles)
 	}
 
-	return tiles*tileTokens + baseTokens, nil
+	return tiles*tileTokens + baseTokens
 }
 
 func CountRequestToken(c *gin.Context, meta *types.TokenCountMeta, info *relaycommon.RelayInfo) (int, error) {
@@ -6,11 +6,14 @@ import (
 
 type GlobalSettings struct {
 	PassThroughRequestEnabled bool `json:"pass_through_request_enabled"`
+	// 开启后调试日志、消费日志中的上游审计记录与上游错误信息里的提示词替换为长度与哈希标记
+	RedactPrompts bool `json:"redact_prompts"`
 }
 
 // 默认配置
 var defaultOpenaiSettings = GlobalSettings{
 	PassThroughRequestEnabled: false,
+	RedactPrompts:             false,
 }
 
 // 全局实例
@@ -0,0 +1,534 @@
+package model_setting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	ImagePromptOverflowTruncate = "truncate"
+	ImagePromptOverflowReject   = "reject"
+
+	ImageStorageFallbackOriginal = "original"
+	ImageStorageFallbackError    = "error"
+
+	ImageMultipartDuplicateMerge = "merge"
+	ImageMultipartDuplicateError = "error"
+
+	ImageBudgetCycleDaily   = "daily"
+	ImageBudgetCycleWeekly  = "weekly"
+	ImageBudgetCycleMonthly = "monthly"
+
+	ImagePostProcessPreferWebp  = "prefer_webp"
+	ImagePostProcessNsfwBlur    = "nsfw_blur"
+	ImagePostProcessMaxOutput   = "max_output"
+	ImagePostProcessStaticFrame = "static_frame"
+
+	ImageSplitPartialFail   = "fail"
+	ImageSplitPartialReturn = "partial"
+
+	ImageFormatFallbackReturn = "return"
+	ImageFormatFallbackError  = "error"
+
+	ImageStyleReferenceStrip = "strip"
+	ImageStyleReferenceError = "error"
+
+	ImageNOverflowReject = "reject"
+	ImageNOverflowClamp  = "clamp"
+
+	ImageUnsupportedParamStrip = "strip"
+	ImageUnsupportedParamError = "error"
+
+	// 使用共享 HTTP 客户端，与未配置时一致
+	ImageHttpClientProfileDefault = "default"
+)
+
+// ImageSettings 图片生成相关的全局配置
+type ImageSettings struct {
+	// 超长提示词摘要，默认关闭
+	PromptSummarizeEnabled        bool   `json:"prompt_summarize_enabled"`
+	PromptSummarizeBaseUrl        string `json:"prompt_summarize_base_url"` // OpenAI 兼容的 chat/completions 地址
+	PromptSummarizeApiKey         string `json:"prompt_summarize_api_key"`
+	PromptSummarizeModel          string `json:"prompt_summarize_model"`
+	PromptSummarizeMaxLength      int    `json:"prompt_summarize_max_length"`    // 超过该字符数时触发摘要
+	PromptSummarizeTargetLength   int    `json:"prompt_summarize_target_length"` // 摘要目标字符数，不超过 MaxLength
+	PromptSummarizeTimeoutSeconds int    `json:"prompt_summarize_timeout_seconds"`
+	PromptSummarizeFallback       string `json:"prompt_summarize_fallback"` // 摘要失败时的处理方式：truncate / reject
+
+	// 合并并发的相同图片编辑请求（相同输入图片、提示词与参数），每个请求仍单独计费
+	EditCoalesceEnabled bool `json:"edit_coalesce_enabled"`
+
+	// 相同图片请求的结果缓存：按模型映射后的完整请求计算键，TTL 内重复请求直接返回之前的结果，按命中倍率计费。
+	// 带上传图片的请求（编辑、变体）默认不缓存
+	ResultCacheEnabled        bool    `json:"result_cache_enabled"`
+	ResultCacheTTLSeconds     int     `json:"result_cache_ttl_seconds"`
+	ResultCacheHitRatio       float64 `json:"result_cache_hit_ratio"` // 命中缓存时按原价的该比例计费
+	ResultCacheIncludeUploads bool    `json:"result_cache_include_uploads"`
+	// 缓存的容量上限，超出时淘汰最久未使用的结果；超过 ResultCacheMaxEntryBytes 的响应不缓存。0 表示不限制
+	ResultCacheMaxEntries    int   `json:"result_cache_max_entries"`
+	ResultCacheMaxBytes      int64 `json:"result_cache_max_bytes"`
+	ResultCacheMaxEntryBytes int   `json:"result_cache_max_entry_bytes"`
+	// 客户端接受 SSE（Accept: text/event-stream）时，提示词相近（忽略大小写、标点、词序与尺寸等参数）的生成结果仍在缓存中，
+	// 先以 preview 事件返回其缩略图，完整结果照常生成并计费，预览不计费
+	ResultCachePreviewEnabled bool `json:"result_cache_preview_enabled"`
+	ResultCachePreviewMaxSize int  `json:"result_cache_preview_max_size"` // 缩略图最长边（像素）
+
+	// 输出图片放大（按张额外计费），请求中通过 upscale 参数指定放大倍数
+	UpscaleBaseUrl        string             `json:"upscale_base_url"` // 放大接口地址，接收 {"model","image","scale"}，返回 {"image"}
+	UpscaleApiKey         string             `json:"upscale_api_key"`
+	UpscaleModel          string             `json:"upscale_model"`
+	UpscaleFactors        []int              `json:"upscale_factors"` // 支持的放大倍数
+	UpscalePrices         map[string]float64 `json:"upscale_prices"`  // 允许放大的模型及单张放大价格（美元），实际价格 = 价格 × 放大倍数
+	UpscaleTimeoutSeconds int                `json:"upscale_timeout_seconds"`
+
+	// 图片临时存储，留空时使用系统临时目录
+	StorageDir        string `json:"storage_dir"`
+	TempUrlTTLSeconds int    `json:"temp_url_ttl_seconds"` // 临时图片地址有效期
+	// 写入存储对象的元数据映射，键为元数据名，值为来源：
+	// user_id、username、token_id、token_name、group、channel_id、model、request_id 或 header:<请求头>
+	StorageMetadata map[string]string `json:"storage_metadata"`
+	// 存储写入的超时与重试，多次失败后按 StorageWriteFallback 处理：original 保留原始数据 / error 返回错误
+	StorageWriteTimeoutSeconds int    `json:"storage_write_timeout_seconds"`
+	StorageWriteRetries        int    `json:"storage_write_retries"`
+	StorageWriteFallback       string `json:"storage_write_fallback"`
+	// 按内容哈希去重，相同图片只保存一份，由引用计数决定何时删除
+	StorageDedupEnabled bool `json:"storage_dedup_enabled"`
+
+	// 渠道不支持风格参考图时的处理方式：strip 移除后继续 / error 返回错误
+	StyleReferenceUnsupportedPolicy string `json:"style_reference_unsupported_policy"`
+	StyleReferenceMaxDimension      int    `json:"style_reference_max_dimension"` // 风格参考图最大边长（像素），0 表示不限制
+
+	// 客户端支持时压缩超过阈值的 JSON 图片响应（gzip/br），multipart 二进制响应不压缩
+	ResponseCompressionEnabled  bool `json:"response_compression_enabled"`
+	ResponseCompressionMinBytes int  `json:"response_compression_min_bytes"`
+
+	// 用户图片消费预算（美元），独立于账户额度，按 BudgetCycle 周期重置。
+	// UserBudgets 的键为用户 ID，未配置的用户使用 DefaultUserBudget，0 表示不限制；
+	// 消费达到 BudgetWarningPercent 时在响应头中提示，达到预算后拒绝请求
+	DefaultUserBudget    float64            `json:"default_user_budget"`
+	UserBudgets          map[string]float64 `json:"user_budgets"`
+	BudgetCycle          string             `json:"budget_cycle"` // daily / weekly / monthly
+	BudgetWarningPercent float64            `json:"budget_warning_percent"`
+
+	// 上游返回的图片 URL 即将过期时，通过响应头告知客户端过期时间。
+	// 过期时间优先从签名参数解析（se、X-Amz-Expires、Expires 等），否则按 UrlExpiryRules 中域名后缀对应的有效期（秒）估算
+	UrlExpiryWarningEnabled bool           `json:"url_expiry_warning_enabled"`
+	UrlExpiryWarnSeconds    int            `json:"url_expiry_warn_seconds"` // 剩余有效期不超过该值时才提示
+	UrlExpiryRules          map[string]int `json:"url_expiry_rules"`
+
+	// 运营方开启后，管理员授予 ImageRawOutputAllowed 的令牌才能通过 X-New-Api-Raw-Output 跳过可选的后处理步骤
+	RawOutputEnabled bool `json:"raw_output_enabled"`
+	// 客户端请求原始输出时仍必须执行的后处理步骤：prefer_webp、nsfw_blur、max_output
+	MandatoryPostProcessSteps []string `json:"mandatory_post_process_steps"`
+
+	// 按次计费模型的尺寸/品质价格档位，键为模型名，值为 "尺寸:品质" 或 "尺寸" 到单张价格倍率的映射，
+	// 例如 {"dall-e-3": {"1024x1024:standard": 1, "1792x1024:hd": 3}}。命中档位时替代内置的尺寸与品质倍率，
+	// 尺寸与品质按发往上游的取值匹配
+	SizePriceTiers map[string]map[string]float64 `json:"size_price_tiers"`
+
+	// 按模型限制客户端可请求的尺寸，键为客户端请求的模型名，例如 {"gpt-image-1": ["1024x1024", "1536x1024", "1024x1536", "auto"]}。
+	// 未配置的模型不限制，尺寸在规范化（小写、统一分隔符 x）后匹配
+	ModelAllowedSizes map[string][]string `json:"model_allowed_sizes"`
+
+	// 客户端单次请求允许的最大张数 n，键为客户端请求的模型名，未配置的模型使用 DefaultMaxN（0 表示不限制）。
+	// 超出时按 NOverflowPolicy 处理：reject 返回参数错误 / clamp 改为最大张数并记录警告
+	ModelMaxN       map[string]int `json:"model_max_n"`
+	DefaultMaxN     int            `json:"default_max_n"`
+	NOverflowPolicy string         `json:"n_overflow_policy"`
+
+	// 模型支持的 output_format 取值，键为客户端请求的模型名，未列出的模型不检查。请求了不支持的格式时直接返回错误
+	ModelOutputFormats map[string][]string `json:"model_output_formats"`
+
+	// 模型支持的可选参数（background、input_fidelity、seed），键为客户端请求的模型名，未列出的模型不检查、原样转发。
+	// 请求了模型不支持的参数时按 UnsupportedParamPolicy 处理：strip 移除并记录警告 / error 返回错误。
+	// seed 单独按 UnsupportedSeedPolicy 处理，默认返回错误，避免忽略 seed 后客户端误以为结果可复现
+	ModelSupportedParams   map[string][]string `json:"model_supported_params"`
+	UnsupportedParamPolicy string              `json:"unsupported_param_policy"`
+	UnsupportedSeedPolicy  string              `json:"unsupported_seed_policy"`
+
+	// 上游单次请求允许的最大生成张数，键为上游模型名。请求张数超过限制时拆分为多次上游请求并合并结果
+	MaxUpstreamBatchSize map[string]int `json:"max_upstream_batch_size"`
+
+	// 按模型设置的图片请求超时（秒），键为客户端请求的模型名。超时覆盖整个上游请求（包括读取响应体），
+	// 配置后不再受全局 RELAY_TIMEOUT 限制；渠道配置的 image_timeout_seconds 优先
+	ModelTimeoutSeconds map[string]int `json:"model_timeout_seconds"`
+
+	// 上游 HTTP 客户端配置，键为配置名。使用配置的请求拥有独立的连接池，慢速图片接口不会占满聊天请求共用的连接
+	HttpClientProfiles map[string]ImageHttpClientProfile `json:"http_client_profiles"`
+	// 按模型选择 HTTP 客户端配置，键为客户端请求的模型名；渠道配置的 image_http_client_profile 优先，
+	// 未配置或为 default 时使用共享客户端
+	ModelHttpClientProfiles map[string]string `json:"model_http_client_profiles"`
+
+	// 转发给客户端的上游响应头（不区分大小写），仅允许列表内的响应头，避免泄露上游内部信息
+	ForwardResponseHeaders []string `json:"forward_response_headers"`
+	// 上游返回 429 时按 retry-after 延后重试，最长等待秒数
+	RetryAfterMaxSeconds int `json:"retry_after_max_seconds"`
+
+	// 渠道未配置 image_retry_backoff 时使用的默认重试退避：第 n 次重试等待 min(最大值, 基础值 × 倍数^(n-1))，再按抖动方式随机化。
+	// 基础值为 0 表示不等待
+	RetryBaseDelayMs int     `json:"retry_base_delay_ms"`
+	RetryMaxDelayMs  int     `json:"retry_max_delay_ms"`
+	RetryMultiplier  float64 `json:"retry_multiplier"`
+	RetryJitter      string  `json:"retry_jitter"` // none、full、equal
+
+	// 拆分后的上游子请求并发数，以及部分子请求失败时的处理方式：fail 整体失败 / partial 返回成功的图片并附带警告
+	UpstreamSplitConcurrency   int    `json:"upstream_split_concurrency"`
+	UpstreamSplitPartialPolicy string `json:"upstream_split_partial_policy"`
+
+	// 批量生成接口的单次最大条目数与并发数
+	BatchMaxItems       int `json:"batch_max_items"`
+	BatchMaxConcurrency int `json:"batch_max_concurrency"`
+
+	// 异步任务：客户端通过 Prefer: respond-async 提交后立即返回 job_id，由后台工作协程完成生成，
+	// 结果通过 /v1/images/jobs/:id 查询。完成后的结果保留 AsyncJobResultTTLSeconds 秒
+	AsyncJobEnabled          bool `json:"async_job_enabled"`
+	AsyncJobWorkers          int  `json:"async_job_workers"`         // 同时执行的任务数
+	AsyncJobMaxPending       int  `json:"async_job_max_pending"`     // 未完成任务数上限，超出时拒绝提交
+	AsyncJobTimeoutSeconds   int  `json:"async_job_timeout_seconds"` // 单个任务从提交到完成的最长时间
+	AsyncJobResultTTLSeconds int  `json:"async_job_result_ttl_seconds"`
+
+	// 幂等键：客户端携带 Idempotency-Key 时，同一令牌的相同键在有效期内只生成一次，重复请求重放保存的响应且不计费。
+	// 超过 IdempotencyMaxBodyBytes 的响应不保存，重复请求会重新执行
+	IdempotencyEnabled      bool `json:"idempotency_enabled"`
+	IdempotencyTTLSeconds   int  `json:"idempotency_ttl_seconds"`
+	IdempotencyMaxBodyBytes int  `json:"idempotency_max_body_bytes"`
+
+	// 会话亲和：同一会话的图片请求在渠道可用时固定使用同一渠道，减少切换上游带来的风格差异。
+	// 会话标识优先读取 AffinityHeader 请求头，其次读取 AffinityCookie
+	AffinityEnabled    bool   `json:"affinity_enabled"`
+	AffinityHeader     string `json:"affinity_header"`
+	AffinityCookie     string `json:"affinity_cookie"`
+	AffinityTTLSeconds int    `json:"affinity_ttl_seconds"` // 会话最后一次成功请求后保持绑定的时间
+
+	// 在图片响应的 new_api 字段与消费日志中记录生效的策略版本与上游模型，便于审计追溯
+	PolicyVersionEnabled bool `json:"policy_version_enabled"`
+
+	// 在响应头中返回实际使用的渠道、模型映射（及回退）后的上游模型与扣除额度，
+	// 会暴露渠道与模型配置，默认关闭
+	EffectiveHeadersEnabled bool `json:"effective_headers_enabled"`
+
+	// 记录每次生成的参数，客户端通过 previous_generation_id 引用之前的生成（请求 ID）时，
+	// 在响应的 new_api.param_diff 中返回与之相比变化的参数
+	GenerationDiffEnabled     bool `json:"generation_diff_enabled"`
+	GenerationParamTTLSeconds int  `json:"generation_param_ttl_seconds"` // 生成参数保留时间
+
+	// 按模型设置的提示词最大字符数（按 Unicode 字符计），键为上游模型名或客户端请求的模型名，未配置的模型使用 DefaultPromptMaxLength，0 表示不限制。
+	// 默认值取各服务商文档中的上限
+	ModelPromptMaxLength   map[string]int `json:"model_prompt_max_length"`
+	DefaultPromptMaxLength int            `json:"default_prompt_max_length"`
+
+	// 允许空提示词的文生图模型（例如支持随机生成的模型），其余模型的空提示词请求直接返回 400
+	AllowEmptyPromptModels []string `json:"allow_empty_prompt_models"`
+
+	// multipart 响应中每张图片的文件名模板，留空时不设置文件名。
+	// 支持的变量：{index}、{ext}、{model}、{request_id}、{timestamp}，重名时自动追加序号
+	MultipartFilenameTemplate string `json:"multipart_filename_template"`
+
+	// 编辑请求同时包含 image 与 image[] 等字段时的处理方式：merge 合并 / error 返回错误
+	MultipartDuplicatePolicy string `json:"multipart_duplicate_policy"`
+
+	// 客户端要求的 response_format 与上游实际返回不一致时自动转换：base64 转存到图片存储并返回 url，
+	// url 下载后内联为 b64_json。单张图片大小与整体耗时受限，失败时返回上游原始结果
+	ResponseFormatConversionEnabled bool `json:"response_format_conversion_enabled"`
+	ResponseFormatMaxBytes          int  `json:"response_format_max_bytes"`
+	ResponseFormatTimeoutSeconds    int  `json:"response_format_timeout_seconds"`
+	// 转换为 url 时写入图片存储失败的处理方式：original 返回 b64_json 并通过响应头告警 / error 返回错误
+	ResponseFormatStorageFallback string `json:"response_format_storage_fallback"`
+
+	// 客户端指定 output_format 而上游返回其他格式、服务端又无法转换（例如要求 GIF）时的处理方式：
+	// return 返回上游格式并通过响应头告警 / error 返回错误
+	OutputFormatFallbackPolicy string `json:"output_format_fallback_policy"`
+
+	// 按模型统计滚动窗口内的生成成功与失败次数（跨渠道汇总），通过管理接口查看
+	ModelStatsEnabled       bool `json:"model_stats_enabled"`
+	ModelStatsWindowSeconds int  `json:"model_stats_window_seconds"`
+
+	// 上游审计：在消费日志中记录实际发往上游的请求体与上游原始响应，base64 图片数据会被省略，
+	// 每条记录按 UpstreamAuditMaxBytes 截断。仅用于排查渠道问题，默认关闭
+	UpstreamAuditEnabled  bool `json:"upstream_audit_enabled"`
+	UpstreamAuditMaxBytes int  `json:"upstream_audit_max_bytes"`
+
+	// 在日志中记录上游返回的改写后提示词（revised_prompt），默认关闭
+	LogRevisedPrompt bool `json:"log_revised_prompt"`
+
+	// 可复现性包的签名密钥（HMAC-SHA256），未配置时不生成可复现性包
+	ReproBundleSecret string `json:"repro_bundle_secret"`
+
+	// 渠道 SLA 监控，键为渠道 ID，违反时向 SlaAlertWebhookUrl 发送告警
+	SlaRules                map[string]ImageSlaRule `json:"sla_rules"`
+	SlaAlertWebhookUrl      string                  `json:"sla_alert_webhook_url"`
+	SlaAlertWebhookSecret   string                  `json:"sla_alert_webhook_secret"`
+	SlaAlertCooldownSeconds int                     `json:"sla_alert_cooldown_seconds"` // 同一渠道两次告警的最小间隔
+
+	// 渠道熔断：窗口内连续失败达到阈值后暂停向该渠道转发图片请求，熔断时长结束后放行一个探测请求，
+	// 探测成功恢复，失败则熔断时长翻倍（不超过最大值）
+	CircuitBreakerEnabled          bool `json:"circuit_breaker_enabled"`
+	CircuitBreakerFailureThreshold int  `json:"circuit_breaker_failure_threshold"`
+	CircuitBreakerWindowSeconds    int  `json:"circuit_breaker_window_seconds"` // 连续失败的统计窗口，距上次失败超过该时间后重新计数
+	CircuitBreakerOpenSeconds      int  `json:"circuit_breaker_open_seconds"`
+	CircuitBreakerMaxOpenSeconds   int  `json:"circuit_breaker_max_open_seconds"`
+
+	// JSON 编辑请求通过 image_url / images[].image_url / mask_url 传入图片时由服务端下载，默认关闭。
+	// 下载受 fetch_setting 的 SSRF 防护约束（私有地址、域名与 IP 白名单/黑名单、端口）
+	InputUrlEnabled        bool  `json:"input_url_enabled"`
+	InputUrlMaxBytes       int64 `json:"input_url_max_bytes"` // 单张图片的最大字节数
+	InputUrlTimeoutSeconds int   `json:"input_url_timeout_seconds"`
+	InputUrlMaxCount       int   `json:"input_url_max_count"` // 单次请求最多的图片地址数
+
+	// 当前节点同时处理的图片请求上限（不区分渠道），0 表示不限制。名额已满时最多排队 GlobalImageQueueSize 个请求，
+	// 队列已满或等待超时返回 503
+	GlobalMaxConcurrentImages   int `json:"global_max_concurrent_images"`
+	GlobalImageQueueSize        int `json:"global_image_queue_size"` // 0 表示不排队，名额已满时直接拒绝
+	GlobalImageQueueWaitSeconds int `json:"global_image_queue_wait_seconds"`
+
+	// 成功的生成写入用户的图片历史（GET /v1/images/history），默认关闭。写入在响应返回后异步进行，
+	// 失败时最多重试 HistoryWriteRetries 次。提示词默认只保存哈希，HistoryStorePrompt 开启后才保存原文
+	HistoryEnabled      bool `json:"history_enabled"`
+	HistoryStorePrompt  bool `json:"history_store_prompt"`
+	HistoryWriteRetries int  `json:"history_write_retries"`
+}
+
+// ImageSlaRule 单个渠道的 SLA 定义，阈值为 0 表示不检查该项
+type ImageSlaRule struct {
+	P95LatencyMs  int64   `json:"p95_latency_ms"`
+	MaxErrorRate  float64 `json:"max_error_rate"` // 0-1
+	WindowSeconds int     `json:"window_seconds"` // 滚动窗口，默认 300 秒
+	MinRequests   int     `json:"min_requests"`   // 窗口内请求数达到该值才评估，默认 20
+}
+
+// ImageHttpClientProfile 上游 HTTP 客户端的连接参数，为 0 的项使用 Go 默认值
+type ImageHttpClientProfile struct {
+	ConnectTimeoutSeconds  int `json:"connect_timeout_seconds"`
+	ReadTimeoutSeconds     int `json:"read_timeout_seconds"` // 请求发出后等待上游响应头的最长时间
+	MaxIdleConns           int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost    int `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSeconds int `json:"idle_conn_timeout_seconds"`
+}
+
+// 默认配置
+var defaultImageSettings = ImageSettings{
+	PromptSummarizeEnabled:          false,
+	PromptSummarizeMaxLength:        4000,
+	PromptSummarizeTargetLength:     3000,
+	PromptSummarizeTimeoutSeconds:   15,
+	PromptSummarizeFallback:         ImagePromptOverflowReject,
+	UpscaleFactors:                  []int{2, 4},
+	UpscalePrices:                   map[string]float64{},
+	UpscaleTimeoutSeconds:           60,
+	ResultCacheTTLSeconds:           30,
+	ResultCacheHitRatio:             0.1,
+	ResultCacheMaxEntries:           256,
+	ResultCacheMaxBytes:             256 * 1024 * 1024,
+	ResultCacheMaxEntryBytes:        16 * 1024 * 1024,
+	ResultCachePreviewMaxSize:       256,
+	TempUrlTTLSeconds:               600,
+	StorageMetadata:                 map[string]string{},
+	StorageWriteTimeoutSeconds:      10,
+	StorageWriteRetries:             2,
+	StorageWriteFallback:            ImageStorageFallbackError,
+	StorageDedupEnabled:             true,
+	StyleReferenceUnsupportedPolicy: ImageStyleReferenceStrip,
+	StyleReferenceMaxDimension:      4096,
+	ResponseCompressionMinBytes:     64 * 1024,
+	UserBudgets:                     map[string]float64{},
+	BudgetCycle:                     ImageBudgetCycleMonthly,
+	BudgetWarningPercent:            80,
+	UrlExpiryWarnSeconds:            24 * 3600,
+	UrlExpiryRules:                  map[string]int{},
+	MandatoryPostProcessSteps:       []string{ImagePostProcessNsfwBlur, ImagePostProcessMaxOutput},
+	SizePriceTiers:                  map[string]map[string]float64{},
+	ModelAllowedSizes:               map[string][]string{},
+	ModelMaxN:                       map[string]int{"dall-e-3": 1, "dall-e-2": 10, "gpt-image-1": 10},
+	DefaultMaxN:                     10,
+	NOverflowPolicy:                 ImageNOverflowReject,
+	ModelSupportedParams: map[string][]string{
+		"dall-e-2":    {},
+		"dall-e-3":    {},
+		"gpt-image-1": {"background", "input_fidelity"},
+	},
+	UnsupportedParamPolicy: ImageUnsupportedParamStrip,
+	UnsupportedSeedPolicy:  ImageUnsupportedParamError,
+	ModelOutputFormats: map[string][]string{
+		"dall-e-2":    {"png"},
+		"dall-e-3":    {"png"},
+		"gpt-image-1": {"png", "jpeg", "webp"},
+	},
+	MaxUpstreamBatchSize: map[string]int{},
+	ModelTimeoutSeconds:  map[string]int{},
+	HttpClientProfiles: map[string]ImageHttpClientProfile{
+		"slow": {ConnectTimeoutSeconds: 10, ReadTimeoutSeconds: 600, MaxIdleConns: 100, MaxIdleConnsPerHost: 20, IdleConnTimeoutSeconds: 90},
+	},
+	ModelHttpClientProfiles:        map[string]string{},
+	ForwardResponseHeaders:         []string{"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests", "x-ratelimit-remaining", "retry-after"},
+	RetryAfterMaxSeconds:           10,
+	RetryBaseDelayMs:               500,
+	RetryMaxDelayMs:                10000,
+	RetryMultiplier:                2,
+	RetryJitter:                    "full",
+	UpstreamSplitConcurrency:       4,
+	UpstreamSplitPartialPolicy:     ImageSplitPartialFail,
+	BatchMaxItems:                  16,
+	BatchMaxConcurrency:            4,
+	AsyncJobWorkers:                4,
+	AsyncJobMaxPending:             100,
+	AsyncJobTimeoutSeconds:         600,
+	AsyncJobResultTTLSeconds:       3600,
+	IdempotencyTTLSeconds:          3600,
+	IdempotencyMaxBodyBytes:        20 * 1024 * 1024,
+	AffinityHeader:                 "X-Session-Id",
+	AffinityCookie:                 "image_session",
+	AffinityTTLSeconds:             1800,
+	GenerationParamTTLSeconds:      24 * 3600,
+	HistoryWriteRetries:            3,
+	ModelPromptMaxLength:           map[string]int{"dall-e-2": 1000, "dall-e-3": 4000, "gpt-image-1": 32000},
+	AllowEmptyPromptModels:         []string{},
+	MultipartDuplicatePolicy:       ImageMultipartDuplicateMerge,
+	ResponseFormatMaxBytes:         20 * 1024 * 1024,
+	OutputFormatFallbackPolicy:     ImageFormatFallbackReturn,
+	UpstreamAuditMaxBytes:          4096,
+	ResponseFormatTimeoutSeconds:   30,
+	ResponseFormatStorageFallback:  ImageStorageFallbackOriginal,
+	ModelStatsWindowSeconds:        3600,
+	SlaRules:                       map[string]ImageSlaRule{},
+	SlaAlertCooldownSeconds:        600,
+	CircuitBreakerFailureThreshold: 5,
+	CircuitBreakerWindowSeconds:    60,
+	CircuitBreakerOpenSeconds:      30,
+	CircuitBreakerMaxOpenSeconds:   600,
+	InputUrlMaxBytes:               20 * 1024 * 1024,
+	InputUrlTimeoutSeconds:         30,
+	InputUrlMaxCount:               16,
+	GlobalImageQueueSize:           50,
+	GlobalImageQueueWaitSeconds:    30,
+}
+
+// 全局实例
+var imageSettings = defaultImageSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("image", &imageSettings)
+}
+
+func GetImageSettings() *ImageSettings {
+	return &imageSettings
+}
+
+// GetImageUpscalePrice 返回模型的单张放大价格，模型未开启放大时返回 false
+func (s *ImageSettings) GetImageUpscalePrice(model string) (float64, bool) {
+	price, ok := s.UpscalePrices[model]
+	return price, ok
+}
+
+// GetImageSettingsVersion 返回当前图片配置的版本号（配置内容的哈希），配置任意项变更后版本随之变化
+func GetImageSettingsVersion() string {
+	raw, err := common.Marshal(imageSettings)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:6])
+}
+
+// GetImageSlaRule 返回渠道的 SLA 定义
+func (s *ImageSettings) GetImageSlaRule(channelId int) (ImageSlaRule, bool) {
+	rule, ok := s.SlaRules[strconv.Itoa(channelId)]
+	return rule, ok
+}
+
+// GetImageSizePriceTier 返回模型在指定尺寸与品质下的单张价格倍率，优先匹配 "尺寸:品质"。
+// 模型未配置档位时 configured 为 false
+func (s *ImageSettings) GetImageSizePriceTier(model string, size string, quality string) (multiplier float64, found bool, configured bool) {
+	tiers, ok := s.SizePriceTiers[model]
+	if !ok || len(tiers) == 0 {
+		return 0, false, false
+	}
+	if quality != "" {
+		if multiplier, ok = tiers[size+":"+quality]; ok {
+			return multiplier, true, true
+		}
+	}
+	multiplier, ok = tiers[size]
+	return multiplier, ok, true
+}
+
+// GetModelAllowedSizes 返回模型允许的尺寸列表，未配置时 configured 为 false
+func (s *ImageSettings) GetModelAllowedSizes(model string) (sizes []string, configured bool) {
+	sizes, ok := s.ModelAllowedSizes[model]
+	return sizes, ok && len(sizes) > 0
+}
+
+// GetModelMaxN 返回模型允许的最大张数 n，0 表示不限制
+func (s *ImageSettings) GetModelMaxN(model string) int {
+	if maxN, ok := s.ModelMaxN[model]; ok {
+		return maxN
+	}
+	return s.DefaultMaxN
+}
+
+// GetMaxUpstreamBatchSize 返回上游模型单次请求的最大生成张数，0 表示不限制
+func (s *ImageSettings) GetMaxUpstreamBatchSize(model string) int {
+	return s.MaxUpstreamBatchSize[model]
+}
+
+// GetHttpClientProfile 返回指定名称的 HTTP 客户端配置，default 与未定义的配置返回 false
+func (s *ImageSettings) GetHttpClientProfile(name string) (ImageHttpClientProfile, bool) {
+	if name == "" || name == ImageHttpClientProfileDefault {
+		return ImageHttpClientProfile{}, false
+	}
+	profile, ok := s.HttpClientProfiles[name]
+	return profile, ok
+}
+
+// GetModelOutputFormats 返回模型支持的 output_format，第二个返回值表示是否配置了限制
+func (s *ImageSettings) GetModelOutputFormats(model string) ([]string, bool) {
+	formats, ok := s.ModelOutputFormats[model]
+	return formats, ok
+}
+
+// IsModelParamSupported 模型是否支持可选参数，未配置的模型视为支持
+func (s *ImageSettings) IsModelParamSupported(model string, param string) bool {
+	supported, ok := s.ModelSupportedParams[model]
+	return !ok || slices.Contains(supported, param)
+}
+
+// GetModelTimeoutSeconds 返回模型的图片请求超时（秒），0 表示未配置
+func (s *ImageSettings) GetModelTimeoutSeconds(model string) int {
+	return s.ModelTimeoutSeconds[model]
+}
+
+// IsImageUpscaleFactorSupported 放大倍数是否在支持列表中
+func (s *ImageSettings) IsImageUpscaleFactorSupported(factor int) bool {
+	return slices.Contains(s.UpscaleFactors, factor)
+}
+
+// GetModelPromptMaxLength 返回模型的提示词最大字符数，0 表示不限制
+func (s *ImageSettings) GetModelPromptMaxLength(model string) int {
+	if maxLength, ok := s.ModelPromptMaxLength[model]; ok {
+		return maxLength
+	}
+	return s.DefaultPromptMaxLength
+}
+
+// IsEmptyPromptAllowed 判断模型是否允许空提示词
+func (s *ImageSettings) IsEmptyPromptAllowed(model string) bool {
+	return slices.Contains(s.AllowEmptyPromptModels, model)
+}
+
+// GetUserImageBudget 返回用户的图片消费预算（美元），0 表示不限制
+func (s *ImageSettings) GetUserImageBudget(userId int) float64 {
+	if budget, ok := s.UserBudgets[strconv.Itoa(userId)]; ok {
+		return budget
+	}
+	return s.DefaultUserBudget
+}
+
+// IsPostProcessStepMandatory 判断后处理步骤是否不允许被客户端跳过
+func (s *ImageSettings) IsPostProcessStepMandatory(step string) bool {
+	return slices.Contains(s.MandatoryPostProcessSteps, step)
+}
@@ -0,0 +1,26 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelExclusionSetting 客户端通过请求头排除指定渠道的限制
+type ChannelExclusionSetting struct {
+	Enabled             bool   `json:"enabled"`               // 是否允许客户端排除渠道
+	Header              string `json:"header"`                // 排除渠道的请求头，值为逗号分隔的渠道 ID，仅对图片请求生效
+	MaxExcludedChannels int    `json:"max_excluded_channels"` // 单次请求最多排除的渠道数，0 表示不限制
+}
+
+// 默认配置
+var channelExclusionSetting = ChannelExclusionSetting{
+	Enabled:             false,
+	Header:              "X-Exclude-Channels",
+	MaxExcludedChannels: 3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_exclusion_setting", &channelExclusionSetting)
+}
+
+func GetChannelExclusionSetting() *ChannelExclusionSetting {
+	return &channelExclusionSetting
+}
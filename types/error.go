@@ -66,14 +66,31 @@ const (
 	ErrorCodeBadRequestBody ErrorCode = "bad_request_body"
 
 	// response error
-	ErrorCodeReadResponseBodyFailed ErrorCode = "read_response_body_failed"
-	ErrorCodeBadResponseStatusCode  ErrorCode = "bad_response_status_code"
-	ErrorCodeBadResponse            ErrorCode = "bad_response"
-	ErrorCodeBadResponseBody        ErrorCode = "bad_response_body"
-	ErrorCodeEmptyResponse          ErrorCode = "empty_response"
-	ErrorCodeAwsInvokeError         ErrorCode = "aws_invoke_error"
-	ErrorCodeModelNotFound          ErrorCode = "model_not_found"
-	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
+	ErrorCodeReadResponseBodyFailed  ErrorCode = "read_response_body_failed"
+	ErrorCodeBadResponseStatusCode   ErrorCode = "bad_response_status_code"
+	ErrorCodeBadResponse             ErrorCode = "bad_response"
+	ErrorCodeBadResponseBody         ErrorCode = "bad_response_body"
+	ErrorCodeEmptyResponse           ErrorCode = "empty_response"
+	ErrorCodeAwsInvokeError          ErrorCode = "aws_invoke_error"
+	ErrorCodeModelNotFound           ErrorCode = "model_not_found"
+	ErrorCodePromptBlocked           ErrorCode = "prompt_blocked"
+	ErrorCodePromptClassifyFailed    ErrorCode = "prompt_classify_failed"
+	ErrorCodeImageSafetyRejected     ErrorCode = "image_safety_rejected"
+	ErrorCodeImageCancelled          ErrorCode = "image_generation_cancelled"
+	ErrorCodeImageBudgetExceeded     ErrorCode = "image_budget_exceeded"
+	ErrorCodeImagePromptTruncated    ErrorCode = "image_prompt_truncated"
+	ErrorCodeImageFormatUnavailable  ErrorCode = "image_format_unavailable"
+	ErrorCodeUpstreamRateLimited     ErrorCode = "upstream_rate_limited"
+	ErrorCodeImageCircuitOpen        ErrorCode = "image_channel_circuit_open"
+	ErrorCodeImageServerBusy         ErrorCode = "image_server_busy"
+	ErrorCodeImageStorageUnavailable ErrorCode = "image_storage_unavailable"
+	ErrorCodeImageRequestTimeout     ErrorCode = "image_request_timeout"
+	// 上游图片错误按适配器的映射表归类后的稳定错误码
+	ErrorCodeImageContentPolicy   ErrorCode = "image_content_policy_violation"
+	ErrorCodeImageInvalidSize     ErrorCode = "image_invalid_size"
+	ErrorCodeImageProviderQuota   ErrorCode = "image_provider_quota_exceeded"
+	ErrorCodeImageModelOverloaded ErrorCode = "image_model_overloaded"
+	ErrorCodeRateLimitExceeded    ErrorCode = "rate_limit_exceeded"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
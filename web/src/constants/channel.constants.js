@@ -179,6 +179,16 @@ export const CHANNEL_OPTIONS = [
     color: 'green',
     label: 'Sora',
   },
+  {
+    value: 56,
+    color: 'purple',
+    label: 'Stability AI',
+  },
+  {
+    value: 57,
+    color: 'grey',
+    label: 'Mock（压测）',
+  },
 ];
 
 export const MODEL_TABLE_PAGE_SIZE = 10;
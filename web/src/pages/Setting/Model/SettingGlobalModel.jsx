@@ -35,6 +35,7 @@ export default function SettingGlobalModel(props) {
   const [loading, setLoading] = useState(false);
   const [inputs, setInputs] = useState({
     'global.pass_through_request_enabled': false,
+    'global.redact_prompts': false,
     'general_setting.ping_interval_enabled': false,
     'general_setting.ping_interval_seconds': 60,
   });
@@ -109,6 +110,21 @@ export default function SettingGlobalModel(props) {
                   }
                 />
               </Col>
+              <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                <Form.Switch
+                  label={t('日志中隐藏提示词')}
+                  field={'global.redact_prompts'}
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      'global.redact_prompts': value,
+                    })
+                  }
+                  extraText={
+                    t('开启后，图片请求的提示词在调试日志、审计记录和错误信息中将替换为长度与哈希')
+                  }
+                />
+              </Col>
             </Row>
 
             <Form.Section text={t('连接保活设置')}>